@@ -2,30 +2,247 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
 	"github.com/go-chi/chi/v5"
+	"github.com/vancho-go/gophermart/internal/app/accrual"
 	"github.com/vancho-go/gophermart/internal/app/auth"
 	"github.com/vancho-go/gophermart/internal/app/config"
+	"github.com/vancho-go/gophermart/internal/app/diagnostics"
+	"github.com/vancho-go/gophermart/internal/app/events"
 	"github.com/vancho-go/gophermart/internal/app/handlers"
 	"github.com/vancho-go/gophermart/internal/app/logger"
+	"github.com/vancho-go/gophermart/internal/app/middleware"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"github.com/vancho-go/gophermart/internal/app/openapi"
 	"github.com/vancho-go/gophermart/internal/app/storage"
+	"github.com/vancho-go/gophermart/internal/app/tracing"
+	"github.com/vancho-go/gophermart/internal/app/updater"
+	"github.com/vancho-go/gophermart/internal/app/webhooks"
 	"go.uber.org/zap"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
 )
 
-func periodicUpdateExecutor(ctx context.Context, interval time.Duration, accrualSystemAddress string, task func(context.Context, string, logger.Logger), logger logger.Logger) {
+// periodicUpdateExecutor запускает task с паузой interval() между запусками, интервал
+// перечитывается перед каждой паузой. После каждой ошибки task подряд пауза удваивается,
+// пока не достигнет maxExecutorBackoff.
+func periodicUpdateExecutor(ctx context.Context, interval func() time.Duration, task func(context.Context) error) {
+	failures := 0
 	for {
-		task(ctx, accrualSystemAddress, logger)
+		if err := task(ctx); err != nil {
+			failures++
+		} else {
+			failures = 0
+		}
+
+		pause := interval()
+		for i := 0; i < failures && pause*2 <= maxExecutorBackoff; i++ {
+			pause *= 2
+		}
+
 		select {
 		case <-ctx.Done():
 			return
-		case <-time.After(interval):
+		case <-time.After(pause):
+		}
+	}
+}
+
+func fixedInterval(interval time.Duration) func() time.Duration {
+	return func() time.Duration {
+		return interval
+	}
+}
+
+// logLevelHandler в режиме json (production) не дает опустить уровень ниже -log-level-floor.
+func logLevelHandler(l logger.Logger, configuration config.ServerConfig) (http.Handler, error) {
+	levelFloor := "debug"
+	if configuration.LogFormat == logger.FormatJSON {
+		levelFloor = configuration.LogLevelFloor
+	}
+	return logger.LevelHandler(l, levelFloor)
+}
+
+// newServer создает сервер API с таймаутами и ограничениями из конфигурации. Потоковые ответы
+// (SSE) сами снимают WriteTimeout для своего соединения.
+func newServer(configuration config.ServerConfig, handler http.Handler) (*http.Server, error) {
+	server := &http.Server{
+		Addr:              configuration.ServerRunAddress,
+		Handler:           handler,
+		ReadHeaderTimeout: configuration.ReadHeaderTimeout,
+		ReadTimeout:       configuration.ReadTimeout,
+		WriteTimeout:      configuration.WriteTimeout,
+		IdleTimeout:       configuration.IdleTimeout,
+		MaxHeaderBytes:    configuration.MaxHeaderBytes,
+	}
+
+	if configuration.EnableHTTPS {
+		certificate, err := tls.LoadX509KeyPair(configuration.TLSCertFile, configuration.TLSKeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("newServer: error loading TLS certificate: %w", err)
+		}
+		server.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{certificate},
+			MinVersion:   tls.VersionTLS12,
 		}
 	}
+	return server, nil
 }
 
-const orderUpdaterPeriod = time.Millisecond * 500
+// newRouter собирает маршруты API. Легаси-пути /api/user/* перенаправляются на версионированный
+// префикс /api/{APIVersion}/user с учетом -base-path.
+func newRouter(configuration config.ServerConfig, dbInstance *storage.Storage, eventBus *events.EventBus,
+	buildInfo models.APIVersionResponse, logger logger.Logger) (http.Handler, error) {
+	httpLogger := logger.With(zap.String("component", "http"))
+	r := chi.NewRouter()
+
+	apiValidation, err := openapi.ValidationMiddleware(configuration.APIValidationMode, httpLogger)
+	if err != nil {
+		return nil, fmt.Errorf("newRouter: error building openapi validation middleware: %w", err)
+	}
+	r.Use(middleware.Trace)
+	r.Use(apiValidation)
+
+	r.Get("/openapi.json", openapi.Handler)
+	r.Get("/api/openapi.json", openapi.Handler)
+	r.Get("/api/docs", openapi.DocsHandler)
+	if configuration.EnableSwaggerUI {
+		r.Get("/docs", openapi.SwaggerUIHandler)
+	}
+	r.Get("/ping", handlers.Ping(dbInstance, httpLogger))
+	r.Get("/api/version", handlers.Version(buildInfo, httpLogger))
+
+	userAPIPrefix := "/api/" + configuration.APIVersion + "/user"
+	r.Handle("/api/user", handlers.RedirectPrefix("/api/user", configuration.BasePath+userAPIPrefix))
+	r.Handle("/api/user/*", handlers.RedirectPrefix("/api/user", configuration.BasePath+userAPIPrefix))
+
+	r.Route(userAPIPrefix, func(r chi.Router) {
+		r.Group(func(r chi.Router) {
+			if configuration.AuthRateLimitRPS > 0 {
+				r.Use(middleware.NewIPRateLimiter(configuration.AuthRateLimitRPS, configuration.AuthRateLimitBurst).Middleware)
+			}
+			r.Post("/register", handlers.RegisterUser(dbInstance, configuration.MaxLoginLength, httpLogger))
+			r.Post("/login", handlers.AuthenticateUser(dbInstance, configuration.MaxLoginLength, httpLogger))
+		})
+		r.Group(func(r chi.Router) {
+			r.Use(auth.Middleware(dbInstance))
+			r.Group(func(r chi.Router) {
+				if configuration.OrderRateLimitRPS > 0 {
+					r.Use(middleware.NewUserRateLimiter(configuration.OrderRateLimitRPS, configuration.OrderRateLimitBurst).Middleware)
+				}
+				r.Post("/orders", handlers.AddOrder(dbInstance, httpLogger))
+				r.Post("/orders/batch", handlers.AddOrdersBatch(dbInstance, configuration.MaxOrderBatchSize, httpLogger))
+			})
+			r.Get("/orders", handlers.GetOrdersList(dbInstance, httpLogger))
+			r.Get("/orders/events", handlers.GetOrderEvents(eventBus, httpLogger))
+			r.Get("/orders/stream", handlers.StreamOrders(eventBus, httpLogger))
+			r.Get("/orders/{orderID}/events", handlers.GetOrderStatusEvents(dbInstance, httpLogger))
+			// история статусов хранится в order_events, /history — синоним /events
+			r.Get("/orders/{orderID}/history", handlers.GetOrderStatusEvents(dbInstance, httpLogger))
+			r.Get("/withdrawals", handlers.GetWithdrawals(dbInstance, httpLogger))
+			r.Post("/webhooks", handlers.SetWebhook(dbInstance, httpLogger))
+			r.Get("/webhooks/{webhookID}/deliveries", handlers.GetWebhookDeliveries(dbInstance, httpLogger))
+			r.Get("/profile", handlers.GetUserProfile(dbInstance, httpLogger))
+			r.Delete("/account", handlers.DeleteAccount(dbInstance, httpLogger))
+		})
+
+		r.Route("/balance", func(r chi.Router) {
+			r.Group(func(r chi.Router) {
+				r.Use(auth.Middleware(dbInstance))
+				r.Get("/", handlers.GetBonusesAmount(dbInstance, httpLogger))
+				r.Get("/history", handlers.GetBalanceHistory(dbInstance, httpLogger))
+				r.With(handlers.Idempotent(dbInstance, httpLogger)).
+					Post("/withdraw", handlers.WithdrawBonuses(dbInstance, configuration.MaxWithdrawalSum, httpLogger))
+			})
+		})
+	})
+
+	if configuration.LogLevelAccess != "off" {
+		levelHandler, err := logLevelHandler(logger, configuration)
+		if err != nil {
+			return nil, fmt.Errorf("newRouter: error building log level handler: %w", err)
+		}
+
+		levelAccess := middleware.LoopbackOnly
+		if configuration.LogLevelAccess == "admin" {
+			levelAccess = auth.AdminMiddleware(configuration.AdminKey)
+		}
+		r.With(levelAccess).Method(http.MethodGet, "/debug/loglevel", levelHandler)
+		r.With(levelAccess).Method(http.MethodPut, "/debug/loglevel", levelHandler)
+	}
+
+	if configuration.AdminKey != "" {
+		statsLocation, err := time.LoadLocation(configuration.StatsTimeZone)
+		if err != nil {
+			return nil, fmt.Errorf("newRouter: error loading stats time zone: %w", err)
+		}
+
+		r.Route("/api/admin", func(r chi.Router) {
+			r.Use(auth.AdminMiddleware(configuration.AdminKey))
+			r.Put("/orders/{orderID}/status", handlers.AdminUpdateOrderStatus(dbInstance, httpLogger))
+			r.Get("/stats", handlers.GetSystemStats(dbInstance, httpLogger))
+			r.Get("/stats/daily", handlers.GetDailyStats(dbInstance, statsLocation, httpLogger))
+			r.Get("/orders/stuck", handlers.GetStuckOrders(dbInstance, configuration.StuckOrderAge, httpLogger))
+			r.Get("/orders/dead", handlers.ListDeadOrders(dbInstance, httpLogger))
+			r.Post("/orders/{orderID}/requeue", handlers.RequeueOrder(dbInstance, httpLogger))
+			r.Get("/balances/reconciliation", handlers.GetBalanceReconciliation(dbInstance, httpLogger))
+			r.Post("/balances/{userID}/rebuild", handlers.RebuildBalance(dbInstance, httpLogger))
+			r.Get("/users", handlers.SearchUsers(dbInstance, httpLogger))
+			r.Get("/users/{userID}/orders", handlers.GetUserOrders(dbInstance, httpLogger))
+			r.Get("/users/{userID}/balance", handlers.GetUserBalance(dbInstance, httpLogger))
+			r.Post("/users/{userID}/balance/adjust", handlers.AdjustBalance(dbInstance, httpLogger))
+		})
+	}
+
+	// за шлюзом API монтируется под -base-path: префикс отрезается до роутера, поэтому маршруты
+	// и проверка по спецификации работают с путями без него
+	var handler http.Handler = r
+	if configuration.BasePath != "" {
+		handler = http.StripPrefix(configuration.BasePath, r)
+	}
+	return handler, nil
+}
+
+func runDebugServer(ctx context.Context, address string, logger logger.Logger) {
+	server := &http.Server{
+		Addr:              address,
+		Handler:           diagnostics.Handler(),
+		ReadHeaderTimeout: time.Second * 5,
+	}
+
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Error("error shutting down debug server", zap.Error(err))
+		}
+	}()
+
+	if err := server.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		logger.Error("error starting debug server", zap.Error(err))
+	}
+}
+
+// Сведения о сборке задаются при компоновке:
+// go build -ldflags "-X main.version=1.0.0 -X main.commit=$(git rev-parse --short HEAD) -X main.buildTime=$(date -u +%Y-%m-%dT%H:%M:%SZ)"
+var (
+	version   = "dev"
+	commit    = "unknown"
+	buildTime = ""
+)
+
+const (
+	idempotencyKeyCleanupPeriod = time.Hour
+	shutdownTimeout             = time.Second * 10
+	maxExecutorBackoff          = time.Minute
+)
 
 func main() {
 	configuration, err := config.BuildServer()
@@ -33,52 +250,226 @@ func main() {
 		log.Fatalf("error building server  configuration: %v", err)
 	}
 
-	err = auth.SetSecretKey(configuration.JWTSecretKey)
+	err = auth.SetKeys(configuration.JWTSecretKey, configuration.JWTFallbackKeys)
 	if err != nil {
 		log.Fatalf("failed setting jwt auth key: %v", err)
 	}
 
-	logger, err := logger.NewLogger("debug")
+	// config.Validate уже проверил значение
+	cookieSameSite, _ := auth.ParseSameSite(configuration.CookieSameSite)
+	auth.Configure(auth.CookieOptions{
+		SameSite: cookieSameSite,
+		Secure:   configuration.CookieSecure || configuration.EnableHTTPS,
+	})
+
+	logger, err := logger.NewLogger(configuration.LogLevel, configuration.LogFormat, logger.OutputConfig{
+		Output:     configuration.LogOutput,
+		FilePath:   configuration.LogFile,
+		MaxSizeMB:  configuration.LogFileMaxSizeMB,
+		MaxBackups: configuration.LogFileMaxBackups,
+		MaxAgeDays: configuration.LogFileMaxAgeDays,
+	})
 
 	if err != nil {
 		log.Fatalf("failed to create logger: %v", err)
 	}
+	defer logger.Sync()
+
+	buildInfo := models.APIVersionResponse{Version: version, Commit: commit, BuildTime: buildTime}
+	logger.Info("gophermart build", zap.String("version", version), zap.String("commit", commit), zap.String("build_time", buildTime))
+
+	shutdownTracing, err := tracing.Init(context.Background(), configuration.OTLPEndpoint, version)
+	if err != nil {
+		logger.Fatal("error initializing tracing", zap.Error(err))
+	}
+
+	eventBus := events.NewEventBus()
+
+	financialTxIsolation, err := storage.ParseIsolationLevel(configuration.FinancialTxIsolation)
+	if err != nil {
+		logger.Fatal("error parsing financial transaction isolation level", zap.Error(err))
+	}
+
+	webhookNotifier := webhooks.NewNotifier(configuration.WebhookTimeout, configuration.WebhookMaxRetries,
+		configuration.WebhookWorkers, configuration.WebhookSecret, logger.With(zap.String("component", "webhooks")))
+
+	accrualClient, err := accrual.NewClient(configuration.AccrualSystemAddress,
+		accrual.WithRateLimit(configuration.AccrualRateLimitRPS, configuration.AccrualRateLimitBurst))
+	if err != nil {
+		logger.Fatal("error creating accrual system client", zap.Error(err))
+	}
+	accrualBreaker := accrual.NewCircuitBreaker(accrualClient, configuration.AccrualBreakerThreshold,
+		configuration.AccrualBreakerCoolDown, logger.With(zap.String("component", "accrual")))
+	diagnostics.SetCircuitBreaker(accrualBreaker)
+
+	storageOptions := []storage.Option{
+		storage.WithPoolLimits(configuration.DBMaxConns, configuration.DBMinConns, configuration.DBConnMaxLifetime),
+		storage.WithReadReplica(configuration.ReadReplicaURI),
+		storage.WithAccrualClient(accrualBreaker),
+		storage.WithAccrualWorkers(configuration.AccrualWorkers),
+		storage.WithAccrualRequestTimeout(configuration.AccrualRequestTimeout),
+		storage.WithAccrualBatchSize(configuration.AccrualBatchSize),
+		storage.WithPendingOrdersBatch(configuration.PendingOrdersBatchSize, configuration.OrderCheckCooldown),
+		storage.WithMaxUpdateAttempts(configuration.MaxUpdateAttempts),
+		storage.WithUpdaterTimeouts(configuration.UpdateCycleTimeout, configuration.UpdaterStatementTimeout),
+		storage.WithFinancialTxIsolation(financialTxIsolation),
+		storage.WithWebhookNotifier(webhookNotifier),
+		storage.WithIdempotencyKeyTTL(configuration.IdempotencyKeyTTL),
+		storage.WithBalanceDiscrepancyThreshold(configuration.BalanceDiscrepancyThreshold),
+		storage.WithQueryTimeout(configuration.DBQueryTimeout),
+		storage.WithStatementTimeout(configuration.DBStatementTimeout),
+		storage.WithOrderOwnerCache(configuration.OrderOwnerCacheSize, configuration.OrderOwnerCacheTTL),
+		storage.WithSlowQueryLog(configuration.SlowQueryThreshold, logger.With(zap.String("component", "storage"))),
+	}
+
+	var dispatchQueue *updater.Queue
+	if configuration.DispatchQueueSize > 0 {
+		dispatchQueue = updater.NewQueue(configuration.DispatchQueueSize)
+		storageOptions = append(storageOptions, storage.WithDispatchQueue(dispatchQueue))
+	}
+
+	dbInstance, err := storage.Initialize(configuration.DatabaseURI, eventBus, storageOptions...)
 
-	dbInstance, err := storage.Initialize(configuration.DatabaseURI)
 	if err != nil {
 		logger.Fatal("error initialising database", zap.Error(err))
 	}
+	diagnostics.SetUpdateResults(dbInstance)
+	diagnostics.SetBalanceInvariant(dbInstance)
+
+	// баланс создается лениво при первой операции, здесь только сообщаем о пропавших строках
+	if missing, err := dbInstance.UsersWithoutBalance(context.Background()); err != nil {
+		logger.Warn("error checking users without balance", zap.Error(err))
+	} else if len(missing) > 0 {
+		logger.Warn("users without balance row found", zap.Int("count", len(missing)), zap.Strings("users", missing))
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	logger.Info("starting database health check")
+	go dbInstance.RunHealthCheck(ctx, configuration.DBHealthCheckInterval, logger.With(zap.String("component", "db-health")))
+
+	logger.Info("starting webhook delivery workers")
+	go webhookNotifier.Run(ctx, dbInstance)
+
+	// pprof раскрывает внутреннее состояние процесса (стеки горутин, heap, командную строку
+	// с секретами из флагов) и позволяет нагрузить сервер профилированием, поэтому отладочные
+	// обработчики доступны только на отдельном адресе -debug-address / DEBUG_ADDRESS.
+	if configuration.DebugAddress != "" {
+		logger.Info("starting debug server", zap.String("address", configuration.DebugAddress))
+		go diagnostics.RunDBStatsPublisher(ctx, dbInstance, configuration.DBStatsInterval)
+		go runDebugServer(ctx, configuration.DebugAddress, logger)
+	}
 
 	logger.Info("starting periodic update order numbers executor")
-	ctx := context.Background()
-	go periodicUpdateExecutor(ctx, orderUpdaterPeriod, configuration.AccrualSystemAddress, dbInstance.HandleOrderNumbers, logger)
+	updaterLogger := logger.With(zap.String("component", "updater"))
+	storage.SetPollIntervalBounds(configuration.AccrualPollInterval, configuration.AccrualMaxPollInterval)
+	// Немедленные обновления переживают отмену ctx: после остановки HTTP-сервера очередь
+	// закрывается и дорабатывается, но не дольше shutdownTimeout.
+	dispatchCtx, cancelDispatch := context.WithCancel(context.Background())
+	defer cancelDispatch()
+	dispatchDone := make(chan struct{})
+	go func() {
+		defer close(dispatchDone)
+		dbInstance.RunDispatchedUpdates(dispatchCtx, updaterLogger)
+	}()
 
-	logger.Info("running server", zap.String("address", configuration.ServerRunAddress))
-	r := chi.NewRouter()
+	go periodicUpdateExecutor(ctx, storage.PollInterval, func(ctx context.Context) error {
+		err := dbInstance.HandleOrderNumbers(ctx, updaterLogger)
+		if err != nil {
+			updaterLogger.Error("error updating order statuses", zap.Error(err))
+		}
+		return err
+	})
 
-	r.Route("/api/user", func(r chi.Router) {
-		r.Group(func(r chi.Router) {
-			r.Post("/register", handlers.RegisterUser(dbInstance, logger))
-			r.Post("/login", handlers.AuthenticateUser(dbInstance, logger))
-		})
-		r.Group(func(r chi.Router) {
-			r.Use(auth.Middleware)
-			r.Post("/orders", handlers.AddOrder(dbInstance, logger))
-			r.Get("/orders", handlers.GetOrdersList(dbInstance, logger))
-			r.Get("/withdrawals", handlers.GetWithdrawals(dbInstance, logger))
-		})
+	logger.Info("starting idempotency keys cleanup executor")
+	go periodicUpdateExecutor(ctx, fixedInterval(idempotencyKeyCleanupPeriod), func(ctx context.Context) error {
+		deleted, err := dbInstance.DeleteExpiredIdempotencyKeys(ctx)
+		if err != nil {
+			logger.Error("error deleting expired idempotency keys", zap.Error(err))
+			return err
+		}
+		logger.Debug("expired idempotency keys deleted", zap.Int64("count", deleted))
+		return nil
+	})
 
-		r.Route("/balance", func(r chi.Router) {
-			r.Group(func(r chi.Router) {
-				r.Use(auth.Middleware)
-				r.Get("/", handlers.GetBonusesAmount(dbInstance, logger))
-				r.Post("/withdraw", handlers.WithdrawBonuses(dbInstance, logger))
-			})
+	if configuration.BalanceReconcileInterval > 0 {
+		logger.Info("starting balance reconciliation executor")
+		reconcileLogger := logger.With(zap.String("component", "reconciliation"))
+		go periodicUpdateExecutor(ctx, fixedInterval(configuration.BalanceReconcileInterval), func(ctx context.Context) error {
+			negative, err := dbInstance.NegativeBalances(ctx)
+			if err != nil {
+				reconcileLogger.Error("error checking negative balances", zap.Error(err))
+				return err
+			}
+			if len(negative) > 0 {
+				reconcileLogger.Error("ALERT negative balances found", zap.Int("count", len(negative)),
+					zap.Strings("users", negative))
+			}
+
+			userIDs, err := dbInstance.ReconcileBalances(ctx)
+			if err != nil {
+				reconcileLogger.Error("error reconciling balances", zap.Error(err))
+				return err
+			}
+			if len(userIDs) > 0 {
+				reconcileLogger.Warn("balances differ from accruals minus withdrawals", zap.Int("count", len(userIDs)),
+					zap.Strings("users", userIDs), zap.Float64("threshold", configuration.BalanceDiscrepancyThreshold))
+				return nil
+			}
+			reconcileLogger.Debug("balances reconciled")
+			return nil
 		})
-	})
+	}
 
-	err = http.ListenAndServe(configuration.ServerRunAddress, r)
+	logger.Info("running server", zap.String("address", configuration.ServerRunAddress), zap.String("base_path", configuration.BasePath),
+		zap.Bool("https", configuration.EnableHTTPS))
+	handler, err := newRouter(configuration, dbInstance, eventBus, buildInfo, logger)
 	if err != nil {
+		logger.Fatal("error building router", zap.Error(err))
+	}
+	if err := openapi.SetBasePath(configuration.BasePath); err != nil {
+		logger.Fatal("error applying base path to openapi spec", zap.Error(err))
+	}
+
+	server, err := newServer(configuration, handler)
+	if err != nil {
+		logger.Fatal("error configuring server", zap.Error(err))
+	}
+
+	shutdownDone := make(chan struct{})
+	go func() {
+		defer close(shutdownDone)
+		<-ctx.Done()
+		logger.Info("shutting down server")
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+		defer cancel()
+		if err := server.Shutdown(shutdownCtx); err != nil {
+			logger.Error("error shutting down server", zap.Error(err))
+		}
+
+		if dispatchQueue != nil {
+			dispatchQueue.Close()
+		}
+		select {
+		case <-dispatchDone:
+		case <-shutdownCtx.Done():
+			logger.Warn("dispatched order updates were not drained before shutdown timeout")
+			cancelDispatch()
+		}
+
+		if err := shutdownTracing(shutdownCtx); err != nil {
+			logger.Error("error flushing traces", zap.Error(err))
+		}
+	}()
+
+	if configuration.EnableHTTPS {
+		err = server.ListenAndServeTLS("", "")
+	} else {
+		err = server.ListenAndServe()
+	}
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
 		logger.Fatal("error starting server", zap.Error(err))
 	}
+	<-shutdownDone
 }
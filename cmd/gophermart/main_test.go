@@ -0,0 +1,253 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"github.com/vancho-go/gophermart/internal/app/auth"
+	"github.com/vancho-go/gophermart/internal/app/config"
+	"github.com/vancho-go/gophermart/internal/app/diagnostics"
+	"github.com/vancho-go/gophermart/internal/app/events"
+	"github.com/vancho-go/gophermart/internal/app/handlers"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"github.com/vancho-go/gophermart/internal/app/storage"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+// activeUsers считает всех пользователей активными.
+var activeUsers = auth.UserCheckerFunc(func(context.Context, string) (bool, error) {
+	return true, nil
+})
+
+// startTestServer запускает newServer на свободном порту loopback и возвращает его адрес.
+func startTestServer(t *testing.T, configuration config.ServerConfig, handler http.Handler) string {
+	t.Helper()
+
+	server, err := newServer(configuration, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go server.Serve(listener)
+	t.Cleanup(func() {
+		server.Close()
+	})
+	return listener.Addr().String()
+}
+
+// TestServerDropsSlowHeaders проверяет, что соединение, не передавшее заголовки за
+// ReadHeaderTimeout (slowloris), закрывается сервером.
+func TestServerDropsSlowHeaders(t *testing.T) {
+	const readHeaderTimeout = time.Millisecond * 200
+
+	configuration := config.ServerConfig{ReadHeaderTimeout: readHeaderTimeout, MaxHeaderBytes: http.DefaultMaxHeaderBytes}
+	address := startTestServer(t, configuration, http.HandlerFunc(func(res http.ResponseWriter, _ *http.Request) {
+		res.WriteHeader(http.StatusOK)
+	}))
+
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// заголовки передаются не полностью, пустая строка в конце так и не приходит
+	if _, err = io.WriteString(conn, "GET / HTTP/1.1\r\nHost: localhost\r\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	started := time.Now()
+	conn.SetReadDeadline(started.Add(readHeaderTimeout * 10))
+	_, err = io.ReadAll(conn)
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		t.Fatalf("connection is still open %s after the header timeout", time.Since(started))
+	}
+	if elapsed := time.Since(started); elapsed < readHeaderTimeout/2 {
+		t.Errorf("connection closed after %s, before the header timeout", elapsed)
+	}
+}
+
+// TestServerKeepsEventStreamPastWriteTimeout проверяет, что SSE-поток на сервере из newServer
+// не обрывается по WriteTimeout: событие, опубликованное позже таймаута, доходит до клиента.
+func TestServerKeepsEventStreamPastWriteTimeout(t *testing.T) {
+	const writeTimeout = time.Millisecond * 200
+
+	if err := auth.SetKeys("server-test-key", nil); err != nil {
+		t.Fatal(err)
+	}
+	bus := events.NewEventBus()
+	configuration := config.ServerConfig{
+		ReadHeaderTimeout: time.Second,
+		WriteTimeout:      writeTimeout,
+		MaxHeaderBytes:    http.DefaultMaxHeaderBytes,
+	}
+	address := startTestServer(t, configuration, auth.Middleware(activeUsers)(handlers.GetOrderEvents(bus, logger.NewNopLogger())))
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+address+"/api/v1/user/orders/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie, err := auth.GenerateCookie("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(cookie)
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+
+	time.Sleep(writeTimeout * 2)
+	bus.Publish("user-1", models.APIOrderStatusEvent{Number: "12345678903", Status: models.OrderStatusProcessed})
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	deadline := time.After(time.Second * 5)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("stream closed before the event was delivered")
+			}
+			if strings.HasPrefix(line, "data: ") && strings.Contains(line, "12345678903") {
+				return
+			}
+		case <-deadline:
+			t.Fatal("event was not delivered")
+		}
+	}
+}
+
+func TestNewServerRejectsMissingCertificate(t *testing.T) {
+	configuration := config.ServerConfig{EnableHTTPS: true, TLSCertFile: "missing.crt", TLSKeyFile: "missing.key"}
+	if _, err := newServer(configuration, http.NotFoundHandler()); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("error = %v, want a missing file error", err)
+	}
+}
+
+// newTestRouter собирает newRouter без базы данных: маршруты, которые проверяются тестами,
+// до хранилища не доходят.
+func newTestRouter(t *testing.T, configuration config.ServerConfig) http.Handler {
+	t.Helper()
+
+	configuration.LogLevelAccess = "off"
+	handler, err := newRouter(configuration, &storage.Storage{}, events.NewEventBus(), models.APIVersionResponse{}, logger.NewNopLogger())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return handler
+}
+
+func TestRouterRedirectsLegacyUserAPI(t *testing.T) {
+	tests := []struct {
+		name          string
+		configuration config.ServerConfig
+		method        string
+		target        string
+		wantStatus    int
+		wantLocation  string
+	}{
+		{name: "get keeps query", configuration: config.ServerConfig{APIVersion: "v1"}, method: http.MethodGet,
+			target: "/api/user/orders?status=NEW", wantStatus: http.StatusMovedPermanently, wantLocation: "/api/v1/user/orders?status=NEW"},
+		{name: "head", configuration: config.ServerConfig{APIVersion: "v1"}, method: http.MethodHead,
+			target: "/api/user/balance", wantStatus: http.StatusMovedPermanently, wantLocation: "/api/v1/user/balance"},
+		{name: "post keeps method", configuration: config.ServerConfig{APIVersion: "v1"}, method: http.MethodPost,
+			target: "/api/user/orders", wantStatus: http.StatusPermanentRedirect, wantLocation: "/api/v1/user/orders"},
+		{name: "nested path", configuration: config.ServerConfig{APIVersion: "v1"}, method: http.MethodPost,
+			target: "/api/user/balance/withdraw", wantStatus: http.StatusPermanentRedirect, wantLocation: "/api/v1/user/balance/withdraw"},
+		{name: "prefix itself", configuration: config.ServerConfig{APIVersion: "v1"}, method: http.MethodGet,
+			target: "/api/user", wantStatus: http.StatusMovedPermanently, wantLocation: "/api/v1/user"},
+		{name: "custom api version", configuration: config.ServerConfig{APIVersion: "v2"}, method: http.MethodGet,
+			target: "/api/user/orders", wantStatus: http.StatusMovedPermanently, wantLocation: "/api/v2/user/orders"},
+		{name: "base path", configuration: config.ServerConfig{APIVersion: "v1", BasePath: "/gophermart"}, method: http.MethodGet,
+			target: "/gophermart/api/user/orders", wantStatus: http.StatusMovedPermanently, wantLocation: "/gophermart/api/v1/user/orders"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newTestRouter(t, tt.configuration)
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, httptest.NewRequest(tt.method, tt.target, strings.NewReader("12345678903")))
+
+			if res.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", res.Code, tt.wantStatus)
+			}
+			if location := res.Header().Get("Location"); location != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", location, tt.wantLocation)
+			}
+		})
+	}
+}
+
+// TestRouterMountsVersionedUserAPI проверяет, что пользовательские маршруты доступны под
+// /api/{APIVersion}/user и только под ним.
+func TestRouterMountsVersionedUserAPI(t *testing.T) {
+	tests := []struct {
+		name       string
+		apiVersion string
+		target     string
+		wantStatus int
+	}{
+		{name: "v1", apiVersion: "v1", target: "/api/v1/user/orders", wantStatus: http.StatusUnauthorized},
+		{name: "balance", apiVersion: "v1", target: "/api/v1/user/balance/", wantStatus: http.StatusUnauthorized},
+		{name: "custom version", apiVersion: "v2", target: "/api/v2/user/orders", wantStatus: http.StatusUnauthorized},
+		{name: "other version", apiVersion: "v2", target: "/api/v1/user/orders", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := newTestRouter(t, config.ServerConfig{APIVersion: tt.apiVersion})
+			res := httptest.NewRecorder()
+			router.ServeHTTP(res, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			if res.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", res.Code, tt.wantStatus)
+			}
+		})
+	}
+}
+
+// TestDiagnosticsOnlyOnDebugListener проверяет, что pprof и expvar отдаются обработчиком
+// debug-листенера и не смонтированы в публичном роутере.
+func TestDiagnosticsOnlyOnDebugListener(t *testing.T) {
+	router := newTestRouter(t, config.ServerConfig{APIVersion: "v1"})
+	debug := diagnostics.Handler()
+
+	for _, target := range []string{"/debug/pprof/goroutine?debug=1", "/debug/pprof/", "/debug/vars"} {
+		t.Run(target, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			debug.ServeHTTP(res, httptest.NewRequest(http.MethodGet, target, nil))
+			if res.Code != http.StatusOK {
+				t.Errorf("debug listener status = %d, want %d", res.Code, http.StatusOK)
+			}
+
+			res = httptest.NewRecorder()
+			router.ServeHTTP(res, httptest.NewRequest(http.MethodGet, target, nil))
+			if res.Code != http.StatusNotFound {
+				t.Errorf("main router status = %d, want %d", res.Code, http.StatusNotFound)
+			}
+		})
+	}
+}
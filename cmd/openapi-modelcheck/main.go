@@ -0,0 +1,92 @@
+// Команда openapi-modelcheck сверяет свойства схем спецификации API с JSON-тегами
+// структур пакета models и завершается с ошибкой при расхождении. Запускается в CI.
+package main
+
+import (
+	"fmt"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"github.com/vancho-go/gophermart/internal/app/openapi"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+)
+
+// schemaModels сопоставляет схемы из components/schemas с моделями, которые их сериализуют.
+var schemaModels = map[string]interface{}{
+	"Credentials":                   models.APIAuthRequest{},
+	"RegisterRequest":               models.APIRegisterRequest{},
+	"UserProfile":                   models.UserProfile{},
+	"AddOrderRequest":               models.APIAddOrderJSONRequest{},
+	"Order":                         models.APIGetOrderResponse{},
+	"OrderBatchResult":              models.APIOrderBatchResult{},
+	"OrderStatusEvent":              models.APIOrderStatusEvent{},
+	"OrderStreamMessage":            models.APIOrderStreamMessage{},
+	"Balance":                       models.APIGetBonusesAmountResponse{},
+	"WithdrawRequest":               models.APIUseBonusesRequest{},
+	"Withdrawal":                    models.APIGetWithdrawalsHistoryResponse{},
+	"WebhookRequest":                models.APIWebhookRequest{},
+	"WebhookResponse":               models.APIWebhookResponse{},
+	"WebhookDelivery":               models.WebhookDelivery{},
+	"AdminUpdateOrderStatusRequest": models.APIAdminUpdateOrderStatusRequest{},
+	"SystemStats":                   models.SystemStats{},
+	"StuckOrder":                    models.StuckOrder{},
+	"DeadOrder":                     models.DeadOrder{},
+	"BalanceDiscrepancy":            models.BalanceDiscrepancy{},
+	"RebuildBalanceResponse":        models.APIRebuildBalanceResponse{},
+	"AdjustBalanceRequest":          models.APIAdjustBalanceRequest{},
+	"AdjustBalanceResponse":         models.APIAdjustBalanceResponse{},
+	"DailyStats":                    models.DailyStats{},
+	"Ping":                          models.APIPingResponse{},
+	"Version":                       models.APIVersionResponse{},
+	"DeleteAccountRequest":          models.APIDeleteAccountRequest{},
+}
+
+func main() {
+	doc, err := openapi.Load()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	var problems []string
+	for name, model := range schemaModels {
+		schema, ok := doc.Components.Schemas[name]
+		if !ok {
+			problems = append(problems, fmt.Sprintf("schema %s is missing", name))
+			continue
+		}
+
+		specFields := make(map[string]bool)
+		for property := range schema.Value.Properties {
+			specFields[property] = true
+		}
+		modelFields := jsonFields(reflect.TypeOf(model))
+
+		for field := range modelFields {
+			if !specFields[field] {
+				problems = append(problems, fmt.Sprintf("%s: field %q is not described in the spec", name, field))
+			}
+		}
+		for field := range specFields {
+			if !modelFields[field] {
+				problems = append(problems, fmt.Sprintf("%s: property %q has no field in the model", name, field))
+			}
+		}
+	}
+
+	if len(problems) > 0 {
+		sort.Strings(problems)
+		log.Fatalf("openapi spec does not match models:\n%s", strings.Join(problems, "\n"))
+	}
+}
+
+func jsonFields(t reflect.Type) map[string]bool {
+	fields := make(map[string]bool)
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("json"), ",")
+		if name != "" && name != "-" {
+			fields[name] = true
+		}
+	}
+	return fields
+}
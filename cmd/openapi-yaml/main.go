@@ -0,0 +1,26 @@
+// Команда openapi-yaml записывает спецификацию API в формате YAML в указанный файл.
+// Запускается через go generate в пакете internal/app/openapi.
+package main
+
+import (
+	"github.com/vancho-go/gophermart/internal/app/openapi"
+	"log"
+	"os"
+)
+
+const header = "# Файл сгенерирован из internal/app/openapi/openapi.json командой go generate, не редактировать.\n"
+
+func main() {
+	if len(os.Args) != 2 {
+		log.Fatalf("usage: %s <output file>", os.Args[0])
+	}
+
+	body, err := openapi.YAML()
+	if err != nil {
+		log.Fatalf("error converting openapi spec: %v", err)
+	}
+
+	if err = os.WriteFile(os.Args[1], append([]byte(header), body...), 0o644); err != nil {
+		log.Fatalf("error writing %s: %v", os.Args[1], err)
+	}
+}
@@ -0,0 +1,206 @@
+// Package accrualtest — поддельная accrual-система для проверки опроса статусов заказов без
+// настоящего бинарника. Ответы задаются сценарием для каждого номера заказа.
+package accrualtest
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Response — один ответ поддельной accrual-системы по заказу.
+type Response struct {
+	// StatusCode — HTTP-статус ответа; тело с OrderStatus и Accrual отправляется только для 200
+	StatusCode  int
+	OrderStatus string
+	Accrual     float64
+	// RetryAfter — значение заголовка Retry-After в секундах для ответа 429
+	RetryAfter int
+}
+
+func Registered() Response {
+	return Response{StatusCode: http.StatusOK, OrderStatus: "REGISTERED"}
+}
+
+func Processing() Response {
+	return Response{StatusCode: http.StatusOK, OrderStatus: "PROCESSING"}
+}
+
+func Invalid() Response {
+	return Response{StatusCode: http.StatusOK, OrderStatus: "INVALID"}
+}
+
+func Processed(accrual float64) Response {
+	return Response{StatusCode: http.StatusOK, OrderStatus: "PROCESSED", Accrual: accrual}
+}
+
+// NotRegistered — ответ 204 для заказа, неизвестного accrual-системе.
+func NotRegistered() Response {
+	return Response{StatusCode: http.StatusNoContent}
+}
+
+func TooManyRequests(retryAfter int) Response {
+	return Response{StatusCode: http.StatusTooManyRequests, RetryAfter: retryAfter}
+}
+
+func InternalError() Response {
+	return Response{StatusCode: http.StatusInternalServerError}
+}
+
+// Server — поддельная accrual-система на httptest.Server. Каждый запрос по заказу забирает
+// следующий ответ его сценария, последний ответ повторяется. Для заказа без сценария
+// отвечает 204. Безопасен для одновременных запросов воркеров.
+type Server struct {
+	*httptest.Server
+
+	mu       sync.Mutex
+	scripts  map[string][]Response
+	requests map[string]int
+	total    int
+	batch    bool
+}
+
+type ServerOption func(*Server)
+
+// WithBatch включает POST /api/orders/batch; без него пакетный запрос получает 404, и клиент
+// переходит на запросы по одному заказу.
+func WithBatch() ServerOption {
+	return func(s *Server) {
+		s.batch = true
+	}
+}
+
+func NewServer(opts ...ServerOption) *Server {
+	s := &Server{
+		scripts:  make(map[string][]Response),
+		requests: make(map[string]int),
+	}
+	for _, opt := range opts {
+		opt(s)
+	}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.serveHTTP))
+	return s
+}
+
+// Script задает последовательность ответов по заказу orderNumber, заменяя прежний сценарий.
+func (s *Server) Script(orderNumber string, responses ...Response) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.scripts[orderNumber] = responses
+}
+
+// Requests возвращает число запросов по заказу orderNumber, включая упоминания в пакетных запросах.
+func (s *Server) Requests(orderNumber string) int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.requests[orderNumber]
+}
+
+// TotalRequests возвращает число HTTP-запросов к серверу.
+func (s *Server) TotalRequests() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.total
+}
+
+// next возвращает очередной ответ по заказу и сдвигает его сценарий.
+func (s *Server) next(orderNumber string) Response {
+	s.requests[orderNumber]++
+	script := s.scripts[orderNumber]
+	if len(script) == 0 {
+		return NotRegistered()
+	}
+	if len(script) > 1 {
+		s.scripts[orderNumber] = script[1:]
+	}
+	return script[0]
+}
+
+func (s *Server) serveHTTP(res http.ResponseWriter, req *http.Request) {
+	s.mu.Lock()
+	s.total++
+	s.mu.Unlock()
+
+	path := strings.TrimPrefix(req.URL.Path, "/api/orders/")
+	switch {
+	case req.Method == http.MethodPost && path == "batch":
+		s.serveBatch(res, req)
+	case req.Method == http.MethodGet && path != "" && !strings.Contains(path, "/"):
+		s.mu.Lock()
+		response := s.next(path)
+		s.mu.Unlock()
+		writeResponse(res, path, response)
+	default:
+		http.NotFound(res, req)
+	}
+}
+
+// serveBatch отвечает на пакетный запрос заказами, чей очередной ответ — 200. Если у какого-либо
+// заказа очередной ответ 429 или 500, он становится ответом на весь пакет.
+func (s *Server) serveBatch(res http.ResponseWriter, req *http.Request) {
+	if !s.batch {
+		http.NotFound(res, req)
+		return
+	}
+
+	var orderNumbers []string
+	if err := json.NewDecoder(req.Body).Decode(&orderNumbers); err != nil {
+		http.Error(res, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	s.mu.Lock()
+	found := make([]orderInfo, 0, len(orderNumbers))
+	var failure *Response
+	for _, orderNumber := range orderNumbers {
+		response := s.next(orderNumber)
+		switch response.StatusCode {
+		case http.StatusOK:
+			found = append(found, newOrderInfo(orderNumber, response))
+		case http.StatusNoContent:
+		default:
+			if failure == nil {
+				failure = &response
+			}
+		}
+	}
+	s.mu.Unlock()
+
+	switch {
+	case failure != nil:
+		writeResponse(res, "", *failure)
+	case len(found) == 0:
+		res.WriteHeader(http.StatusNoContent)
+	default:
+		res.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(res).Encode(found)
+	}
+}
+
+type orderInfo struct {
+	Order   string  `json:"order"`
+	Status  string  `json:"status"`
+	Accrual float64 `json:"accrual,omitempty"`
+}
+
+func newOrderInfo(orderNumber string, response Response) orderInfo {
+	return orderInfo{Order: orderNumber, Status: response.OrderStatus, Accrual: response.Accrual}
+}
+
+func writeResponse(res http.ResponseWriter, orderNumber string, response Response) {
+	switch response.StatusCode {
+	case http.StatusOK:
+		res.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(res).Encode(newOrderInfo(orderNumber, response))
+	case http.StatusTooManyRequests:
+		if response.RetryAfter > 0 {
+			res.Header().Set("Retry-After", strconv.Itoa(response.RetryAfter))
+		}
+		http.Error(res, "No more than N requests per minute allowed", http.StatusTooManyRequests)
+	default:
+		res.WriteHeader(response.StatusCode)
+	}
+}
@@ -0,0 +1,148 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen возвращается без обращения к accrual-системе, пока цепь разомкнута.
+var ErrCircuitOpen = errors.New("accrual system circuit breaker is open")
+
+const (
+	CircuitClosed   = "closed"
+	CircuitOpen     = "open"
+	CircuitHalfOpen = "half-open"
+)
+
+type orderInfoGetter interface {
+	GetOrderInfo(ctx context.Context, orderNumber string) (*models.APIOrderInfoResponse, error)
+	BatchGetOrderInfo(ctx context.Context, orderNumbers []string) ([]models.APIOrderInfoResponse, error)
+}
+
+// CircuitBreaker размыкает цепь после threshold ошибок accrual-системы подряд: в течение coolDown
+// запросы сразу завершаются ErrCircuitOpen, затем пропускается один пробный запрос, успех которого
+// замыкает цепь, а ошибка размыкает ее снова.
+type CircuitBreaker struct {
+	client    orderInfoGetter
+	threshold int
+	coolDown  time.Duration
+	logger    logger.Logger
+	now       func() time.Time
+
+	mu       sync.Mutex
+	state    string
+	failures int
+	openedAt time.Time
+	probing  bool
+}
+
+func NewCircuitBreaker(client orderInfoGetter, threshold int, coolDown time.Duration, logger logger.Logger) *CircuitBreaker {
+	return &CircuitBreaker{
+		client:    client,
+		threshold: threshold,
+		coolDown:  coolDown,
+		logger:    logger,
+		now:       time.Now,
+		state:     CircuitClosed,
+	}
+}
+
+// State возвращает состояние цепи: closed, open или half-open.
+func (b *CircuitBreaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.currentState()
+}
+
+func (b *CircuitBreaker) GetOrderInfo(ctx context.Context, orderNumber string) (*models.APIOrderInfoResponse, error) {
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+	orderInfo, err := b.client.GetOrderInfo(ctx, orderNumber)
+	b.record(ctx, err)
+	return orderInfo, err
+}
+
+func (b *CircuitBreaker) BatchGetOrderInfo(ctx context.Context, orderNumbers []string) ([]models.APIOrderInfoResponse, error) {
+	if err := b.allow(); err != nil {
+		return nil, err
+	}
+	ordersInfo, err := b.client.BatchGetOrderInfo(ctx, orderNumbers)
+	b.record(ctx, err)
+	return ordersInfo, err
+}
+
+// currentState переводит разомкнутую цепь в half-open по истечении coolDown, вызывается под mu.
+func (b *CircuitBreaker) currentState() string {
+	if b.state == CircuitOpen && b.now().Sub(b.openedAt) >= b.coolDown {
+		b.setState(CircuitHalfOpen)
+	}
+	return b.state
+}
+
+func (b *CircuitBreaker) allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	switch b.currentState() {
+	case CircuitOpen:
+		return ErrCircuitOpen
+	case CircuitHalfOpen:
+		if b.probing {
+			return ErrCircuitOpen
+		}
+		b.probing = true
+	}
+	return nil
+}
+
+func (b *CircuitBreaker) record(ctx context.Context, err error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	halfOpen := b.state == CircuitHalfOpen
+	b.probing = false
+
+	switch {
+	case err != nil && errors.Is(ctx.Err(), context.Canceled):
+		// запрос прерван вызывающим, о состоянии accrual-системы это ничего не говорит
+	case isAccrualFailure(err):
+		b.failures++
+		if halfOpen || b.failures >= b.threshold {
+			b.openedAt = b.now()
+			b.setState(CircuitOpen)
+		}
+	default:
+		b.failures = 0
+		if halfOpen {
+			b.setState(CircuitClosed)
+		}
+	}
+}
+
+// setState логирует только смену состояния, вызывается под mu.
+func (b *CircuitBreaker) setState(state string) {
+	if b.state == state {
+		return
+	}
+	if state == CircuitOpen {
+		b.logger.Error("accrual system circuit breaker opened", zap.Int("failures", b.failures), zap.Duration("cool_down", b.coolDown))
+	} else {
+		b.logger.Info("accrual system circuit breaker state changed", zap.String("from", b.state), zap.String("to", state))
+	}
+	b.state = state
+}
+
+// isAccrualFailure отличает недоступность accrual-системы от ответов, которые она дает в штатном
+// режиме: 429, незарегистрированный заказ, отсутствие пакетного запроса.
+func isAccrualFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	return !errors.Is(err, ErrTooManyRequests) && !errors.Is(err, ErrOrderNotRegistered) && !errors.Is(err, ErrBatchNotSupported)
+}
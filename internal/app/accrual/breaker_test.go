@@ -0,0 +1,199 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"testing"
+	"time"
+)
+
+var errUnavailable = errors.New("accrual system is unavailable")
+
+// fakeOrderInfoGetter отвечает ошибкой err и считает дошедшие до него запросы.
+type fakeOrderInfoGetter struct {
+	err   error
+	calls int
+}
+
+func (f *fakeOrderInfoGetter) GetOrderInfo(_ context.Context, orderNumber string) (*models.APIOrderInfoResponse, error) {
+	f.calls++
+	if f.err != nil {
+		return nil, f.err
+	}
+	return &models.APIOrderInfoResponse{Order: orderNumber, Status: models.OrderStatusProcessed}, nil
+}
+
+func (f *fakeOrderInfoGetter) BatchGetOrderInfo(_ context.Context, _ []string) ([]models.APIOrderInfoResponse, error) {
+	f.calls++
+	return nil, f.err
+}
+
+// fakeClock — управляемые часы для CircuitBreaker.now.
+type fakeClock struct {
+	current time.Time
+}
+
+func (c *fakeClock) now() time.Time {
+	return c.current
+}
+
+func (c *fakeClock) advance(d time.Duration) {
+	c.current = c.current.Add(d)
+}
+
+const (
+	testThreshold = 3
+	testCoolDown  = time.Second * 30
+)
+
+func newTestBreaker() (*CircuitBreaker, *fakeOrderInfoGetter, *fakeClock) {
+	client := &fakeOrderInfoGetter{}
+	clock := &fakeClock{current: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)}
+	breaker := NewCircuitBreaker(client, testThreshold, testCoolDown, logger.NewNopLogger())
+	breaker.now = clock.now
+	return breaker, client, clock
+}
+
+// openBreaker доводит цепь до размыкания ошибками accrual-системы.
+func openBreaker(t *testing.T, breaker *CircuitBreaker, client *fakeOrderInfoGetter) {
+	t.Helper()
+	client.err = errUnavailable
+	for i := 0; i < testThreshold; i++ {
+		if _, err := breaker.GetOrderInfo(context.Background(), "12345678903"); !errors.Is(err, errUnavailable) {
+			t.Fatalf("request %d error = %v, want %v", i+1, err, errUnavailable)
+		}
+	}
+	if state := breaker.State(); state != CircuitOpen {
+		t.Fatalf("state after %d failures = %s, want %s", testThreshold, state, CircuitOpen)
+	}
+}
+
+func TestCircuitBreakerOpensAfterThreshold(t *testing.T) {
+	breaker, client, _ := newTestBreaker()
+	client.err = errUnavailable
+
+	for i := 0; i < testThreshold-1; i++ {
+		_, _ = breaker.GetOrderInfo(context.Background(), "12345678903")
+	}
+	if state := breaker.State(); state != CircuitClosed {
+		t.Fatalf("state after %d failures = %s, want %s", testThreshold-1, state, CircuitClosed)
+	}
+
+	_, _ = breaker.GetOrderInfo(context.Background(), "12345678903")
+	if state := breaker.State(); state != CircuitOpen {
+		t.Fatalf("state after %d failures = %s, want %s", testThreshold, state, CircuitOpen)
+	}
+
+	calls := client.calls
+	if _, err := breaker.GetOrderInfo(context.Background(), "12345678903"); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("error while open = %v, want %v", err, ErrCircuitOpen)
+	}
+	if _, err := breaker.BatchGetOrderInfo(context.Background(), []string{"12345678903"}); !errors.Is(err, ErrCircuitOpen) {
+		t.Errorf("batch error while open = %v, want %v", err, ErrCircuitOpen)
+	}
+	if client.calls != calls {
+		t.Errorf("open breaker passed %d requests to the accrual system", client.calls-calls)
+	}
+}
+
+func TestCircuitBreakerIgnoresRegularResponses(t *testing.T) {
+	breaker, client, _ := newTestBreaker()
+
+	for _, err := range []error{ErrTooManyRequests, ErrOrderNotRegistered, ErrBatchNotSupported} {
+		client.err = err
+		for i := 0; i < testThreshold; i++ {
+			_, _ = breaker.GetOrderInfo(context.Background(), "12345678903")
+		}
+	}
+	if state := breaker.State(); state != CircuitClosed {
+		t.Errorf("state = %s, want %s", state, CircuitClosed)
+	}
+
+	client.err = errUnavailable
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	for i := 0; i < testThreshold; i++ {
+		_, _ = breaker.GetOrderInfo(ctx, "12345678903")
+	}
+	if state := breaker.State(); state != CircuitClosed {
+		t.Errorf("state after canceled requests = %s, want %s", state, CircuitClosed)
+	}
+}
+
+func TestCircuitBreakerSuccessResetsFailures(t *testing.T) {
+	breaker, client, _ := newTestBreaker()
+
+	client.err = errUnavailable
+	for i := 0; i < testThreshold-1; i++ {
+		_, _ = breaker.GetOrderInfo(context.Background(), "12345678903")
+	}
+	client.err = nil
+	_, _ = breaker.GetOrderInfo(context.Background(), "12345678903")
+	client.err = errUnavailable
+	_, _ = breaker.GetOrderInfo(context.Background(), "12345678903")
+
+	if state := breaker.State(); state != CircuitClosed {
+		t.Errorf("state = %s, want %s: failures must be counted in a row", state, CircuitClosed)
+	}
+}
+
+func TestCircuitBreakerHalfOpenAfterCoolDown(t *testing.T) {
+	breaker, client, clock := newTestBreaker()
+	openBreaker(t, breaker, client)
+
+	clock.advance(testCoolDown - time.Millisecond)
+	if state := breaker.State(); state != CircuitOpen {
+		t.Fatalf("state before cool down = %s, want %s", state, CircuitOpen)
+	}
+	clock.advance(time.Millisecond)
+	if state := breaker.State(); state != CircuitHalfOpen {
+		t.Fatalf("state after cool down = %s, want %s", state, CircuitHalfOpen)
+	}
+}
+
+func TestCircuitBreakerProbe(t *testing.T) {
+	tests := []struct {
+		name      string
+		probeErr  error
+		wantState string
+	}{
+		{name: "success closes", probeErr: nil, wantState: CircuitClosed},
+		{name: "failure reopens", probeErr: errUnavailable, wantState: CircuitOpen},
+		{name: "too many requests closes", probeErr: ErrTooManyRequests, wantState: CircuitClosed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			breaker, client, clock := newTestBreaker()
+			openBreaker(t, breaker, client)
+			clock.advance(testCoolDown)
+
+			// пока пробный запрос не завершился, остальные запросы не пропускаются
+			if err := breaker.allow(); err != nil {
+				t.Fatalf("probe was not allowed: %v", err)
+			}
+			if _, err := breaker.GetOrderInfo(context.Background(), "12345678903"); !errors.Is(err, ErrCircuitOpen) {
+				t.Fatalf("concurrent request during probe error = %v, want %v", err, ErrCircuitOpen)
+			}
+			breaker.record(context.Background(), tt.probeErr)
+
+			if state := breaker.State(); state != tt.wantState {
+				t.Fatalf("state after probe = %s, want %s", state, tt.wantState)
+			}
+			if tt.wantState != CircuitOpen {
+				return
+			}
+
+			// повторное размыкание снова выжидает полный coolDown
+			clock.advance(testCoolDown - time.Millisecond)
+			if state := breaker.State(); state != CircuitOpen {
+				t.Errorf("state before the next cool down = %s, want %s", state, CircuitOpen)
+			}
+			clock.advance(time.Millisecond)
+			if state := breaker.State(); state != CircuitHalfOpen {
+				t.Errorf("state after the next cool down = %s, want %s", state, CircuitHalfOpen)
+			}
+		})
+	}
+}
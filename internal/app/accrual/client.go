@@ -0,0 +1,199 @@
+package accrual
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"golang.org/x/time/rate"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// ErrTooManyRequests возвращается, когда accrual-система отвечает 429.
+var ErrTooManyRequests = errors.New("accrual system rate limit exceeded")
+
+// ErrOrderNotRegistered возвращается, когда заказ не зарегистрирован в accrual-системе (204).
+var ErrOrderNotRegistered = errors.New("order not registered in the accrual system")
+
+// ErrBatchNotSupported возвращается, когда accrual-система не поддерживает пакетный запрос (404).
+var ErrBatchNotSupported = errors.New("accrual system does not support batch requests")
+
+// Client — клиент системы расчета начислений баллов лояльности.
+type Client struct {
+	ordersURL  *url.URL
+	httpClient *http.Client
+	// limiter общий для всех воркеров, nil — без ограничения частоты запросов
+	limiter *rate.Limiter
+	// pausedUntil — время в наносекундах Unix, до которого запросы не отправляются после 429
+	pausedUntil atomic.Int64
+}
+
+type ClientOption func(*Client)
+
+// WithRateLimit ограничивает частоту запросов к accrual-системе: rps запросов в секунду с
+// всплесками до burst запросов. rps = 0 снимает ограничение.
+func WithRateLimit(rps float64, burst int) ClientOption {
+	return func(c *Client) {
+		if rps > 0 {
+			c.limiter = rate.NewLimiter(rate.Limit(rps), burst)
+		}
+	}
+}
+
+// ParseBaseURL проверяет, что адрес accrual-системы — абсолютный http(s) URL, и нормализует его,
+// убирая завершающие слэши.
+func ParseBaseURL(address string) (*url.URL, error) {
+	if address == "" {
+		return nil, errors.New("parseBaseURL: address is empty")
+	}
+	baseURL, err := url.Parse(strings.TrimRight(address, "/"))
+	if err != nil {
+		return nil, fmt.Errorf("parseBaseURL: address is not a valid url: %w", err)
+	}
+	if (baseURL.Scheme != "http" && baseURL.Scheme != "https") || baseURL.Host == "" {
+		return nil, fmt.Errorf("parseBaseURL: address must be an absolute http(s) url with host, got %q", address)
+	}
+	return baseURL, nil
+}
+
+func NewClient(address string, opts ...ClientOption) (*Client, error) {
+	baseURL, err := ParseBaseURL(address)
+	if err != nil {
+		return nil, fmt.Errorf("newClient: %w", err)
+	}
+	client := &Client{
+		ordersURL:  baseURL.JoinPath("api", "orders"),
+		httpClient: &http.Client{},
+	}
+	for _, opt := range opts {
+		opt(client)
+	}
+	return client, nil
+}
+
+// wait блокирует запрос, пока не истечет пауза после 429 и не освободится токен лимитера.
+func (c *Client) wait(ctx context.Context) error {
+	if pause := time.Until(time.Unix(0, c.pausedUntil.Load())); pause > 0 {
+		timer := time.NewTimer(pause)
+		defer timer.Stop()
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-timer.C:
+		}
+	}
+	if c.limiter == nil {
+		return nil
+	}
+	return c.limiter.Wait(ctx)
+}
+
+// pause приостанавливает все запросы клиента на время из заголовка Retry-After ответа 429.
+func (c *Client) pause(retryAfter string) {
+	seconds, err := strconv.Atoi(retryAfter)
+	if err != nil || seconds <= 0 {
+		return
+	}
+	until := time.Now().Add(time.Duration(seconds) * time.Second).UnixNano()
+	for {
+		current := c.pausedUntil.Load()
+		if current >= until || c.pausedUntil.CompareAndSwap(current, until) {
+			return
+		}
+	}
+}
+
+func (c *Client) GetOrderInfo(ctx context.Context, orderNumber string) (*models.APIOrderInfoResponse, error) {
+	if err := c.wait(ctx); err != nil {
+		return nil, fmt.Errorf("getOrderInfo: error waiting for rate limiter: %w", err)
+	}
+
+	orderURL := c.ordersURL.JoinPath(orderNumber)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, orderURL.String(), nil)
+	if err != nil {
+		return nil, fmt.Errorf("getOrderInfo: error with request: %w", err)
+	}
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("getOrderInfo: error get: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var orderInfo models.APIOrderInfoResponse
+		if err := json.NewDecoder(resp.Body).Decode(&orderInfo); err != nil {
+			return nil, fmt.Errorf("getOrderInfo: error decoding JSON resp: %w: %w", ErrInvalidResponse, err)
+		}
+		return &orderInfo, nil
+	case http.StatusAccepted:
+		// заказ принят, но расчет не начат — то же, что статус REGISTERED
+		return &models.APIOrderInfoResponse{Order: orderNumber, Status: models.OrderStatusProcessing}, nil
+	case http.StatusNoContent:
+		return nil, fmt.Errorf("getOrderInfo: order %s: %w", orderNumber, ErrOrderNotRegistered)
+	case http.StatusTooManyRequests:
+		retryAfter := resp.Header.Get("Retry-After")
+		c.pause(retryAfter)
+		return nil, fmt.Errorf("getOrderInfo: %w, retry after %s seconds", ErrTooManyRequests, retryAfter)
+	case http.StatusInternalServerError:
+		return nil, fmt.Errorf("getOrderInfo: internal server error")
+	default:
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("getOrderInfo: unexpected status code: %d, body: %s", resp.StatusCode, string(body))
+	}
+}
+
+// BatchGetOrderInfo запрашивает статусы нескольких заказов одним запросом POST /api/orders/batch.
+// Заказы, не зарегистрированные в accrual-системе, в ответ не попадают.
+func (c *Client) BatchGetOrderInfo(ctx context.Context, orderNumbers []string) ([]models.APIOrderInfoResponse, error) {
+	body, err := json.Marshal(orderNumbers)
+	if err != nil {
+		return nil, fmt.Errorf("batchGetOrderInfo: error encoding request: %w", err)
+	}
+
+	if err := c.wait(ctx); err != nil {
+		return nil, fmt.Errorf("batchGetOrderInfo: error waiting for rate limiter: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.ordersURL.JoinPath("batch").String(), bytes.NewReader(body))
+	if err != nil {
+		return nil, fmt.Errorf("batchGetOrderInfo: error with request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := c.httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("batchGetOrderInfo: error post: %w", err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		var ordersInfo []models.APIOrderInfoResponse
+		if err := json.NewDecoder(resp.Body).Decode(&ordersInfo); err != nil {
+			return nil, fmt.Errorf("batchGetOrderInfo: error decoding JSON resp: %w: %w", ErrInvalidResponse, err)
+		}
+		return ordersInfo, nil
+	case http.StatusNoContent:
+		return nil, nil
+	case http.StatusNotFound:
+		return nil, fmt.Errorf("batchGetOrderInfo: %w", ErrBatchNotSupported)
+	case http.StatusTooManyRequests:
+		retryAfter := resp.Header.Get("Retry-After")
+		c.pause(retryAfter)
+		return nil, fmt.Errorf("batchGetOrderInfo: %w, retry after %s seconds", ErrTooManyRequests, retryAfter)
+	default:
+		body, _ := io.ReadAll(resp.Body)
+		return nil, fmt.Errorf("batchGetOrderInfo: unexpected status code: %d, body: %s", resp.StatusCode, string(body))
+	}
+}
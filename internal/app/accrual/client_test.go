@@ -0,0 +1,194 @@
+package accrual
+
+import (
+	"context"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseBaseURL(t *testing.T) {
+	tests := []struct {
+		name    string
+		address string
+		want    string
+		wantErr bool
+	}{
+		{name: "http", address: "http://localhost:8081", want: "http://localhost:8081"},
+		{name: "https", address: "https://accrual.example.com", want: "https://accrual.example.com"},
+		{name: "trailing slash", address: "http://localhost:8081/", want: "http://localhost:8081"},
+		{name: "several trailing slashes", address: "http://localhost:8081///", want: "http://localhost:8081"},
+		{name: "path prefix", address: "http://gateway/accrual/", want: "http://gateway/accrual"},
+		{name: "missing scheme", address: "localhost:8081", wantErr: true},
+		{name: "missing scheme with ip", address: "127.0.0.1:8081", wantErr: true},
+		{name: "scheme-relative", address: "//localhost:8081", wantErr: true},
+		{name: "unsupported scheme", address: "ftp://localhost:8081", wantErr: true},
+		{name: "no host", address: "http://", wantErr: true},
+		{name: "empty", address: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			baseURL, err := ParseBaseURL(tt.address)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("address %q was accepted as %s", tt.address, baseURL)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if baseURL.String() != tt.want {
+				t.Errorf("base url = %s, want %s", baseURL, tt.want)
+			}
+		})
+	}
+}
+
+func TestClientRequestPath(t *testing.T) {
+	tests := []struct {
+		name     string
+		suffix   string
+		wantPath string
+	}{
+		{name: "without trailing slash", suffix: "", wantPath: "/api/orders/12345678903"},
+		{name: "with trailing slash", suffix: "/", wantPath: "/api/orders/12345678903"},
+		{name: "path prefix with trailing slash", suffix: "/accrual/", wantPath: "/accrual/api/orders/12345678903"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotPath string
+			server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				gotPath = req.URL.Path
+				res.Header().Set("Content-Type", "application/json")
+				res.Write([]byte(`{"order":"12345678903","status":"PROCESSING"}`))
+			}))
+			defer server.Close()
+
+			client, err := NewClient(server.URL + tt.suffix)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err = client.GetOrderInfo(context.Background(), "12345678903"); err != nil {
+				t.Fatalf("get order info: %v", err)
+			}
+			if gotPath != tt.wantPath {
+				t.Errorf("request path = %s, want %s", gotPath, tt.wantPath)
+			}
+		})
+	}
+}
+
+// TestGetOrderInfoRegistered проверяет, что заказ, принятый accrual-системой без расчета,
+// — ответ REGISTERED или 202 без тела — возвращается в статусе PROCESSING.
+func TestGetOrderInfoRegistered(t *testing.T) {
+	tests := []struct {
+		name   string
+		handle http.HandlerFunc
+	}{
+		{name: "registered", handle: func(res http.ResponseWriter, _ *http.Request) {
+			res.Header().Set("Content-Type", "application/json")
+			res.Write([]byte(`{"order":"12345678903","status":"REGISTERED"}`))
+		}},
+		{name: "accepted", handle: func(res http.ResponseWriter, _ *http.Request) {
+			res.WriteHeader(http.StatusAccepted)
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(tt.handle)
+			defer server.Close()
+
+			client, err := NewClient(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			info, err := client.GetOrderInfo(context.Background(), "12345678903")
+			if err != nil {
+				t.Fatalf("get order info: %v", err)
+			}
+			if info.Order != "12345678903" || info.Status != models.OrderStatusProcessing || info.Accrual != 0 {
+				t.Errorf("order info = %+v, want order 12345678903 in PROCESSING", info)
+			}
+		})
+	}
+}
+
+func TestNewClientRejectsAddressWithoutScheme(t *testing.T) {
+	if _, err := NewClient("localhost:8081"); err == nil || !strings.Contains(err.Error(), "absolute http(s) url") {
+		t.Errorf("error = %v, want an absolute url error", err)
+	}
+}
+
+// TestClientRateLimit проверяет, что воркеры с общим клиентом не превышают заданную частоту:
+// 100 запросов при rps и burst занимают не меньше (100 - burst) / rps.
+func TestClientRateLimit(t *testing.T) {
+	const (
+		lookups = 100
+		workers = 4
+		rps     = 500
+		burst   = 10
+	)
+
+	var requests atomic.Int64
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		requests.Add(1)
+		res.Header().Set("Content-Type", "application/json")
+		res.Write([]byte(`{"order":"12345678903","status":"PROCESSING"}`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL, WithRateLimit(rps, burst))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lookupsLeft := make(chan struct{}, lookups)
+	for i := 0; i < lookups; i++ {
+		lookupsLeft <- struct{}{}
+	}
+	close(lookupsLeft)
+
+	started := time.Now()
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for range lookupsLeft {
+				if _, err := client.GetOrderInfo(context.Background(), "12345678903"); err != nil {
+					t.Errorf("get order info: %v", err)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	elapsed := time.Since(started)
+
+	if requests.Load() != lookups {
+		t.Errorf("server got %d requests, want %d", requests.Load(), lookups)
+	}
+	// допуск 10% на точность таймеров
+	want := time.Duration(float64(lookups-burst) / rps * float64(time.Second))
+	if elapsed < want*9/10 {
+		t.Errorf("%d lookups took %s, want at least %s at %d rps", lookups, elapsed, want, rps)
+	}
+	if elapsed > want*10 {
+		t.Errorf("%d lookups took %s, want about %s at %d rps", lookups, elapsed, want, rps)
+	}
+}
+
+func TestClientWithoutRateLimit(t *testing.T) {
+	client, err := NewClient("http://localhost:8081", WithRateLimit(0, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if client.limiter != nil {
+		t.Error("rps 0 set a limiter")
+	}
+}
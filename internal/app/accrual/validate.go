@@ -0,0 +1,34 @@
+package accrual
+
+import (
+	"errors"
+	"fmt"
+	"github.com/vancho-go/gophermart/internal/app/models"
+)
+
+// ErrInvalidResponse возвращается для ответа accrual-системы, который нельзя сохранить.
+var ErrInvalidResponse = errors.New("invalid accrual system response")
+
+// maxAccrual — верхняя граница правдоподобного начисления за заказ. Выше нее float64 уже теряет
+// точность до копеек, а ответ почти наверняка ошибочен.
+const maxAccrual = 1e12
+
+// ValidateOrderInfo проверяет ответ accrual-системы по заказу orderNumber. Статус переводится в
+// статус заказа еще при разборе JSON (см. models.APIOrderInfoResponse); неизвестный статус, чужой
+// номер заказа, отрицательное или неправдоподобно большое начисление дают ErrInvalidResponse.
+func ValidateOrderInfo(orderNumber string, orderInfo models.APIOrderInfoResponse) (models.APIOrderInfoResponse, error) {
+	if orderInfo.Order != "" && orderInfo.Order != orderNumber {
+		return models.APIOrderInfoResponse{}, fmt.Errorf("validateOrderInfo: %w: order %q in response for order %s", ErrInvalidResponse, orderInfo.Order, orderNumber)
+	}
+
+	if !orderInfo.Status.Valid() || orderInfo.Status == models.OrderStatusNew {
+		return models.APIOrderInfoResponse{}, fmt.Errorf("validateOrderInfo: %w: unknown status %q for order %s", ErrInvalidResponse, orderInfo.Status, orderNumber)
+	}
+
+	if orderInfo.Accrual < 0 || orderInfo.Accrual > maxAccrual {
+		return models.APIOrderInfoResponse{}, fmt.Errorf("validateOrderInfo: %w: accrual %v out of range for order %s", ErrInvalidResponse, orderInfo.Accrual, orderNumber)
+	}
+
+	orderInfo.Order = orderNumber
+	return orderInfo, nil
+}
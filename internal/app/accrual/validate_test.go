@@ -0,0 +1,75 @@
+package accrual
+
+import (
+	"context"
+	"errors"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestValidateOrderInfo(t *testing.T) {
+	const orderNumber = "12345678903"
+
+	tests := []struct {
+		name      string
+		orderInfo models.APIOrderInfoResponse
+		want      models.APIOrderInfoResponse
+		wantErr   bool
+	}{
+		{name: "processed", orderInfo: models.APIOrderInfoResponse{Order: orderNumber, Status: models.OrderStatusProcessed, Accrual: 729.98},
+			want: models.APIOrderInfoResponse{Order: orderNumber, Status: models.OrderStatusProcessed, Accrual: 729.98}},
+		{name: "processed without accrual", orderInfo: models.APIOrderInfoResponse{Order: orderNumber, Status: models.OrderStatusProcessed},
+			want: models.APIOrderInfoResponse{Order: orderNumber, Status: models.OrderStatusProcessed}},
+		{name: "processing", orderInfo: models.APIOrderInfoResponse{Order: orderNumber, Status: models.OrderStatusProcessing},
+			want: models.APIOrderInfoResponse{Order: orderNumber, Status: models.OrderStatusProcessing}},
+		{name: "invalid", orderInfo: models.APIOrderInfoResponse{Order: orderNumber, Status: models.OrderStatusInvalid},
+			want: models.APIOrderInfoResponse{Order: orderNumber, Status: models.OrderStatusInvalid}},
+		{name: "order number filled in", orderInfo: models.APIOrderInfoResponse{Status: models.OrderStatusProcessing},
+			want: models.APIOrderInfoResponse{Order: orderNumber, Status: models.OrderStatusProcessing}},
+		{name: "maximum accrual", orderInfo: models.APIOrderInfoResponse{Order: orderNumber, Status: models.OrderStatusProcessed, Accrual: maxAccrual},
+			want: models.APIOrderInfoResponse{Order: orderNumber, Status: models.OrderStatusProcessed, Accrual: maxAccrual}},
+		{name: "unknown status", orderInfo: models.APIOrderInfoResponse{Order: orderNumber, Status: "CANCELLED"}, wantErr: true},
+		{name: "empty status", orderInfo: models.APIOrderInfoResponse{Order: orderNumber}, wantErr: true},
+		{name: "new status", orderInfo: models.APIOrderInfoResponse{Order: orderNumber, Status: models.OrderStatusNew}, wantErr: true},
+		{name: "negative accrual", orderInfo: models.APIOrderInfoResponse{Order: orderNumber, Status: models.OrderStatusProcessed, Accrual: -10}, wantErr: true},
+		{name: "accrual above maximum", orderInfo: models.APIOrderInfoResponse{Order: orderNumber, Status: models.OrderStatusProcessed, Accrual: maxAccrual * 10}, wantErr: true},
+		{name: "other order", orderInfo: models.APIOrderInfoResponse{Order: "2377225624", Status: models.OrderStatusProcessed, Accrual: 10}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ValidateOrderInfo(orderNumber, tt.orderInfo)
+			if tt.wantErr {
+				if !errors.Is(err, ErrInvalidResponse) {
+					t.Errorf("error = %v, want %v", err, ErrInvalidResponse)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("order info = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+// TestGetOrderInfoRejectsUnknownStatus проверяет, что статус, которого нет в протоколе
+// accrual-системы, отклоняется уже при разборе ответа.
+func TestGetOrderInfoRejectsUnknownStatus(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "application/json")
+		res.Write([]byte(`{"order":"12345678903","status":"CANCELLED","accrual":10}`))
+	}))
+	defer server.Close()
+
+	client, err := NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = client.GetOrderInfo(context.Background(), "12345678903"); !errors.Is(err, ErrInvalidResponse) {
+		t.Errorf("error = %v, want %v", err, ErrInvalidResponse)
+	}
+}
@@ -0,0 +1,27 @@
+package auth
+
+import (
+	"crypto/subtle"
+	"net/http"
+)
+
+const (
+	AdminKeyHeader = "X-Admin-Key"
+	// AdminOperatorHeader называет сотрудника, выполняющего изменяющий запрос admin API, для аудита
+	AdminOperatorHeader = "X-Admin-Operator"
+)
+
+// AdminMiddleware пропускает только запросы с заголовком X-Admin-Key, совпадающим с adminKey.
+// Остальным отвечает так же, как на несуществующий путь, чтобы не раскрывать наличие admin API.
+func AdminMiddleware(adminKey string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			key := req.Header.Get(AdminKeyHeader)
+			if adminKey == "" || subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) != 1 {
+				http.NotFound(res, req)
+				return
+			}
+			next.ServeHTTP(res, req)
+		})
+	}
+}
@@ -0,0 +1,48 @@
+package auth
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// TestAdminMiddleware проверяет, что без верного X-Admin-Key admin API неотличимо от
+// несуществующего пути, в том числе когда ключ не настроен.
+func TestAdminMiddleware(t *testing.T) {
+	tests := []struct {
+		name       string
+		adminKey   string
+		header     string
+		setHeader  bool
+		wantStatus int
+	}{
+		{name: "valid key", adminKey: "admin-secret", header: "admin-secret", setHeader: true, wantStatus: http.StatusOK},
+		{name: "missing key", adminKey: "admin-secret", wantStatus: http.StatusNotFound},
+		{name: "empty key", adminKey: "admin-secret", header: "", setHeader: true, wantStatus: http.StatusNotFound},
+		{name: "wrong key", adminKey: "admin-secret", header: "admin-secreT", setHeader: true, wantStatus: http.StatusNotFound},
+		{name: "key prefix", adminKey: "admin-secret", header: "admin", setHeader: true, wantStatus: http.StatusNotFound},
+		{name: "not configured", adminKey: "", header: "", setHeader: true, wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var reached bool
+			handler := AdminMiddleware(tt.adminKey)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+				reached = true
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/admin/users", nil)
+			if tt.setHeader {
+				req.Header.Set(AdminKeyHeader, tt.header)
+			}
+			res := httptest.NewRecorder()
+			handler.ServeHTTP(res, req)
+
+			if res.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", res.Code, tt.wantStatus)
+			}
+			if reached != (tt.wantStatus == http.StatusOK) {
+				t.Errorf("handler reached = %v, want %v", reached, tt.wantStatus == http.StatusOK)
+			}
+		})
+	}
+}
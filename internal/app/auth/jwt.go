@@ -1,10 +1,14 @@
 package auth
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
 	"github.com/golang-jwt/jwt/v4"
 	"github.com/google/uuid"
 	"net/http"
+	"strings"
 	"time"
 )
 
@@ -12,7 +16,29 @@ const (
 	tokenExp = time.Hour * 24
 )
 
-var secretKey string
+var (
+	// keys — ключ подписи новых токенов и резервные ключи, которым еще доверяют при проверке,
+	// чтобы смена ключа не разлогинивала всех
+	keys          keyring
+	cookieOptions = CookieOptions{SameSite: http.SameSiteLaxMode}
+)
+
+// CookieOptions — атрибуты cookie авторизации.
+type CookieOptions struct {
+	SameSite http.SameSite
+	Secure   bool
+}
+
+type signingKey struct {
+	kid string
+	key []byte
+}
+
+type keyring struct {
+	primary signingKey
+	// fallbacks в порядке из конфигурации
+	fallbacks []signingKey
+}
 
 type claims struct {
 	jwt.RegisteredClaims
@@ -23,11 +49,74 @@ func newClaims() *claims {
 	return &claims{}
 }
 
-func SetSecretKey(key string) error {
-	secretKey = key
+// SetKeys задает ключ подписи новых токенов primary и резервные ключи fallbacks, которыми токены
+// только проверяются. При смене ключа прежний primary переносится в fallbacks и удаляется оттуда
+// не раньше, чем через время жизни токена (24 часа), когда истекут подписанные им токены.
+func SetKeys(primary string, fallbacks []string) error {
+	if primary == "" {
+		return errors.New("setKeys: primary key is empty")
+	}
+
+	ring := keyring{primary: newSigningKey(primary)}
+	for _, fallback := range fallbacks {
+		if fallback == "" {
+			return errors.New("setKeys: fallback key is empty")
+		}
+		ring.fallbacks = append(ring.fallbacks, newSigningKey(fallback))
+	}
+	keys = ring
 	return nil
 }
 
+func newSigningKey(key string) signingKey {
+	return signingKey{kid: keyID(key), key: []byte(key)}
+}
+
+// keyID вычисляет kid ключа по его хешу, чтобы не раскрывать сам ключ в заголовке токена.
+func keyID(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:8])
+}
+
+// candidateKeys возвращает ключи, которыми можно проверить токен: ключ с совпавшим kid или,
+// для токенов без kid, выпущенных до появления ротации, все ключи по порядку — primary первым.
+func candidateKeys(t *jwt.Token) ([][]byte, error) {
+	all := append([]signingKey{keys.primary}, keys.fallbacks...)
+	kid, ok := t.Header["kid"].(string)
+	if !ok {
+		candidates := make([][]byte, 0, len(all))
+		for _, k := range all {
+			candidates = append(candidates, k.key)
+		}
+		return candidates, nil
+	}
+	for _, k := range all {
+		if k.kid == kid {
+			return [][]byte{k.key}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown key id %q", kid)
+}
+
+// Configure задает атрибуты cookie авторизации. В продакшене нужны SameSite=Strict и Secure,
+// по умолчанию — Lax без Secure для локальной разработки по HTTP.
+func Configure(options CookieOptions) {
+	cookieOptions = options
+}
+
+// ParseSameSite разбирает значение SameSite из конфигурации: lax, strict или none.
+func ParseSameSite(value string) (http.SameSite, error) {
+	switch strings.ToLower(value) {
+	case "lax":
+		return http.SameSiteLaxMode, nil
+	case "strict":
+		return http.SameSiteStrictMode, nil
+	case "none":
+		return http.SameSiteNoneMode, nil
+	}
+	return 0, fmt.Errorf("must be lax, strict or none, got %q", value)
+}
+
 func GenerateUserID() string {
 	return uuid.New().String()
 }
@@ -42,10 +131,25 @@ func GenerateCookie(userID string) (*http.Cookie, error) {
 		Value:    jwtToken,
 		Expires:  time.Now().Add(tokenExp),
 		HttpOnly: true,
+		Secure:   cookieOptions.Secure,
+		SameSite: cookieOptions.SameSite,
 		Path:     "/",
 	}, nil
 }
 
+// ExpiredCookie удаляет cookie авторизации на стороне клиента.
+func ExpiredCookie() *http.Cookie {
+	return &http.Cookie{
+		Name:     "AuthToken",
+		Value:    "",
+		MaxAge:   -1,
+		HttpOnly: true,
+		Secure:   cookieOptions.Secure,
+		SameSite: cookieOptions.SameSite,
+		Path:     "/",
+	}
+}
+
 func generateJWTToken(userID string) (string, error) {
 	// создаём новый токен с алгоритмом подписи HS256 и утверждениями — Claims
 	expirationTime := time.Now().Add(tokenExp)
@@ -56,7 +160,8 @@ func generateJWTToken(userID string) (string, error) {
 			},
 			UserID: userID,
 		})
-	return token.SignedString([]byte(secretKey))
+	token.Header["kid"] = keys.primary.kid
+	return token.SignedString(keys.primary.key)
 }
 
 func GetUserID(req *http.Request) (string, error) {
@@ -68,32 +173,40 @@ func GetUserID(req *http.Request) (string, error) {
 
 	tokenString := cookie.Value
 
-	if err = isTokenValid(tokenString); err != nil {
-		return "", fmt.Errorf("getUserID: error validating token : %w", err)
-	}
-
 	claims := newClaims()
-	_, err = jwt.ParseWithClaims(tokenString, claims, func(t *jwt.Token) (interface{}, error) {
-		return []byte(secretKey), nil
-	})
-	if err != nil {
-		return "", fmt.Errorf("getUserID: error parsing token: %w", err)
+	if err = isTokenValid(tokenString, claims); err != nil {
+		return "", fmt.Errorf("getUserID: error validating token : %w", err)
 	}
 	return claims.UserID, nil
 }
 
-func isTokenValid(tokenString string) error {
-	token, err := jwt.Parse(tokenString, func(t *jwt.Token) (interface{}, error) {
-		if _, ok := t.Method.(*jwt.SigningMethodHMAC); !ok {
-			return nil, fmt.Errorf("isTokenValid: unexpected signing method: %v", t.Header["alg"])
-		}
-		return []byte(secretKey), nil
-	})
+// isTokenValid проверяет подпись и срок действия токена, перебирая подходящие ключи, и
+// заполняет tokenClaims.
+func isTokenValid(tokenString string, tokenClaims *claims) error {
+	unverified, _, err := jwt.NewParser().ParseUnverified(tokenString, newClaims())
 	if err != nil {
-		return err
+		return fmt.Errorf("isTokenValid: %w", err)
 	}
-	if !token.Valid {
-		return fmt.Errorf("isTokenValid: token is not valid")
+	if _, ok := unverified.Method.(*jwt.SigningMethodHMAC); !ok {
+		return fmt.Errorf("isTokenValid: unexpected signing method: %v", unverified.Header["alg"])
 	}
-	return nil
+	candidates, err := candidateKeys(unverified)
+	if err != nil {
+		return fmt.Errorf("isTokenValid: %w", err)
+	}
+
+	for _, key := range candidates {
+		key := key
+		token, err := jwt.ParseWithClaims(tokenString, tokenClaims, func(*jwt.Token) (interface{}, error) {
+			return key, nil
+		})
+		if err == nil && token.Valid {
+			return nil
+		}
+		// истекший токен не станет действительным с другим ключом
+		if err != nil && !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+			return fmt.Errorf("isTokenValid: %w", err)
+		}
+	}
+	return errors.New("isTokenValid: token signature is invalid")
 }
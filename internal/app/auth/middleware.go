@@ -2,6 +2,7 @@ package auth
 
 import (
 	"context"
+	"encoding/json"
 	"net/http"
 )
 
@@ -11,17 +12,54 @@ const (
 	UserIDContextKey contextKey = iota
 )
 
-func Middleware(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
-		userID, err := GetUserID(req)
-		if err != nil {
-			http.Error(res, "Unauthorized", http.StatusUnauthorized)
-			return
-		}
+// UserChecker сообщает, что пользователь существует и не удален.
+type UserChecker interface {
+	IsUserActive(ctx context.Context, userID string) (active bool, err error)
+}
+
+// UserCheckerFunc позволяет использовать функцию как UserChecker.
+type UserCheckerFunc func(ctx context.Context, userID string) (bool, error)
+
+func (f UserCheckerFunc) IsUserActive(ctx context.Context, userID string) (bool, error) {
+	return f(ctx, userID)
+}
 
-		ctx := context.WithValue(req.Context(), UserIDContextKey, userID)
-		req = req.WithContext(ctx)
+// Middleware пропускает запросы с действительным токеном активного пользователя. Токен удаленного
+// пользователя отклоняется, даже если срок его действия не истек: удаление учетной записи
+// отзывает все ее токены.
+func Middleware(users UserChecker) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			userID, err := GetUserID(req)
+			if err != nil {
+				writeError(res, http.StatusUnauthorized, "UNAUTHORIZED", "Unauthorized")
+				return
+			}
+
+			active, err := users.IsUserActive(req.Context(), userID)
+			if err != nil {
+				writeError(res, http.StatusInternalServerError, "INTERNAL_ERROR", "Internal error")
+				return
+			}
+			if !active {
+				writeError(res, http.StatusUnauthorized, "UNAUTHORIZED", "Unauthorized")
+				return
+			}
+
+			ctx := context.WithValue(req.Context(), UserIDContextKey, userID)
+			req = req.WithContext(ctx)
+
+			next.ServeHTTP(res, req)
+		})
+	}
+}
 
-		next.ServeHTTP(res, req)
+// writeError отвечает в том же формате, что и handlers: {"error":{"code":"...","message":"..."}}.
+func writeError(res http.ResponseWriter, status int, code, message string) {
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.WriteHeader(status)
+	json.NewEncoder(res).Encode(map[string]map[string]string{
+		"error": {"code": code, "message": message},
 	})
 }
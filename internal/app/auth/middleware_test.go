@@ -0,0 +1,111 @@
+package auth
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeUserChecker хранит состояние пользователей в памяти и считает проверки.
+type fakeUserChecker struct {
+	active map[string]bool
+	err    error
+	calls  int
+}
+
+func (f *fakeUserChecker) IsUserActive(_ context.Context, userID string) (bool, error) {
+	f.calls++
+	return f.active[userID], f.err
+}
+
+func TestMiddleware(t *testing.T) {
+	if err := SetKeys("middleware-test-key", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		userID     string
+		cookie     bool
+		checkerErr error
+		wantStatus int
+		wantCode   string
+		wantChecks int
+	}{
+		{name: "active user", userID: "user-1", cookie: true, wantStatus: http.StatusOK, wantChecks: 1},
+		{name: "deleted user", userID: "user-deleted", cookie: true, wantStatus: http.StatusUnauthorized, wantCode: "UNAUTHORIZED", wantChecks: 1},
+		{name: "unknown user", userID: "user-unknown", cookie: true, wantStatus: http.StatusUnauthorized, wantCode: "UNAUTHORIZED", wantChecks: 1},
+		{name: "no cookie", wantStatus: http.StatusUnauthorized, wantCode: "UNAUTHORIZED"},
+		{name: "checker error", userID: "user-1", cookie: true, checkerErr: errors.New("database is down"), wantStatus: http.StatusInternalServerError, wantCode: "INTERNAL_ERROR", wantChecks: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := &fakeUserChecker{active: map[string]bool{"user-1": true, "user-deleted": false}, err: tt.checkerErr}
+			var gotUserID string
+			handler := Middleware(checker)(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				gotUserID, _ = req.Context().Value(UserIDContextKey).(string)
+			}))
+
+			req := httptest.NewRequest(http.MethodGet, "/api/v1/user/orders", nil)
+			if tt.cookie {
+				cookie, err := GenerateCookie(tt.userID)
+				if err != nil {
+					t.Fatal(err)
+				}
+				req.AddCookie(cookie)
+			}
+			res := httptest.NewRecorder()
+			handler.ServeHTTP(res, req)
+
+			if res.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", res.Code, tt.wantStatus)
+			}
+			if checker.calls != tt.wantChecks {
+				t.Errorf("user checks = %d, want %d", checker.calls, tt.wantChecks)
+			}
+			if tt.wantStatus == http.StatusOK {
+				if gotUserID != tt.userID {
+					t.Errorf("user id in context = %q, want %q", gotUserID, tt.userID)
+				}
+				return
+			}
+			if gotUserID != "" {
+				t.Errorf("rejected request reached the handler as %q", gotUserID)
+			}
+			if !strings.Contains(res.Body.String(), `"code":"`+tt.wantCode+`"`) {
+				t.Errorf("body = %s, want error code %s", res.Body, tt.wantCode)
+			}
+		})
+	}
+}
+
+func TestMiddlewareRejectsTokenAfterDeletion(t *testing.T) {
+	if err := SetKeys("middleware-test-key", nil); err != nil {
+		t.Fatal(err)
+	}
+	checker := &fakeUserChecker{active: map[string]bool{"user-1": true}}
+	handler := Middleware(checker)(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}))
+
+	cookie, err := GenerateCookie("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func() int {
+		req := httptest.NewRequest(http.MethodGet, "/api/v1/user/balance", nil)
+		req.AddCookie(cookie)
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res.Code
+	}
+
+	if status := do(); status != http.StatusOK {
+		t.Fatalf("status before deletion = %d, want %d", status, http.StatusOK)
+	}
+	checker.active["user-1"] = false
+	if status := do(); status != http.StatusUnauthorized {
+		t.Errorf("status of an unexpired token after deletion = %d, want %d", status, http.StatusUnauthorized)
+	}
+}
@@ -1,19 +1,87 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/base64"
+	"errors"
 	"fmt"
+	"golang.org/x/crypto/argon2"
 	"golang.org/x/crypto/bcrypt"
+	"strings"
 )
 
+// Параметры Argon2id для новых хешей. Хеш хранится в формате PHC
+// ($argon2id$v=19$m=...,t=...,p=...$соль$хеш), поэтому смена параметров не ломает старые хеши.
+const (
+	argon2Scheme  = "$argon2id$"
+	argon2Time    = 1
+	argon2Memory  = 64 * 1024
+	argon2Threads = 4
+	argon2KeyLen  = 32
+	argon2SaltLen = 16
+)
+
+// HashPassword хеширует пароль Argon2id.
 func HashPassword(password string) (string, error) {
-	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
-	if err != nil {
-		return "", fmt.Errorf("hashPassword: generating hash from password error: %w", err)
+	salt := make([]byte, argon2SaltLen)
+	if _, err := rand.Read(salt); err != nil {
+		return "", fmt.Errorf("hashPassword: generating salt error: %w", err)
 	}
-	return string(hashedPassword), nil
+
+	key := argon2.IDKey([]byte(password), salt, argon2Time, argon2Memory, argon2Threads, argon2KeyLen)
+	return fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2Scheme, argon2.Version, argon2Memory, argon2Time, argon2Threads,
+		base64.RawStdEncoding.EncodeToString(salt), base64.RawStdEncoding.EncodeToString(key)), nil
 }
 
+// IsPasswordEqualsToHashedPassword проверяет пароль по хешу. Схема определяется по префиксу хеша:
+// $argon2id$ — Argon2id, остальные хеши считаются bcrypt ($2a$, $2b$), созданными до перехода на Argon2id.
 func IsPasswordEqualsToHashedPassword(password, hashedPassword string) bool {
+	if strings.HasPrefix(hashedPassword, argon2Scheme) {
+		return isArgon2PasswordEqual(password, hashedPassword)
+	}
 	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 	return err == nil
 }
+
+// NeedsRehash сообщает, что хеш создан устаревшей схемой или с другими параметрами и пароль
+// нужно перехешировать при следующем успешном входе.
+func NeedsRehash(hashedPassword string) bool {
+	version, memory, time, threads, _, _, err := parseArgon2Hash(hashedPassword)
+	if err != nil {
+		return true
+	}
+	return version != argon2.Version || memory != argon2Memory || time != argon2Time || threads != argon2Threads
+}
+
+func isArgon2PasswordEqual(password, hashedPassword string) bool {
+	_, memory, time, threads, salt, key, err := parseArgon2Hash(hashedPassword)
+	if err != nil {
+		return false
+	}
+	candidate := argon2.IDKey([]byte(password), salt, time, memory, threads, uint32(len(key)))
+	return subtle.ConstantTimeCompare(candidate, key) == 1
+}
+
+func parseArgon2Hash(hashedPassword string) (version int, memory, time uint32, threads uint8, salt, key []byte, err error) {
+	parts := strings.Split(hashedPassword, "$")
+	if len(parts) != 6 || "$"+parts[1]+"$" != argon2Scheme {
+		return 0, 0, 0, 0, nil, nil, errors.New("parseArgon2Hash: not an argon2id hash")
+	}
+	if _, err = fmt.Sscanf(parts[2], "v=%d", &version); err != nil {
+		return 0, 0, 0, 0, nil, nil, fmt.Errorf("parseArgon2Hash: error parsing version: %w", err)
+	}
+	if _, err = fmt.Sscanf(parts[3], "m=%d,t=%d,p=%d", &memory, &time, &threads); err != nil {
+		return 0, 0, 0, 0, nil, nil, fmt.Errorf("parseArgon2Hash: error parsing parameters: %w", err)
+	}
+	if salt, err = base64.RawStdEncoding.DecodeString(parts[4]); err != nil {
+		return 0, 0, 0, 0, nil, nil, fmt.Errorf("parseArgon2Hash: error decoding salt: %w", err)
+	}
+	if key, err = base64.RawStdEncoding.DecodeString(parts[5]); err != nil {
+		return 0, 0, 0, 0, nil, nil, fmt.Errorf("parseArgon2Hash: error decoding key: %w", err)
+	}
+	if len(key) == 0 {
+		return 0, 0, 0, 0, nil, nil, errors.New("parseArgon2Hash: empty key")
+	}
+	return version, memory, time, threads, salt, key, nil
+}
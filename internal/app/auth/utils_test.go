@@ -0,0 +1,54 @@
+package auth
+
+import (
+	"encoding/base64"
+	"fmt"
+	"golang.org/x/crypto/argon2"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"testing"
+)
+
+func TestPasswordHashSchemes(t *testing.T) {
+	const password = "correct-horse"
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := HashPassword(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(current, argon2Scheme) {
+		t.Fatalf("new hash %s is not argon2id", current)
+	}
+	// хеш с параметрами слабее текущих проверяется, но требует перехеширования
+	salt := []byte("0123456789abcdef")
+	weaker := fmt.Sprintf("%sv=%d$m=%d,t=%d,p=%d$%s$%s", argon2Scheme, argon2.Version, 32*1024, 1, 2,
+		base64.RawStdEncoding.EncodeToString(salt),
+		base64.RawStdEncoding.EncodeToString(argon2.IDKey([]byte(password), salt, 1, 32*1024, 2, argon2KeyLen)))
+
+	tests := []struct {
+		name       string
+		hash       string
+		wantRehash bool
+	}{
+		{name: "bcrypt", hash: string(legacy), wantRehash: true},
+		{name: "argon2id", hash: current, wantRehash: false},
+		{name: "argon2id with other parameters", hash: weaker, wantRehash: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !IsPasswordEqualsToHashedPassword(password, tt.hash) {
+				t.Errorf("password does not match its hash %s", tt.hash)
+			}
+			if IsPasswordEqualsToHashedPassword("wrong-password", tt.hash) {
+				t.Errorf("wrong password matches the hash %s", tt.hash)
+			}
+			if got := NeedsRehash(tt.hash); got != tt.wantRehash {
+				t.Errorf("NeedsRehash(%s) = %v, want %v", tt.hash, got, tt.wantRehash)
+			}
+		})
+	}
+}
@@ -1,15 +1,101 @@
 package config
 
 import (
+	"errors"
 	"flag"
+	"fmt"
+	"log"
+	"net/http"
 	"os"
+	"strconv"
+	"strings"
+	"time"
 )
 
+// Значения берутся в порядке приоритета: флаги командной строки, переменные окружения,
+// файл конфигурации (-c / -config / CONFIG), значения по умолчанию.
+
+// DefaultJWTSecretKey — ключ по умолчанию, запуск с ним разрешен только с -allow-insecure-dev-secret.
+const DefaultJWTSecretKey = "temp_secret_key"
+
 type ServerConfig struct {
 	ServerRunAddress     string
 	DatabaseURI          string
 	AccrualSystemAddress string
 	JWTSecretKey         string
+	// JWTFallbackKeys — прежние ключи подписи, токены с ними еще принимаются
+	JWTFallbackKeys      []string
+	APIValidationMode    string
+	FinancialTxIsolation string
+	WebhookTimeout       time.Duration
+	WebhookMaxRetries    int
+	WebhookSecret        string
+	IdempotencyKeyTTL    time.Duration
+	ReadHeaderTimeout    time.Duration
+	ReadTimeout          time.Duration
+	WriteTimeout         time.Duration
+	IdleTimeout          time.Duration
+	MaxHeaderBytes       int
+	MaxWithdrawalSum     float64
+	EnableHTTPS          bool
+	TLSCertFile          string
+	TLSKeyFile           string
+	MaxLoginLength       int
+	AdminKey             string
+	// AllowInsecureDevSecret разрешает запуск с JWT-ключом по умолчанию, только для локальной разработки
+	AllowInsecureDevSecret      bool
+	DBHealthCheckInterval       time.Duration
+	DBQueryTimeout              time.Duration
+	LogLevel                    string
+	LogFormat                   string
+	APIVersion                  string
+	MaxOrderBatchSize           int
+	LogOutput                   string
+	LogFile                     string
+	LogFileMaxSizeMB            int
+	LogFileMaxBackups           int
+	LogFileMaxAgeDays           int
+	AuthRateLimitRPS            float64
+	AuthRateLimitBurst          int
+	LogLevelAccess              string
+	LogLevelFloor               string
+	OrderRateLimitRPS           float64
+	OrderRateLimitBurst         int
+	EnableSwaggerUI             bool
+	DebugAddress                string
+	DBStatsInterval             time.Duration
+	AccrualWorkers              int
+	AccrualPollInterval         time.Duration
+	AccrualMaxPollInterval      time.Duration
+	DBMaxConns                  int
+	DBMinConns                  int
+	DBConnMaxLifetime           time.Duration
+	AccrualRequestTimeout       time.Duration
+	AccrualBatchSize            int
+	UpdateCycleTimeout          time.Duration
+	UpdaterStatementTimeout     time.Duration
+	SlowQueryThreshold          time.Duration
+	PendingOrdersBatchSize      int
+	OrderCheckCooldown          time.Duration
+	ReadReplicaURI              string
+	DispatchQueueSize           int
+	AccrualRateLimitRPS         float64
+	AccrualRateLimitBurst       int
+	AccrualBreakerThreshold     int
+	AccrualBreakerCoolDown      time.Duration
+	StuckOrderAge               time.Duration
+	OrderOwnerCacheSize         int
+	OrderOwnerCacheTTL          time.Duration
+	CookieSameSite              string
+	CookieSecure                bool
+	MaxUpdateAttempts           int
+	BasePath                    string
+	BalanceReconcileInterval    time.Duration
+	BalanceDiscrepancyThreshold float64
+	StatsTimeZone               string
+	DBStatementTimeout          time.Duration
+	WebhookWorkers              int
+	OTLPEndpoint                string
 }
 
 type serverConfigBuilder struct {
@@ -42,44 +128,1019 @@ func (sc *serverConfigBuilder) withJWTSecretKey(JWTSecretKey string) *serverConf
 	return sc
 }
 
+func (sc *serverConfigBuilder) withJWTFallbackKeys(keys []string) *serverConfigBuilder {
+	sc.serviceConfig.JWTFallbackKeys = keys
+	return sc
+}
+
+func (sc *serverConfigBuilder) withAPIValidationMode(apiValidationMode string) *serverConfigBuilder {
+	sc.serviceConfig.APIValidationMode = apiValidationMode
+	return sc
+}
+
+func (sc *serverConfigBuilder) withFinancialTxIsolation(financialTxIsolation string) *serverConfigBuilder {
+	sc.serviceConfig.FinancialTxIsolation = financialTxIsolation
+	return sc
+}
+
+func (sc *serverConfigBuilder) withWebhookTimeout(webhookTimeout time.Duration) *serverConfigBuilder {
+	sc.serviceConfig.WebhookTimeout = webhookTimeout
+	return sc
+}
+
+func (sc *serverConfigBuilder) withWebhookMaxRetries(webhookMaxRetries int) *serverConfigBuilder {
+	sc.serviceConfig.WebhookMaxRetries = webhookMaxRetries
+	return sc
+}
+
+func (sc *serverConfigBuilder) withWebhookSecret(webhookSecret string) *serverConfigBuilder {
+	sc.serviceConfig.WebhookSecret = webhookSecret
+	return sc
+}
+
+func (sc *serverConfigBuilder) withIdempotencyKeyTTL(idempotencyKeyTTL time.Duration) *serverConfigBuilder {
+	sc.serviceConfig.IdempotencyKeyTTL = idempotencyKeyTTL
+	return sc
+}
+
+func (sc *serverConfigBuilder) withReadHeaderTimeout(readHeaderTimeout time.Duration) *serverConfigBuilder {
+	sc.serviceConfig.ReadHeaderTimeout = readHeaderTimeout
+	return sc
+}
+
+func (sc *serverConfigBuilder) withReadTimeout(readTimeout time.Duration) *serverConfigBuilder {
+	sc.serviceConfig.ReadTimeout = readTimeout
+	return sc
+}
+
+func (sc *serverConfigBuilder) withWriteTimeout(writeTimeout time.Duration) *serverConfigBuilder {
+	sc.serviceConfig.WriteTimeout = writeTimeout
+	return sc
+}
+
+func (sc *serverConfigBuilder) withIdleTimeout(idleTimeout time.Duration) *serverConfigBuilder {
+	sc.serviceConfig.IdleTimeout = idleTimeout
+	return sc
+}
+
+func (sc *serverConfigBuilder) withMaxHeaderBytes(maxHeaderBytes int) *serverConfigBuilder {
+	sc.serviceConfig.MaxHeaderBytes = maxHeaderBytes
+	return sc
+}
+
+func (sc *serverConfigBuilder) withMaxWithdrawalSum(maxWithdrawalSum float64) *serverConfigBuilder {
+	sc.serviceConfig.MaxWithdrawalSum = maxWithdrawalSum
+	return sc
+}
+
+func (sc *serverConfigBuilder) withEnableHTTPS(enableHTTPS bool) *serverConfigBuilder {
+	sc.serviceConfig.EnableHTTPS = enableHTTPS
+	return sc
+}
+
+func (sc *serverConfigBuilder) withTLSCertFile(tlsCertFile string) *serverConfigBuilder {
+	sc.serviceConfig.TLSCertFile = tlsCertFile
+	return sc
+}
+
+func (sc *serverConfigBuilder) withTLSKeyFile(tlsKeyFile string) *serverConfigBuilder {
+	sc.serviceConfig.TLSKeyFile = tlsKeyFile
+	return sc
+}
+
+func (sc *serverConfigBuilder) withMaxLoginLength(maxLoginLength int) *serverConfigBuilder {
+	sc.serviceConfig.MaxLoginLength = maxLoginLength
+	return sc
+}
+
+func (sc *serverConfigBuilder) withAdminKey(adminKey string) *serverConfigBuilder {
+	sc.serviceConfig.AdminKey = adminKey
+	return sc
+}
+
+func (sc *serverConfigBuilder) withAllowInsecureDevSecret(allowInsecureDevSecret bool) *serverConfigBuilder {
+	sc.serviceConfig.AllowInsecureDevSecret = allowInsecureDevSecret
+	return sc
+}
+
+func (sc *serverConfigBuilder) withDBHealthCheckInterval(dbHealthCheckInterval time.Duration) *serverConfigBuilder {
+	sc.serviceConfig.DBHealthCheckInterval = dbHealthCheckInterval
+	return sc
+}
+
+func (sc *serverConfigBuilder) withDBQueryTimeout(dbQueryTimeout time.Duration) *serverConfigBuilder {
+	sc.serviceConfig.DBQueryTimeout = dbQueryTimeout
+	return sc
+}
+
+func (sc *serverConfigBuilder) withLogLevel(logLevel string) *serverConfigBuilder {
+	sc.serviceConfig.LogLevel = logLevel
+	return sc
+}
+
+func (sc *serverConfigBuilder) withLogFormat(logFormat string) *serverConfigBuilder {
+	sc.serviceConfig.LogFormat = logFormat
+	return sc
+}
+
+func (sc *serverConfigBuilder) withAPIVersion(apiVersion string) *serverConfigBuilder {
+	sc.serviceConfig.APIVersion = apiVersion
+	return sc
+}
+
+func (sc *serverConfigBuilder) withMaxOrderBatchSize(maxOrderBatchSize int) *serverConfigBuilder {
+	sc.serviceConfig.MaxOrderBatchSize = maxOrderBatchSize
+	return sc
+}
+
+func (sc *serverConfigBuilder) withLogOutput(logOutput string) *serverConfigBuilder {
+	sc.serviceConfig.LogOutput = logOutput
+	return sc
+}
+
+func (sc *serverConfigBuilder) withLogFile(logFile string) *serverConfigBuilder {
+	sc.serviceConfig.LogFile = logFile
+	return sc
+}
+
+func (sc *serverConfigBuilder) withLogFileMaxSizeMB(logFileMaxSizeMB int) *serverConfigBuilder {
+	sc.serviceConfig.LogFileMaxSizeMB = logFileMaxSizeMB
+	return sc
+}
+
+func (sc *serverConfigBuilder) withLogFileMaxBackups(logFileMaxBackups int) *serverConfigBuilder {
+	sc.serviceConfig.LogFileMaxBackups = logFileMaxBackups
+	return sc
+}
+
+func (sc *serverConfigBuilder) withLogFileMaxAgeDays(logFileMaxAgeDays int) *serverConfigBuilder {
+	sc.serviceConfig.LogFileMaxAgeDays = logFileMaxAgeDays
+	return sc
+}
+
+func (sc *serverConfigBuilder) withAuthRateLimitRPS(authRateLimitRPS float64) *serverConfigBuilder {
+	sc.serviceConfig.AuthRateLimitRPS = authRateLimitRPS
+	return sc
+}
+
+func (sc *serverConfigBuilder) withAuthRateLimitBurst(authRateLimitBurst int) *serverConfigBuilder {
+	sc.serviceConfig.AuthRateLimitBurst = authRateLimitBurst
+	return sc
+}
+
+func (sc *serverConfigBuilder) withLogLevelAccess(logLevelAccess string) *serverConfigBuilder {
+	sc.serviceConfig.LogLevelAccess = logLevelAccess
+	return sc
+}
+
+func (sc *serverConfigBuilder) withLogLevelFloor(logLevelFloor string) *serverConfigBuilder {
+	sc.serviceConfig.LogLevelFloor = logLevelFloor
+	return sc
+}
+
+func (sc *serverConfigBuilder) withOrderRateLimitRPS(orderRateLimitRPS float64) *serverConfigBuilder {
+	sc.serviceConfig.OrderRateLimitRPS = orderRateLimitRPS
+	return sc
+}
+
+func (sc *serverConfigBuilder) withOrderRateLimitBurst(orderRateLimitBurst int) *serverConfigBuilder {
+	sc.serviceConfig.OrderRateLimitBurst = orderRateLimitBurst
+	return sc
+}
+
+func (sc *serverConfigBuilder) withEnableSwaggerUI(enableSwaggerUI bool) *serverConfigBuilder {
+	sc.serviceConfig.EnableSwaggerUI = enableSwaggerUI
+	return sc
+}
+
+func (sc *serverConfigBuilder) withDebugAddress(debugAddress string) *serverConfigBuilder {
+	sc.serviceConfig.DebugAddress = debugAddress
+	return sc
+}
+
+func (sc *serverConfigBuilder) withDBStatsInterval(dbStatsInterval time.Duration) *serverConfigBuilder {
+	sc.serviceConfig.DBStatsInterval = dbStatsInterval
+	return sc
+}
+
+func (sc *serverConfigBuilder) withAccrualWorkers(accrualWorkers int) *serverConfigBuilder {
+	sc.serviceConfig.AccrualWorkers = accrualWorkers
+	return sc
+}
+
+func (sc *serverConfigBuilder) withAccrualPollInterval(accrualPollInterval time.Duration) *serverConfigBuilder {
+	sc.serviceConfig.AccrualPollInterval = accrualPollInterval
+	return sc
+}
+
+func (sc *serverConfigBuilder) withAccrualMaxPollInterval(accrualMaxPollInterval time.Duration) *serverConfigBuilder {
+	sc.serviceConfig.AccrualMaxPollInterval = accrualMaxPollInterval
+	return sc
+}
+
+func (sc *serverConfigBuilder) withDBMaxConns(dbMaxConns int) *serverConfigBuilder {
+	sc.serviceConfig.DBMaxConns = dbMaxConns
+	return sc
+}
+
+func (sc *serverConfigBuilder) withDBMinConns(dbMinConns int) *serverConfigBuilder {
+	sc.serviceConfig.DBMinConns = dbMinConns
+	return sc
+}
+
+func (sc *serverConfigBuilder) withDBConnMaxLifetime(dbConnMaxLifetime time.Duration) *serverConfigBuilder {
+	sc.serviceConfig.DBConnMaxLifetime = dbConnMaxLifetime
+	return sc
+}
+
+func (sc *serverConfigBuilder) withAccrualRequestTimeout(accrualRequestTimeout time.Duration) *serverConfigBuilder {
+	sc.serviceConfig.AccrualRequestTimeout = accrualRequestTimeout
+	return sc
+}
+
+func (sc *serverConfigBuilder) withAccrualBatchSize(accrualBatchSize int) *serverConfigBuilder {
+	sc.serviceConfig.AccrualBatchSize = accrualBatchSize
+	return sc
+}
+
+func (sc *serverConfigBuilder) withUpdateCycleTimeout(updateCycleTimeout time.Duration) *serverConfigBuilder {
+	sc.serviceConfig.UpdateCycleTimeout = updateCycleTimeout
+	return sc
+}
+
+func (sc *serverConfigBuilder) withUpdaterStatementTimeout(updaterStatementTimeout time.Duration) *serverConfigBuilder {
+	sc.serviceConfig.UpdaterStatementTimeout = updaterStatementTimeout
+	return sc
+}
+
+func (sc *serverConfigBuilder) withSlowQueryThreshold(slowQueryThreshold time.Duration) *serverConfigBuilder {
+	sc.serviceConfig.SlowQueryThreshold = slowQueryThreshold
+	return sc
+}
+
+func (sc *serverConfigBuilder) withPendingOrdersBatchSize(pendingOrdersBatchSize int) *serverConfigBuilder {
+	sc.serviceConfig.PendingOrdersBatchSize = pendingOrdersBatchSize
+	return sc
+}
+
+func (sc *serverConfigBuilder) withOrderCheckCooldown(orderCheckCooldown time.Duration) *serverConfigBuilder {
+	sc.serviceConfig.OrderCheckCooldown = orderCheckCooldown
+	return sc
+}
+
+func (sc *serverConfigBuilder) withReadReplicaURI(readReplicaURI string) *serverConfigBuilder {
+	sc.serviceConfig.ReadReplicaURI = readReplicaURI
+	return sc
+}
+
+func (sc *serverConfigBuilder) withDispatchQueueSize(dispatchQueueSize int) *serverConfigBuilder {
+	sc.serviceConfig.DispatchQueueSize = dispatchQueueSize
+	return sc
+}
+
+func (sc *serverConfigBuilder) withAccrualRateLimitRPS(accrualRateLimitRPS float64) *serverConfigBuilder {
+	sc.serviceConfig.AccrualRateLimitRPS = accrualRateLimitRPS
+	return sc
+}
+
+func (sc *serverConfigBuilder) withAccrualRateLimitBurst(accrualRateLimitBurst int) *serverConfigBuilder {
+	sc.serviceConfig.AccrualRateLimitBurst = accrualRateLimitBurst
+	return sc
+}
+
+func (sc *serverConfigBuilder) withAccrualBreakerThreshold(accrualBreakerThreshold int) *serverConfigBuilder {
+	sc.serviceConfig.AccrualBreakerThreshold = accrualBreakerThreshold
+	return sc
+}
+
+func (sc *serverConfigBuilder) withAccrualBreakerCoolDown(accrualBreakerCoolDown time.Duration) *serverConfigBuilder {
+	sc.serviceConfig.AccrualBreakerCoolDown = accrualBreakerCoolDown
+	return sc
+}
+
+func (sc *serverConfigBuilder) withStuckOrderAge(stuckOrderAge time.Duration) *serverConfigBuilder {
+	sc.serviceConfig.StuckOrderAge = stuckOrderAge
+	return sc
+}
+
+func (sc *serverConfigBuilder) withOrderOwnerCacheSize(orderOwnerCacheSize int) *serverConfigBuilder {
+	sc.serviceConfig.OrderOwnerCacheSize = orderOwnerCacheSize
+	return sc
+}
+
+func (sc *serverConfigBuilder) withOrderOwnerCacheTTL(orderOwnerCacheTTL time.Duration) *serverConfigBuilder {
+	sc.serviceConfig.OrderOwnerCacheTTL = orderOwnerCacheTTL
+	return sc
+}
+
+func (sc *serverConfigBuilder) withCookieSameSite(cookieSameSite string) *serverConfigBuilder {
+	sc.serviceConfig.CookieSameSite = cookieSameSite
+	return sc
+}
+
+func (sc *serverConfigBuilder) withCookieSecure(cookieSecure bool) *serverConfigBuilder {
+	sc.serviceConfig.CookieSecure = cookieSecure
+	return sc
+}
+
+func (sc *serverConfigBuilder) withMaxUpdateAttempts(maxUpdateAttempts int) *serverConfigBuilder {
+	sc.serviceConfig.MaxUpdateAttempts = maxUpdateAttempts
+	return sc
+}
+
+func (sc *serverConfigBuilder) withBasePath(basePath string) *serverConfigBuilder {
+	sc.serviceConfig.BasePath = basePath
+	return sc
+}
+
+func (sc *serverConfigBuilder) withBalanceReconcileInterval(balanceReconcileInterval time.Duration) *serverConfigBuilder {
+	sc.serviceConfig.BalanceReconcileInterval = balanceReconcileInterval
+	return sc
+}
+
+func (sc *serverConfigBuilder) withBalanceDiscrepancyThreshold(balanceDiscrepancyThreshold float64) *serverConfigBuilder {
+	sc.serviceConfig.BalanceDiscrepancyThreshold = balanceDiscrepancyThreshold
+	return sc
+}
+
+func (sc *serverConfigBuilder) withStatsTimeZone(statsTimeZone string) *serverConfigBuilder {
+	sc.serviceConfig.StatsTimeZone = statsTimeZone
+	return sc
+}
+
+func (sc *serverConfigBuilder) withDBStatementTimeout(dbStatementTimeout time.Duration) *serverConfigBuilder {
+	sc.serviceConfig.DBStatementTimeout = dbStatementTimeout
+	return sc
+}
+
+func (sc *serverConfigBuilder) withWebhookWorkers(webhookWorkers int) *serverConfigBuilder {
+	sc.serviceConfig.WebhookWorkers = webhookWorkers
+	return sc
+}
+
+func (sc *serverConfigBuilder) withOTLPEndpoint(otlpEndpoint string) *serverConfigBuilder {
+	sc.serviceConfig.OTLPEndpoint = otlpEndpoint
+	return sc
+}
+
 func (sc *serverConfigBuilder) build() ServerConfig {
 	return sc.serviceConfig
 }
 
 func BuildServer() (ServerConfig, error) {
+	serverConfig, err := BuildServerFromArgs(os.Args[1:], os.LookupEnv)
+	if errors.Is(err, flag.ErrHelp) {
+		os.Exit(0)
+	}
+	return serverConfig, err
+}
+
+// BuildServerFromArgs собирает конфигурацию из переданных аргументов командной строки и
+// функции поиска переменных окружения, не затрагивая глобальные flag.CommandLine и os.Environ.
+func BuildServerFromArgs(args []string, lookupEnv func(string) (string, bool)) (ServerConfig, error) {
+	fs := flag.NewFlagSet("gophermart", flag.ContinueOnError)
+
 	var (
-		serverRunAddress     string
-		databaseURI          string
-		accrualSystemAddress string
-		jwtSecretKey         string
+		serverRunAddress            string
+		databaseURI                 string
+		accrualSystemAddress        string
+		jwtSecretKey                string
+		jwtFallbackKeys             string
+		apiValidationMode           string
+		financialTxIsolation        string
+		webhookTimeout              time.Duration
+		webhookMaxRetries           int
+		webhookSecret               string
+		idempotencyKeyTTL           time.Duration
+		readHeaderTimeout           time.Duration
+		readTimeout                 time.Duration
+		writeTimeout                time.Duration
+		idleTimeout                 time.Duration
+		maxHeaderBytes              int
+		maxWithdrawalSum            float64
+		enableHTTPS                 bool
+		tlsCertFile                 string
+		tlsKeyFile                  string
+		maxLoginLength              int
+		adminKey                    string
+		allowInsecureDevSecret      bool
+		configFile                  string
+		dbHealthCheckInterval       time.Duration
+		dbQueryTimeout              time.Duration
+		logLevel                    string
+		logFormat                   string
+		apiVersion                  string
+		maxOrderBatchSize           int
+		logOutput                   string
+		logFile                     string
+		logFileMaxSizeMB            int
+		logFileMaxBackups           int
+		logFileMaxAgeDays           int
+		authRateLimitRPS            float64
+		authRateLimitBurst          int
+		logLevelAccess              string
+		logLevelFloor               string
+		orderRateLimitRPS           float64
+		orderRateLimitBurst         int
+		enableSwaggerUI             bool
+		debugAddress                string
+		dbStatsInterval             time.Duration
+		accrualWorkers              int
+		accrualPollInterval         time.Duration
+		accrualMaxPollInterval      time.Duration
+		dbMaxConns                  int
+		dbMinConns                  int
+		dbConnMaxLifetime           time.Duration
+		accrualRequestTimeout       time.Duration
+		accrualBatchSize            int
+		updateCycleTimeout          time.Duration
+		updaterStatementTimeout     time.Duration
+		slowQueryThreshold          time.Duration
+		pendingOrdersBatchSize      int
+		orderCheckCooldown          time.Duration
+		readReplicaURI              string
+		dispatchQueueSize           int
+		accrualRateLimitRPS         float64
+		accrualRateLimitBurst       int
+		accrualBreakerThreshold     int
+		accrualBreakerCoolDown      time.Duration
+		stuckOrderAge               time.Duration
+		orderOwnerCacheSize         int
+		orderOwnerCacheTTL          time.Duration
+		cookieSameSite              string
+		cookieSecure                bool
+		maxUpdateAttempts           int
+		basePath                    string
+		balanceReconcileInterval    time.Duration
+		balanceDiscrepancyThreshold float64
+		statsTimeZone               string
+		dbStatementTimeout          time.Duration
+		webhookWorkers              int
+		otlpEndpoint                string
 	)
 
-	flag.StringVar(&serverRunAddress, "a", "localhost:8080", "address:port to run server")
-	flag.StringVar(&databaseURI, "d", "", "connection string for driver to establish connection to he DB")
-	flag.StringVar(&accrualSystemAddress, "r", "", "address of the accrual calculation system")
-	flag.StringVar(&jwtSecretKey, "j", "temp_secret_key", "jwt secret key")
-	flag.Parse()
+	fs.StringVar(&serverRunAddress, "a", "localhost:8080", "address:port to run server")
+	fs.StringVar(&databaseURI, "d", "", "connection string for driver to establish connection to he DB")
+	fs.StringVar(&accrualSystemAddress, "r", "", "address of the accrual calculation system")
+	fs.StringVar(&jwtSecretKey, "j", DefaultJWTSecretKey, "jwt secret key")
+	fs.StringVar(&jwtFallbackKeys, "jwt-fallback-keys", "", "comma-separated previous jwt secret keys still accepted for verification, in order")
+	fs.StringVar(&apiValidationMode, "validate", "off", "openapi request validation mode: off, warn or enforce")
+	fs.StringVar(&financialTxIsolation, "tx-isolation", "repeatable_read", "isolation level of balance transactions: read_committed, repeatable_read or serializable")
+	fs.DurationVar(&webhookTimeout, "webhook-timeout", time.Second*5, "timeout of a single webhook delivery")
+	fs.IntVar(&webhookMaxRetries, "webhook-retries", 3, "max retries of a webhook delivery")
+	fs.StringVar(&webhookSecret, "webhook-secret", "", "secret used to sign webhook payloads")
+	fs.DurationVar(&idempotencyKeyTTL, "idempotency-ttl", time.Hour*24, "lifetime of withdrawal idempotency keys")
+	fs.DurationVar(&readHeaderTimeout, "read-header-timeout", time.Second*5, "time allowed to read request headers")
+	fs.DurationVar(&readTimeout, "read-timeout", time.Second*10, "time allowed to read the entire request")
+	fs.DurationVar(&writeTimeout, "write-timeout", time.Second*10, "time allowed to write the response")
+	fs.DurationVar(&idleTimeout, "idle-timeout", time.Second*60, "time to keep idle keep-alive connections")
+	fs.IntVar(&maxHeaderBytes, "max-header-bytes", http.DefaultMaxHeaderBytes, "max size of request headers in bytes")
+	fs.Float64Var(&maxWithdrawalSum, "max-withdrawal", 0, "max sum of a single withdrawal, 0 means unlimited")
+	fs.BoolVar(&enableHTTPS, "s", false, "enable HTTPS")
+	fs.StringVar(&tlsCertFile, "tls-cert", "", "path to TLS certificate file")
+	fs.StringVar(&tlsKeyFile, "tls-key", "", "path to TLS private key file")
+	fs.IntVar(&maxLoginLength, "max-login-length", 50, "max length of user login")
+	fs.StringVar(&adminKey, "admin-key", "", "api key for admin endpoints, admin api is disabled if empty")
+	fs.BoolVar(&allowInsecureDevSecret, "allow-insecure-dev-secret", false, "allow running with the default jwt secret key (local development only)")
+	fs.DurationVar(&dbHealthCheckInterval, "db-health-interval", time.Second*5, "interval of database health checks")
+	fs.DurationVar(&dbQueryTimeout, "db-query-timeout", time.Second*5, "max duration of a single read query, 0 disables the limit")
+	fs.StringVar(&logLevel, "log-level", "debug", "log level: debug, info, warn, error")
+	fs.StringVar(&logFormat, "log-format", "console", "log format: console or json")
+	fs.StringVar(&apiVersion, "api-version", "v1", "version prefix of the user api, /api/user is redirected to /api/<version>/user")
+	fs.IntVar(&maxOrderBatchSize, "max-order-batch", 100, "max number of order numbers in a batch upload")
+	fs.StringVar(&logOutput, "log-output", "stdout", "standard stream for logs: stdout, stderr or none")
+	fs.StringVar(&logFile, "log-file", "", "path to a log file written in addition to -log-output, rotated by size")
+	fs.IntVar(&logFileMaxSizeMB, "log-file-max-size", 100, "max size of the log file in megabytes before rotation")
+	fs.IntVar(&logFileMaxBackups, "log-file-max-backups", 3, "max number of rotated log files to keep, 0 keeps all")
+	fs.IntVar(&logFileMaxAgeDays, "log-file-max-age", 28, "max age of rotated log files in days, 0 disables age-based removal")
+	fs.Float64Var(&authRateLimitRPS, "auth-rate-limit", 1, "requests per second allowed from one IP to register and login, 0 disables the limit")
+	fs.IntVar(&authRateLimitBurst, "auth-rate-burst", 10, "burst of requests allowed from one IP to register and login")
+	fs.StringVar(&logLevelAccess, "log-level-access", "off", "access to PUT /debug/loglevel: off, loopback or admin (requires -admin-key)")
+	fs.StringVar(&logLevelFloor, "log-level-floor", "info", "lowest log level that can be set at runtime with -log-format json")
+	fs.Float64Var(&orderRateLimitRPS, "order-rate-limit", 10, "order uploads per second allowed for one user, 0 disables the limit")
+	fs.IntVar(&orderRateLimitBurst, "order-rate-burst", 10, "burst of order uploads allowed for one user")
+	fs.BoolVar(&enableSwaggerUI, "swagger-ui", false, "serve Swagger UI under /docs")
+	fs.StringVar(&debugAddress, "debug-address", "", "address:port of the debug listener with pprof and expvar, disabled if empty (never expose publicly)")
+	fs.DurationVar(&dbStatsInterval, "db-stats-interval", time.Second*10, "interval of publishing database pool stats to expvar")
+	fs.IntVar(&accrualWorkers, "accrual-workers", 10, "number of concurrent requests to the accrual system; higher values add load on it and hit its rate limit sooner")
+	fs.DurationVar(&accrualPollInterval, "accrual-poll-interval", time.Millisecond*500, "min interval between accrual system polls")
+	fs.DurationVar(&accrualMaxPollInterval, "accrual-max-poll-interval", time.Second*60, "max interval between accrual system polls while it responds 429")
+	fs.IntVar(&dbMaxConns, "db-max-conns", 25, "max number of open database connections")
+	fs.IntVar(&dbMinConns, "db-min-conns", 5, "number of idle database connections kept open")
+	fs.DurationVar(&dbConnMaxLifetime, "db-conn-max-lifetime", time.Minute*30, "max lifetime of a database connection, 0 disables the limit")
+	fs.DurationVar(&accrualRequestTimeout, "accrual-request-timeout", time.Second*5, "timeout of a single accrual system request")
+	fs.IntVar(&accrualBatchSize, "accrual-batch-size", 50, "number of orders in a single accrual system batch request, 1 disables batch requests")
+	fs.DurationVar(&updateCycleTimeout, "update-cycle-timeout", time.Second*30, "max duration of an order status update cycle")
+	fs.DurationVar(&updaterStatementTimeout, "updater-statement-timeout", time.Second*5, "statement_timeout for order status updater queries, 0 disables it")
+	fs.DurationVar(&slowQueryThreshold, "slow-query-threshold", 0, "log database operations slower than this, 0 disables slow query logging")
+	fs.IntVar(&pendingOrdersBatchSize, "pending-orders-batch-size", 100, "max number of pending orders polled per update cycle")
+	fs.DurationVar(&orderCheckCooldown, "order-check-cooldown", time.Second*10, "min time between two status checks of the same order")
+	fs.StringVar(&readReplicaURI, "read-replica-uri", "", "connection string of a read replica for order, withdrawal and balance reads")
+	fs.IntVar(&dispatchQueueSize, "dispatch-queue-size", 1000, "capacity of the queue of new orders checked right after upload, 0 disables immediate checks")
+	fs.Float64Var(&accrualRateLimitRPS, "accrual-rate-limit", 100, "max accrual system requests per second shared by all workers, 0 disables the limit")
+	fs.IntVar(&accrualRateLimitBurst, "accrual-rate-burst", 10, "max burst of accrual system requests")
+	fs.IntVar(&accrualBreakerThreshold, "accrual-breaker-threshold", 5, "consecutive accrual system failures that open the circuit breaker")
+	fs.DurationVar(&accrualBreakerCoolDown, "accrual-breaker-cool-down", time.Second*30, "time the accrual circuit breaker stays open before a probe request")
+	fs.DurationVar(&stuckOrderAge, "stuck-order-age", time.Hour, "age after which an unfinished order is reported as stuck to admins")
+	fs.IntVar(&orderOwnerCacheSize, "order-owner-cache-size", 10000, "max entries of the in-memory order owner cache, 0 disables it")
+	fs.DurationVar(&orderOwnerCacheTTL, "order-owner-cache-ttl", time.Minute*5, "time to live of order owner cache entries")
+	fs.StringVar(&cookieSameSite, "cookie-same-site", "lax", "SameSite attribute of the auth cookie: lax, strict or none")
+	fs.BoolVar(&cookieSecure, "cookie-secure", false, "set the Secure attribute on the auth cookie (always set with HTTPS)")
+	fs.IntVar(&maxUpdateAttempts, "max-update-attempts", 5, "consecutive failed status updates after which an order is excluded from polling")
+	fs.StringVar(&basePath, "base-path", "", "path prefix the whole api is mounted under, e.g. /gophermart, empty mounts it at the root")
+	fs.DurationVar(&balanceReconcileInterval, "balance-reconcile-interval", time.Hour*24, "interval of comparing balances with accruals minus withdrawals, 0 disables the check")
+	fs.Float64Var(&balanceDiscrepancyThreshold, "balance-discrepancy-threshold", 0.01, "balance discrepancy reported by the reconciliation check")
+	fs.StringVar(&statsTimeZone, "stats-time-zone", "UTC", "IANA time zone the daily admin stats are bucketed in, e.g. Europe/Moscow")
+	fs.DurationVar(&dbStatementTimeout, "db-statement-timeout", time.Second*30, "server-side statement_timeout set on every database connection, 0 disables it")
+	fs.IntVar(&webhookWorkers, "webhook-workers", 4, "number of concurrent webhook deliveries")
+	fs.StringVar(&otlpEndpoint, "otlp-endpoint", "", "OTLP/HTTP collector address traces are exported to, e.g. http://localhost:4318, empty disables export")
+	fs.StringVar(&configFile, "c", "", "path to YAML or JSON config file")
+	fs.StringVar(&configFile, "config", "", "path to YAML or JSON config file")
+	if err := fs.Parse(args); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	// флаги командной строки запоминаются, чтобы вернуть их поверх переменных окружения
+	cliValues := make(map[string]string)
+	fs.Visit(func(f *flag.Flag) {
+		cliValues[f.Name] = f.Value.String()
+	})
+
+	if envConfigFile, ok := lookupEnv("CONFIG"); envConfigFile != "" && ok && configFile == "" {
+		configFile = envConfigFile
+	}
+
+	if configFile != "" {
+		unknownKeys, err := applyConfigFile(configFile, fs)
+		if err != nil {
+			return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+		}
+		if len(unknownKeys) > 0 {
+			log.Printf("warning: unknown keys in config file %s: %s", configFile, strings.Join(unknownKeys, ", "))
+		}
+	}
 
-	if envServerRunAddress, ok := os.LookupEnv("RUN_ADDRESS"); envServerRunAddress != "" && ok {
+	if envServerRunAddress, ok := lookupEnv("RUN_ADDRESS"); envServerRunAddress != "" && ok {
 		serverRunAddress = envServerRunAddress
 	}
 
-	if envDatabaseURI, ok := os.LookupEnv("DATABASE_URI"); envDatabaseURI != "" && ok {
-		databaseURI = envDatabaseURI
+	if err := lookupEnvSecret(lookupEnv, "DATABASE_URI", &databaseURI); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
 	}
 
-	if envAccrualSystemAddress, ok := os.LookupEnv("ACCRUAL_SYSTEM_ADDRESS"); envAccrualSystemAddress != "" && ok {
+	if envAccrualSystemAddress, ok := lookupEnv("ACCRUAL_SYSTEM_ADDRESS"); envAccrualSystemAddress != "" && ok {
 		accrualSystemAddress = envAccrualSystemAddress
 	}
 
-	if envJWTSecretKey, ok := os.LookupEnv("JWT_SECRET_KEY"); envJWTSecretKey != "" && ok {
-		jwtSecretKey = envJWTSecretKey
+	if err := lookupEnvSecret(lookupEnv, "JWT_SECRET_KEY", &jwtSecretKey); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+	if err := lookupEnvSecret(lookupEnv, "JWT_FALLBACK_KEYS", &jwtFallbackKeys); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if envAPIValidationMode, ok := lookupEnv("API_VALIDATION_MODE"); envAPIValidationMode != "" && ok {
+		apiValidationMode = envAPIValidationMode
+	}
+
+	if envFinancialTxIsolation, ok := lookupEnv("FINANCIAL_TX_ISOLATION"); envFinancialTxIsolation != "" && ok {
+		financialTxIsolation = envFinancialTxIsolation
+	}
+
+	if err := lookupEnvDuration(lookupEnv, "WEBHOOK_TIMEOUT", &webhookTimeout); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvInt(lookupEnv, "WEBHOOK_MAX_RETRIES", &webhookMaxRetries); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvSecret(lookupEnv, "WEBHOOK_SECRET", &webhookSecret); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvDuration(lookupEnv, "IDEMPOTENCY_KEY_TTL", &idempotencyKeyTTL); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvDuration(lookupEnv, "READ_HEADER_TIMEOUT", &readHeaderTimeout); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvDuration(lookupEnv, "READ_TIMEOUT", &readTimeout); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvDuration(lookupEnv, "WRITE_TIMEOUT", &writeTimeout); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvDuration(lookupEnv, "IDLE_TIMEOUT", &idleTimeout); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvInt(lookupEnv, "MAX_HEADER_BYTES", &maxHeaderBytes); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvFloat(lookupEnv, "MAX_WITHDRAWAL_SUM", &maxWithdrawalSum); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvBool(lookupEnv, "ENABLE_HTTPS", &enableHTTPS); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if envTLSCertFile, ok := lookupEnv("TLS_CERT_FILE"); envTLSCertFile != "" && ok {
+		tlsCertFile = envTLSCertFile
+	}
+
+	if envTLSKeyFile, ok := lookupEnv("TLS_KEY_FILE"); envTLSKeyFile != "" && ok {
+		tlsKeyFile = envTLSKeyFile
+	}
+
+	if err := lookupEnvInt(lookupEnv, "MAX_LOGIN_LENGTH", &maxLoginLength); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvSecret(lookupEnv, "ADMIN_KEY", &adminKey); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvDuration(lookupEnv, "DB_HEALTH_CHECK_INTERVAL", &dbHealthCheckInterval); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvDuration(lookupEnv, "DB_QUERY_TIMEOUT", &dbQueryTimeout); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if envLogLevel, ok := lookupEnv("LOG_LEVEL"); envLogLevel != "" && ok {
+		logLevel = envLogLevel
+	}
+
+	if envLogFormat, ok := lookupEnv("LOG_FORMAT"); envLogFormat != "" && ok {
+		logFormat = envLogFormat
+	}
+
+	if envAPIVersion, ok := lookupEnv("API_VERSION"); envAPIVersion != "" && ok {
+		apiVersion = envAPIVersion
+	}
+
+	if err := lookupEnvInt(lookupEnv, "MAX_ORDER_BATCH_SIZE", &maxOrderBatchSize); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if envLogOutput, ok := lookupEnv("LOG_OUTPUT"); envLogOutput != "" && ok {
+		logOutput = envLogOutput
+	}
+
+	if envLogFile, ok := lookupEnv("LOG_FILE"); envLogFile != "" && ok {
+		logFile = envLogFile
+	}
+
+	if err := lookupEnvInt(lookupEnv, "LOG_FILE_MAX_SIZE_MB", &logFileMaxSizeMB); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvInt(lookupEnv, "LOG_FILE_MAX_BACKUPS", &logFileMaxBackups); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvInt(lookupEnv, "LOG_FILE_MAX_AGE_DAYS", &logFileMaxAgeDays); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvFloat(lookupEnv, "AUTH_RATE_LIMIT_RPS", &authRateLimitRPS); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvInt(lookupEnv, "AUTH_RATE_LIMIT_BURST", &authRateLimitBurst); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if envLogLevelAccess, ok := lookupEnv("LOG_LEVEL_ACCESS"); envLogLevelAccess != "" && ok {
+		logLevelAccess = envLogLevelAccess
 	}
 
-	return newServiceConfigBuilder().
+	if envLogLevelFloor, ok := lookupEnv("LOG_LEVEL_FLOOR"); envLogLevelFloor != "" && ok {
+		logLevelFloor = envLogLevelFloor
+	}
+
+	if err := lookupEnvFloat(lookupEnv, "ORDER_RATE_LIMIT_RPS", &orderRateLimitRPS); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvInt(lookupEnv, "ORDER_RATE_LIMIT_BURST", &orderRateLimitBurst); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvBool(lookupEnv, "SWAGGER_UI", &enableSwaggerUI); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if envDebugAddress, ok := lookupEnv("DEBUG_ADDRESS"); envDebugAddress != "" && ok {
+		debugAddress = envDebugAddress
+	}
+
+	if err := lookupEnvDuration(lookupEnv, "DB_STATS_INTERVAL", &dbStatsInterval); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvInt(lookupEnv, "ACCRUAL_WORKERS", &accrualWorkers); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvDuration(lookupEnv, "ACCRUAL_POLL_INTERVAL", &accrualPollInterval); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvDuration(lookupEnv, "ACCRUAL_MAX_POLL_INTERVAL", &accrualMaxPollInterval); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvInt(lookupEnv, "DB_MAX_CONNS", &dbMaxConns); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvInt(lookupEnv, "DB_MIN_CONNS", &dbMinConns); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvDuration(lookupEnv, "DB_CONN_MAX_LIFETIME", &dbConnMaxLifetime); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvDuration(lookupEnv, "ACCRUAL_REQUEST_TIMEOUT", &accrualRequestTimeout); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvInt(lookupEnv, "ACCRUAL_BATCH_SIZE", &accrualBatchSize); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvDuration(lookupEnv, "UPDATE_CYCLE_TIMEOUT", &updateCycleTimeout); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvDuration(lookupEnv, "UPDATER_STATEMENT_TIMEOUT", &updaterStatementTimeout); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvDuration(lookupEnv, "SLOW_QUERY_THRESHOLD", &slowQueryThreshold); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvInt(lookupEnv, "PENDING_ORDERS_BATCH_SIZE", &pendingOrdersBatchSize); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvDuration(lookupEnv, "ORDER_CHECK_COOLDOWN", &orderCheckCooldown); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvSecret(lookupEnv, "READ_REPLICA_URI", &readReplicaURI); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvInt(lookupEnv, "DISPATCH_QUEUE_SIZE", &dispatchQueueSize); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvFloat(lookupEnv, "ACCRUAL_RATE_LIMIT", &accrualRateLimitRPS); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvInt(lookupEnv, "ACCRUAL_RATE_BURST", &accrualRateLimitBurst); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvInt(lookupEnv, "ACCRUAL_BREAKER_THRESHOLD", &accrualBreakerThreshold); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvDuration(lookupEnv, "ACCRUAL_BREAKER_COOL_DOWN", &accrualBreakerCoolDown); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvDuration(lookupEnv, "STUCK_ORDER_AGE", &stuckOrderAge); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvInt(lookupEnv, "ORDER_OWNER_CACHE_SIZE", &orderOwnerCacheSize); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+	if err := lookupEnvDuration(lookupEnv, "ORDER_OWNER_CACHE_TTL", &orderOwnerCacheTTL); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if envCookieSameSite, ok := lookupEnv("COOKIE_SAME_SITE"); envCookieSameSite != "" && ok {
+		cookieSameSite = envCookieSameSite
+	}
+	if err := lookupEnvBool(lookupEnv, "COOKIE_SECURE", &cookieSecure); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvInt(lookupEnv, "MAX_UPDATE_ATTEMPTS", &maxUpdateAttempts); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if envBasePath, ok := lookupEnv("BASE_PATH"); envBasePath != "" && ok {
+		basePath = envBasePath
+	}
+
+	if err := lookupEnvDuration(lookupEnv, "BALANCE_RECONCILE_INTERVAL", &balanceReconcileInterval); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+	if err := lookupEnvFloat(lookupEnv, "BALANCE_DISCREPANCY_THRESHOLD", &balanceDiscrepancyThreshold); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if envStatsTimeZone, ok := lookupEnv("STATS_TIME_ZONE"); envStatsTimeZone != "" && ok {
+		statsTimeZone = envStatsTimeZone
+	}
+
+	if err := lookupEnvDuration(lookupEnv, "DB_STATEMENT_TIMEOUT", &dbStatementTimeout); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if err := lookupEnvInt(lookupEnv, "WEBHOOK_WORKERS", &webhookWorkers); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+
+	if envOtlpEndpoint, ok := lookupEnv("OTEL_EXPORTER_OTLP_ENDPOINT"); envOtlpEndpoint != "" && ok {
+		otlpEndpoint = envOtlpEndpoint
+	}
+
+	// флаги командной строки приоритетнее переменных окружения
+	for name, value := range cliValues {
+		if err := fs.Set(name, value); err != nil {
+			return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+		}
+	}
+
+	serverConfig := newServiceConfigBuilder().
 		withServerRunAddress(serverRunAddress).
 		withDatabaseURI(databaseURI).
 		withAccrualSystemAddress(accrualSystemAddress).
 		withJWTSecretKey(jwtSecretKey).
-		build(), nil
+		withJWTFallbackKeys(splitList(jwtFallbackKeys)).
+		withAPIValidationMode(apiValidationMode).
+		withFinancialTxIsolation(financialTxIsolation).
+		withWebhookTimeout(webhookTimeout).
+		withWebhookMaxRetries(webhookMaxRetries).
+		withWebhookSecret(webhookSecret).
+		withIdempotencyKeyTTL(idempotencyKeyTTL).
+		withReadHeaderTimeout(readHeaderTimeout).
+		withReadTimeout(readTimeout).
+		withWriteTimeout(writeTimeout).
+		withIdleTimeout(idleTimeout).
+		withMaxHeaderBytes(maxHeaderBytes).
+		withMaxWithdrawalSum(maxWithdrawalSum).
+		withEnableHTTPS(enableHTTPS).
+		withTLSCertFile(tlsCertFile).
+		withTLSKeyFile(tlsKeyFile).
+		withMaxLoginLength(maxLoginLength).
+		withAdminKey(adminKey).
+		withAllowInsecureDevSecret(allowInsecureDevSecret).
+		withDBHealthCheckInterval(dbHealthCheckInterval).
+		withDBQueryTimeout(dbQueryTimeout).
+		withLogLevel(logLevel).
+		withLogFormat(logFormat).
+		withAPIVersion(apiVersion).
+		withMaxOrderBatchSize(maxOrderBatchSize).
+		withLogOutput(logOutput).
+		withLogFile(logFile).
+		withLogFileMaxSizeMB(logFileMaxSizeMB).
+		withLogFileMaxBackups(logFileMaxBackups).
+		withLogFileMaxAgeDays(logFileMaxAgeDays).
+		withAuthRateLimitRPS(authRateLimitRPS).
+		withAuthRateLimitBurst(authRateLimitBurst).
+		withLogLevelAccess(logLevelAccess).
+		withLogLevelFloor(logLevelFloor).
+		withOrderRateLimitRPS(orderRateLimitRPS).
+		withOrderRateLimitBurst(orderRateLimitBurst).
+		withEnableSwaggerUI(enableSwaggerUI).
+		withDebugAddress(debugAddress).
+		withDBStatsInterval(dbStatsInterval).
+		withAccrualWorkers(accrualWorkers).
+		withAccrualPollInterval(accrualPollInterval).
+		withAccrualMaxPollInterval(accrualMaxPollInterval).
+		withDBMaxConns(dbMaxConns).
+		withDBMinConns(dbMinConns).
+		withDBConnMaxLifetime(dbConnMaxLifetime).
+		withAccrualRequestTimeout(accrualRequestTimeout).
+		withAccrualBatchSize(accrualBatchSize).
+		withUpdateCycleTimeout(updateCycleTimeout).
+		withUpdaterStatementTimeout(updaterStatementTimeout).
+		withSlowQueryThreshold(slowQueryThreshold).
+		withPendingOrdersBatchSize(pendingOrdersBatchSize).
+		withOrderCheckCooldown(orderCheckCooldown).
+		withReadReplicaURI(readReplicaURI).
+		withDispatchQueueSize(dispatchQueueSize).
+		withAccrualRateLimitRPS(accrualRateLimitRPS).
+		withAccrualRateLimitBurst(accrualRateLimitBurst).
+		withAccrualBreakerThreshold(accrualBreakerThreshold).
+		withAccrualBreakerCoolDown(accrualBreakerCoolDown).
+		withStuckOrderAge(stuckOrderAge).
+		withOrderOwnerCacheSize(orderOwnerCacheSize).
+		withOrderOwnerCacheTTL(orderOwnerCacheTTL).
+		withCookieSameSite(cookieSameSite).
+		withCookieSecure(cookieSecure).
+		withMaxUpdateAttempts(maxUpdateAttempts).
+		withBasePath(basePath).
+		withBalanceReconcileInterval(balanceReconcileInterval).
+		withBalanceDiscrepancyThreshold(balanceDiscrepancyThreshold).
+		withStatsTimeZone(statsTimeZone).
+		withDBStatementTimeout(dbStatementTimeout).
+		withWebhookWorkers(webhookWorkers).
+		withOTLPEndpoint(otlpEndpoint).
+		build()
+
+	if err := serverConfig.Validate(); err != nil {
+		return ServerConfig{}, fmt.Errorf("buildServer: %w", err)
+	}
+	return serverConfig, nil
+}
+
+// lookupEnvSecret читает секрет из переменной name либо из файла, путь к которому задан в name_FILE
+// (Docker/Kubernetes secrets). Одновременное задание обеих переменных считается ошибкой.
+func lookupEnvSecret(lookupEnv func(string) (string, bool), name string, target *string) error {
+	value, ok := lookupEnv(name)
+	hasValue := ok && value != ""
+	path, ok := lookupEnv(name + "_FILE")
+	hasFile := ok && path != ""
+
+	switch {
+	case hasValue && hasFile:
+		return fmt.Errorf("both %s and %s_FILE are set, use only one of them", name, name)
+	case hasValue:
+		*target = value
+	case hasFile:
+		content, err := os.ReadFile(path)
+		if err != nil {
+			return fmt.Errorf("error reading %s_FILE: %w", name, err)
+		}
+		secret := strings.TrimSpace(string(content))
+		if secret == "" {
+			return fmt.Errorf("error reading %s_FILE: file %s is empty", name, path)
+		}
+		*target = secret
+	}
+	return nil
+}
+
+func lookupEnvDuration(lookupEnv func(string) (string, bool), name string, target *time.Duration) error {
+	if value, ok := lookupEnv(name); value != "" && ok {
+		parsed, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("error parsing %s: %w", name, err)
+		}
+		*target = parsed
+	}
+	return nil
+}
+
+func lookupEnvInt(lookupEnv func(string) (string, bool), name string, target *int) error {
+	if value, ok := lookupEnv(name); value != "" && ok {
+		parsed, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("error parsing %s: %w", name, err)
+		}
+		*target = parsed
+	}
+	return nil
+}
+
+func lookupEnvFloat(lookupEnv func(string) (string, bool), name string, target *float64) error {
+	if value, ok := lookupEnv(name); value != "" && ok {
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return fmt.Errorf("error parsing %s: %w", name, err)
+		}
+		*target = parsed
+	}
+	return nil
+}
+
+func lookupEnvBool(lookupEnv func(string) (string, bool), name string, target *bool) error {
+	if value, ok := lookupEnv(name); value != "" && ok {
+		parsed, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("error parsing %s: %w", name, err)
+		}
+		*target = parsed
+	}
+	return nil
+}
+
+// splitList разбирает список значений через запятую, пропуская пустые элементы.
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
 }
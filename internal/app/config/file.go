@@ -0,0 +1,130 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"os"
+	"sort"
+)
+
+// configFileKeys сопоставляет ключи файла конфигурации (поля ServerConfig в snake_case) с флагами.
+var configFileKeys = map[string]string{
+	"server_run_address":            "a",
+	"database_uri":                  "d",
+	"accrual_system_address":        "r",
+	"jwt_secret_key":                "j",
+	"jwt_fallback_keys":             "jwt-fallback-keys",
+	"api_validation_mode":           "validate",
+	"financial_tx_isolation":        "tx-isolation",
+	"webhook_timeout":               "webhook-timeout",
+	"webhook_max_retries":           "webhook-retries",
+	"webhook_secret":                "webhook-secret",
+	"idempotency_key_ttl":           "idempotency-ttl",
+	"read_header_timeout":           "read-header-timeout",
+	"read_timeout":                  "read-timeout",
+	"write_timeout":                 "write-timeout",
+	"idle_timeout":                  "idle-timeout",
+	"max_header_bytes":              "max-header-bytes",
+	"max_withdrawal_sum":            "max-withdrawal",
+	"enable_https":                  "s",
+	"tls_cert_file":                 "tls-cert",
+	"tls_key_file":                  "tls-key",
+	"max_login_length":              "max-login-length",
+	"admin_key":                     "admin-key",
+	"allow_insecure_dev_secret":     "allow-insecure-dev-secret",
+	"db_health_check_interval":      "db-health-interval",
+	"db_query_timeout":              "db-query-timeout",
+	"log_level":                     "log-level",
+	"log_format":                    "log-format",
+	"api_version":                   "api-version",
+	"max_order_batch_size":          "max-order-batch",
+	"log_output":                    "log-output",
+	"log_file":                      "log-file",
+	"log_file_max_size_mb":          "log-file-max-size",
+	"log_file_max_backups":          "log-file-max-backups",
+	"log_file_max_age_days":         "log-file-max-age",
+	"auth_rate_limit_rps":           "auth-rate-limit",
+	"auth_rate_limit_burst":         "auth-rate-burst",
+	"log_level_access":              "log-level-access",
+	"log_level_floor":               "log-level-floor",
+	"order_rate_limit_rps":          "order-rate-limit",
+	"order_rate_limit_burst":        "order-rate-burst",
+	"enable_swagger_ui":             "swagger-ui",
+	"debug_address":                 "debug-address",
+	"db_stats_interval":             "db-stats-interval",
+	"accrual_workers":               "accrual-workers",
+	"accrual_poll_interval":         "accrual-poll-interval",
+	"accrual_max_poll_interval":     "accrual-max-poll-interval",
+	"db_max_conns":                  "db-max-conns",
+	"db_min_conns":                  "db-min-conns",
+	"db_conn_max_lifetime":          "db-conn-max-lifetime",
+	"accrual_request_timeout":       "accrual-request-timeout",
+	"accrual_batch_size":            "accrual-batch-size",
+	"update_cycle_timeout":          "update-cycle-timeout",
+	"updater_statement_timeout":     "updater-statement-timeout",
+	"slow_query_threshold":          "slow-query-threshold",
+	"pending_orders_batch_size":     "pending-orders-batch-size",
+	"order_check_cooldown":          "order-check-cooldown",
+	"read_replica_uri":              "read-replica-uri",
+	"dispatch_queue_size":           "dispatch-queue-size",
+	"accrual_rate_limit":            "accrual-rate-limit",
+	"accrual_rate_burst":            "accrual-rate-burst",
+	"accrual_breaker_threshold":     "accrual-breaker-threshold",
+	"accrual_breaker_cool_down":     "accrual-breaker-cool-down",
+	"stuck_order_age":               "stuck-order-age",
+	"order_owner_cache_size":        "order-owner-cache-size",
+	"order_owner_cache_ttl":         "order-owner-cache-ttl",
+	"cookie_same_site":              "cookie-same-site",
+	"cookie_secure":                 "cookie-secure",
+	"max_update_attempts":           "max-update-attempts",
+	"base_path":                     "base-path",
+	"balance_reconcile_interval":    "balance-reconcile-interval",
+	"balance_discrepancy_threshold": "balance-discrepancy-threshold",
+	"stats_time_zone":               "stats-time-zone",
+	"db_statement_timeout":          "db-statement-timeout",
+	"webhook_workers":               "webhook-workers",
+	"otlp_endpoint":                 "otlp-endpoint",
+}
+
+// applyConfigFile читает YAML или JSON файл и выставляет значения флагов, не заданных явно
+// в командной строке. Так значения из файла перекрывают только значения по умолчанию,
+// а переменные окружения, применяемые после, перекрывают файл, но не флаги.
+// Возвращает отсортированный список неизвестных ключей.
+func applyConfigFile(path string, flagSet *flag.FlagSet) ([]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("applyConfigFile: error reading config file: %w", err)
+	}
+
+	// JSON является подмножеством YAML, поэтому один декодер подходит для обоих форматов
+	values := make(map[string]interface{})
+	if err = yaml.Unmarshal(data, &values); err != nil {
+		return nil, fmt.Errorf("applyConfigFile: malformed config file %s: %w", path, err)
+	}
+
+	explicit := make(map[string]bool)
+	flagSet.Visit(func(f *flag.Flag) {
+		explicit[f.Name] = true
+	})
+
+	var unknownKeys []string
+	for key, value := range values {
+		flagName, ok := configFileKeys[key]
+		if !ok {
+			unknownKeys = append(unknownKeys, key)
+			continue
+		}
+		if explicit[flagName] {
+			continue
+		}
+		if value == nil {
+			continue
+		}
+		if err = flagSet.Set(flagName, fmt.Sprint(value)); err != nil {
+			return nil, fmt.Errorf("applyConfigFile: invalid value for %q in %s: %w", key, path, err)
+		}
+	}
+	sort.Strings(unknownKeys)
+	return unknownKeys, nil
+}
@@ -0,0 +1,88 @@
+package config
+
+import (
+	"bytes"
+	"flag"
+	"log"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyConfigFile(t *testing.T) {
+	tests := []struct {
+		name        string
+		file        string
+		content     string
+		wantErr     string
+		wantUnknown []string
+		wantTimeout time.Duration
+	}{
+		{name: "yaml", file: "config.yaml", content: "write_timeout: 20s\n", wantTimeout: time.Second * 20},
+		{name: "json", file: "config.json", content: `{"write_timeout": "20s"}`, wantTimeout: time.Second * 20},
+		{name: "null value keeps default", file: "config.yaml", content: "write_timeout:\n", wantTimeout: time.Second * 10},
+		{name: "unknown keys", file: "config.yaml", content: "write_timout: 20s\nlog_levle: info\nwrite_timeout: 20s\n", wantUnknown: []string{"log_levle", "write_timout"}, wantTimeout: time.Second * 20},
+		{name: "malformed yaml", file: "config.yaml", content: "write_timeout: [20s\n", wantErr: "malformed config file"},
+		{name: "malformed json", file: "config.json", content: `{"write_timeout": "20s"`, wantErr: "malformed config file"},
+		{name: "not a mapping", file: "config.yaml", content: "- write_timeout\n", wantErr: "malformed config file"},
+		{name: "invalid value", file: "config.yaml", content: "write_timeout: soon\n", wantErr: `invalid value for "write_timeout"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			path := writeTestFile(t, tt.file, tt.content)
+			fs := flag.NewFlagSet("test", flag.ContinueOnError)
+			writeTimeout := fs.Duration("write-timeout", time.Second*10, "")
+
+			unknownKeys, err := applyConfigFile(path, fs)
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) || !strings.Contains(err.Error(), path) {
+					t.Fatalf("error = %v, want one containing %q and the file path", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+			if strings.Join(unknownKeys, ",") != strings.Join(tt.wantUnknown, ",") {
+				t.Errorf("unknown keys = %q, want %q", unknownKeys, tt.wantUnknown)
+			}
+			if *writeTimeout != tt.wantTimeout {
+				t.Errorf("write timeout = %s, want %s", *writeTimeout, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+func TestBuildServerFromArgsMalformedConfigFile(t *testing.T) {
+	path := writeTestFile(t, "config.yaml", "write_timeout: [20s\n")
+
+	_, err := BuildServerFromArgs(append([]string{"-config", path}, requiredArgs...), envFrom(nil))
+	if err == nil || !strings.Contains(err.Error(), "malformed config file "+path) {
+		t.Errorf("error = %v, want a malformed config file error naming %s", err, path)
+	}
+}
+
+func TestBuildServerFromArgsWarnsAboutUnknownKeys(t *testing.T) {
+	path := writeTestFile(t, "config.yaml", "write_timout: 20s\nlog_level: info\n")
+
+	var output bytes.Buffer
+	writer, flags := log.Writer(), log.Flags()
+	log.SetOutput(&output)
+	log.SetFlags(0)
+	defer func() {
+		log.SetOutput(writer)
+		log.SetFlags(flags)
+	}()
+
+	c, err := BuildServerFromArgs(append([]string{"-config", path}, requiredArgs...), envFrom(nil))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if c.LogLevel != "info" {
+		t.Errorf("LogLevel = %q, want info: known keys must still be applied", c.LogLevel)
+	}
+	want := "warning: unknown keys in config file " + path + ": write_timout"
+	if !strings.Contains(output.String(), want) {
+		t.Errorf("log output = %q, want %q", output.String(), want)
+	}
+}
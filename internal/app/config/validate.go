@@ -0,0 +1,308 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgconn"
+	"github.com/vancho-go/gophermart/internal/app/accrual"
+	"github.com/vancho-go/gophermart/internal/app/auth"
+	"go.uber.org/zap/zapcore"
+	"math"
+	"net"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"time"
+)
+
+var (
+	apiVersionPattern = regexp.MustCompile(`^v[0-9]+$`)
+	basePathPattern   = regexp.MustCompile(`^(/[a-zA-Z0-9._~-]+)+$`)
+)
+
+// Validate проверяет конфигурацию при старте, чтобы ошибки настройки не всплывали
+// при первом обращении к БД или accrual-системе. Ошибки содержат имя флага и переменной окружения.
+func (c ServerConfig) Validate() error {
+	var errs []error
+
+	if c.DatabaseURI == "" {
+		errs = append(errs, errors.New("database uri (-d / DATABASE_URI) is required"))
+	} else if _, err := pgconn.ParseConfig(c.DatabaseURI); err != nil {
+		errs = append(errs, fmt.Errorf("database uri (-d / DATABASE_URI) is invalid: %w", err))
+	}
+
+	if c.ReadReplicaURI != "" {
+		if _, err := pgconn.ParseConfig(c.ReadReplicaURI); err != nil {
+			errs = append(errs, fmt.Errorf("read replica uri (-read-replica-uri / READ_REPLICA_URI) is invalid: %w", err))
+		}
+	}
+
+	if _, err := accrual.ParseBaseURL(c.AccrualSystemAddress); err != nil {
+		errs = append(errs, fmt.Errorf("accrual system address (-r / ACCRUAL_SYSTEM_ADDRESS) is invalid: %w", err))
+	}
+
+	if err := validateHostPort(c.ServerRunAddress); err != nil {
+		errs = append(errs, fmt.Errorf("run address (-a / RUN_ADDRESS) %w", err))
+	}
+
+	if c.DebugAddress != "" {
+		if err := validateHostPort(c.DebugAddress); err != nil {
+			errs = append(errs, fmt.Errorf("debug address (-debug-address / DEBUG_ADDRESS) %w", err))
+		}
+		if c.DBStatsInterval <= 0 {
+			errs = append(errs, errors.New("db stats interval (-db-stats-interval / DB_STATS_INTERVAL) must be positive"))
+		}
+	}
+
+	if c.JWTSecretKey == "" {
+		errs = append(errs, errors.New("jwt secret key (-j / JWT_SECRET_KEY) is required"))
+	} else if c.JWTSecretKey == DefaultJWTSecretKey && !c.AllowInsecureDevSecret {
+		errs = append(errs, errors.New("jwt secret key (-j / JWT_SECRET_KEY) must be set: the default key is allowed only with -allow-insecure-dev-secret"))
+	}
+	for _, key := range c.JWTFallbackKeys {
+		if key == c.JWTSecretKey {
+			errs = append(errs, errors.New("jwt fallback keys (-jwt-fallback-keys / JWT_FALLBACK_KEYS) must not contain the current secret key"))
+			break
+		}
+	}
+
+	if sameSite, err := auth.ParseSameSite(c.CookieSameSite); err != nil {
+		errs = append(errs, fmt.Errorf("cookie same site (-cookie-same-site / COOKIE_SAME_SITE) %w", err))
+	} else if sameSite == http.SameSiteNoneMode && !c.CookieSecure && !c.EnableHTTPS {
+		errs = append(errs, errors.New("cookie same site (-cookie-same-site / COOKIE_SAME_SITE) none requires a secure cookie (-cookie-secure / COOKIE_SECURE)"))
+	}
+
+	if c.EnableHTTPS && (c.TLSCertFile == "" || c.TLSKeyFile == "") {
+		errs = append(errs, errors.New("tls certificate (-tls-cert / TLS_CERT_FILE) and key (-tls-key / TLS_KEY_FILE) are required when HTTPS (-s / ENABLE_HTTPS) is enabled"))
+	}
+
+	for _, d := range []struct {
+		name  string
+		value int64
+	}{
+		{"read header timeout (-read-header-timeout / READ_HEADER_TIMEOUT)", int64(c.ReadHeaderTimeout)},
+		{"read timeout (-read-timeout / READ_TIMEOUT)", int64(c.ReadTimeout)},
+		{"write timeout (-write-timeout / WRITE_TIMEOUT)", int64(c.WriteTimeout)},
+		{"idle timeout (-idle-timeout / IDLE_TIMEOUT)", int64(c.IdleTimeout)},
+		{"max header bytes (-max-header-bytes / MAX_HEADER_BYTES)", int64(c.MaxHeaderBytes)},
+		{"webhook timeout (-webhook-timeout / WEBHOOK_TIMEOUT)", int64(c.WebhookTimeout)},
+		{"webhook retries (-webhook-retries / WEBHOOK_MAX_RETRIES)", int64(c.WebhookMaxRetries)},
+		{"idempotency key ttl (-idempotency-ttl / IDEMPOTENCY_KEY_TTL)", int64(c.IdempotencyKeyTTL)},
+		{"balance reconcile interval (-balance-reconcile-interval / BALANCE_RECONCILE_INTERVAL)", int64(c.BalanceReconcileInterval)},
+		{"db query timeout (-db-query-timeout / DB_QUERY_TIMEOUT)", int64(c.DBQueryTimeout)},
+		{"db statement timeout (-db-statement-timeout / DB_STATEMENT_TIMEOUT)", int64(c.DBStatementTimeout)},
+		{"log file max backups (-log-file-max-backups / LOG_FILE_MAX_BACKUPS)", int64(c.LogFileMaxBackups)},
+		{"log file max age (-log-file-max-age / LOG_FILE_MAX_AGE_DAYS)", int64(c.LogFileMaxAgeDays)},
+	} {
+		if d.value < 0 {
+			errs = append(errs, fmt.Errorf("%s must not be negative", d.name))
+		}
+	}
+
+	if c.DBMaxConns <= 0 {
+		errs = append(errs, errors.New("db max conns (-db-max-conns / DB_MAX_CONNS) must be positive"))
+	}
+
+	if c.DBMinConns < 0 || c.DBMinConns > c.DBMaxConns {
+		errs = append(errs, errors.New("db min conns (-db-min-conns / DB_MIN_CONNS) must be between 0 and db max conns (-db-max-conns / DB_MAX_CONNS)"))
+	}
+
+	if c.DBConnMaxLifetime < 0 {
+		errs = append(errs, errors.New("db conn max lifetime (-db-conn-max-lifetime / DB_CONN_MAX_LIFETIME) must not be negative"))
+	}
+
+	if c.DBHealthCheckInterval <= 0 {
+		errs = append(errs, errors.New("db health check interval (-db-health-interval / DB_HEALTH_CHECK_INTERVAL) must be positive"))
+	}
+
+	if c.MaxWithdrawalSum < 0 || math.IsNaN(c.MaxWithdrawalSum) || math.IsInf(c.MaxWithdrawalSum, 0) {
+		errs = append(errs, errors.New("max withdrawal sum (-max-withdrawal / MAX_WITHDRAWAL_SUM) must be a finite non-negative number"))
+	}
+
+	if c.BalanceDiscrepancyThreshold <= 0 || math.IsNaN(c.BalanceDiscrepancyThreshold) || math.IsInf(c.BalanceDiscrepancyThreshold, 0) {
+		errs = append(errs, errors.New("balance discrepancy threshold (-balance-discrepancy-threshold / BALANCE_DISCREPANCY_THRESHOLD) must be a finite positive number"))
+	}
+
+	if c.AuthRateLimitRPS < 0 || math.IsNaN(c.AuthRateLimitRPS) || math.IsInf(c.AuthRateLimitRPS, 0) {
+		errs = append(errs, errors.New("auth rate limit (-auth-rate-limit / AUTH_RATE_LIMIT_RPS) must be a finite non-negative number"))
+	}
+
+	if c.AuthRateLimitRPS > 0 && c.AuthRateLimitBurst <= 0 {
+		errs = append(errs, errors.New("auth rate burst (-auth-rate-burst / AUTH_RATE_LIMIT_BURST) must be positive"))
+	}
+
+	if c.OrderRateLimitRPS < 0 || math.IsNaN(c.OrderRateLimitRPS) || math.IsInf(c.OrderRateLimitRPS, 0) {
+		errs = append(errs, errors.New("order rate limit (-order-rate-limit / ORDER_RATE_LIMIT_RPS) must be a finite non-negative number"))
+	}
+
+	if c.OrderRateLimitRPS > 0 && c.OrderRateLimitBurst <= 0 {
+		errs = append(errs, errors.New("order rate burst (-order-rate-burst / ORDER_RATE_LIMIT_BURST) must be positive"))
+	}
+
+	if c.AccrualPollInterval <= 0 || c.AccrualMaxPollInterval < c.AccrualPollInterval {
+		errs = append(errs, errors.New("accrual poll interval (-accrual-poll-interval / ACCRUAL_POLL_INTERVAL) must be positive and not greater than max poll interval (-accrual-max-poll-interval / ACCRUAL_MAX_POLL_INTERVAL)"))
+	}
+
+	// Цикл обновления ждет ответы всех воркеров, поэтому зависший запрос не должен растягивать
+	// цикл дольше максимальной паузы между циклами.
+	if c.AccrualRequestTimeout <= 0 || c.AccrualRequestTimeout >= c.AccrualMaxPollInterval {
+		errs = append(errs, errors.New("accrual request timeout (-accrual-request-timeout / ACCRUAL_REQUEST_TIMEOUT) must be positive and shorter than max poll interval (-accrual-max-poll-interval / ACCRUAL_MAX_POLL_INTERVAL)"))
+	}
+
+	if c.SlowQueryThreshold < 0 {
+		errs = append(errs, errors.New("slow query threshold (-slow-query-threshold / SLOW_QUERY_THRESHOLD) must not be negative"))
+	}
+
+	if c.StuckOrderAge <= 0 {
+		errs = append(errs, errors.New("stuck order age (-stuck-order-age / STUCK_ORDER_AGE) must be positive"))
+	}
+
+	if c.UpdateCycleTimeout <= 0 {
+		errs = append(errs, errors.New("update cycle timeout (-update-cycle-timeout / UPDATE_CYCLE_TIMEOUT) must be positive"))
+	}
+
+	if c.UpdaterStatementTimeout < 0 {
+		errs = append(errs, errors.New("updater statement timeout (-updater-statement-timeout / UPDATER_STATEMENT_TIMEOUT) must not be negative"))
+	}
+
+	if c.MaxUpdateAttempts <= 0 {
+		errs = append(errs, errors.New("max update attempts (-max-update-attempts / MAX_UPDATE_ATTEMPTS) must be positive"))
+	}
+
+	if c.PendingOrdersBatchSize <= 0 {
+		errs = append(errs, errors.New("pending orders batch size (-pending-orders-batch-size / PENDING_ORDERS_BATCH_SIZE) must be positive"))
+	}
+
+	if c.OrderCheckCooldown < 0 {
+		errs = append(errs, errors.New("order check cooldown (-order-check-cooldown / ORDER_CHECK_COOLDOWN) must not be negative"))
+	}
+
+	if c.OrderOwnerCacheSize < 0 {
+		errs = append(errs, errors.New("order owner cache size (-order-owner-cache-size / ORDER_OWNER_CACHE_SIZE) must not be negative"))
+	} else if c.OrderOwnerCacheSize > 0 && c.OrderOwnerCacheTTL <= 0 {
+		errs = append(errs, errors.New("order owner cache ttl (-order-owner-cache-ttl / ORDER_OWNER_CACHE_TTL) must be positive"))
+	}
+
+	if c.DispatchQueueSize < 0 {
+		errs = append(errs, errors.New("dispatch queue size (-dispatch-queue-size / DISPATCH_QUEUE_SIZE) must not be negative"))
+	}
+
+	if math.IsNaN(c.AccrualRateLimitRPS) || math.IsInf(c.AccrualRateLimitRPS, 0) || c.AccrualRateLimitRPS < 0 {
+		errs = append(errs, errors.New("accrual rate limit (-accrual-rate-limit / ACCRUAL_RATE_LIMIT) must be a finite non-negative number"))
+	}
+
+	if c.AccrualRateLimitRPS > 0 && c.AccrualRateLimitBurst <= 0 {
+		errs = append(errs, errors.New("accrual rate burst (-accrual-rate-burst / ACCRUAL_RATE_BURST) must be positive"))
+	}
+
+	if c.AccrualBreakerThreshold <= 0 {
+		errs = append(errs, errors.New("accrual breaker threshold (-accrual-breaker-threshold / ACCRUAL_BREAKER_THRESHOLD) must be positive"))
+	}
+
+	if c.AccrualBreakerCoolDown <= 0 {
+		errs = append(errs, errors.New("accrual breaker cool down (-accrual-breaker-cool-down / ACCRUAL_BREAKER_COOL_DOWN) must be positive"))
+	}
+
+	if c.AccrualBatchSize <= 0 {
+		errs = append(errs, errors.New("accrual batch size (-accrual-batch-size / ACCRUAL_BATCH_SIZE) must be positive"))
+	}
+
+	if c.AccrualWorkers <= 0 {
+		errs = append(errs, errors.New("accrual workers (-accrual-workers / ACCRUAL_WORKERS) must be positive"))
+	}
+
+	if c.WebhookWorkers <= 0 {
+		errs = append(errs, errors.New("webhook workers (-webhook-workers / WEBHOOK_WORKERS) must be positive"))
+	}
+
+	if c.OTLPEndpoint != "" {
+		u, err := url.Parse(c.OTLPEndpoint)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+			errs = append(errs, fmt.Errorf("otlp endpoint (-otlp-endpoint / OTEL_EXPORTER_OTLP_ENDPOINT) must be an http or https url, got %q", c.OTLPEndpoint))
+		}
+	}
+
+	if c.MaxOrderBatchSize <= 0 {
+		errs = append(errs, errors.New("max order batch size (-max-order-batch / MAX_ORDER_BATCH_SIZE) must be positive"))
+	}
+
+	if c.MaxLoginLength < 3 {
+		errs = append(errs, errors.New("max login length (-max-login-length / MAX_LOGIN_LENGTH) must be at least 3"))
+	}
+
+	switch c.APIValidationMode {
+	case "off", "warn", "enforce":
+	default:
+		errs = append(errs, fmt.Errorf("api validation mode (-validate / API_VALIDATION_MODE) must be one of off, warn, enforce, got %q", c.APIValidationMode))
+	}
+
+	switch c.FinancialTxIsolation {
+	case "read_committed", "repeatable_read", "serializable":
+	default:
+		errs = append(errs, fmt.Errorf("financial tx isolation (-tx-isolation / FINANCIAL_TX_ISOLATION) must be one of read_committed, repeatable_read, serializable, got %q", c.FinancialTxIsolation))
+	}
+
+	if _, err := zapcore.ParseLevel(c.LogLevel); err != nil {
+		errs = append(errs, fmt.Errorf("log level (-log-level / LOG_LEVEL) is invalid: %w", err))
+	}
+
+	switch c.LogFormat {
+	case "console", "json":
+	default:
+		errs = append(errs, fmt.Errorf("log format (-log-format / LOG_FORMAT) must be one of console, json, got %q", c.LogFormat))
+	}
+
+	if _, err := zapcore.ParseLevel(c.LogLevelFloor); err != nil {
+		errs = append(errs, fmt.Errorf("log level floor (-log-level-floor / LOG_LEVEL_FLOOR) is invalid: %w", err))
+	}
+
+	switch c.LogLevelAccess {
+	case "off", "loopback":
+	case "admin":
+		if c.AdminKey == "" {
+			errs = append(errs, errors.New("admin key (-admin-key / ADMIN_KEY) is required when log level access (-log-level-access / LOG_LEVEL_ACCESS) is admin"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("log level access (-log-level-access / LOG_LEVEL_ACCESS) must be one of off, loopback, admin, got %q", c.LogLevelAccess))
+	}
+
+	switch c.LogOutput {
+	case "stdout", "stderr":
+	case "none":
+		if c.LogFile == "" {
+			errs = append(errs, errors.New("log file (-log-file / LOG_FILE) is required when log output (-log-output / LOG_OUTPUT) is none"))
+		}
+	default:
+		errs = append(errs, fmt.Errorf("log output (-log-output / LOG_OUTPUT) must be one of stdout, stderr, none, got %q", c.LogOutput))
+	}
+
+	if c.LogFile != "" && c.LogFileMaxSizeMB <= 0 {
+		errs = append(errs, errors.New("log file max size (-log-file-max-size / LOG_FILE_MAX_SIZE_MB) must be positive"))
+	}
+
+	if _, err := time.LoadLocation(c.StatsTimeZone); err != nil || c.StatsTimeZone == "" {
+		errs = append(errs, fmt.Errorf("stats time zone (-stats-time-zone / STATS_TIME_ZONE) must be an IANA time zone name, got %q", c.StatsTimeZone))
+	}
+
+	if c.BasePath != "" && !basePathPattern.MatchString(c.BasePath) {
+		errs = append(errs, fmt.Errorf("base path (-base-path / BASE_PATH) must start with / and have no trailing /, got %q", c.BasePath))
+	}
+
+	if !apiVersionPattern.MatchString(c.APIVersion) {
+		errs = append(errs, fmt.Errorf("api version (-api-version / API_VERSION) must look like v1, got %q", c.APIVersion))
+	}
+
+	return errors.Join(errs...)
+}
+
+func validateHostPort(address string) error {
+	_, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return fmt.Errorf("must be host:port: %w", err)
+	}
+	if _, err = strconv.ParseUint(port, 10, 16); err != nil {
+		return fmt.Errorf("has invalid port %q", port)
+	}
+	return nil
+}
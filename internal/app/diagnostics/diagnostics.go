@@ -0,0 +1,127 @@
+// Package diagnostics собирает отладочные обработчики (pprof, expvar) для отдельного
+// debug-листенера, который не должен быть доступен из публичной сети.
+package diagnostics
+
+import (
+	"context"
+	"expvar"
+	"github.com/go-chi/chi/v5/middleware"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"net/http"
+	"runtime"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+type DBStatsProvider interface {
+	Stats() *pgxpool.Stat
+}
+
+type CircuitStateProvider interface {
+	State() string
+}
+
+type UpdateResultsProvider interface {
+	UpdateResults() map[string]int64
+}
+
+type BalanceInvariantProvider interface {
+	NegativeBalanceViolations() int64
+}
+
+var (
+	dbStats        = new(expvar.Map)
+	circuitBreaker atomic.Value // CircuitStateProvider
+	updateResults  atomic.Value // UpdateResultsProvider
+	balances       atomic.Value // BalanceInvariantProvider
+	publishOnce    sync.Once
+)
+
+// SetCircuitBreaker публикует состояние цепи accrual-клиента в expvar accrual_circuit_breaker.
+func SetCircuitBreaker(provider CircuitStateProvider) {
+	circuitBreaker.Store(provider)
+}
+
+// SetUpdateResults публикует счетчики результатов обновления заказов по классам в expvar
+// order_update_results.
+func SetUpdateResults(provider UpdateResultsProvider) {
+	updateResults.Store(provider)
+}
+
+// SetBalanceInvariant публикует число обнаруженных отрицательных балансов в expvar
+// negative_balance_violations.
+func SetBalanceInvariant(provider BalanceInvariantProvider) {
+	balances.Store(provider)
+}
+
+// publish регистрирует переменные expvar один раз: повторная регистрация имени вызывает панику.
+func publish() {
+	publishOnce.Do(func() {
+		expvar.Publish("goroutines", expvar.Func(func() interface{} {
+			return runtime.NumGoroutine()
+		}))
+		expvar.Publish("db_stats", dbStats)
+		expvar.Publish("accrual_circuit_breaker", expvar.Func(func() interface{} {
+			if provider, ok := circuitBreaker.Load().(CircuitStateProvider); ok {
+				return provider.State()
+			}
+			return nil
+		}))
+		expvar.Publish("order_update_results", expvar.Func(func() interface{} {
+			if provider, ok := updateResults.Load().(UpdateResultsProvider); ok {
+				return provider.UpdateResults()
+			}
+			return nil
+		}))
+		expvar.Publish("negative_balance_violations", expvar.Func(func() interface{} {
+			if provider, ok := balances.Load().(BalanceInvariantProvider); ok {
+				return provider.NegativeBalanceViolations()
+			}
+			return nil
+		}))
+	})
+}
+
+// Handler отдает /debug/pprof/* и /debug/vars.
+func Handler() http.Handler {
+	publish()
+
+	mux := http.NewServeMux()
+	mux.Handle("/debug/", http.StripPrefix("/debug", middleware.Profiler()))
+	return mux
+}
+
+// RunDBStatsPublisher периодически выгружает статистику пула соединений в expvar db_stats
+// до отмены ctx.
+func RunDBStatsPublisher(ctx context.Context, provider DBStatsProvider, interval time.Duration) {
+	publish()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		publishDBStats(provider.Stats())
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func publishDBStats(stats *pgxpool.Stat) {
+	setInt := func(name string, value int64) {
+		v := new(expvar.Int)
+		v.Set(value)
+		dbStats.Set(name, v)
+	}
+	setInt("max_open_connections", int64(stats.MaxConns()))
+	setInt("open_connections", int64(stats.TotalConns()))
+	setInt("in_use", int64(stats.AcquiredConns()))
+	setInt("idle", int64(stats.IdleConns()))
+	setInt("wait_count", stats.EmptyAcquireCount())
+	setInt("wait_duration_ms", stats.AcquireDuration().Milliseconds())
+	setInt("canceled_acquire_count", stats.CanceledAcquireCount())
+	setInt("max_idle_time_closed", stats.MaxIdleDestroyCount())
+	setInt("max_lifetime_closed", stats.MaxLifetimeDestroyCount())
+}
@@ -0,0 +1,42 @@
+package diagnostics
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+type fakeUpdateResults map[string]int64
+
+func (f fakeUpdateResults) UpdateResults() map[string]int64 {
+	return f
+}
+
+// TestHandlerPublishesUpdateResults проверяет, что счетчики результатов обновления заказов
+// отдаются в /debug/vars как order_update_results.
+func TestHandlerPublishesUpdateResults(t *testing.T) {
+	SetUpdateResults(fakeUpdateResults{"updated": 3, "rate_limited": 1, "dead": 1})
+
+	res := httptest.NewRecorder()
+	Handler().ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/debug/vars", nil))
+	if res.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d", res.Code, http.StatusOK)
+	}
+
+	var vars struct {
+		UpdateResults map[string]int64 `json:"order_update_results"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&vars); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]int64{"updated": 3, "rate_limited": 1, "dead": 1}
+	if len(vars.UpdateResults) != len(want) {
+		t.Fatalf("order_update_results = %v, want %v", vars.UpdateResults, want)
+	}
+	for class, count := range want {
+		if vars.UpdateResults[class] != count {
+			t.Errorf("order_update_results[%s] = %d, want %d", class, vars.UpdateResults[class], count)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package events
+
+import (
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"sync"
+)
+
+const subscriberBufferSize = 16
+
+// EventBus — in-memory pub/sub изменений статусов заказов, ключ — userID.
+type EventBus struct {
+	mu          sync.RWMutex
+	subscribers map[string]map[chan models.APIOrderStatusEvent]struct{}
+}
+
+func NewEventBus() *EventBus {
+	return &EventBus{
+		subscribers: make(map[string]map[chan models.APIOrderStatusEvent]struct{}),
+	}
+}
+
+// Subscribe возвращает канал событий пользователя и функцию отписки,
+// которую необходимо вызвать при закрытии соединения.
+func (b *EventBus) Subscribe(userID string) (<-chan models.APIOrderStatusEvent, func()) {
+	ch := make(chan models.APIOrderStatusEvent, subscriberBufferSize)
+
+	b.mu.Lock()
+	if b.subscribers[userID] == nil {
+		b.subscribers[userID] = make(map[chan models.APIOrderStatusEvent]struct{})
+	}
+	b.subscribers[userID][ch] = struct{}{}
+	b.mu.Unlock()
+
+	var once sync.Once
+	unsubscribe := func() {
+		once.Do(func() {
+			b.mu.Lock()
+			delete(b.subscribers[userID], ch)
+			if len(b.subscribers[userID]) == 0 {
+				delete(b.subscribers, userID)
+			}
+			b.mu.Unlock()
+			close(ch)
+		})
+	}
+	return ch, unsubscribe
+}
+
+// Publish рассылает событие всем подписчикам пользователя. Медленные подписчики
+// не блокируют обновление заказов: если буфер заполнен, событие для них отбрасывается.
+func (b *EventBus) Publish(userID string, event models.APIOrderStatusEvent) {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	for ch := range b.subscribers[userID] {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/vancho-go/gophermart/internal/app/auth"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"github.com/vancho-go/gophermart/internal/app/storage"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+type AccountDeleter interface {
+	VerifyUserPassword(ctx context.Context, userID, password string) (err error)
+	SoftDeleteUser(ctx context.Context, userID string) (err error)
+}
+
+type UserProfileProvider interface {
+	GetUserProfile(ctx context.Context, userID string) (profile models.UserProfile, err error)
+}
+
+func GetUserProfile(upp UserProfileProvider, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "getUserProfile"))
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		ctx, span := tracer.Start(req.Context(), "handler.GetUserProfile")
+		defer span.End()
+
+		userID, ok := getUserIDFromContext(ctx)
+		if !ok {
+			logger.Debug("unauthorized")
+			writeJSONError(res, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+			return
+		}
+
+		profile, err := upp.GetUserProfile(ctx, userID)
+		if errors.Is(err, storage.ErrUserNotFound) {
+			// токен удаленного пользователя еще не истек
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+			return
+		} else if err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(res)
+		if err := encoder.Encode(profile); err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+	}
+}
+
+func DeleteAccount(ad AccountDeleter, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "deleteAccount"))
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		ctx, span := tracer.Start(req.Context(), "handler.DeleteAccount")
+		defer span.End()
+
+		userID, ok := getUserIDFromContext(ctx)
+		if !ok {
+			logger.Debug("unauthorized")
+			writeJSONError(res, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+			return
+		}
+
+		var request models.APIDeleteAccountRequest
+		decoder := json.NewDecoder(req.Body)
+		if err := decoder.Decode(&request); err != nil {
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request format")
+			return
+		}
+		defer req.Body.Close()
+
+		err := ad.VerifyUserPassword(ctx, userID, request.Password)
+		if errors.Is(err, storage.ErrUserNotFound) {
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusUnauthorized, errCodeInvalidCredentials, "Wrong password")
+			return
+		} else if err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+
+		// после удаления auth.Middleware отклоняет все токены пользователя, в том числе этот
+		err = ad.SoftDeleteUser(ctx, userID)
+		if errors.Is(err, storage.ErrUserNotFound) {
+			// учетную запись удалил одновременный запрос
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+			return
+		} else if err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+
+		logger.Info("user deleted", zap.String("userID", userID))
+		http.SetCookie(res, auth.ExpiredCookie())
+		res.WriteHeader(http.StatusNoContent)
+	}
+}
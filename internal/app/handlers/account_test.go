@@ -0,0 +1,119 @@
+package handlers
+
+import (
+	"context"
+	"github.com/vancho-go/gophermart/internal/app/auth"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"github.com/vancho-go/gophermart/internal/app/storage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// fakeAccounts хранит пароли активных пользователей в памяти, как storage.Storage: удаленный
+// пользователь не проходит ни проверку пароля, ни auth.Middleware.
+type fakeAccounts struct {
+	passwords map[string]string
+}
+
+func (f *fakeAccounts) VerifyUserPassword(_ context.Context, userID, password string) error {
+	if stored, ok := f.passwords[userID]; !ok || stored != password {
+		return storage.ErrUserNotFound
+	}
+	return nil
+}
+
+func (f *fakeAccounts) SoftDeleteUser(_ context.Context, userID string) error {
+	if _, ok := f.passwords[userID]; !ok {
+		return storage.ErrUserNotFound
+	}
+	delete(f.passwords, userID)
+	return nil
+}
+
+func (f *fakeAccounts) IsUserActive(_ context.Context, userID string) (bool, error) {
+	_, ok := f.passwords[userID]
+	return ok, nil
+}
+
+func TestDeleteAccount(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "deleted", body: `{"password":"secret"}`, wantStatus: http.StatusNoContent},
+		{name: "wrong password", body: `{"password":"guess"}`, wantStatus: http.StatusUnauthorized, wantCode: errCodeInvalidCredentials},
+		{name: "malformed json", body: `{"password":`, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			accounts := &fakeAccounts{passwords: map[string]string{"user-1": "secret"}}
+			req := newUserRequest(http.MethodDelete, "/api/v1/user/account", strings.NewReader(tt.body), "user-1")
+			res := httptest.NewRecorder()
+			DeleteAccount(accounts, logger.NewNopLogger())(res, req)
+
+			if res.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body %s", res.Code, tt.wantStatus, res.Body)
+			}
+			_, active := accounts.passwords["user-1"]
+			if tt.wantStatus == http.StatusNoContent {
+				if active {
+					t.Error("user was not deleted")
+				}
+				return
+			}
+			if !active {
+				t.Error("rejected request deleted the user")
+			}
+			if !strings.Contains(res.Body.String(), `"code":"`+tt.wantCode+`"`) {
+				t.Errorf("body = %s, want error code %s", res.Body, tt.wantCode)
+			}
+		})
+	}
+}
+
+// TestDeleteAccountRevokesToken проверяет, что после удаления учетной записи ее токен, срок
+// действия которого не истек, больше не принимается.
+func TestDeleteAccountRevokesToken(t *testing.T) {
+	if err := auth.SetKeys("account-test-key", nil); err != nil {
+		t.Fatal(err)
+	}
+	accounts := &fakeAccounts{passwords: map[string]string{"user-1": "secret"}}
+	processor := &fakeOrderProcessor{}
+	log := logger.NewNopLogger()
+	deleteAccount := auth.Middleware(accounts)(DeleteAccount(accounts, log))
+	addOrder := auth.Middleware(accounts)(AddOrder(processor, log))
+
+	cookie, err := auth.GenerateCookie("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	do := func(handler http.Handler, method, target, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, target, strings.NewReader(body))
+		req.AddCookie(cookie)
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res
+	}
+
+	deleted := do(deleteAccount, http.MethodDelete, "/api/v1/user/account", `{"password":"secret"}`)
+	if deleted.Code != http.StatusNoContent {
+		t.Fatalf("delete status = %d, want %d", deleted.Code, http.StatusNoContent)
+	}
+	if setCookie := deleted.Header().Get("Set-Cookie"); !strings.Contains(setCookie, "AuthToken=;") {
+		t.Errorf("Set-Cookie = %q, want the auth cookie cleared", setCookie)
+	}
+
+	if res := do(addOrder, http.MethodPost, "/api/v1/user/orders", "12345678903"); res.Code != http.StatusUnauthorized {
+		t.Errorf("order upload with the old token: status %d, want %d", res.Code, http.StatusUnauthorized)
+	}
+	if len(processor.added) != 0 {
+		t.Errorf("deleted user added orders %q", processor.added)
+	}
+	if res := do(deleteAccount, http.MethodDelete, "/api/v1/user/account", `{"password":"secret"}`); res.Code != http.StatusUnauthorized {
+		t.Errorf("repeated deletion with the old token: status %d, want %d", res.Code, http.StatusUnauthorized)
+	}
+}
@@ -0,0 +1,551 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/vancho-go/gophermart/internal/app/auth"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"github.com/vancho-go/gophermart/internal/app/storage"
+	"go.uber.org/zap"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type AdminOrderProcessor interface {
+	AdminUpdateOrderStatus(ctx context.Context, orderID string, status models.OrderStatus, accrual *float64) (err error)
+}
+
+type SystemStatsProvider interface {
+	GetSystemStats(ctx context.Context) (stats models.SystemStats, err error)
+}
+
+type DailyStatsProvider interface {
+	GetDailyStats(ctx context.Context, from, to, timeZone string) (stats []models.DailyStats, err error)
+}
+
+type StuckOrdersProvider interface {
+	GetStuckOrders(ctx context.Context, olderThan time.Duration, limit, offset int) (orders []models.StuckOrder, err error)
+}
+
+// DeadOrdersManager перечисляет заказы, исключенные из опроса, и возвращает их в опрос.
+type DeadOrdersManager interface {
+	ListDeadOrders(ctx context.Context) (orders []models.DeadOrder, err error)
+	RequeueOrder(ctx context.Context, orderID string) (err error)
+}
+
+// UserInspector дает поддержке доступ на чтение к учетной записи, заказам и балансу любого
+// пользователя.
+type UserInspector interface {
+	SearchUsers(ctx context.Context, query string, limit, offset int) (users []models.UserProfile, err error)
+	GetUserProfile(ctx context.Context, userID string) (profile models.UserProfile, err error)
+	GetOrders(ctx context.Context, userID string, filter storage.OrdersFilter) (orders []models.APIGetOrderResponse, err error)
+	GetCurrentBonusesAmount(ctx context.Context, userID string) (bonuses models.APIGetBonusesAmountResponse, err error)
+}
+
+type BalanceAdjuster interface {
+	AdjustBalance(ctx context.Context, userID string, request models.APIAdjustBalanceRequest, operator string) (response models.APIAdjustBalanceResponse, err error)
+}
+
+// BalanceReconciler пересчитывает кешированные балансы по журналу balance_transactions.
+type BalanceReconciler interface {
+	GetBalanceDiscrepancies(ctx context.Context) (discrepancies []models.BalanceDiscrepancy, err error)
+	RebuildBalance(ctx context.Context, userID string) (balance float64, err error)
+}
+
+func AdminUpdateOrderStatus(aop AdminOrderProcessor, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "adminUpdateOrderStatus"))
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		ctx, span := tracer.Start(req.Context(), "handler.AdminUpdateOrderStatus")
+		defer span.End()
+
+		orderID := chi.URLParam(req, "orderID")
+
+		var request models.APIAdminUpdateOrderStatusRequest
+		decoder := json.NewDecoder(req.Body)
+		if err := decoder.Decode(&request); err != nil {
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request format")
+			return
+		}
+		defer req.Body.Close()
+
+		if !request.Status.Valid() {
+			logger.Debug("invalid status", zap.String("status", string(request.Status)))
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidOrderStatus, "Status must be one of NEW, PROCESSING, INVALID, PROCESSED")
+			return
+		}
+		if request.Accrual != nil && *request.Accrual < 0 {
+			logger.Debug("negative accrual", zap.Float64("accrual", *request.Accrual))
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidRequest, "Accrual must not be negative")
+			return
+		}
+
+		err := aop.AdminUpdateOrderStatus(ctx, orderID, request.Status, request.Accrual)
+		if errors.Is(err, storage.ErrOrderNotFound) {
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusNotFound, errCodeOrderNotFound, "Order not found")
+			return
+		} else if errors.Is(err, storage.ErrOrderStatusTransition) {
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusConflict, errCodeOrderStatusTransition, "Order with credited accrual can only stay PROCESSED")
+			return
+		} else if errors.Is(err, storage.ErrNotEnoughBonuses) {
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusConflict, errCodeNotEnoughBonuses, "Accrual decrease would make the balance negative")
+			return
+		} else if err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+
+		logger.Info("order status updated", zap.String("order", orderID), zap.String("status", string(request.Status)))
+		res.WriteHeader(http.StatusOK)
+	}
+}
+
+func GetSystemStats(ssp SystemStatsProvider, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "getSystemStats"))
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		ctx, span := tracer.Start(req.Context(), "handler.GetSystemStats")
+		defer span.End()
+
+		stats, err := ssp.GetSystemStats(ctx)
+		if err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(res)
+		if err := encoder.Encode(stats); err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+	}
+}
+
+const (
+	// defaultDailyStatsDays — период GetDailyStats без параметров, включая текущий день
+	defaultDailyStatsDays = 30
+	// maxDailyStatsDays ограничивает период одного запроса GetDailyStats
+	maxDailyStatsDays = 366
+)
+
+// GetDailyStats отдает показатели по дням за период from–to (YYYY-MM-DD, обе даты включительно)
+// в часовом поясе location. По умолчанию — последние 30 дней.
+func GetDailyStats(dsp DailyStatsProvider, location *time.Location, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "getDailyStats"))
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		ctx, span := tracer.Start(req.Context(), "handler.GetDailyStats")
+		defer span.End()
+
+		query := req.URL.Query()
+
+		now := time.Now().In(location)
+		to := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+		if value := query.Get("to"); value != "" {
+			parsed, err := time.Parse(dateLayout, value)
+			if err != nil {
+				writeJSONError(res, http.StatusBadRequest, errCodeInvalidDateRange, "to must be a date in YYYY-MM-DD format")
+				return
+			}
+			to = parsed
+		}
+		from := to.AddDate(0, 0, -(defaultDailyStatsDays - 1))
+		if value := query.Get("from"); value != "" {
+			parsed, err := time.Parse(dateLayout, value)
+			if err != nil {
+				writeJSONError(res, http.StatusBadRequest, errCodeInvalidDateRange, "from must be a date in YYYY-MM-DD format")
+				return
+			}
+			from = parsed
+		}
+		if from.After(to) {
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidDateRange, "from must not be after to")
+			return
+		}
+		if to.Sub(from) >= maxDailyStatsDays*24*time.Hour {
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidDateRange, fmt.Sprintf("period must not exceed %d days", maxDailyStatsDays))
+			return
+		}
+
+		stats, err := dsp.GetDailyStats(ctx, from.Format(dateLayout), to.Format(dateLayout), location.String())
+		if err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(res)
+		if err := encoder.Encode(stats); err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+	}
+}
+
+// GetBalanceReconciliation возвращает пользователей, чей баланс расходится с журналом операций.
+func GetBalanceReconciliation(br BalanceReconciler, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "getBalanceReconciliation"))
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		ctx, span := tracer.Start(req.Context(), "handler.GetBalanceReconciliation")
+		defer span.End()
+
+		discrepancies, err := br.GetBalanceDiscrepancies(ctx)
+		if err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(res)
+		if err := encoder.Encode(discrepancies); err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+	}
+}
+
+// RebuildBalance пересчитывает баланс пользователя {userID} по журналу операций.
+func RebuildBalance(br BalanceReconciler, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "rebuildBalance"))
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		ctx, span := tracer.Start(req.Context(), "handler.RebuildBalance")
+		defer span.End()
+
+		userID := chi.URLParam(req, "userID")
+
+		balance, err := br.RebuildBalance(ctx, userID)
+		if errors.Is(err, storage.ErrUserNotFound) {
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusNotFound, errCodeUserNotFound, "User not found")
+			return
+		} else if err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+
+		logger.Info("balance rebuilt", zap.String("user", userID), zap.Float64("balance", balance))
+		res.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(res)
+		if err := encoder.Encode(models.APIRebuildBalanceResponse{UserID: userID, Current: balance}); err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+	}
+}
+
+// GetStuckOrders перечисляет незавершенные заказы старше defaultAge. Параметр older_than
+// (например, 30m) переопределяет возраст, limit и offset задают страницу.
+func GetStuckOrders(sop StuckOrdersProvider, defaultAge time.Duration, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "getStuckOrders"))
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		ctx, span := tracer.Start(req.Context(), "handler.GetStuckOrders")
+		defer span.End()
+
+		query := req.URL.Query()
+
+		olderThan := defaultAge
+		if value := query.Get("older_than"); value != "" {
+			parsed, err := time.ParseDuration(value)
+			if err != nil || parsed <= 0 {
+				writeJSONError(res, http.StatusBadRequest, errCodeInvalidRequest, "older_than must be a positive duration, e.g. 30m")
+				return
+			}
+			olderThan = parsed
+		}
+
+		limit, err := parsePageParam(query.Get("limit"), defaultPageLimit)
+		if err != nil || limit == 0 || limit > maxPageLimit {
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidRequest, "limit must be between 1 and "+strconv.Itoa(maxPageLimit))
+			return
+		}
+		offset, err := parsePageParam(query.Get("offset"), 0)
+		if err != nil {
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidRequest, "offset must be a non-negative integer")
+			return
+		}
+
+		orders, err := sop.GetStuckOrders(ctx, olderThan, limit, offset)
+		if err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(res)
+		if err := encoder.Encode(orders); err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+	}
+}
+
+func ListDeadOrders(dom DeadOrdersManager, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "listDeadOrders"))
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		ctx, span := tracer.Start(req.Context(), "handler.ListDeadOrders")
+		defer span.End()
+
+		orders, err := dom.ListDeadOrders(ctx)
+		if err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(res)
+		if err := encoder.Encode(orders); err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+	}
+}
+
+// RequeueOrder сбрасывает счетчик неудач заказа {orderID} и возвращает его в опрос.
+func RequeueOrder(dom DeadOrdersManager, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "requeueOrder"))
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		ctx, span := tracer.Start(req.Context(), "handler.RequeueOrder")
+		defer span.End()
+
+		orderID := chi.URLParam(req, "orderID")
+
+		err := dom.RequeueOrder(ctx, orderID)
+		if errors.Is(err, storage.ErrOrderNotFound) {
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusNotFound, errCodeOrderNotFound, "Order not found")
+			return
+		} else if err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+
+		logger.Info("order requeued", zap.String("order", orderID))
+		res.WriteHeader(http.StatusAccepted)
+	}
+}
+
+// SearchUsers ищет пользователей по подстроке логина из параметра query, limit и offset задают страницу.
+func SearchUsers(ui UserInspector, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "searchUsers"))
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		ctx, span := tracer.Start(req.Context(), "handler.SearchUsers")
+		defer span.End()
+
+		query := req.URL.Query()
+
+		limit, err := parsePageParam(query.Get("limit"), defaultPageLimit)
+		if err != nil || limit == 0 || limit > maxPageLimit {
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidRequest, "limit must be between 1 and "+strconv.Itoa(maxPageLimit))
+			return
+		}
+		offset, err := parsePageParam(query.Get("offset"), 0)
+		if err != nil {
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidRequest, "offset must be a non-negative integer")
+			return
+		}
+
+		users, err := ui.SearchUsers(ctx, query.Get("query"), limit, offset)
+		if err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(res)
+		if err := encoder.Encode(users); err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+	}
+}
+
+// GetUserOrders отдает заказы пользователя {userID} с теми же фильтрами, что и список заказов
+// пользователя; пустой список отдается с 200, а не 204.
+func GetUserOrders(ui UserInspector, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "getUserOrders"))
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		ctx, span := tracer.Start(req.Context(), "handler.GetUserOrders")
+		defer span.End()
+
+		userID := chi.URLParam(req, "userID")
+
+		filter, ok := parseOrdersFilter(res, req)
+		if !ok {
+			return
+		}
+		if !inspectedUserExists(res, req, ui, userID, logger) {
+			return
+		}
+
+		orders, err := ui.GetOrders(ctx, userID, filter)
+		if err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+		if orders == nil {
+			orders = []models.APIGetOrderResponse{}
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(res)
+		if err := encoder.Encode(orders); err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+	}
+}
+
+// GetUserBalance отдает баланс пользователя {userID}.
+func GetUserBalance(ui UserInspector, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "getUserBalance"))
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		ctx, span := tracer.Start(req.Context(), "handler.GetUserBalance")
+		defer span.End()
+
+		userID := chi.URLParam(req, "userID")
+
+		if !inspectedUserExists(res, req, ui, userID, logger) {
+			return
+		}
+
+		balance, err := ui.GetCurrentBonusesAmount(ctx, userID)
+		if err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(res)
+		if err := encoder.Encode(balance); err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+	}
+}
+
+// inspectedUserExists отвечает 404, если пользователя userID нет: хранилище для неизвестного
+// пользователя вернуло бы пустые заказы и нулевой баланс.
+func inspectedUserExists(res http.ResponseWriter, req *http.Request, ui UserInspector, userID string, logger logger.Logger) bool {
+	_, err := ui.GetUserProfile(req.Context(), userID)
+	if errors.Is(err, storage.ErrUserNotFound) {
+		logger.Debug("request failed", zap.Error(err))
+		writeJSONError(res, http.StatusNotFound, errCodeUserNotFound, "User not found")
+		return false
+	} else if err != nil {
+		logger.Error("request failed", zap.Error(err))
+		writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+		return false
+	}
+	return true
+}
+
+// maxAdjustmentReasonLength ограничивает причину корректировки баланса.
+const maxAdjustmentReasonLength = 500
+
+// AdjustBalance вручную начисляет или списывает бонусы пользователя {userID}. Оператор берется из
+// заголовка X-Admin-Operator и сохраняется вместе с причиной корректировки.
+func AdjustBalance(ba BalanceAdjuster, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "adjustBalance"))
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		ctx, span := tracer.Start(req.Context(), "handler.AdjustBalance")
+		defer span.End()
+
+		userID := chi.URLParam(req, "userID")
+
+		operator := strings.TrimSpace(req.Header.Get(auth.AdminOperatorHeader))
+		if operator == "" {
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidRequest, auth.AdminOperatorHeader+" header is required")
+			return
+		}
+
+		var request models.APIAdjustBalanceRequest
+		decoder := json.NewDecoder(req.Body)
+		if err := decoder.Decode(&request); err != nil {
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request format")
+			return
+		}
+		defer req.Body.Close()
+
+		request.Reason = strings.TrimSpace(request.Reason)
+		validationErrors := make(map[string]string)
+		if math.IsNaN(request.Delta) || math.IsInf(request.Delta, 0) || request.Delta == 0 {
+			validationErrors["delta"] = "must be a non-zero number"
+		}
+		if request.Reason == "" {
+			validationErrors["reason"] = "is required"
+		} else if len(request.Reason) > maxAdjustmentReasonLength {
+			validationErrors["reason"] = fmt.Sprintf("must be at most %d bytes", maxAdjustmentReasonLength)
+		}
+		if len(validationErrors) > 0 {
+			writeJSONValidationErrors(res, validationErrors)
+			return
+		}
+
+		response, err := ba.AdjustBalance(ctx, userID, request, operator)
+		if errors.Is(err, storage.ErrUserNotFound) {
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusNotFound, errCodeUserNotFound, "User not found")
+			return
+		} else if errors.Is(err, storage.ErrNotEnoughBonuses) {
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusConflict, errCodeNotEnoughBonuses, "Adjustment would make the balance negative")
+			return
+		} else if err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+
+		logger.Info("balance adjusted", zap.String("user", userID), zap.Float64("delta", request.Delta),
+			zap.String("operator", operator), zap.Int64("adjustment", response.AdjustmentID))
+		res.Header().Set("Content-Type", "application/json")
+		encoder := json.NewEncoder(res)
+		if err := encoder.Encode(response); err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+	}
+}
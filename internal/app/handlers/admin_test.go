@@ -0,0 +1,423 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/vancho-go/gophermart/internal/app/auth"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"github.com/vancho-go/gophermart/internal/app/storage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeBalanceAdjuster хранит балансы в памяти и, как storage.Storage, отклоняет корректировку,
+// после которой баланс стал бы отрицательным.
+type fakeBalanceAdjuster struct {
+	balances map[string]float64
+	operator string
+}
+
+func (f *fakeBalanceAdjuster) AdjustBalance(_ context.Context, userID string, request models.APIAdjustBalanceRequest, operator string) (models.APIAdjustBalanceResponse, error) {
+	current, ok := f.balances[userID]
+	if !ok {
+		return models.APIAdjustBalanceResponse{}, storage.ErrUserNotFound
+	}
+	if current+request.Delta < 0 {
+		return models.APIAdjustBalanceResponse{}, storage.ErrNotEnoughBonuses
+	}
+	f.balances[userID] = current + request.Delta
+	f.operator = operator
+	return models.APIAdjustBalanceResponse{AdjustmentID: 1, UserID: userID, Current: f.balances[userID]}, nil
+}
+
+func TestAdjustBalance(t *testing.T) {
+	tests := []struct {
+		name        string
+		userID      string
+		operator    string
+		body        string
+		wantStatus  int
+		wantCode    string
+		wantCurrent float64
+	}{
+		{name: "credit", userID: "user-1", operator: "alice", body: `{"delta":25,"reason":"goodwill"}`, wantStatus: http.StatusOK, wantCurrent: 125},
+		{name: "debit", userID: "user-1", operator: "alice", body: `{"delta":-100,"reason":"mistaken accrual"}`, wantStatus: http.StatusOK, wantCurrent: 0},
+		{name: "negative result", userID: "user-1", operator: "alice", body: `{"delta":-100.01,"reason":"mistaken accrual"}`, wantStatus: http.StatusConflict, wantCode: errCodeNotEnoughBonuses, wantCurrent: 100},
+		{name: "unknown user", userID: "user-2", operator: "alice", body: `{"delta":25,"reason":"goodwill"}`, wantStatus: http.StatusNotFound, wantCode: errCodeUserNotFound, wantCurrent: 100},
+		{name: "no operator", userID: "user-1", body: `{"delta":25,"reason":"goodwill"}`, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidRequest, wantCurrent: 100},
+		{name: "zero delta", userID: "user-1", operator: "alice", body: `{"delta":0,"reason":"goodwill"}`, wantStatus: http.StatusBadRequest, wantCode: errCodeValidationFailed, wantCurrent: 100},
+		{name: "no reason", userID: "user-1", operator: "alice", body: `{"delta":25,"reason":"  "}`, wantStatus: http.StatusBadRequest, wantCode: errCodeValidationFailed, wantCurrent: 100},
+		{name: "malformed json", userID: "user-1", operator: "alice", body: `{"delta":`, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidRequest, wantCurrent: 100},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			adjuster := &fakeBalanceAdjuster{balances: map[string]float64{"user-1": 100}}
+			r := chi.NewRouter()
+			r.Post("/api/admin/users/{userID}/balance/adjust", AdjustBalance(adjuster, logger.NewNopLogger()))
+
+			req := httptest.NewRequest(http.MethodPost, "/api/admin/users/"+tt.userID+"/balance/adjust", strings.NewReader(tt.body))
+			if tt.operator != "" {
+				req.Header.Set(auth.AdminOperatorHeader, tt.operator)
+			}
+			res := httptest.NewRecorder()
+			r.ServeHTTP(res, req)
+
+			if res.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", res.Code, tt.wantStatus, res.Body)
+			}
+			if tt.wantCode != "" {
+				var body apiErrorResponse
+				if err := json.NewDecoder(res.Body).Decode(&body); err != nil || body.Error.Code != tt.wantCode {
+					t.Errorf("error code = %q (%v), want %q", body.Error.Code, err, tt.wantCode)
+				}
+			} else {
+				var response models.APIAdjustBalanceResponse
+				if err := json.NewDecoder(res.Body).Decode(&response); err != nil || response.Current != tt.wantCurrent {
+					t.Errorf("response = %+v (%v), want current %v", response, err, tt.wantCurrent)
+				}
+				if adjuster.operator != tt.operator {
+					t.Errorf("operator = %q, want %q", adjuster.operator, tt.operator)
+				}
+			}
+			if current := adjuster.balances["user-1"]; current != tt.wantCurrent {
+				t.Errorf("stored balance = %v, want %v", current, tt.wantCurrent)
+			}
+		})
+	}
+}
+
+// fakeDeadOrders хранит мертвые заказы в памяти и, как storage.Storage, возвращает
+// ErrOrderNotFound для неизвестного заказа.
+type fakeDeadOrders struct {
+	dead  []models.DeadOrder
+	known map[string]bool
+}
+
+func (f *fakeDeadOrders) ListDeadOrders(context.Context) ([]models.DeadOrder, error) {
+	return f.dead, nil
+}
+
+func (f *fakeDeadOrders) RequeueOrder(_ context.Context, orderID string) error {
+	if !f.known[orderID] {
+		return storage.ErrOrderNotFound
+	}
+	remaining := f.dead[:0]
+	for _, order := range f.dead {
+		if order.OrderID != orderID {
+			remaining = append(remaining, order)
+		}
+	}
+	f.dead = remaining
+	return nil
+}
+
+func TestRequeueOrder(t *testing.T) {
+	dom := &fakeDeadOrders{
+		dead:  []models.DeadOrder{{OrderID: "12345678903", UserID: "user-1", Status: models.OrderStatusNew, Attempts: 5}},
+		known: map[string]bool{"12345678903": true},
+	}
+	r := chi.NewRouter()
+	r.Get("/api/admin/orders/dead", ListDeadOrders(dom, logger.NewNopLogger()))
+	r.Post("/api/admin/orders/{orderID}/requeue", RequeueOrder(dom, logger.NewNopLogger()))
+
+	listDead := func() []models.DeadOrder {
+		t.Helper()
+		res := httptest.NewRecorder()
+		r.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/api/admin/orders/dead", nil))
+		if res.Code != http.StatusOK {
+			t.Fatalf("list status = %d, want %d", res.Code, http.StatusOK)
+		}
+		var orders []models.DeadOrder
+		if err := json.NewDecoder(res.Body).Decode(&orders); err != nil {
+			t.Fatal(err)
+		}
+		return orders
+	}
+
+	if orders := listDead(); len(orders) != 1 || orders[0].OrderID != "12345678903" {
+		t.Fatalf("dead orders = %+v, want order 12345678903", orders)
+	}
+
+	res := httptest.NewRecorder()
+	r.ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/api/admin/orders/79927398713/requeue", nil))
+	if res.Code != http.StatusNotFound {
+		t.Errorf("requeue unknown order: status = %d, want %d", res.Code, http.StatusNotFound)
+	}
+
+	res = httptest.NewRecorder()
+	r.ServeHTTP(res, httptest.NewRequest(http.MethodPost, "/api/admin/orders/12345678903/requeue", nil))
+	if res.Code != http.StatusAccepted {
+		t.Fatalf("requeue: status = %d, want %d", res.Code, http.StatusAccepted)
+	}
+	if orders := listDead(); len(orders) != 0 {
+		t.Errorf("dead orders after requeue = %+v, want none", orders)
+	}
+}
+
+// fakeUserInspector ищет пользователей в памяти по подстроке логина, как storage.Storage.
+type fakeUserInspector struct {
+	users         []models.UserProfile
+	calls         int
+	limit, offset int
+}
+
+func (f *fakeUserInspector) SearchUsers(_ context.Context, query string, limit, offset int) ([]models.UserProfile, error) {
+	f.calls++
+	f.limit, f.offset = limit, offset
+	found := []models.UserProfile{}
+	for _, user := range f.users {
+		if strings.Contains(user.Login, query) {
+			found = append(found, user)
+		}
+	}
+	if offset >= len(found) {
+		return []models.UserProfile{}, nil
+	}
+	found = found[offset:]
+	if limit < len(found) {
+		found = found[:limit]
+	}
+	return found, nil
+}
+
+func (f *fakeUserInspector) GetUserProfile(context.Context, string) (models.UserProfile, error) {
+	return models.UserProfile{}, storage.ErrUserNotFound
+}
+
+func (f *fakeUserInspector) GetOrders(context.Context, string, storage.OrdersFilter) ([]models.APIGetOrderResponse, error) {
+	return nil, nil
+}
+
+func (f *fakeUserInspector) GetCurrentBonusesAmount(context.Context, string) (models.APIGetBonusesAmountResponse, error) {
+	return models.APIGetBonusesAmountResponse{}, nil
+}
+
+// newAdminTestRouter монтирует SearchUsers за AdminMiddleware, как в main.
+func newAdminTestRouter(inspector UserInspector) http.Handler {
+	r := chi.NewRouter()
+	r.Route("/api/admin", func(r chi.Router) {
+		r.Use(auth.AdminMiddleware("admin-secret"))
+		r.Get("/users", SearchUsers(inspector, logger.NewNopLogger()))
+	})
+	return r
+}
+
+func TestSearchUsersRequiresAdminKey(t *testing.T) {
+	tests := []struct {
+		name       string
+		key        string
+		wantStatus int
+	}{
+		{name: "valid key", key: "admin-secret", wantStatus: http.StatusOK},
+		{name: "missing key", wantStatus: http.StatusNotFound},
+		{name: "wrong key", key: "user-secret", wantStatus: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inspector := &fakeUserInspector{users: []models.UserProfile{{UserID: "user-1", Login: "alice"}}}
+			req := httptest.NewRequest(http.MethodGet, "/api/admin/users?query=ali", nil)
+			if tt.key != "" {
+				req.Header.Set(auth.AdminKeyHeader, tt.key)
+			}
+			res := httptest.NewRecorder()
+			newAdminTestRouter(inspector).ServeHTTP(res, req)
+
+			if res.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", res.Code, tt.wantStatus)
+			}
+			wantCalls := 0
+			if tt.wantStatus == http.StatusOK {
+				wantCalls = 1
+			}
+			if inspector.calls != wantCalls {
+				t.Errorf("storage calls = %d, want %d", inspector.calls, wantCalls)
+			}
+			if tt.wantStatus == http.StatusNotFound && strings.Contains(res.Body.String(), "alice") {
+				t.Errorf("rejected request leaked users: %s", res.Body)
+			}
+		})
+	}
+}
+
+func TestSearchUsersPagination(t *testing.T) {
+	users := []models.UserProfile{
+		{UserID: "user-1", Login: "alice"},
+		{UserID: "user-2", Login: "alina"},
+		{UserID: "user-3", Login: "alinka"},
+		{UserID: "user-4", Login: "bob"},
+	}
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+		wantLimit  int
+		wantOffset int
+		wantLogins []string
+	}{
+		{name: "defaults", target: "/api/admin/users?query=ali", wantStatus: http.StatusOK, wantLimit: defaultPageLimit, wantLogins: []string{"alice", "alina", "alinka"}},
+		{name: "first page", target: "/api/admin/users?query=ali&limit=2", wantStatus: http.StatusOK, wantLimit: 2, wantLogins: []string{"alice", "alina"}},
+		{name: "second page", target: "/api/admin/users?query=ali&limit=2&offset=2", wantStatus: http.StatusOK, wantLimit: 2, wantOffset: 2, wantLogins: []string{"alinka"}},
+		{name: "past the end", target: "/api/admin/users?query=ali&limit=2&offset=4", wantStatus: http.StatusOK, wantLimit: 2, wantOffset: 4, wantLogins: []string{}},
+		{name: "max limit", target: "/api/admin/users?limit=500", wantStatus: http.StatusOK, wantLimit: maxPageLimit, wantLogins: []string{"alice", "alina", "alinka", "bob"}},
+		{name: "zero limit", target: "/api/admin/users?limit=0", wantStatus: http.StatusBadRequest},
+		{name: "limit over max", target: "/api/admin/users?limit=501", wantStatus: http.StatusBadRequest},
+		{name: "negative offset", target: "/api/admin/users?offset=-1", wantStatus: http.StatusBadRequest},
+		{name: "non-numeric limit", target: "/api/admin/users?limit=ten", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			inspector := &fakeUserInspector{users: users}
+			req := httptest.NewRequest(http.MethodGet, tt.target, nil)
+			req.Header.Set(auth.AdminKeyHeader, "admin-secret")
+			res := httptest.NewRecorder()
+			newAdminTestRouter(inspector).ServeHTTP(res, req)
+
+			if res.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", res.Code, tt.wantStatus, res.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if inspector.calls != 0 {
+					t.Errorf("invalid page reached storage")
+				}
+				return
+			}
+			if inspector.limit != tt.wantLimit || inspector.offset != tt.wantOffset {
+				t.Errorf("limit, offset = %d, %d; want %d, %d", inspector.limit, inspector.offset, tt.wantLimit, tt.wantOffset)
+			}
+			var found []models.UserProfile
+			if err := json.NewDecoder(res.Body).Decode(&found); err != nil {
+				t.Fatal(err)
+			}
+			logins := make([]string, 0, len(found))
+			for _, user := range found {
+				logins = append(logins, user.Login)
+			}
+			if strings.Join(logins, ",") != strings.Join(tt.wantLogins, ",") || found == nil {
+				t.Errorf("logins = %v, want %v", logins, tt.wantLogins)
+			}
+		})
+	}
+}
+
+// fakeDailyStats запоминает запрошенный период и часовой пояс.
+type fakeDailyStats struct {
+	from, to, timeZone string
+	calls              int
+}
+
+func (f *fakeDailyStats) GetDailyStats(_ context.Context, from, to, timeZone string) ([]models.DailyStats, error) {
+	f.calls++
+	f.from, f.to, f.timeZone = from, to, timeZone
+	return []models.DailyStats{{Date: from}}, nil
+}
+
+func TestGetDailyStatsPeriod(t *testing.T) {
+	moscow, err := time.LoadLocation("Europe/Moscow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now().In(moscow)
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name       string
+		target     string
+		wantStatus int
+		wantFrom   string
+		wantTo     string
+	}{
+		{name: "period", target: "/api/admin/stats/daily?from=2024-03-01&to=2024-03-04", wantStatus: http.StatusOK, wantFrom: "2024-03-01", wantTo: "2024-03-04"},
+		{name: "single day", target: "/api/admin/stats/daily?from=2024-03-01&to=2024-03-01", wantStatus: http.StatusOK, wantFrom: "2024-03-01", wantTo: "2024-03-01"},
+		{name: "default period", target: "/api/admin/stats/daily", wantStatus: http.StatusOK,
+			wantFrom: today.AddDate(0, 0, -(defaultDailyStatsDays - 1)).Format(dateLayout), wantTo: today.Format(dateLayout)},
+		{name: "only to", target: "/api/admin/stats/daily?to=2024-03-30", wantStatus: http.StatusOK, wantFrom: "2024-03-01", wantTo: "2024-03-30"},
+		{name: "longest period", target: "/api/admin/stats/daily?from=2024-01-01&to=2024-12-31", wantStatus: http.StatusOK, wantFrom: "2024-01-01", wantTo: "2024-12-31"},
+		{name: "period too long", target: "/api/admin/stats/daily?from=2024-01-01&to=2025-01-01", wantStatus: http.StatusBadRequest},
+		{name: "from after to", target: "/api/admin/stats/daily?from=2024-03-05&to=2024-03-04", wantStatus: http.StatusBadRequest},
+		{name: "malformed from", target: "/api/admin/stats/daily?from=01.03.2024", wantStatus: http.StatusBadRequest},
+		{name: "malformed to", target: "/api/admin/stats/daily?to=2024-02-30", wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			provider := &fakeDailyStats{}
+			res := httptest.NewRecorder()
+			GetDailyStats(provider, moscow, logger.NewNopLogger())(res, httptest.NewRequest(http.MethodGet, tt.target, nil))
+
+			if res.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", res.Code, tt.wantStatus, res.Body)
+			}
+			if tt.wantStatus != http.StatusOK {
+				if provider.calls != 0 {
+					t.Errorf("invalid period reached storage")
+				}
+				if !strings.Contains(res.Body.String(), errCodeInvalidDateRange) {
+					t.Errorf("body = %s, want error code %s", res.Body, errCodeInvalidDateRange)
+				}
+				return
+			}
+			if provider.from != tt.wantFrom || provider.to != tt.wantTo || provider.timeZone != "Europe/Moscow" {
+				t.Errorf("period = %s..%s in %s, want %s..%s in Europe/Moscow", provider.from, provider.to, provider.timeZone, tt.wantFrom, tt.wantTo)
+			}
+		})
+	}
+}
+
+// fakeAdminOrderProcessor возвращает заданную ошибку и запоминает, дошел ли запрос до хранилища.
+type fakeAdminOrderProcessor struct {
+	err   error
+	calls int
+}
+
+func (f *fakeAdminOrderProcessor) AdminUpdateOrderStatus(context.Context, string, models.OrderStatus, *float64) error {
+	f.calls++
+	return f.err
+}
+
+func TestAdminUpdateOrderStatus(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		storageErr error
+		wantStatus int
+		wantCode   string
+		wantCalls  int
+	}{
+		{name: "updated", body: `{"status":"PROCESSED","accrual":500}`, wantStatus: http.StatusOK, wantCalls: 1},
+		{name: "unknown order", body: `{"status":"INVALID"}`, storageErr: storage.ErrOrderNotFound, wantStatus: http.StatusNotFound, wantCode: errCodeOrderNotFound, wantCalls: 1},
+		{name: "credited order leaves PROCESSED", body: `{"status":"INVALID"}`, storageErr: fmt.Errorf("adminUpdateOrderStatus: %w", storage.ErrOrderStatusTransition), wantStatus: http.StatusConflict, wantCode: errCodeOrderStatusTransition, wantCalls: 1},
+		{name: "accrual already spent", body: `{"status":"PROCESSED","accrual":10}`, storageErr: fmt.Errorf("adminUpdateOrderStatus: %w", storage.ErrNotEnoughBonuses), wantStatus: http.StatusConflict, wantCode: errCodeNotEnoughBonuses, wantCalls: 1},
+		{name: "storage failure", body: `{"status":"PROCESSED","accrual":10}`, storageErr: errors.New("connection reset"), wantStatus: http.StatusInternalServerError, wantCode: errCodeInternal, wantCalls: 1},
+		{name: "unknown status", body: `{"status":"DONE"}`, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidOrderStatus},
+		{name: "negative accrual", body: `{"status":"PROCESSED","accrual":-1}`, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := &fakeAdminOrderProcessor{err: tt.storageErr}
+			r := chi.NewRouter()
+			r.Put("/api/admin/orders/{orderID}/status", AdminUpdateOrderStatus(processor, logger.NewNopLogger()))
+
+			res := httptest.NewRecorder()
+			r.ServeHTTP(res, httptest.NewRequest(http.MethodPut, "/api/admin/orders/12345678903/status", strings.NewReader(tt.body)))
+
+			if res.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", res.Code, tt.wantStatus, res.Body)
+			}
+			if tt.wantCode != "" {
+				var body apiErrorResponse
+				if err := json.NewDecoder(res.Body).Decode(&body); err != nil || body.Error.Code != tt.wantCode {
+					t.Errorf("error code = %q (%v), want %q", body.Error.Code, err, tt.wantCode)
+				}
+			}
+			if processor.calls != tt.wantCalls {
+				t.Errorf("storage calls = %d, want %d", processor.calls, tt.wantCalls)
+			}
+		})
+	}
+}
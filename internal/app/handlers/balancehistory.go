@@ -0,0 +1,126 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"github.com/vancho-go/gophermart/internal/app/storage"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type BalanceHistoryProvider interface {
+	GetBalanceHistory(ctx context.Context, userID string, filter storage.BalanceHistoryFilter) (history []models.APIBalanceHistoryEntry, err error)
+}
+
+const (
+	dateLayout = "2006-01-02"
+
+	defaultPageLimit = 50
+	maxPageLimit     = 500
+)
+
+// parsePageParam разбирает неотрицательный целочисленный параметр пагинации, пустое значение — fallback.
+func parsePageParam(value string, fallback int) (int, error) {
+	if value == "" {
+		return fallback, nil
+	}
+	parsed, err := strconv.Atoi(value)
+	if err != nil || parsed < 0 {
+		return 0, errors.New("expected non-negative integer")
+	}
+	return parsed, nil
+}
+
+// parseHistoryBound разбирает границу периода в формате RFC3339 или YYYY-MM-DD. Дата в параметре
+// to включает весь день, поэтому переносится на начало следующего дня.
+func parseHistoryBound(value string, endOfDay bool) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(dateLayout, value)
+	if err != nil {
+		return time.Time{}, errors.New("expected RFC3339 timestamp or YYYY-MM-DD date")
+	}
+	if endOfDay {
+		t = t.AddDate(0, 0, 1)
+	}
+	return t, nil
+}
+
+// GetBalanceHistory отдает операции с балансом пользователя от новых к старым с балансом после
+// каждой операции. Необязательные параметры from и to ограничивают период, limit и offset — страницу.
+func GetBalanceHistory(bhp BalanceHistoryProvider, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "getBalanceHistory"))
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		ctx, span := tracer.Start(req.Context(), "handler.GetBalanceHistory")
+		defer span.End()
+
+		userID, ok := getUserIDFromContext(ctx)
+		if !ok {
+			logger.Debug("unauthorized")
+			writeJSONError(res, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+			return
+		}
+
+		from, err := parseHistoryBound(req.URL.Query().Get("from"), false)
+		if err != nil {
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidDateRange, "Invalid from: "+err.Error())
+			return
+		}
+		to, err := parseHistoryBound(req.URL.Query().Get("to"), true)
+		if err != nil {
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidDateRange, "Invalid to: "+err.Error())
+			return
+		}
+		if !from.IsZero() && !to.IsZero() && !from.Before(to) {
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidDateRange, "from must be before to")
+			return
+		}
+
+		limit, err := parsePageParam(req.URL.Query().Get("limit"), defaultPageLimit)
+		if err != nil || limit == 0 || limit > maxPageLimit {
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidRequest, "limit must be between 1 and "+strconv.Itoa(maxPageLimit))
+			return
+		}
+		offset, err := parsePageParam(req.URL.Query().Get("offset"), 0)
+		if err != nil {
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidRequest, "Invalid offset: "+err.Error())
+			return
+		}
+
+		history, err := bhp.GetBalanceHistory(ctx, userID, storage.BalanceHistoryFilter{
+			From:   from,
+			To:     to,
+			Limit:  limit,
+			Offset: offset,
+		})
+		if err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+
+		if len(history) == 0 {
+			res.WriteHeader(http.StatusNoContent)
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(res).Encode(history); err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+	}
+}
@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+const (
+	errCodeInvalidRequest           = "INVALID_REQUEST"
+	errCodeUnsupportedMediaType     = "UNSUPPORTED_MEDIA_TYPE"
+	errCodeValidationFailed         = "VALIDATION_FAILED"
+	errCodeUnauthorized             = "UNAUTHORIZED"
+	errCodeInvalidCredentials       = "INVALID_CREDENTIALS"
+	errCodeUserNotFound             = "USER_NOT_FOUND"
+	errCodeLoginAlreadyExists       = "LOGIN_ALREADY_EXISTS"
+	errCodeLoginPendingRelease      = "LOGIN_PENDING_RELEASE"
+	errCodeEmailAlreadyExists       = "EMAIL_ALREADY_EXISTS"
+	errCodeInvalidOrderNumber       = "INVALID_ORDER_NUMBER"
+	errCodeOrderAlreadyUploaded     = "ORDER_ALREADY_UPLOADED"
+	errCodeOrderAlreadyExists       = "ORDER_ALREADY_EXISTS"
+	errCodeOrderNotFound            = "ORDER_NOT_FOUND"
+	errCodeInvalidOrderBatch        = "INVALID_ORDER_BATCH"
+	errCodeInvalidOrderStatus       = "INVALID_ORDER_STATUS"
+	errCodeOrderStatusTransition    = "ORDER_STATUS_TRANSITION_NOT_ALLOWED"
+	errCodeNotEnoughBonuses         = "NOT_ENOUGH_BONUSES"
+	errCodeInvalidWithdrawalSum     = "INVALID_WITHDRAWAL_SUM"
+	errCodeInvalidWebhookURL        = "INVALID_WEBHOOK_URL"
+	errCodeWebhookNotFound          = "WEBHOOK_NOT_FOUND"
+	errCodeInvalidDateRange         = "INVALID_DATE_RANGE"
+	errCodeInvalidIdempotencyKey    = "INVALID_IDEMPOTENCY_KEY"
+	errCodeIdempotencyKeyInProgress = "IDEMPOTENCY_KEY_IN_PROGRESS"
+	errCodeIdempotencyKeyReused     = "IDEMPOTENCY_KEY_REUSED"
+	errCodeInternal                 = "INTERNAL_ERROR"
+)
+
+type apiError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+type apiErrorResponse struct {
+	Error apiError `json:"error"`
+	// Errors — ошибки по полям запроса, заполняется только при VALIDATION_FAILED
+	Errors map[string]string `json:"errors,omitempty"`
+}
+
+// writeJSONError отвечает телом вида {"error":{"code":"...","message":"..."}}.
+func writeJSONError(res http.ResponseWriter, status int, code, message string) {
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.WriteHeader(status)
+	json.NewEncoder(res).Encode(apiErrorResponse{Error: apiError{Code: code, Message: message}})
+}
+
+// writeJSONValidationErrors отвечает 400 с кодом VALIDATION_FAILED и ошибками по полям в "errors".
+func writeJSONValidationErrors(res http.ResponseWriter, fieldErrors map[string]string) {
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(res).Encode(apiErrorResponse{
+		Error:  apiError{Code: errCodeValidationFailed, Message: "Request validation failed"},
+		Errors: fieldErrors,
+	})
+}
@@ -0,0 +1,41 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+)
+
+// weakETag строит слабый ETag из метки состояния данных: ответ с той же меткой совпадает по
+// смыслу, но не обязательно побайтно.
+func weakETag(version string) string {
+	return `W/"` + version + `"`
+}
+
+// etagMatches сообщает, содержит ли заголовок If-None-Match запроса тег etag. Теги сравниваются
+// слабым сравнением (RFC 9110, 8.8.3.2), то есть без учета префикса W/.
+func etagMatches(req *http.Request, etag string) bool {
+	header := req.Header.Get("If-None-Match")
+	if header == "" {
+		return false
+	}
+	if strings.TrimSpace(header) == "*" {
+		return true
+	}
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, candidate := range strings.Split(header, ",") {
+		if strings.TrimPrefix(strings.TrimSpace(candidate), "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// writeNotModified отвечает 304 без тела, если клиент уже получил данные с тегом etag.
+func writeNotModified(res http.ResponseWriter, req *http.Request, etag string) bool {
+	if !etagMatches(req, etag) {
+		return false
+	}
+	res.Header().Set("ETag", etag)
+	res.WriteHeader(http.StatusNotModified)
+	return true
+}
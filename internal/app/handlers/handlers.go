@@ -4,28 +4,50 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
 	"github.com/vancho-go/gophermart/internal/app/auth"
 	"github.com/vancho-go/gophermart/internal/app/logger"
 	"github.com/vancho-go/gophermart/internal/app/models"
 	"github.com/vancho-go/gophermart/internal/app/storage"
+	"go.opentelemetry.io/otel"
 	"go.uber.org/zap"
 	"io"
+	"math"
+	"mime"
 	"net/http"
+	"strconv"
+	"strings"
+	"time"
 )
 
+const orderEventsKeepAlivePeriod = time.Second * 15
+
+// tracer создает спаны обработчиков; они вкладываются в серверный спан middleware.Trace.
+var tracer = otel.Tracer("github.com/vancho-go/gophermart/internal/app/handlers")
+
 type UserAuthenticator interface {
-	RegisterUser(ctx context.Context, username, password string) (userID string, err error)
+	RegisterUser(ctx context.Context, username, email, password string) (userID string, err error)
 	AuthenticateUser(ctx context.Context, username, password string) (userID string, err error)
 }
 
 type OrderProcessor interface {
 	AddOrder(ctx context.Context, order models.APIAddOrderRequest) (err error)
-	GetOrders(ctx context.Context, userID string) (orders []models.APIGetOrderResponse, err error)
+	GetOrders(ctx context.Context, userID string, filter storage.OrdersFilter) (orders []models.APIGetOrderResponse, err error)
+	OrdersVersion(ctx context.Context, userID string) (version string, err error)
+}
+
+type OrderBatchProcessor interface {
+	AddOrders(ctx context.Context, userID string, orderNumbers []string) (results []error, err error)
+}
+
+type OrderEventsSubscriber interface {
+	Subscribe(userID string) (events <-chan models.APIOrderStatusEvent, unsubscribe func())
 }
 
 type BonusesProcessor interface {
 	GetCurrentBonusesAmount(ctx context.Context, userID string) (bonuses models.APIGetBonusesAmountResponse, err error)
 	UseBonuses(ctx context.Context, request models.APIUseBonusesRequest, userID string) (err error)
+	BalanceVersion(ctx context.Context, userID string) (version string, err error)
 }
 
 type WithdrawalsProcessor interface {
@@ -37,32 +59,52 @@ func getUserIDFromContext(ctx context.Context) (string, bool) {
 	return userID, ok
 }
 
-func RegisterUser(ua UserAuthenticator, logger logger.Logger) http.HandlerFunc {
+func RegisterUser(ua UserAuthenticator, maxLoginLength int, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "registerUser"))
+
 	return func(res http.ResponseWriter, req *http.Request) {
+		ctx, span := tracer.Start(req.Context(), "handler.RegisterUser")
+		defer span.End()
+
 		var request models.APIRegisterRequest
 
 		decoder := json.NewDecoder(req.Body)
 		if err := decoder.Decode(&request); err != nil {
-			logger.Debug("registerUser:", zap.Error(err))
-			http.Error(res, "Invalid request format", http.StatusBadRequest)
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request format")
+			return
+		}
+
+		if fieldErrors := validateRegisterRequest(request, maxLoginLength); len(fieldErrors) > 0 {
+			logger.Debug("request failed", zap.Any("errors", fieldErrors))
+			writeJSONValidationErrors(res, fieldErrors)
 			return
 		}
+		login, email := strings.TrimSpace(request.Login), strings.TrimSpace(request.Email)
 
-		userID, err := ua.RegisterUser(req.Context(), request.Login, request.Password)
+		userID, err := ua.RegisterUser(ctx, login, email, request.Password)
 		if errors.Is(err, storage.ErrUsernameNotUnique) {
-			logger.Debug("registerUser:", zap.Error(err))
-			http.Error(res, "Username is already in use", http.StatusConflict)
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusConflict, errCodeLoginAlreadyExists, "Username is already in use")
+			return
+		} else if errors.Is(err, storage.ErrUsernameDeletedPendingExpiry) {
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusConflict, errCodeLoginPendingRelease, "Username belongs to a deleted account and is not available yet")
+			return
+		} else if errors.Is(err, storage.ErrEmailNotUnique) {
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusConflict, errCodeEmailAlreadyExists, "Email is already in use")
 			return
 		} else if err != nil {
-			logger.Error("registerUser:", zap.Error(err))
-			http.Error(res, "Internal error", http.StatusInternalServerError)
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
 			return
 		}
 
 		cookie, err := auth.GenerateCookie(userID)
 		if err != nil {
-			logger.Error("registerUser:", zap.Error(err))
-			http.Error(res, "Internal error", http.StatusInternalServerError)
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
 			return
 		}
 
@@ -71,32 +113,44 @@ func RegisterUser(ua UserAuthenticator, logger logger.Logger) http.HandlerFunc {
 	}
 }
 
-func AuthenticateUser(ua UserAuthenticator, logger logger.Logger) http.HandlerFunc {
+func AuthenticateUser(ua UserAuthenticator, maxLoginLength int, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "authenticateUser"))
+
 	return func(res http.ResponseWriter, req *http.Request) {
+		ctx, span := tracer.Start(req.Context(), "handler.AuthenticateUser")
+		defer span.End()
+
 		var request models.APIAuthRequest
 
 		decoder := json.NewDecoder(req.Body)
 		if err := decoder.Decode(&request); err != nil {
-			logger.Debug("authenticateUser:", zap.Error(err))
-			http.Error(res, "Invalid request format", http.StatusBadRequest)
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request format")
+			return
+		}
+
+		login, err := normalizeLogin(request.Login, maxLoginLength)
+		if err != nil {
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusUnauthorized, errCodeInvalidCredentials, "Wrong username or password")
 			return
 		}
 
-		userID, err := ua.AuthenticateUser(req.Context(), request.Login, request.Password)
+		userID, err := ua.AuthenticateUser(ctx, login, request.Password)
 		if errors.Is(err, storage.ErrUserNotFound) {
-			logger.Debug("authenticateUser:", zap.Error(err))
-			http.Error(res, "Wrong username or password", http.StatusUnauthorized)
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusUnauthorized, errCodeInvalidCredentials, "Wrong username or password")
 			return
 		} else if err != nil {
-			logger.Error("authenticateUser:", zap.Error(err))
-			http.Error(res, "Internal error", http.StatusInternalServerError)
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
 			return
 		}
 
 		cookie, err := auth.GenerateCookie(userID)
 		if err != nil {
-			logger.Error("authenticateUser:", zap.Error(err))
-			http.Error(res, "Internal error", http.StatusInternalServerError)
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
 			return
 		}
 
@@ -105,142 +159,350 @@ func AuthenticateUser(ua UserAuthenticator, logger logger.Logger) http.HandlerFu
 	}
 }
 
+// errUnsupportedMediaType возвращается readOrderNumber для тела, которое не является ни
+// text/plain, ни application/json.
+var errUnsupportedMediaType = errors.New("unsupported media type")
+
+// readOrderNumber читает номер заказа из тела запроса: объект {"order": "..."} при
+// Content-Type: application/json, тело целиком при text/plain или без Content-Type.
+func readOrderNumber(req *http.Request) (string, error) {
+	defer req.Body.Close()
+
+	var mediaType string
+	if contentType := req.Header.Get("Content-Type"); contentType != "" {
+		var err error
+		mediaType, _, err = mime.ParseMediaType(contentType)
+		if err != nil {
+			return "", fmt.Errorf("readOrderNumber: %w: %s", errUnsupportedMediaType, contentType)
+		}
+	}
+
+	switch mediaType {
+	case "", "text/plain":
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			return "", fmt.Errorf("readOrderNumber: error reading body: %w", err)
+		}
+		return string(body), nil
+	case "application/json":
+		var request models.APIAddOrderJSONRequest
+		if err := json.NewDecoder(req.Body).Decode(&request); err != nil {
+			return "", fmt.Errorf("readOrderNumber: error decoding body: %w", err)
+		}
+		return request.Order, nil
+	}
+	return "", fmt.Errorf("readOrderNumber: %w: %s", errUnsupportedMediaType, mediaType)
+}
+
 func AddOrder(op OrderProcessor, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "addOrder"))
+
 	return func(res http.ResponseWriter, req *http.Request) {
-		userID, ok := getUserIDFromContext(req.Context())
+		ctx, span := tracer.Start(req.Context(), "handler.AddOrder")
+		defer span.End()
+
+		userID, ok := getUserIDFromContext(ctx)
 		if !ok {
-			logger.Debug("addOrder: unauthorized")
-			http.Error(res, "Unauthorized", http.StatusUnauthorized)
+			logger.Debug("unauthorized")
+			writeJSONError(res, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
 			return
 		}
 
-		body, err := io.ReadAll(req.Body)
-		defer req.Body.Close()
+		orderNumber, err := readOrderNumber(req)
+		if errors.Is(err, errUnsupportedMediaType) {
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusUnsupportedMediaType, errCodeUnsupportedMediaType,
+				"Order number must be sent as text/plain or application/json")
+			return
+		}
 		if err != nil {
-			logger.Info("authenticateUser:", zap.Error(err))
-			http.Error(res, "Invalid request format", http.StatusBadRequest)
+			logger.Info("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request format")
 			return
 		}
 
-		orderNumber := string(body)
-
 		err = isOrderNumberValid(orderNumber)
 		if err != nil {
-			logger.Debug("authenticateUser:", zap.Error(err))
-			http.Error(res, "Incorrect order number format", http.StatusUnprocessableEntity)
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusUnprocessableEntity, errCodeInvalidOrderNumber, "Incorrect order number format")
 			return
 		}
 
 		orderRequest := models.APIAddOrderRequest{OrderNumber: orderNumber, UserID: userID}
 
-		err = op.AddOrder(req.Context(), orderRequest)
+		err = op.AddOrder(ctx, orderRequest)
 		if err != nil {
 			if errors.Is(err, storage.ErrOrderNumberWasAlreadyAddedByThisUser) {
-				logger.Debug("authenticateUser:", zap.Error(err))
-				http.Error(res, "Order number was already added", http.StatusOK)
+				logger.Debug("request failed", zap.Error(err))
+				writeJSONError(res, http.StatusOK, errCodeOrderAlreadyUploaded, "Order number was already added")
 				return
 			} else if errors.Is(err, storage.ErrOrderNumberWasAlreadyAddedByAnotherUser) {
-				logger.Debug("authenticateUser:", zap.Error(err))
-				http.Error(res, "Order number was already added", http.StatusConflict)
+				logger.Debug("request failed", zap.Error(err))
+				writeJSONError(res, http.StatusConflict, errCodeOrderAlreadyExists, "Order number was already added by another user")
 				return
 			}
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
 		}
 		res.WriteHeader(http.StatusAccepted)
 	}
 }
 
+func AddOrdersBatch(obp OrderBatchProcessor, maxBatchSize int, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "addOrdersBatch"))
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		ctx, span := tracer.Start(req.Context(), "handler.AddOrdersBatch")
+		defer span.End()
+
+		userID, ok := getUserIDFromContext(ctx)
+		if !ok {
+			logger.Debug("unauthorized")
+			writeJSONError(res, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+			return
+		}
+
+		var orderNumbers []string
+		decoder := json.NewDecoder(req.Body)
+		if err := decoder.Decode(&orderNumbers); err != nil {
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request format")
+			return
+		}
+		defer req.Body.Close()
+
+		if len(orderNumbers) == 0 || len(orderNumbers) > maxBatchSize {
+			logger.Debug("invalid batch size", zap.Int("size", len(orderNumbers)))
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidOrderBatch,
+				fmt.Sprintf("Batch must contain from 1 to %d order numbers", maxBatchSize))
+			return
+		}
+
+		results := make([]models.APIOrderBatchResult, len(orderNumbers))
+		var validNumbers []string
+		var validIndexes []int
+		for i, orderNumber := range orderNumbers {
+			results[i].Number = orderNumber
+			if err := isOrderNumberValid(orderNumber); err != nil {
+				results[i].Status = models.OrderBatchInvalid
+				results[i].Error = errCodeInvalidOrderNumber
+				continue
+			}
+			validNumbers = append(validNumbers, orderNumber)
+			validIndexes = append(validIndexes, i)
+		}
+
+		if len(validNumbers) > 0 {
+			addErrors, err := obp.AddOrders(ctx, userID, validNumbers)
+			if err != nil {
+				logger.Error("request failed", zap.Error(err))
+				writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+				return
+			}
+			for j, addErr := range addErrors {
+				result := &results[validIndexes[j]]
+				switch {
+				case addErr == nil:
+					result.Status = models.OrderBatchAccepted
+				case errors.Is(addErr, storage.ErrOrderNumberWasAlreadyAddedByThisUser):
+					result.Status = models.OrderBatchDuplicate
+					result.Error = errCodeOrderAlreadyUploaded
+				default:
+					result.Status = models.OrderBatchDuplicate
+					result.Error = errCodeOrderAlreadyExists
+				}
+			}
+		}
+
+		// у каждого номера свой результат, поэтому ответ 207 Multi-Status
+		res.Header().Set("Content-Type", "application/json")
+		res.WriteHeader(http.StatusMultiStatus)
+		if err := json.NewEncoder(res).Encode(results); err != nil {
+			logger.Error("request failed", zap.Error(err))
+		}
+	}
+}
+
+// parseOrdersFilter разбирает необязательные параметры status, limit и offset списка заказов.
+// Без limit возвращается весь список. При ошибке отвечает 400 и возвращает false.
+func parseOrdersFilter(res http.ResponseWriter, req *http.Request) (storage.OrdersFilter, bool) {
+	query := req.URL.Query()
+
+	status := models.OrderStatus(query.Get("status"))
+	if status != "" && !status.Valid() {
+		writeJSONError(res, http.StatusBadRequest, errCodeInvalidOrderStatus, "Status must be one of NEW, PROCESSING, INVALID, PROCESSED")
+		return storage.OrdersFilter{}, false
+	}
+
+	limit, err := parsePageParam(query.Get("limit"), 0)
+	if err != nil || (query.Get("limit") != "" && (limit == 0 || limit > maxPageLimit)) {
+		writeJSONError(res, http.StatusBadRequest, errCodeInvalidRequest, "limit must be between 1 and "+strconv.Itoa(maxPageLimit))
+		return storage.OrdersFilter{}, false
+	}
+	offset, err := parsePageParam(query.Get("offset"), 0)
+	if err != nil {
+		writeJSONError(res, http.StatusBadRequest, errCodeInvalidRequest, "offset must be a non-negative integer")
+		return storage.OrdersFilter{}, false
+	}
+	return storage.OrdersFilter{Status: status, Limit: limit, Offset: offset}, true
+}
+
 func GetOrdersList(op OrderProcessor, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "getOrdersList"))
+
 	return func(res http.ResponseWriter, req *http.Request) {
-		userID, ok := getUserIDFromContext(req.Context())
+		ctx, span := tracer.Start(req.Context(), "handler.GetOrdersList")
+		defer span.End()
+
+		userID, ok := getUserIDFromContext(ctx)
+		if !ok {
+			logger.Debug("unauthorized")
+			writeJSONError(res, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+			return
+		}
+
+		filter, ok := parseOrdersFilter(res, req)
 		if !ok {
-			logger.Debug("getOrdersList: unauthorized")
-			http.Error(res, "Unauthorized", http.StatusUnauthorized)
 			return
 		}
 
-		orders, err := op.GetOrders(req.Context(), userID)
+		version, err := op.OrdersVersion(ctx, userID)
 		if err != nil {
-			logger.Error("getOrdersList:", zap.Error(err))
-			http.Error(res, "Internal error", http.StatusInternalServerError)
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+		etag := weakETag(version)
+		if writeNotModified(res, req, etag) {
 			return
 		}
 
+		orders, err := op.GetOrders(ctx, userID, filter)
+		if err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+
+		res.Header().Set("ETag", etag)
 		if len(orders) == 0 {
-			logger.Debug("getOrdersList:", zap.Error(err))
-			http.Error(res, "No data", http.StatusNoContent)
+			res.WriteHeader(http.StatusNoContent)
 			return
 		}
 
 		res.Header().Set("Content-Type", "application/json")
 		encoder := json.NewEncoder(res)
 		if err := encoder.Encode(orders); err != nil {
-			logger.Error("getOrdersList:", zap.Error(err))
-			http.Error(res, "Internal error", http.StatusInternalServerError)
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
 			return
 		}
 	}
 }
 
 func GetBonusesAmount(bp BonusesProcessor, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "getBonusesAmount"))
+
 	return func(res http.ResponseWriter, req *http.Request) {
-		userID, ok := getUserIDFromContext(req.Context())
+		ctx, span := tracer.Start(req.Context(), "handler.GetBonusesAmount")
+		defer span.End()
+
+		userID, ok := getUserIDFromContext(ctx)
 		if !ok {
-			logger.Debug("getBonusesAmount: unauthorized")
-			http.Error(res, "Unauthorized", http.StatusUnauthorized)
+			logger.Debug("unauthorized")
+			writeJSONError(res, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
 			return
 		}
 
-		bonuses, err := bp.GetCurrentBonusesAmount(req.Context(), userID)
+		version, err := bp.BalanceVersion(ctx, userID)
 		if err != nil {
-			logger.Error("getBonusesAmount:", zap.Error(err))
-			http.Error(res, "Internal error", http.StatusInternalServerError)
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
 			return
 		}
+		etag := weakETag(version)
+		if writeNotModified(res, req, etag) {
+			return
+		}
+
+		bonuses, err := bp.GetCurrentBonusesAmount(ctx, userID)
+		if err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+		res.Header().Set("ETag", etag)
 		res.Header().Set("Content-Type", "application/json")
 		encoder := json.NewEncoder(res)
 		if err := encoder.Encode(bonuses); err != nil {
-			logger.Error("getBonusesAmount:", zap.Error(err))
-			http.Error(res, "Internal error", http.StatusInternalServerError)
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
 			return
 		}
 
 	}
 }
 
-func WithdrawBonuses(bp BonusesProcessor, logger logger.Logger) http.HandlerFunc {
+func WithdrawBonuses(bp BonusesProcessor, maxWithdrawalSum float64, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "withdrawBonuses"))
+
 	return func(res http.ResponseWriter, req *http.Request) {
-		userID, ok := getUserIDFromContext(req.Context())
+		ctx, span := tracer.Start(req.Context(), "handler.WithdrawBonuses")
+		defer span.End()
+
+		userID, ok := getUserIDFromContext(ctx)
 		if !ok {
-			logger.Debug("withdrawBonuses: unauthorized")
-			http.Error(res, "Unauthorized", http.StatusUnauthorized)
+			logger.Debug("unauthorized")
+			writeJSONError(res, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
 			return
 		}
 
 		var request models.APIUseBonusesRequest
 		decoder := json.NewDecoder(req.Body)
 		if err := decoder.Decode(&request); err != nil {
-			logger.Info("withdrawBonuses:", zap.Error(err))
-			http.Error(res, "Invalid request format", http.StatusInternalServerError)
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request format")
 			return
 		}
 		defer req.Body.Close()
 
+		// неположительная сумма при списании увеличила бы баланс
+		if math.IsNaN(request.Sum) || math.IsInf(request.Sum, 0) || request.Sum <= 0 {
+			logger.Debug("invalid sum", zap.Float64("sum", request.Sum))
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidWithdrawalSum, "Sum must be a finite number greater than 0")
+			return
+		}
+
+		// лимит на одно списание ограничивает ущерб от угнанной учетной записи, 0 — без лимита
+		if maxWithdrawalSum > 0 && request.Sum > maxWithdrawalSum {
+			logger.Debug("withdrawal over limit", zap.Float64("sum", request.Sum))
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidWithdrawalSum,
+				fmt.Sprintf("Sum must not be greater than %g", maxWithdrawalSum))
+			return
+		}
+
 		err := isOrderNumberValid(request.OrderNumber)
 		if err != nil {
-			logger.Debug("withdrawBonuses:", zap.Error(err))
-			http.Error(res, "Incorrect order number format", http.StatusUnprocessableEntity)
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusUnprocessableEntity, errCodeInvalidOrderNumber, "Incorrect order number format")
 			return
 		}
 
-		err = bp.UseBonuses(req.Context(), request, userID)
+		err = bp.UseBonuses(ctx, request, userID)
 		if err != nil {
 			if errors.Is(err, storage.ErrNotEnoughBonuses) {
-				logger.Debug("withdrawBonuses:", zap.Error(err))
-				http.Error(res, "Not enough bonuses", http.StatusPaymentRequired)
+				logger.Debug("request failed", zap.Error(err))
+				writeJSONError(res, http.StatusPaymentRequired, errCodeNotEnoughBonuses, "Not enough bonuses")
+				return
+			} else if errors.Is(err, storage.ErrNegativeBalance) {
+				logger.Error("ALERT withdrawal would leave balance negative", zap.String("user", userID),
+					zap.String("order", request.OrderNumber), zap.Error(err))
+				writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
 				return
 			} else {
-				logger.Error("withdrawBonuses:", zap.Error(err))
-				http.Error(res, "Internal error", http.StatusInternalServerError)
+				logger.Error("request failed", zap.Error(err))
+				writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
 				return
 			}
 		}
@@ -248,32 +510,110 @@ func WithdrawBonuses(bp BonusesProcessor, logger logger.Logger) http.HandlerFunc
 }
 
 func GetWithdrawals(wp WithdrawalsProcessor, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "getWithdrawals"))
+
 	return func(res http.ResponseWriter, req *http.Request) {
-		userID, ok := getUserIDFromContext(req.Context())
+		ctx, span := tracer.Start(req.Context(), "handler.GetWithdrawals")
+		defer span.End()
+
+		userID, ok := getUserIDFromContext(ctx)
 		if !ok {
-			logger.Debug("getWithdrawals: unauthorized")
-			http.Error(res, "Unauthorized", http.StatusUnauthorized)
+			logger.Debug("unauthorized")
+			writeJSONError(res, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
 			return
 		}
 
-		response, err := wp.GetWithdrawalsHistory(req.Context(), userID)
+		response, err := wp.GetWithdrawalsHistory(ctx, userID)
 		if err != nil {
-			if errors.Is(err, storage.ErrEmptyWithdrawalHistory) {
-				logger.Debug("getWithdrawals:", zap.Error(err))
-				http.Error(res, "No withdrawals", http.StatusNoContent)
-				return
-			} else {
-				logger.Error("getWithdrawals:", zap.Error(err))
-				http.Error(res, "Internal error", http.StatusInternalServerError)
-				return
-			}
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+
+		if len(response) == 0 {
+			res.WriteHeader(http.StatusNoContent)
+			return
 		}
+
 		res.Header().Set("Content-Type", "application/json")
 		encoder := json.NewEncoder(res)
 		if err := encoder.Encode(response); err != nil {
-			logger.Error("getWithdrawals:", zap.Error(err))
-			http.Error(res, "Internal error", http.StatusInternalServerError)
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+	}
+}
+
+// orderUpdateEvent — имя SSE-события изменения статуса заказа.
+const orderUpdateEvent = "order_update"
+
+// GetOrderEvents держит соединение открытым и отправляет SSE-событие order_update при каждом
+// изменении статуса или начисления заказа пользователя. Комментарий keep-alive раз в
+// orderEventsKeepAlivePeriod не дает прокси закрыть простаивающий поток.
+func GetOrderEvents(es OrderEventsSubscriber, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "getOrderEvents"))
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		userID, ok := getUserIDFromContext(req.Context())
+		if !ok {
+			logger.Debug("unauthorized")
+			writeJSONError(res, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
 			return
 		}
+
+		flusher, ok := res.(http.Flusher)
+		if !ok {
+			logger.Error("streaming is not supported")
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+
+		// поток живет дольше WriteTimeout сервера
+		if err := http.NewResponseController(res).SetWriteDeadline(time.Time{}); err != nil {
+			logger.Warn("stream will be closed by the server write timeout", zap.Error(err))
+		}
+
+		orderEvents, unsubscribe := es.Subscribe(userID)
+		defer unsubscribe()
+
+		res.Header().Set("Content-Type", "text/event-stream")
+		res.Header().Set("Cache-Control", "no-cache")
+		res.Header().Set("Connection", "keep-alive")
+		// nginx иначе буферизует ответ и доставляет события пачками
+		res.Header().Set("X-Accel-Buffering", "no")
+		res.WriteHeader(http.StatusOK)
+		flusher.Flush()
+
+		keepAlive := time.NewTicker(orderEventsKeepAlivePeriod)
+		defer keepAlive.Stop()
+
+		for {
+			select {
+			case <-req.Context().Done():
+				logger.Debug("client disconnected")
+				return
+			case <-keepAlive.C:
+				if _, err := fmt.Fprint(res, ": keep-alive\n\n"); err != nil {
+					logger.Debug("request failed", zap.Error(err))
+					return
+				}
+				flusher.Flush()
+			case event, ok := <-orderEvents:
+				if !ok {
+					return
+				}
+				data, err := json.Marshal(event)
+				if err != nil {
+					logger.Error("request failed", zap.Error(err))
+					continue
+				}
+				if _, err := fmt.Fprintf(res, "event: %s\ndata: %s\n\n", orderUpdateEvent, data); err != nil {
+					logger.Debug("request failed", zap.Error(err))
+					return
+				}
+				flusher.Flush()
+			}
+		}
 	}
 }
@@ -0,0 +1,552 @@
+package handlers
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"github.com/vancho-go/gophermart/internal/app/auth"
+	"github.com/vancho-go/gophermart/internal/app/events"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"github.com/vancho-go/gophermart/internal/app/storage"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withUserID выполняет handler от имени пользователя userID, как после auth.Middleware.
+func withUserID(userID string, handler http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		handler.ServeHTTP(res, req.WithContext(context.WithValue(req.Context(), auth.UserIDContextKey, userID)))
+	})
+}
+
+func TestGetOrderEvents(t *testing.T) {
+	bus := events.NewEventBus()
+	server := httptest.NewServer(withUserID("user-1", GetOrderEvents(bus, logger.NewNopLogger())))
+	defer server.Close()
+
+	res, err := server.Client().Get(server.URL + "/api/v1/user/orders/events")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+	wantHeaders := map[string]string{
+		"Content-Type":      "text/event-stream",
+		"Cache-Control":     "no-cache",
+		"X-Accel-Buffering": "no",
+	}
+	for name, want := range wantHeaders {
+		if got := res.Header.Get(name); got != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+
+	bus.Publish("user-2", models.APIOrderStatusEvent{Number: "79927398713", Status: models.OrderStatusProcessed})
+	bus.Publish("user-1", models.APIOrderStatusEvent{Number: "12345678903", Status: models.OrderStatusProcessed})
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	want := []string{"event: " + orderUpdateEvent, `data: {"number":"12345678903","status":"PROCESSED"}`}
+	deadline := time.After(time.Second * 5)
+	for _, wantLine := range want {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("stream closed before the event was delivered")
+			}
+			if line != wantLine {
+				t.Fatalf("stream line = %q, want %q", line, wantLine)
+			}
+		case <-deadline:
+			t.Fatal("event was not delivered")
+		}
+	}
+}
+
+func TestGetOrderEventsUnauthorized(t *testing.T) {
+	res := httptest.NewRecorder()
+	GetOrderEvents(events.NewEventBus(), logger.NewNopLogger())(res, httptest.NewRequest(http.MethodGet, "/api/v1/user/orders/events", nil))
+
+	if res.Code != http.StatusUnauthorized {
+		t.Errorf("status = %d, want %d", res.Code, http.StatusUnauthorized)
+	}
+	if strings.HasPrefix(res.Header().Get("Content-Type"), "text/event-stream") {
+		t.Error("unauthorized response is an event stream")
+	}
+}
+
+// fakeOrderProcessor отдает заказы и метку их состояния из памяти и считает чтения заказов.
+type fakeOrderProcessor struct {
+	orders  []models.APIGetOrderResponse
+	version string
+	reads   int
+	added   []string
+}
+
+func (f *fakeOrderProcessor) AddOrder(_ context.Context, request models.APIAddOrderRequest) error {
+	f.added = append(f.added, request.OrderNumber)
+	return nil
+}
+
+func (f *fakeOrderProcessor) GetOrders(context.Context, string, storage.OrdersFilter) ([]models.APIGetOrderResponse, error) {
+	f.reads++
+	return f.orders, nil
+}
+
+func (f *fakeOrderProcessor) OrdersVersion(context.Context, string) (string, error) {
+	return f.version, nil
+}
+
+// fakeBonusesProcessor отдает баланс и метку его состояния из памяти, считает чтения баланса и
+// запоминает списания.
+type fakeBonusesProcessor struct {
+	balance   models.APIGetBonusesAmountResponse
+	version   string
+	reads     int
+	withdrawn []models.APIUseBonusesRequest
+}
+
+func (f *fakeBonusesProcessor) GetCurrentBonusesAmount(context.Context, string) (models.APIGetBonusesAmountResponse, error) {
+	f.reads++
+	return f.balance, nil
+}
+
+func (f *fakeBonusesProcessor) UseBonuses(_ context.Context, request models.APIUseBonusesRequest, _ string) error {
+	f.withdrawn = append(f.withdrawn, request)
+	return nil
+}
+
+func (f *fakeBonusesProcessor) BalanceVersion(context.Context, string) (string, error) {
+	return f.version, nil
+}
+
+func TestAddOrderContentType(t *testing.T) {
+	const orderNumber = "12345678903"
+
+	tests := []struct {
+		name        string
+		contentType string
+		body        string
+		wantStatus  int
+		wantCode    string
+	}{
+		{name: "text/plain", contentType: "text/plain", body: orderNumber, wantStatus: http.StatusAccepted},
+		{name: "text/plain with charset", contentType: "text/plain; charset=utf-8", body: orderNumber, wantStatus: http.StatusAccepted},
+		{name: "without content type", body: orderNumber, wantStatus: http.StatusAccepted},
+		{name: "json", contentType: "application/json", body: `{"order":"` + orderNumber + `"}`, wantStatus: http.StatusAccepted},
+		{name: "malformed json", contentType: "application/json", body: `{"order":`, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidRequest},
+		{name: "json number as text", contentType: "text/plain", body: `{"order":"` + orderNumber + `"}`, wantStatus: http.StatusUnprocessableEntity, wantCode: errCodeInvalidOrderNumber},
+		{name: "unsupported media type", contentType: "application/xml", body: "<order>" + orderNumber + "</order>", wantStatus: http.StatusUnsupportedMediaType, wantCode: errCodeUnsupportedMediaType},
+		{name: "invalid content type", contentType: "text/", body: orderNumber, wantStatus: http.StatusUnsupportedMediaType, wantCode: errCodeUnsupportedMediaType},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := &fakeOrderProcessor{}
+			req := newUserRequest(http.MethodPost, "/api/v1/user/orders", strings.NewReader(tt.body), "user-1")
+			if tt.contentType != "" {
+				req.Header.Set("Content-Type", tt.contentType)
+			}
+			res := httptest.NewRecorder()
+			AddOrder(processor, logger.NewNopLogger())(res, req)
+
+			if res.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body %s", res.Code, tt.wantStatus, res.Body)
+			}
+			if tt.wantStatus == http.StatusAccepted {
+				if len(processor.added) != 1 || processor.added[0] != orderNumber {
+					t.Errorf("added orders = %q, want [%s]", processor.added, orderNumber)
+				}
+				return
+			}
+			if len(processor.added) != 0 {
+				t.Errorf("rejected request added orders %q", processor.added)
+			}
+			if !strings.Contains(res.Body.String(), `"code":"`+tt.wantCode+`"`) {
+				t.Errorf("body = %s, want error code %s", res.Body, tt.wantCode)
+			}
+		})
+	}
+}
+
+// conditionalGet выполняет GET от имени user-1 с заголовком If-None-Match, если он задан.
+func conditionalGet(handler http.HandlerFunc, target, ifNoneMatch string) *httptest.ResponseRecorder {
+	req := newUserRequest(http.MethodGet, target, nil, "user-1")
+	if ifNoneMatch != "" {
+		req.Header.Set("If-None-Match", ifNoneMatch)
+	}
+	res := httptest.NewRecorder()
+	handler(res, req)
+	return res
+}
+
+func TestGetOrdersListETag(t *testing.T) {
+	processor := &fakeOrderProcessor{
+		orders:  []models.APIGetOrderResponse{{Number: "12345678903", Status: models.OrderStatusNew}},
+		version: "10-1",
+	}
+	handler := GetOrdersList(processor, logger.NewNopLogger())
+
+	first := conditionalGet(handler, "/api/v1/user/orders", "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("first request: status %d, ETag %q", first.Code, etag)
+	}
+
+	notModified := conditionalGet(handler, "/api/v1/user/orders", etag)
+	if notModified.Code != http.StatusNotModified || notModified.Body.Len() != 0 {
+		t.Fatalf("request with a current ETag: status %d, body %q; want 304 without a body", notModified.Code, notModified.Body)
+	}
+	if notModified.Header().Get("ETag") != etag {
+		t.Errorf("304 ETag = %q, want %q", notModified.Header().Get("ETag"), etag)
+	}
+	if processor.reads != 1 {
+		t.Errorf("orders were read %d times, want 1: 304 must not read the list", processor.reads)
+	}
+
+	// начисление по заказу меняет метку, и прежний ETag больше не совпадает
+	accrualSum := 500.0
+	processor.orders[0].Status, processor.orders[0].Accrual = models.OrderStatusProcessed, &accrualSum
+	processor.version = "21-1"
+	changed := conditionalGet(handler, "/api/v1/user/orders", etag)
+	if changed.Code != http.StatusOK || changed.Header().Get("ETag") == etag {
+		t.Fatalf("request after an accrual: status %d, ETag %q; want 200 with a new ETag", changed.Code, changed.Header().Get("ETag"))
+	}
+	if !strings.Contains(changed.Body.String(), `"status":"PROCESSED"`) {
+		t.Errorf("body = %s, want the processed order", changed.Body)
+	}
+}
+
+func TestGetBonusesAmountETag(t *testing.T) {
+	processor := &fakeBonusesProcessor{balance: models.APIGetBonusesAmountResponse{Current: 500}, version: "1001"}
+	handler := GetBonusesAmount(processor, logger.NewNopLogger())
+
+	first := conditionalGet(handler, "/api/v1/user/balance", "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || etag == "" {
+		t.Fatalf("first request: status %d, ETag %q", first.Code, etag)
+	}
+
+	for _, header := range []string{etag, strings.TrimPrefix(etag, "W/"), `"other", ` + etag, "*"} {
+		if res := conditionalGet(handler, "/api/v1/user/balance", header); res.Code != http.StatusNotModified {
+			t.Errorf("If-None-Match %s: status %d, want %d", header, res.Code, http.StatusNotModified)
+		}
+	}
+	if processor.reads != 1 {
+		t.Errorf("balance was read %d times, want 1: 304 must not read the balance", processor.reads)
+	}
+
+	// списание меняет метку баланса
+	processor.balance = models.APIGetBonusesAmountResponse{Current: 400, Withdrawn: 100}
+	processor.version = "1002"
+	changed := conditionalGet(handler, "/api/v1/user/balance", etag)
+	if changed.Code != http.StatusOK || changed.Header().Get("ETag") == etag {
+		t.Fatalf("request after a withdrawal: status %d, ETag %q; want 200 with a new ETag", changed.Code, changed.Header().Get("ETag"))
+	}
+	if !strings.Contains(changed.Body.String(), `"withdrawn":100`) {
+		t.Errorf("body = %s, want the balance after the withdrawal", changed.Body)
+	}
+}
+
+// fakeUserAuthenticator запоминает логин и email, с которыми вызвана регистрация.
+type fakeUserAuthenticator struct {
+	login string
+	email string
+}
+
+func (f *fakeUserAuthenticator) RegisterUser(_ context.Context, username, email, _ string) (string, error) {
+	f.login, f.email = username, email
+	return "user-1", nil
+}
+
+func (f *fakeUserAuthenticator) AuthenticateUser(_ context.Context, username, _ string) (string, error) {
+	f.login = username
+	return "user-1", nil
+}
+
+func TestNormalizeLogin(t *testing.T) {
+	const maxLength = 10
+
+	tests := []struct {
+		name    string
+		login   string
+		want    string
+		wantErr error
+	}{
+		{name: "plain", login: "alice", want: "alice"},
+		{name: "surrounding spaces", login: "  alice  ", want: "alice"},
+		{name: "tabs and newlines", login: "\talice\r\n", want: "alice"},
+		{name: "inner space kept", login: " al ice ", want: "al ice"},
+		{name: "empty", login: "", wantErr: errLoginEmpty},
+		{name: "only whitespace", login: " \t\n ", wantErr: errLoginEmpty},
+		{name: "max length", login: strings.Repeat("a", maxLength), want: strings.Repeat("a", maxLength)},
+		{name: "max length after trimming", login: " " + strings.Repeat("a", maxLength) + " ", want: strings.Repeat("a", maxLength)},
+		{name: "too long", login: strings.Repeat("a", maxLength+1), wantErr: errLoginTooLong},
+		{name: "length in characters", login: strings.Repeat("ж", maxLength), want: strings.Repeat("ж", maxLength)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := normalizeLogin(tt.login, maxLength)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("login = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestValidateRegisterRequestLogin(t *testing.T) {
+	const maxLoginLength = 50
+
+	tests := []struct {
+		name      string
+		login     string
+		wantError bool
+	}{
+		{name: "letters and digits", login: "Alice2024"},
+		{name: "allowed punctuation", login: "a.b_c@d-e"},
+		{name: "surrounding whitespace", login: "  alice\t"},
+		{name: "only whitespace", login: "   ", wantError: true},
+		{name: "inner space", login: "al ice", wantError: true},
+		{name: "cyrillic", login: "алиса", wantError: true},
+		{name: "null byte", login: "ali\x00ce", wantError: true},
+		{name: "control character", login: "ali\x1bce", wantError: true},
+		{name: "plus sign", login: "alice+1", wantError: true},
+		{name: "emoji", login: "alice🙂", wantError: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := models.APIRegisterRequest{Login: tt.login, Password: "password"}
+			fieldErrors := validateRegisterRequest(request, maxLoginLength)
+			if _, got := fieldErrors["login"]; got != tt.wantError {
+				t.Errorf("login error = %q, want error %v", fieldErrors["login"], tt.wantError)
+			}
+			if len(fieldErrors) > 1 || (len(fieldErrors) == 1 && !tt.wantError) {
+				t.Errorf("unexpected field errors %v", fieldErrors)
+			}
+		})
+	}
+}
+
+func TestValidateRegisterRequestLengths(t *testing.T) {
+	const maxLoginLength = 20
+
+	tests := []struct {
+		name      string
+		login     string
+		password  string
+		wantField string
+	}{
+		{name: "login below minimum", login: strings.Repeat("a", minLoginLength-1), password: "password", wantField: "login"},
+		{name: "login minimum", login: strings.Repeat("a", minLoginLength), password: "password"},
+		{name: "login below maximum", login: strings.Repeat("a", maxLoginLength-1), password: "password"},
+		{name: "login maximum", login: strings.Repeat("a", maxLoginLength), password: "password"},
+		{name: "login above maximum", login: strings.Repeat("a", maxLoginLength+1), password: "password", wantField: "login"},
+		{name: "password below minimum", login: "alice", password: strings.Repeat("p", minPasswordLength-1), wantField: "password"},
+		{name: "password minimum", login: "alice", password: strings.Repeat("p", minPasswordLength)},
+		{name: "password below maximum", login: "alice", password: strings.Repeat("p", maxPasswordLength-1)},
+		{name: "password maximum", login: "alice", password: strings.Repeat("p", maxPasswordLength)},
+		{name: "password above maximum", login: "alice", password: strings.Repeat("p", maxPasswordLength+1), wantField: "password"},
+		{name: "password length in bytes", login: "alice", password: strings.Repeat("ж", maxPasswordLength/2+1), wantField: "password"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			request := models.APIRegisterRequest{Login: tt.login, Password: tt.password}
+			fieldErrors := validateRegisterRequest(request, maxLoginLength)
+			if tt.wantField == "" {
+				if len(fieldErrors) != 0 {
+					t.Errorf("unexpected field errors %v", fieldErrors)
+				}
+				return
+			}
+			if _, ok := fieldErrors[tt.wantField]; !ok || len(fieldErrors) != 1 {
+				t.Errorf("field errors = %v, want only %s", fieldErrors, tt.wantField)
+			}
+		})
+	}
+}
+
+func TestRegisterUserNormalizesLogin(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantLogin  string
+		wantEmail  string
+	}{
+		{name: "trimmed", body: `{"login":"  alice ","password":"password"}`, wantStatus: http.StatusOK, wantLogin: "alice"},
+		{name: "trimmed email", body: `{"login":"alice","password":"password","email":" alice@example.com "}`, wantStatus: http.StatusOK, wantLogin: "alice", wantEmail: "alice@example.com"},
+		{name: "only whitespace", body: `{"login":"   ","password":"password"}`, wantStatus: http.StatusBadRequest},
+		{name: "empty", body: `{"login":"","password":"password"}`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := auth.SetKeys("register-test-key", nil); err != nil {
+				t.Fatal(err)
+			}
+			users := &fakeUserAuthenticator{}
+			req := httptest.NewRequest(http.MethodPost, "/api/v1/user/register", strings.NewReader(tt.body))
+			res := httptest.NewRecorder()
+			RegisterUser(users, 50, logger.NewNopLogger())(res, req)
+
+			if res.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body %s", res.Code, tt.wantStatus, res.Body)
+			}
+			if users.login != tt.wantLogin || users.email != tt.wantEmail {
+				t.Errorf("registered login, email = %q, %q; want %q, %q", users.login, users.email, tt.wantLogin, tt.wantEmail)
+			}
+		})
+	}
+}
+
+// TestHandlerLoggerFields проверяет, что записи обработчика несут поля компонента от
+// родительского логгера и имя обработчика.
+func TestHandlerLoggerFields(t *testing.T) {
+	core, recorded := observer.New(zapcore.DebugLevel)
+	httpLogger := logger.NewCoreLogger(core).With(zap.String("component", "http"))
+
+	req := newUserRequest(http.MethodPost, "/api/v1/user/orders", strings.NewReader("12345678900"), "user-1")
+	req.Header.Set("Content-Type", "text/plain")
+	res := httptest.NewRecorder()
+	AddOrder(&fakeOrderProcessor{}, httpLogger)(res, req)
+
+	if res.Code != http.StatusUnprocessableEntity {
+		t.Fatalf("status = %d, want %d", res.Code, http.StatusUnprocessableEntity)
+	}
+	entries := recorded.FilterMessage("request failed").AllUntimed()
+	if len(entries) != 1 {
+		t.Fatalf("got %d request failed entries, want 1", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	if fields["component"] != "http" || fields["handler"] != "addOrder" {
+		t.Errorf("fields = %v, want component=http and handler=addOrder", fields)
+	}
+	if _, ok := fields["error"]; !ok {
+		t.Errorf("fields = %v, want the error", fields)
+	}
+}
+
+func TestWithdrawBonusesSum(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		maxSum     float64
+		wantStatus int
+		wantCode   string
+	}{
+		{name: "positive", body: `{"order":"2377225624","sum":100}`, wantStatus: http.StatusOK},
+		{name: "fractional", body: `{"order":"2377225624","sum":0.01}`, wantStatus: http.StatusOK},
+		{name: "zero", body: `{"order":"2377225624","sum":0}`, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidWithdrawalSum},
+		{name: "negative zero", body: `{"order":"2377225624","sum":-0}`, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidWithdrawalSum},
+		{name: "negative", body: `{"order":"2377225624","sum":-100}`, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidWithdrawalSum},
+		{name: "missing sum", body: `{"order":"2377225624"}`, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidWithdrawalSum},
+		{name: "overflow", body: `{"order":"2377225624","sum":1e400}`, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidRequest},
+		{name: "below limit", body: `{"order":"2377225624","sum":999.99}`, maxSum: 1000, wantStatus: http.StatusOK},
+		{name: "at limit", body: `{"order":"2377225624","sum":1000}`, maxSum: 1000, wantStatus: http.StatusOK},
+		{name: "over limit", body: `{"order":"2377225624","sum":1000.01}`, maxSum: 1000, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidWithdrawalSum},
+		{name: "over limit with invalid order", body: `{"order":"12345","sum":5000}`, maxSum: 1000, wantStatus: http.StatusBadRequest, wantCode: errCodeInvalidWithdrawalSum},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := &fakeBonusesProcessor{}
+			req := newUserRequest(http.MethodPost, "/api/v1/user/balance/withdraw", strings.NewReader(tt.body), "user-1")
+			res := httptest.NewRecorder()
+			WithdrawBonuses(processor, tt.maxSum, logger.NewNopLogger())(res, req)
+
+			if res.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body %s", res.Code, tt.wantStatus, res.Body)
+			}
+			if tt.wantStatus == http.StatusOK {
+				if len(processor.withdrawn) != 1 {
+					t.Errorf("withdrawals = %+v, want one", processor.withdrawn)
+				}
+				return
+			}
+			if len(processor.withdrawn) != 0 {
+				t.Errorf("rejected request reached storage: %+v", processor.withdrawn)
+			}
+			if !strings.Contains(res.Body.String(), `"code":"`+tt.wantCode+`"`) {
+				t.Errorf("body = %s, want error code %s", res.Body, tt.wantCode)
+			}
+		})
+	}
+}
+
+// fakeWithdrawals отдает историю списаний из памяти или ошибку err.
+type fakeWithdrawals struct {
+	history []models.APIGetWithdrawalsHistoryResponse
+	err     error
+}
+
+func (f *fakeWithdrawals) GetWithdrawalsHistory(context.Context, string) ([]models.APIGetWithdrawalsHistoryResponse, error) {
+	return f.history, f.err
+}
+
+func TestGetWithdrawals(t *testing.T) {
+	tests := []struct {
+		name        string
+		withdrawals *fakeWithdrawals
+		wantStatus  int
+		wantBody    string
+	}{
+		{name: "nil history", withdrawals: &fakeWithdrawals{}, wantStatus: http.StatusNoContent},
+		{name: "empty history", withdrawals: &fakeWithdrawals{history: []models.APIGetWithdrawalsHistoryResponse{}}, wantStatus: http.StatusNoContent},
+		{name: "history", withdrawals: &fakeWithdrawals{history: []models.APIGetWithdrawalsHistoryResponse{
+			{Order: "2377225624", Sum: 500, ProcessedAt: time.Date(2020, 12, 9, 16, 9, 57, 0, time.UTC)},
+		}}, wantStatus: http.StatusOK, wantBody: `[{"order":"2377225624","sum":500,"processed_at":"2020-12-09T16:09:57Z"}]` + "\n"},
+		{name: "storage error", withdrawals: &fakeWithdrawals{err: errors.New("connection refused")}, wantStatus: http.StatusInternalServerError},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := newUserRequest(http.MethodGet, "/api/v1/user/withdrawals", nil, "user-1")
+			res := httptest.NewRecorder()
+			GetWithdrawals(tt.withdrawals, logger.NewNopLogger())(res, req)
+
+			if res.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", res.Code, tt.wantStatus)
+			}
+			if tt.wantStatus == http.StatusNoContent && res.Body.Len() != 0 {
+				t.Errorf("204 response has a body: %s", res.Body)
+			}
+			if tt.wantBody != "" && res.Body.String() != tt.wantBody {
+				t.Errorf("body = %s, want %s", res.Body, tt.wantBody)
+			}
+		})
+	}
+}
+
+func TestGetOrdersListEmpty(t *testing.T) {
+	for name, orders := range map[string][]models.APIGetOrderResponse{"nil": nil, "empty": {}} {
+		t.Run(name, func(t *testing.T) {
+			processor := &fakeOrderProcessor{orders: orders, version: "1001"}
+			req := newUserRequest(http.MethodGet, "/api/v1/user/orders", nil, "user-1")
+			res := httptest.NewRecorder()
+			GetOrdersList(processor, logger.NewNopLogger())(res, req)
+
+			if res.Code != http.StatusNoContent {
+				t.Fatalf("status = %d, want %d", res.Code, http.StatusNoContent)
+			}
+			if res.Body.Len() != 0 {
+				t.Errorf("204 response has a body: %s", res.Body)
+			}
+		})
+	}
+}
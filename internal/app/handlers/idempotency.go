@@ -0,0 +1,159 @@
+package handlers
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"github.com/vancho-go/gophermart/internal/app/storage"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"time"
+)
+
+const (
+	IdempotencyKeyHeader = "X-Idempotency-Key"
+	// legacyIdempotencyKeyHeader поддерживается для клиентов, использующих прежнее имя заголовка
+	legacyIdempotencyKeyHeader = "Idempotency-Key"
+
+	maxIdempotencyKeyLength = 255
+	idempotencySaveTimeout  = time.Second * 5
+)
+
+type IdempotencyStore interface {
+	ReserveIdempotencyKey(ctx context.Context, userID, key, requestHash string) (response *models.IdempotentResponse, err error)
+	SaveIdempotentResponse(ctx context.Context, userID, key string, response models.IdempotentResponse) (err error)
+	ReleaseIdempotencyKey(ctx context.Context, userID, key string) (err error)
+}
+
+// skippedReplayHeaders — заголовки ответа, которые не повторяются при воспроизведении: они
+// относятся к исходному ответу, а не к результату операции.
+var skippedReplayHeaders = map[string]bool{"Date": true, "Set-Cookie": true}
+
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+		r.header = r.ResponseWriter.Header().Clone()
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.WriteHeader(http.StatusOK)
+	}
+	r.body.Write(b)
+	return r.ResponseWriter.Write(b)
+}
+
+func (r *responseRecorder) Unwrap() http.ResponseWriter {
+	return r.ResponseWriter
+}
+
+// requestHash связывает ключ идемпотентности с запросом: повтор ключа с другим методом, путем
+// или телом не должен получить чужой сохраненный ответ.
+func requestHash(req *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(req.Method + "\n" + req.URL.Path + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// Idempotent повторно возвращает сохраненный ответ с его заголовками, если запрос с тем же
+// X-Idempotency-Key уже был выполнен этим пользователем. Ключ, повторно использованный для
+// запроса с другим методом, путем или телом, отклоняется с 422. Запросы без заголовка
+// обрабатываются как обычно.
+func Idempotent(is IdempotencyStore, logger logger.Logger) func(http.Handler) http.Handler {
+	logger = logger.With(zap.String("handler", "idempotent"))
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			key := req.Header.Get(IdempotencyKeyHeader)
+			if key == "" {
+				key = req.Header.Get(legacyIdempotencyKeyHeader)
+			}
+			if key == "" {
+				next.ServeHTTP(res, req)
+				return
+			}
+			if len(key) > maxIdempotencyKeyLength {
+				logger.Debug("idempotency key is too long")
+				writeJSONError(res, http.StatusBadRequest, errCodeInvalidIdempotencyKey, "Invalid idempotency key")
+				return
+			}
+
+			userID, ok := getUserIDFromContext(req.Context())
+			if !ok {
+				logger.Debug("unauthorized")
+				writeJSONError(res, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+				return
+			}
+
+			body, err := io.ReadAll(req.Body)
+			req.Body.Close()
+			if err != nil {
+				logger.Debug("request failed", zap.Error(err))
+				writeJSONError(res, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request format")
+				return
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body))
+
+			stored, err := is.ReserveIdempotencyKey(req.Context(), userID, key, requestHash(req, body))
+			if errors.Is(err, storage.ErrIdempotencyKeyInProgress) {
+				logger.Debug("request failed", zap.Error(err))
+				writeJSONError(res, http.StatusConflict, errCodeIdempotencyKeyInProgress, "Request with this idempotency key is in progress")
+				return
+			} else if errors.Is(err, storage.ErrIdempotencyKeyReused) {
+				logger.Debug("request failed", zap.Error(err))
+				writeJSONError(res, http.StatusUnprocessableEntity, errCodeIdempotencyKeyReused, "Idempotency key was used for a different request")
+				return
+			} else if err != nil {
+				logger.Error("request failed", zap.Error(err))
+				writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+				return
+			}
+			if stored != nil {
+				for name, values := range stored.Header {
+					if !skippedReplayHeaders[name] {
+						res.Header()[name] = values
+					}
+				}
+				res.Header().Set("Idempotent-Replayed", "true")
+				res.WriteHeader(stored.Status)
+				res.Write(stored.Body)
+				return
+			}
+
+			recorder := &responseRecorder{ResponseWriter: res}
+			next.ServeHTTP(recorder, req)
+			if recorder.status == 0 {
+				recorder.status = http.StatusOK
+			}
+
+			// клиент мог уже отключиться, поэтому контекст запроса не используется
+			ctx, cancel := context.WithTimeout(context.Background(), idempotencySaveTimeout)
+			defer cancel()
+			if recorder.status >= http.StatusInternalServerError {
+				if err = is.ReleaseIdempotencyKey(ctx, userID, key); err != nil {
+					logger.Error("request failed", zap.Error(err))
+				}
+				return
+			}
+			response := models.IdempotentResponse{Status: recorder.status, Header: recorder.header, Body: recorder.body.Bytes()}
+			if err = is.SaveIdempotentResponse(ctx, userID, key, response); err != nil {
+				logger.Error("request failed", zap.Error(err))
+			}
+		})
+	}
+}
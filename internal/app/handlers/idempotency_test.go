@@ -0,0 +1,123 @@
+package handlers
+
+import (
+	"context"
+	"fmt"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"github.com/vancho-go/gophermart/internal/app/storage"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type idempotencyEntry struct {
+	hash     string
+	response *models.IdempotentResponse
+}
+
+// fakeIdempotencyStore повторяет семантику storage.Storage для ключей идемпотентности в памяти.
+type fakeIdempotencyStore struct {
+	mu      sync.Mutex
+	entries map[string]*idempotencyEntry
+}
+
+func newFakeIdempotencyStore() *fakeIdempotencyStore {
+	return &fakeIdempotencyStore{entries: make(map[string]*idempotencyEntry)}
+}
+
+func (f *fakeIdempotencyStore) ReserveIdempotencyKey(_ context.Context, userID, key, requestHash string) (*models.IdempotentResponse, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	entry, ok := f.entries[userID+"/"+key]
+	switch {
+	case !ok:
+		f.entries[userID+"/"+key] = &idempotencyEntry{hash: requestHash}
+		return nil, nil
+	case entry.hash != requestHash:
+		return nil, storage.ErrIdempotencyKeyReused
+	case entry.response == nil:
+		return nil, storage.ErrIdempotencyKeyInProgress
+	}
+	return entry.response, nil
+}
+
+func (f *fakeIdempotencyStore) SaveIdempotentResponse(_ context.Context, userID, key string, response models.IdempotentResponse) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.entries[userID+"/"+key].response = &response
+	return nil
+}
+
+func (f *fakeIdempotencyStore) ReleaseIdempotencyKey(_ context.Context, userID, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	delete(f.entries, userID+"/"+key)
+	return nil
+}
+
+func TestIdempotent(t *testing.T) {
+	store := newFakeIdempotencyStore()
+	calls := 0
+	failNext := false
+	next := http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		calls++
+		body, _ := io.ReadAll(req.Body)
+		if failNext {
+			failNext = false
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+		res.Header().Set("Content-Type", "application/json")
+		fmt.Fprintf(res, `{"call":%d,"body":%q}`, calls, body)
+	})
+	handler := Idempotent(store, logger.NewNopLogger())(next)
+
+	do := func(key, body string) *httptest.ResponseRecorder {
+		req := newUserRequest(http.MethodPost, "/api/v1/user/balance/withdraw", strings.NewReader(body), "user-1")
+		req.Header.Set(IdempotencyKeyHeader, key)
+		res := httptest.NewRecorder()
+		handler.ServeHTTP(res, req)
+		return res
+	}
+
+	first := do("key-1", `{"order":"2377225624","sum":100}`)
+	if first.Code != http.StatusOK || calls != 1 {
+		t.Fatalf("first request: status %d, calls %d", first.Code, calls)
+	}
+
+	replay := do("key-1", `{"order":"2377225624","sum":100}`)
+	if calls != 1 {
+		t.Fatalf("replayed request reached the handler")
+	}
+	if replay.Code != first.Code || replay.Body.String() != first.Body.String() {
+		t.Errorf("replay = %d %s, want %d %s", replay.Code, replay.Body, first.Code, first.Body)
+	}
+	if replay.Header().Get("Content-Type") != "application/json" {
+		t.Errorf("replay Content-Type = %q, want application/json", replay.Header().Get("Content-Type"))
+	}
+	if replay.Header().Get("Idempotent-Replayed") != "true" {
+		t.Error("replay is not marked with Idempotent-Replayed")
+	}
+
+	reused := do("key-1", `{"order":"2377225624","sum":500}`)
+	if reused.Code != http.StatusUnprocessableEntity || calls != 1 {
+		t.Errorf("key reused with another body: status %d, calls %d; want %d, 1", reused.Code, calls, http.StatusUnprocessableEntity)
+	}
+
+	failNext = true
+	if failed := do("key-2", `{"order":"2377225624","sum":1}`); failed.Code != http.StatusInternalServerError {
+		t.Fatalf("failing request status = %d", failed.Code)
+	}
+	if retried := do("key-2", `{"order":"2377225624","sum":1}`); retried.Code != http.StatusOK || calls != 3 {
+		t.Errorf("retry after 5xx: status %d, calls %d; want %d, 3", retried.Code, calls, http.StatusOK)
+	}
+
+	if plain := do("", `{}`); plain.Code != http.StatusOK || calls != 4 {
+		t.Errorf("request without key: status %d, calls %d; want %d, 4", plain.Code, calls, http.StatusOK)
+	}
+}
@@ -0,0 +1,78 @@
+package handlers
+
+import (
+	"errors"
+	"fmt"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"net/mail"
+	"regexp"
+	"strings"
+	"unicode/utf8"
+)
+
+const (
+	minLoginLength    = 3
+	minPasswordLength = 8
+	// Argon2id не обрезает пароль, верхний предел ограничивает данные, которые хешируются при
+	// каждом входе. 72 байта оставлены от bcrypt, чтобы не менять контракт API
+	maxPasswordLength = 72
+)
+
+var loginPattern = regexp.MustCompile(`^[a-zA-Z0-9._@-]+$`)
+
+var (
+	errLoginEmpty   = errors.New("login is empty")
+	errLoginTooLong = errors.New("login is too long")
+	errEmailInvalid = errors.New("email is invalid")
+)
+
+// normalizeLogin убирает пробельные символы по краям логина, чтобы " alice" и "alice"
+// считались одним пользователем, и проверяет длину результата.
+func normalizeLogin(login string, maxLength int) (string, error) {
+	login = strings.TrimSpace(login)
+	if login == "" {
+		return "", errLoginEmpty
+	}
+	if utf8.RuneCountInString(login) > maxLength {
+		return "", errLoginTooLong
+	}
+	return login, nil
+}
+
+// normalizeEmail проверяет, что email — голый адрес без отображаемого имени. Пустой email допустим.
+func normalizeEmail(email string, maxLength int) (string, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return "", nil
+	}
+	if utf8.RuneCountInString(email) > maxLength {
+		return "", errEmailInvalid
+	}
+	address, err := mail.ParseAddress(email)
+	if err != nil || address.Address != email {
+		return "", errEmailInvalid
+	}
+	return email, nil
+}
+
+// validateRegisterRequest проверяет поля запроса на регистрацию и возвращает ошибки по именам
+// полей. Пустой результат означает, что запрос корректен.
+func validateRegisterRequest(req models.APIRegisterRequest, maxLoginLength int) map[string]string {
+	fieldErrors := map[string]string{}
+
+	login := strings.TrimSpace(req.Login)
+	if length := utf8.RuneCountInString(login); length < minLoginLength || length > maxLoginLength {
+		fieldErrors["login"] = fmt.Sprintf("must be %d to %d characters long", minLoginLength, maxLoginLength)
+	} else if !loginPattern.MatchString(login) {
+		fieldErrors["login"] = "may contain only latin letters, digits and . _ @ -"
+	}
+
+	if len(req.Password) < minPasswordLength || len(req.Password) > maxPasswordLength {
+		fieldErrors["password"] = fmt.Sprintf("must be %d to %d bytes long", minPasswordLength, maxPasswordLength)
+	}
+
+	if _, err := normalizeEmail(req.Email, maxLoginLength); err != nil {
+		fieldErrors["email"] = "must be a valid address"
+	}
+	return fieldErrors
+}
@@ -0,0 +1,52 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"github.com/go-chi/chi/v5"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"github.com/vancho-go/gophermart/internal/app/storage"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+type OrderEventsProvider interface {
+	GetOrderEvents(ctx context.Context, userID, orderID string) (orderEvents []models.APIOrderEvent, err error)
+}
+
+// GetOrderStatusEvents отдает журнал смены статусов заказа пользователя.
+func GetOrderStatusEvents(oep OrderEventsProvider, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "getOrderStatusEvents"))
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		ctx, span := tracer.Start(req.Context(), "handler.GetOrderStatusEvents")
+		defer span.End()
+
+		userID, ok := getUserIDFromContext(ctx)
+		if !ok {
+			logger.Debug("unauthorized")
+			writeJSONError(res, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+			return
+		}
+
+		orderEvents, err := oep.GetOrderEvents(ctx, userID, chi.URLParam(req, "orderID"))
+		if errors.Is(err, storage.ErrOrderNotFound) {
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusNotFound, errCodeOrderNotFound, "Order not found")
+			return
+		} else if err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(res).Encode(orderEvents); err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+	}
+}
@@ -2,18 +2,30 @@ package handlers
 
 import (
 	"errors"
+	"fmt"
 	"strconv"
 	"strings"
 )
 
-// isOrderNumberValid проверяет номер заказа с использованием алгоритма Луна.
-// Возвращает true если номер валидный, иначе false и соответствующую ошибку.
+// Допустимая длина номера заказа: номера банковских карт не длиннее 19 цифр,
+// а для контрольной цифры Луна нужна хотя бы одна значащая.
+const (
+	minOrderNumberLength = 2
+	maxOrderNumberLength = 19
+)
+
+// isOrderNumberValid проверяет длину номера заказа и его контрольную цифру по алгоритму Луна.
+// Возвращает nil, если номер валидный, иначе ошибку с причиной.
 func isOrderNumberValid(orderNumber string) error {
 	// Удаляем все пробелы для чистоты ввода
 	cleanOrderNumber := strings.ReplaceAll(orderNumber, " ", "")
 	if cleanOrderNumber == "" {
 		return errors.New("isOrderNumberValid: order number is empty")
 	}
+	if len(cleanOrderNumber) < minOrderNumberLength || len(cleanOrderNumber) > maxOrderNumberLength {
+		return fmt.Errorf("isOrderNumberValid: order number must be %d to %d digits long, got %d",
+			minOrderNumberLength, maxOrderNumberLength, len(cleanOrderNumber))
+	}
 
 	// Алгоритм Луна:
 	sum := 0
@@ -36,7 +48,7 @@ func isOrderNumberValid(orderNumber string) error {
 	}
 
 	if sum%10 != 0 {
-		return errors.New("isOrderNumberValid: order number contains invalid characters")
+		return errors.New("isOrderNumberValid: order number checksum mismatch")
 	}
 	// Если сумма кратна 10, номер валидный
 	return nil
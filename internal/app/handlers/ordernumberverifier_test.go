@@ -0,0 +1,96 @@
+package handlers
+
+import (
+	"strings"
+	"testing"
+)
+
+// luhnTestNumber возвращает номер длины length из единиц с верной контрольной цифрой.
+func luhnTestNumber(length int) string {
+	payload := strings.Repeat("1", length-1)
+	sum := 0
+	for i := len(payload) - 1; i >= 0; i-- {
+		n := int(payload[i] - '0')
+		// контрольная цифра допишется справа, поэтому удваиваются цифры на четных местах с конца payload
+		if (len(payload)-i)%2 == 1 {
+			n *= 2
+			if n > 9 {
+				n -= 9
+			}
+		}
+		sum += n
+	}
+	return payload + string(rune('0'+(10-sum%10)%10))
+}
+
+func TestIsOrderNumberValidLength(t *testing.T) {
+	tests := []struct {
+		name    string
+		length  int
+		wantErr bool
+	}{
+		{name: "below minimum", length: minOrderNumberLength - 1, wantErr: true},
+		{name: "minimum", length: minOrderNumberLength},
+		{name: "above minimum", length: minOrderNumberLength + 1},
+		{name: "below maximum", length: maxOrderNumberLength - 1},
+		{name: "maximum", length: maxOrderNumberLength},
+		{name: "above maximum", length: maxOrderNumberLength + 1, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			orderNumber := luhnTestNumber(tt.length)
+			err := isOrderNumberValid(orderNumber)
+			if tt.wantErr {
+				if err == nil || !strings.Contains(err.Error(), "digits long") {
+					t.Errorf("order number %s: error = %v, want a length error", orderNumber, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Errorf("order number %s: unexpected error: %v", orderNumber, err)
+			}
+		})
+	}
+
+	// пробелы не учитываются в длине
+	spaced := luhnTestNumber(maxOrderNumberLength)
+	if err := isOrderNumberValid(spaced[:4] + " " + spaced[4:]); err != nil {
+		t.Errorf("maximum length with a space: unexpected error: %v", err)
+	}
+}
+
+// FuzzIsOrderNumberValid проверяет, что валидный номер заказа состоит только из цифр (пробелы
+// отбрасываются) и его длина укладывается в minOrderNumberLength..maxOrderNumberLength.
+func FuzzIsOrderNumberValid(f *testing.F) {
+	for _, seed := range []string{
+		"12345678903",
+		"1234 5678 903",
+		"",
+		" ",
+		"0",
+		"18",
+		"4561261212345467",
+		"123456789012345678",
+		"1234567890123456782",
+		"12345678901234567893",
+		"00000000000000000000000000000000",
+		"1234567890a",
+		"-18",
+		"١٢",
+	} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, orderNumber string) {
+		if err := isOrderNumberValid(orderNumber); err != nil {
+			return
+		}
+		clean := strings.ReplaceAll(orderNumber, " ", "")
+		if len(clean) < minOrderNumberLength || len(clean) > maxOrderNumberLength {
+			t.Errorf("order number %q of length %d accepted", orderNumber, len(clean))
+		}
+		if strings.Trim(clean, "0123456789") != "" {
+			t.Errorf("order number %q with non-digits accepted", orderNumber)
+		}
+	})
+}
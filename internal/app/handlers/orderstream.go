@@ -0,0 +1,86 @@
+package handlers
+
+import (
+	"errors"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"go.uber.org/zap"
+	"golang.org/x/net/websocket"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+// checkSameOrigin отклоняет WebSocket-рукопожатие со страниц чужих сайтов: авторизация идет
+// по cookie, и без проверки Origin любая страница могла бы читать заказы пользователя.
+// Клиенты без браузера заголовок Origin обычно не передают.
+func checkSameOrigin(config *websocket.Config, req *http.Request) error {
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	originURL, err := url.Parse(origin)
+	if err != nil {
+		return err
+	}
+	if originURL.Host != req.Host {
+		return errors.New("cross-origin websocket connection")
+	}
+	config.Origin = originURL
+	return nil
+}
+
+// StreamOrders отдает изменения статусов заказов пользователя через WebSocket, каждое
+// сообщение — JSON APIOrderStreamMessage.
+func StreamOrders(es OrderEventsSubscriber, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "streamOrders"))
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		userID, ok := getUserIDFromContext(req.Context())
+		if !ok {
+			logger.Debug("unauthorized")
+			writeJSONError(res, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+			return
+		}
+
+		server := websocket.Server{
+			Handshake: checkSameOrigin,
+			Handler: func(ws *websocket.Conn) {
+				// соединение живет дольше ReadTimeout и WriteTimeout сервера
+				if err := ws.SetDeadline(time.Time{}); err != nil {
+					logger.Debug("request failed", zap.Error(err))
+				}
+
+				orderEvents, unsubscribe := es.Subscribe(userID)
+				defer unsubscribe()
+
+				// сообщения клиента не ожидаются, чтение нужно только чтобы заметить закрытие соединения
+				closed := make(chan struct{})
+				go func() {
+					defer close(closed)
+					var message []byte
+					for websocket.Message.Receive(ws, &message) == nil {
+					}
+				}()
+
+				for {
+					select {
+					case <-closed:
+						logger.Debug("client disconnected")
+						return
+					case event, ok := <-orderEvents:
+						if !ok {
+							return
+						}
+						message := models.APIOrderStreamMessage{OrderID: event.Number, Status: event.Status, Accrual: event.Accrual}
+						if err := websocket.JSON.Send(ws, message); err != nil {
+							logger.Debug("request failed", zap.Error(err))
+							return
+						}
+					}
+				}
+			},
+		}
+		server.ServeHTTP(res, req)
+	}
+}
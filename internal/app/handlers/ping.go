@@ -0,0 +1,32 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+type HealthReporter interface {
+	Health() models.DBHealth
+}
+
+func Ping(hr HealthReporter, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "ping"))
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		response := models.APIPingResponse{Status: "ok", Database: hr.Health()}
+		status := http.StatusOK
+		if !response.Database.Healthy {
+			response.Status = "unavailable"
+			status = http.StatusServiceUnavailable
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		res.WriteHeader(status)
+		if err := json.NewEncoder(res).Encode(response); err != nil {
+			logger.Error("request failed", zap.Error(err))
+		}
+	}
+}
@@ -0,0 +1,24 @@
+package handlers
+
+import (
+	"net/http"
+	"strings"
+)
+
+// RedirectPrefix перенаправляет запросы со старого префикса на новый, сохраняя остаток пути и query.
+// GET и HEAD перенаправляются с 301, остальные методы — с 308, чтобы клиенты не превращали
+// POST в GET и повторили запрос с тем же телом.
+func RedirectPrefix(fromPrefix, toPrefix string) http.HandlerFunc {
+	return func(res http.ResponseWriter, req *http.Request) {
+		target := toPrefix + strings.TrimPrefix(req.URL.Path, fromPrefix)
+		if req.URL.RawQuery != "" {
+			target += "?" + req.URL.RawQuery
+		}
+
+		status := http.StatusMovedPermanently
+		if req.Method != http.MethodGet && req.Method != http.MethodHead {
+			status = http.StatusPermanentRedirect
+		}
+		http.Redirect(res, req, target, status)
+	}
+}
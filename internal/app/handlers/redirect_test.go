@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRedirectPrefix(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		target       string
+		wantStatus   int
+		wantLocation string
+	}{
+		{name: "get", method: http.MethodGet, target: "/api/user/orders", wantStatus: http.StatusMovedPermanently, wantLocation: "/api/v1/user/orders"},
+		{name: "head", method: http.MethodHead, target: "/api/user/orders", wantStatus: http.StatusMovedPermanently, wantLocation: "/api/v1/user/orders"},
+		{name: "query", method: http.MethodGet, target: "/api/user/orders?status=NEW&limit=10", wantStatus: http.StatusMovedPermanently, wantLocation: "/api/v1/user/orders?status=NEW&limit=10"},
+		{name: "post", method: http.MethodPost, target: "/api/user/orders", wantStatus: http.StatusPermanentRedirect, wantLocation: "/api/v1/user/orders"},
+		{name: "delete", method: http.MethodDelete, target: "/api/user/account", wantStatus: http.StatusPermanentRedirect, wantLocation: "/api/v1/user/account"},
+		{name: "prefix only", method: http.MethodGet, target: "/api/user", wantStatus: http.StatusMovedPermanently, wantLocation: "/api/v1/user"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			res := httptest.NewRecorder()
+			RedirectPrefix("/api/user", "/api/v1/user")(res, httptest.NewRequest(tt.method, tt.target, nil))
+
+			if res.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", res.Code, tt.wantStatus)
+			}
+			if location := res.Header().Get("Location"); location != tt.wantLocation {
+				t.Errorf("Location = %q, want %q", location, tt.wantLocation)
+			}
+		})
+	}
+}
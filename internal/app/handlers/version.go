@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"encoding/json"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+// Version отдает сведения о сборке, заданные через -ldflags при компоновке.
+func Version(info models.APIVersionResponse, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "version"))
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		res.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(res).Encode(info); err != nil {
+			logger.Error("request failed", zap.Error(err))
+		}
+	}
+}
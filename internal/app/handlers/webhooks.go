@@ -0,0 +1,145 @@
+package handlers
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/go-chi/chi/v5"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"github.com/vancho-go/gophermart/internal/app/storage"
+	"github.com/vancho-go/gophermart/internal/app/webhooks"
+	"go.uber.org/zap"
+	"net/http"
+	"strconv"
+)
+
+// minWebhookSecretLength — минимальная длина ключа подписи, заданного пользователем.
+const minWebhookSecretLength = 16
+
+type WebhookProcessor interface {
+	SetWebhook(ctx context.Context, userID, url, secret string) (webhookID int64, err error)
+	GetWebhookDeliveries(ctx context.Context, userID string, webhookID int64, limit, offset int) (deliveries []models.WebhookDelivery, err error)
+}
+
+// SetWebhook регистрирует адрес уведомлений о переходе заказов пользователя в конечный статус.
+// Если ключ подписи не передан, он генерируется и возвращается в ответе.
+func SetWebhook(wp WebhookProcessor, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "setWebhook"))
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		ctx, span := tracer.Start(req.Context(), "handler.SetWebhook")
+		defer span.End()
+
+		userID, ok := getUserIDFromContext(ctx)
+		if !ok {
+			logger.Debug("unauthorized")
+			writeJSONError(res, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+			return
+		}
+
+		var request models.APIWebhookRequest
+		decoder := json.NewDecoder(req.Body)
+		if err := decoder.Decode(&request); err != nil {
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidRequest, "Invalid request format")
+			return
+		}
+		defer req.Body.Close()
+
+		webhookURL, err := webhooks.ParseURL(request.URL)
+		if errors.Is(err, webhooks.ErrForbiddenAddress) {
+			logger.Debug("forbidden url", zap.String("url", request.URL))
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidWebhookURL, "Webhook url points to a forbidden address")
+			return
+		} else if err != nil {
+			logger.Debug("invalid url", zap.String("url", request.URL))
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidWebhookURL, "Invalid webhook url")
+			return
+		}
+
+		secret := request.Secret
+		if secret == "" {
+			if secret, err = webhooks.GenerateSecret(); err != nil {
+				logger.Error("request failed", zap.Error(err))
+				writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+				return
+			}
+		} else if len(secret) < minWebhookSecretLength {
+			writeJSONValidationErrors(res, map[string]string{
+				"secret": fmt.Sprintf("must be at least %d bytes", minWebhookSecretLength),
+			})
+			return
+		}
+
+		webhookID, err := wp.SetWebhook(ctx, userID, webhookURL.String(), secret)
+		if err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		response := models.APIWebhookResponse{ID: webhookID, URL: webhookURL.String(), Secret: secret}
+		if err := json.NewEncoder(res).Encode(response); err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+	}
+}
+
+// GetWebhookDeliveries отдает попытки доставки уведомлений на webhook {webhookID} пользователя,
+// начиная с последних.
+func GetWebhookDeliveries(wp WebhookProcessor, logger logger.Logger) http.HandlerFunc {
+	logger = logger.With(zap.String("handler", "getWebhookDeliveries"))
+
+	return func(res http.ResponseWriter, req *http.Request) {
+		ctx, span := tracer.Start(req.Context(), "handler.GetWebhookDeliveries")
+		defer span.End()
+
+		userID, ok := getUserIDFromContext(ctx)
+		if !ok {
+			logger.Debug("unauthorized")
+			writeJSONError(res, http.StatusUnauthorized, errCodeUnauthorized, "Unauthorized")
+			return
+		}
+
+		webhookID, err := strconv.ParseInt(chi.URLParam(req, "webhookID"), 10, 64)
+		if err != nil {
+			writeJSONError(res, http.StatusNotFound, errCodeWebhookNotFound, "Webhook not found")
+			return
+		}
+
+		query := req.URL.Query()
+		limit, err := parsePageParam(query.Get("limit"), defaultPageLimit)
+		if err != nil || limit == 0 || limit > maxPageLimit {
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidRequest, "limit must be between 1 and "+strconv.Itoa(maxPageLimit))
+			return
+		}
+		offset, err := parsePageParam(query.Get("offset"), 0)
+		if err != nil {
+			writeJSONError(res, http.StatusBadRequest, errCodeInvalidRequest, "offset must be a non-negative integer")
+			return
+		}
+
+		deliveries, err := wp.GetWebhookDeliveries(ctx, userID, webhookID, limit, offset)
+		if errors.Is(err, storage.ErrWebhookNotFound) {
+			logger.Debug("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusNotFound, errCodeWebhookNotFound, "Webhook not found")
+			return
+		} else if err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+
+		res.Header().Set("Content-Type", "application/json")
+		if err := json.NewEncoder(res).Encode(deliveries); err != nil {
+			logger.Error("request failed", zap.Error(err))
+			writeJSONError(res, http.StatusInternalServerError, errCodeInternal, "Internal error")
+			return
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package handlers
+
+import (
+	"context"
+	"github.com/vancho-go/gophermart/internal/app/auth"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// newUserRequest создает запрос аутентифицированного пользователя userID, как после auth.Middleware.
+func newUserRequest(method, target string, body io.Reader, userID string) *http.Request {
+	req := httptest.NewRequest(method, target, body)
+	return req.WithContext(context.WithValue(req.Context(), auth.UserIDContextKey, userID))
+}
+
+type fakeWebhookProcessor struct {
+	url    string
+	secret string
+}
+
+func (f *fakeWebhookProcessor) SetWebhook(_ context.Context, _, url, secret string) (int64, error) {
+	f.url, f.secret = url, secret
+	return 1, nil
+}
+
+func (f *fakeWebhookProcessor) GetWebhookDeliveries(context.Context, string, int64, int, int) ([]models.WebhookDelivery, error) {
+	return []models.WebhookDelivery{}, nil
+}
+
+func TestSetWebhook(t *testing.T) {
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantSaved  bool
+	}{
+		{name: "public url", body: `{"url":"https://example.com/hook"}`, wantStatus: http.StatusOK, wantSaved: true},
+		{name: "own secret", body: `{"url":"https://example.com/hook","secret":"0123456789abcdef"}`, wantStatus: http.StatusOK, wantSaved: true},
+		{name: "short secret", body: `{"url":"https://example.com/hook","secret":"short"}`, wantStatus: http.StatusBadRequest},
+		{name: "not http", body: `{"url":"file:///etc/passwd"}`, wantStatus: http.StatusBadRequest},
+		{name: "loopback", body: `{"url":"http://127.0.0.1:8080/hook"}`, wantStatus: http.StatusBadRequest},
+		{name: "localhost", body: `{"url":"http://localhost/hook"}`, wantStatus: http.StatusBadRequest},
+		{name: "cloud metadata", body: `{"url":"http://169.254.169.254/latest/meta-data"}`, wantStatus: http.StatusBadRequest},
+		{name: "private network", body: `{"url":"http://10.1.2.3/hook"}`, wantStatus: http.StatusBadRequest},
+		{name: "malformed json", body: `{"url":`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			processor := &fakeWebhookProcessor{}
+			res := httptest.NewRecorder()
+			SetWebhook(processor, logger.NewNopLogger())(res,
+				newUserRequest(http.MethodPost, "/api/v1/user/webhooks", strings.NewReader(tt.body), "user-1"))
+
+			if res.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", res.Code, tt.wantStatus, res.Body)
+			}
+			if saved := processor.url != ""; saved != tt.wantSaved {
+				t.Fatalf("webhook saved = %v, want %v", saved, tt.wantSaved)
+			}
+			if tt.wantSaved && len(processor.secret) < minWebhookSecretLength {
+				t.Errorf("saved secret %q is shorter than %d bytes", processor.secret, minWebhookSecretLength)
+			}
+		})
+	}
+}
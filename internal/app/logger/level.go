@@ -0,0 +1,70 @@
+package logger
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"go.uber.org/zap/zapcore"
+	"io"
+	"net/http"
+	"net/url"
+)
+
+// maxLevelRequestSize ограничивает тело запроса на смену уровня, ожидается {"level":"debug"}.
+const maxLevelRequestSize = 1024
+
+// LevelHandler возвращает обработчик zap.AtomicLevel для чтения (GET) и смены (PUT) уровня
+// логирования без перезапуска. Уровни ниже floor отклоняются с 403.
+func LevelHandler(l Logger, floor string) (http.Handler, error) {
+	zapLogger, ok := l.(*ZapLogger)
+	if !ok {
+		return nil, errors.New("levelHandler: logger does not support level changes")
+	}
+	floorLevel, err := zapcore.ParseLevel(floor)
+	if err != nil {
+		return nil, fmt.Errorf("levelHandler: invalid level floor: %w", err)
+	}
+
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if req.Method == http.MethodPut {
+			body, err := io.ReadAll(io.LimitReader(req.Body, maxLevelRequestSize))
+			if err != nil {
+				http.Error(res, "Invalid request format", http.StatusBadRequest)
+				return
+			}
+			level, err := requestedLevel(req.Header.Get("Content-Type"), body)
+			if err != nil {
+				http.Error(res, err.Error(), http.StatusBadRequest)
+				return
+			}
+			if level < floorLevel {
+				http.Error(res, fmt.Sprintf("level %s is below the allowed floor %s", level, floorLevel), http.StatusForbidden)
+				return
+			}
+			req.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		zapLogger.level.ServeHTTP(res, req)
+	}), nil
+}
+
+// requestedLevel разбирает тело запроса так же, как zap.AtomicLevel: JSON или form-urlencoded.
+func requestedLevel(contentType string, body []byte) (zapcore.Level, error) {
+	var value string
+	if contentType == "application/x-www-form-urlencoded" {
+		form, err := url.ParseQuery(string(body))
+		if err != nil {
+			return 0, fmt.Errorf("invalid form: %w", err)
+		}
+		value = form.Get("level")
+	} else {
+		var request struct {
+			Level string `json:"level"`
+		}
+		if err := json.Unmarshal(body, &request); err != nil {
+			return 0, fmt.Errorf("invalid json: %w", err)
+		}
+		value = request.Level
+	}
+	return zapcore.ParseLevel(value)
+}
@@ -0,0 +1,91 @@
+package logger
+
+import (
+	"go.uber.org/zap"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// setLevel отправляет в handler запрос на смену уровня и возвращает ответ.
+func setLevel(handler http.Handler, contentType, body string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodPut, "/debug/loglevel", strings.NewReader(body))
+	req.Header.Set("Content-Type", contentType)
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, req)
+	return res
+}
+
+// TestLevelHandlerEnablesDebug проверяет, что после PUT {"level":"debug"} записи уровня debug
+// появляются и в логгере, и в уже созданных дочерних логгерах.
+func TestLevelHandlerEnablesDebug(t *testing.T) {
+	l, output := newBufferLogger(t, "info", FormatJSON)
+	child := l.With(zap.String("component", "http"))
+	handler, err := LevelHandler(l, "debug")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	child.Debug("before the change")
+	if output.Len() != 0 {
+		t.Fatalf("debug line written at info level: %s", output)
+	}
+
+	if res := setLevel(handler, "application/json", `{"level":"debug"}`); res.Code != http.StatusOK {
+		t.Fatalf("status = %d, want %d; body %s", res.Code, http.StatusOK, res.Body)
+	}
+	l.Debug("root after the change")
+	child.Debug("child after the change")
+
+	entries := decodeLines(t, output)
+	if len(entries) != 2 || entries[0]["msg"] != "root after the change" || entries[1]["msg"] != "child after the change" {
+		t.Fatalf("entries = %v, want both debug lines after the change", entries)
+	}
+
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest(http.MethodGet, "/debug/loglevel", nil))
+	if !strings.Contains(res.Body.String(), `"level":"debug"`) {
+		t.Errorf("GET body = %s, want the debug level", res.Body)
+	}
+}
+
+func TestLevelHandlerRequests(t *testing.T) {
+	tests := []struct {
+		name        string
+		floor       string
+		contentType string
+		body        string
+		wantStatus  int
+		wantLevel   string
+	}{
+		{name: "json", floor: "debug", contentType: "application/json", body: `{"level":"warn"}`, wantStatus: http.StatusOK, wantLevel: "warn"},
+		{name: "form", floor: "debug", contentType: "application/x-www-form-urlencoded", body: "level=error", wantStatus: http.StatusOK, wantLevel: "error"},
+		{name: "at the floor", floor: "info", contentType: "application/json", body: `{"level":"info"}`, wantStatus: http.StatusOK, wantLevel: "info"},
+		{name: "below the floor", floor: "info", contentType: "application/json", body: `{"level":"debug"}`, wantStatus: http.StatusForbidden, wantLevel: "info"},
+		{name: "unknown level", floor: "debug", contentType: "application/json", body: `{"level":"verbose"}`, wantStatus: http.StatusBadRequest, wantLevel: "info"},
+		{name: "malformed json", floor: "debug", contentType: "application/json", body: `{"level":`, wantStatus: http.StatusBadRequest, wantLevel: "info"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, _ := newBufferLogger(t, "info", FormatJSON)
+			handler, err := LevelHandler(l, tt.floor)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if res := setLevel(handler, tt.contentType, tt.body); res.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d; body %s", res.Code, tt.wantStatus, res.Body)
+			}
+			if level := l.(*ZapLogger).level.String(); level != tt.wantLevel {
+				t.Errorf("level = %s, want %s", level, tt.wantLevel)
+			}
+		})
+	}
+}
+
+func TestLevelHandlerRejectsInvalidFloor(t *testing.T) {
+	if _, err := LevelHandler(NewNopLogger(), "verbose"); err == nil {
+		t.Error("invalid floor was accepted")
+	}
+}
@@ -1,8 +1,11 @@
 package logger
 
 import (
+	"fmt"
 	"go.uber.org/zap"
 	"go.uber.org/zap/zapcore"
+	"gopkg.in/natefinch/lumberjack.v2"
+	"os"
 )
 
 type Logger interface {
@@ -11,13 +14,45 @@ type Logger interface {
 	Warn(msg string, fields ...zap.Field)
 	Error(msg string, fields ...zap.Field)
 	Fatal(msg string, fields ...zap.Field)
+	// With возвращает дочерний логгер, добавляющий fields к каждой записи.
+	With(fields ...zap.Field) Logger
+	// Sync сбрасывает буферизованные записи, вызывается перед завершением процесса.
+	Sync() error
 }
 
 type ZapLogger struct {
 	logger *zap.Logger
+	level  zap.AtomicLevel
 }
 
-func NewLogger(logLevel string) (Logger, error) {
+const (
+	FormatConsole = "console"
+	FormatJSON    = "json"
+
+	OutputStdout = "stdout"
+	OutputStderr = "stderr"
+	OutputNone   = "none"
+)
+
+// OutputConfig задает, куда пишутся логи: в стандартный поток Output и, если указан FilePath,
+// дополнительно в файл с ротацией по размеру.
+type OutputConfig struct {
+	Output     string
+	FilePath   string
+	MaxSizeMB  int
+	MaxBackups int
+	MaxAgeDays int
+}
+
+// NewLogger создает логгер с уровнем logLevel и форматом logFormat: console — для разработки,
+// json — для сборщиков логов (без development-режима, время в ISO 8601).
+func NewLogger(logLevel, logFormat string, output OutputConfig) (Logger, error) {
+	return newLogger(logLevel, logFormat, output, zapcore.Lock(os.Stdout), zapcore.Lock(os.Stderr))
+}
+
+// newLogger создает логгер, пишущий стандартные потоки в stdout и stderr, чтобы тесты могли
+// подменить их буферами.
+func newLogger(logLevel, logFormat string, output OutputConfig, stdout, stderr zapcore.WriteSyncer) (Logger, error) {
 	parsedLevel, err := zap.ParseAtomicLevel(logLevel)
 	if err != nil {
 		return nil, err
@@ -37,24 +72,59 @@ func NewLogger(logLevel string) (Logger, error) {
 		EncodeCaller:   zapcore.ShortCallerEncoder,
 	}
 
-	config := zap.Config{
-		Encoding:          "console",
-		DisableStacktrace: true,
-		DisableCaller:     false,
-		Development:       true,
-		Level:             parsedLevel,
-		OutputPaths:       []string{"stdout"},
-		ErrorOutputPaths:  []string{"stderr"},
-		EncoderConfig:     encoderConfig,
+	var (
+		encoder zapcore.Encoder
+		options = []zap.Option{zap.ErrorOutput(stderr)}
+	)
+	switch logFormat {
+	case FormatConsole:
+		encoder = zapcore.NewConsoleEncoder(encoderConfig)
+		options = append(options, zap.Development())
+	case FormatJSON:
+		encoder = zapcore.NewJSONEncoder(encoderConfig)
+	default:
+		return nil, fmt.Errorf("newLogger: unknown log format %q", logFormat)
 	}
-	logger, err := config.Build()
-	if err != nil {
-		return nil, err
+
+	var cores []zapcore.Core
+	switch output.Output {
+	case OutputStdout:
+		cores = append(cores, zapcore.NewCore(encoder, stdout, parsedLevel))
+	case OutputStderr:
+		cores = append(cores, zapcore.NewCore(encoder, stderr, parsedLevel))
+	case OutputNone:
+	default:
+		return nil, fmt.Errorf("newLogger: unknown log output %q", output.Output)
+	}
+
+	if output.FilePath != "" {
+		file := &lumberjack.Logger{
+			Filename:   output.FilePath,
+			MaxSize:    output.MaxSizeMB,
+			MaxBackups: output.MaxBackups,
+			MaxAge:     output.MaxAgeDays,
+		}
+		cores = append(cores, zapcore.NewCore(encoder.Clone(), zapcore.AddSync(file), parsedLevel))
+	}
+
+	if len(cores) == 0 {
+		return nil, fmt.Errorf("newLogger: log output is %q and no log file is set", output.Output)
 	}
 
+	logger := zap.New(zapcore.NewTee(cores...), options...)
 	logger = logger.WithOptions(zap.AddCaller(), zap.AddCallerSkip(1))
 
-	return &ZapLogger{logger: logger}, nil
+	return &ZapLogger{logger: logger, level: parsedLevel}, nil
+}
+
+// NewCoreLogger создает логгер поверх готового core, например observer.Core в тестах.
+func NewCoreLogger(core zapcore.Core) Logger {
+	return &ZapLogger{logger: zap.New(core, zap.AddCaller(), zap.AddCallerSkip(1)), level: zap.NewAtomicLevel()}
+}
+
+// NewNopLogger возвращает логгер, отбрасывающий все записи.
+func NewNopLogger() Logger {
+	return &ZapLogger{logger: zap.NewNop(), level: zap.NewAtomicLevel()}
 }
 
 func (l *ZapLogger) Debug(msg string, fields ...zap.Field) {
@@ -76,3 +146,11 @@ func (l *ZapLogger) Error(msg string, fields ...zap.Field) {
 func (l *ZapLogger) Fatal(msg string, fields ...zap.Field) {
 	l.logger.Fatal(msg, fields...)
 }
+
+func (l *ZapLogger) With(fields ...zap.Field) Logger {
+	return &ZapLogger{logger: l.logger.With(fields...), level: l.level}
+}
+
+func (l *ZapLogger) Sync() error {
+	return l.logger.Sync()
+}
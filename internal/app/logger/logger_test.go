@@ -0,0 +1,180 @@
+package logger
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newBufferLogger создает логгер, пишущий в буфер вместо stdout.
+func newBufferLogger(t *testing.T, logLevel, logFormat string) (Logger, *bytes.Buffer) {
+	t.Helper()
+
+	var output bytes.Buffer
+	l, err := newLogger(logLevel, logFormat, OutputConfig{Output: OutputStdout}, zapcore.AddSync(&output), zapcore.AddSync(&output))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return l, &output
+}
+
+// decodeLines разбирает каждую строку вывода как JSON-объект.
+func decodeLines(t *testing.T, output *bytes.Buffer) []map[string]interface{} {
+	t.Helper()
+
+	var entries []map[string]interface{}
+	scanner := bufio.NewScanner(output)
+	for scanner.Scan() {
+		entry := make(map[string]interface{})
+		if err := json.Unmarshal(scanner.Bytes(), &entry); err != nil {
+			t.Fatalf("line %q is not JSON: %v", scanner.Text(), err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries
+}
+
+func TestNewLoggerJSON(t *testing.T) {
+	l, output := newBufferLogger(t, "info", FormatJSON)
+
+	l.With(zap.String("component", "updater")).Info("order updated", zap.String("order", "12345678903"),
+		zap.Duration("duration", time.Millisecond*1500), zap.Error(errors.New("accrual system is unavailable")))
+	l.Debug("below the level")
+	l.Warn("second line")
+
+	entries := decodeLines(t, output)
+	if len(entries) != 2 {
+		t.Fatalf("got %d lines, want 2: %s", len(entries), output)
+	}
+
+	entry := entries[0]
+	for key, want := range map[string]interface{}{
+		"level":     "info",
+		"msg":       "order updated",
+		"component": "updater",
+		"order":     "12345678903",
+		"duration":  1.5,
+		"error":     "accrual system is unavailable",
+	} {
+		if entry[key] != want {
+			t.Errorf("%s = %v, want %v", key, entry[key], want)
+		}
+	}
+	ts, _ := entry["ts"].(string)
+	if _, err := time.Parse("2006-01-02T15:04:05.000Z0700", ts); err != nil {
+		t.Errorf("ts = %q, want an ISO 8601 time: %v", ts, err)
+	}
+	if caller, _ := entry["caller"].(string); !strings.HasPrefix(caller, "logger/logger_test.go:") {
+		t.Errorf("caller = %q, want the test file, not the logger wrapper", caller)
+	}
+	if entries[1]["level"] != "warn" {
+		t.Errorf("second line level = %v, want warn", entries[1]["level"])
+	}
+}
+
+func TestNewLoggerConsole(t *testing.T) {
+	l, output := newBufferLogger(t, "debug", FormatConsole)
+	l.Debug("order updated", zap.String("order", "12345678903"))
+
+	line := output.String()
+	if json.Valid(bytes.TrimSpace(output.Bytes())) {
+		t.Errorf("console output is JSON: %s", line)
+	}
+	if !strings.Contains(line, "debug") || !strings.Contains(line, "order updated") || !strings.Contains(line, `{"order": "12345678903"}`) {
+		t.Errorf("console line = %q", line)
+	}
+}
+
+func TestNewLoggerRejectsInvalidSettings(t *testing.T) {
+	tests := []struct {
+		name   string
+		level  string
+		format string
+		output OutputConfig
+	}{
+		{name: "level", level: "verbose", format: FormatJSON, output: OutputConfig{Output: OutputStdout}},
+		{name: "format", level: "info", format: "xml", output: OutputConfig{Output: OutputStdout}},
+		{name: "output", level: "info", format: FormatJSON, output: OutputConfig{Output: "syslog"}},
+		{name: "no output", level: "info", format: FormatJSON, output: OutputConfig{Output: OutputNone}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := NewLogger(tt.level, tt.format, tt.output); err == nil {
+				t.Error("invalid settings were accepted")
+			}
+		})
+	}
+}
+
+func TestWithAddsFieldsToChildLogger(t *testing.T) {
+	core, recorded := observer.New(zapcore.DebugLevel)
+	parent := NewCoreLogger(core).With(zap.String("component", "http"))
+	child := parent.With(zap.String("handler", "addOrder"))
+
+	child.Info("request failed", zap.String("order", "12345678903"))
+	parent.Info("running server")
+
+	entries := recorded.AllUntimed()
+	if len(entries) != 2 {
+		t.Fatalf("got %d entries, want 2", len(entries))
+	}
+	childFields := entries[0].ContextMap()
+	for key, want := range map[string]string{"component": "http", "handler": "addOrder", "order": "12345678903"} {
+		if childFields[key] != want {
+			t.Errorf("child %s = %v, want %s", key, childFields[key], want)
+		}
+	}
+	parentFields := entries[1].ContextMap()
+	if parentFields["component"] != "http" {
+		t.Errorf("parent component = %v, want http", parentFields["component"])
+	}
+	if _, ok := parentFields["handler"]; ok {
+		t.Error("child fields leaked into the parent logger")
+	}
+	if caller := entries[0].Caller.TrimmedPath(); !strings.HasPrefix(caller, "logger/logger_test.go:") {
+		t.Errorf("caller = %q, want the test file, not the logger wrapper", caller)
+	}
+}
+
+// TestNewLoggerRotatesFile проверяет, что файл лога, превысивший MaxSizeMB, уходит в резервную
+// копию, а запись продолжается в новый файл.
+func TestNewLoggerRotatesFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "gophermart.log")
+	l, err := NewLogger("info", FormatJSON, OutputConfig{Output: OutputNone, FilePath: path, MaxSizeMB: 1, MaxBackups: 3})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	payload := strings.Repeat("x", 1024)
+	for i := 0; i < 1100; i++ {
+		l.Info("order updated", zap.String("payload", payload))
+	}
+	if err = l.Sync(); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := filepath.Glob(filepath.Join(dir, "gophermart-*.log"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) == 0 {
+		t.Fatal("no backup file after writing more than MaxSizeMB")
+	}
+	current, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.Size() == 0 || current.Size() > 1024*1024 {
+		t.Errorf("current log size = %d, want between 0 and 1 MB", current.Size())
+	}
+}
@@ -0,0 +1,22 @@
+package middleware
+
+import (
+	"net"
+	"net/http"
+)
+
+// LoopbackOnly пропускает только запросы с локального адреса, остальным отвечает 404,
+// не раскрывая существование обработчика.
+func LoopbackOnly(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		host, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			host = req.RemoteAddr
+		}
+		if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			http.NotFound(res, req)
+			return
+		}
+		next.ServeHTTP(res, req)
+	})
+}
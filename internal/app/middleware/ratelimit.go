@@ -0,0 +1,130 @@
+package middleware
+
+import (
+	"encoding/json"
+	"github.com/vancho-go/gophermart/internal/app/auth"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// idleBucketTTL — через сколько после последнего запроса корзина адреса удаляется,
+// к этому моменту она все равно успевает наполниться полностью.
+const idleBucketTTL = time.Minute * 10
+
+type tokenBucket struct {
+	mu       sync.Mutex
+	tokens   float64
+	lastSeen time.Time
+}
+
+// take забирает токен и возвращает ноль или время, через которое появится следующий токен.
+func (b *tokenBucket) take(now time.Time, rps float64, burst int) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.tokens = math.Min(float64(burst), b.tokens+now.Sub(b.lastSeen).Seconds()*rps)
+	b.lastSeen = now
+	if b.tokens >= 1 {
+		b.tokens--
+		return 0
+	}
+	return time.Duration((1 - b.tokens) / rps * float64(time.Second))
+}
+
+func (b *tokenBucket) idleSince(now time.Time) time.Duration {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return now.Sub(b.lastSeen)
+}
+
+// RateLimiter ограничивает частоту запросов с одним ключом (IP-адрес, пользователь)
+// алгоритмом token bucket.
+type RateLimiter struct {
+	rps       float64
+	burst     int
+	key       func(req *http.Request) (string, bool)
+	buckets   sync.Map // ключ -> *tokenBucket
+	mu        sync.Mutex
+	lastSweep time.Time
+}
+
+// NewIPRateLimiter ограничивает запросы по IP-адресу. Адрес берется из RemoteAddr:
+// X-Forwarded-For подделывается клиентом.
+func NewIPRateLimiter(rps float64, burst int) *RateLimiter {
+	return newRateLimiter(rps, burst, func(req *http.Request) (string, bool) {
+		ip, _, err := net.SplitHostPort(req.RemoteAddr)
+		if err != nil {
+			return req.RemoteAddr, true
+		}
+		return ip, true
+	})
+}
+
+// NewUserRateLimiter ограничивает запросы по пользователю, поэтому подключается после auth.Middleware.
+func NewUserRateLimiter(rps float64, burst int) *RateLimiter {
+	return newRateLimiter(rps, burst, func(req *http.Request) (string, bool) {
+		userID, ok := req.Context().Value(auth.UserIDContextKey).(string)
+		return userID, ok
+	})
+}
+
+func newRateLimiter(rps float64, burst int, key func(req *http.Request) (string, bool)) *RateLimiter {
+	return &RateLimiter{rps: rps, burst: burst, key: key, lastSweep: time.Now()}
+}
+
+// Middleware отвечает 429 с заголовками Retry-After и X-RateLimit-Reset, если ключ исчерпал лимит.
+// Запросы без ключа пропускаются без ограничений.
+func (l *RateLimiter) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		now := time.Now()
+		l.sweep(now)
+
+		key, ok := l.key(req)
+		if !ok {
+			next.ServeHTTP(res, req)
+			return
+		}
+
+		value, _ := l.buckets.LoadOrStore(key, &tokenBucket{tokens: float64(l.burst), lastSeen: now})
+		if wait := value.(*tokenBucket).take(now, l.rps, l.burst); wait > 0 {
+			writeTooManyRequests(res, now, wait)
+			return
+		}
+		next.ServeHTTP(res, req)
+	})
+}
+
+// sweep раз в idleBucketTTL удаляет корзины ключей, не присылавших запросы дольше idleBucketTTL.
+func (l *RateLimiter) sweep(now time.Time) {
+	l.mu.Lock()
+	if now.Sub(l.lastSweep) < idleBucketTTL {
+		l.mu.Unlock()
+		return
+	}
+	l.lastSweep = now
+	l.mu.Unlock()
+
+	l.buckets.Range(func(key, value interface{}) bool {
+		if value.(*tokenBucket).idleSince(now) > idleBucketTTL {
+			l.buckets.Delete(key)
+		}
+		return true
+	})
+}
+
+// writeTooManyRequests отвечает в том же формате, что и handlers: {"error":{"code":"...","message":"..."}}.
+func writeTooManyRequests(res http.ResponseWriter, now time.Time, wait time.Duration) {
+	retryAfter := int(math.Ceil(wait.Seconds()))
+	res.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+	res.Header().Set("X-RateLimit-Reset", strconv.FormatInt(now.Unix()+int64(retryAfter), 10))
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.WriteHeader(http.StatusTooManyRequests)
+	json.NewEncoder(res).Encode(map[string]map[string]string{
+		"error": {"code": "TOO_MANY_REQUESTS", "message": "Too many requests"},
+	})
+}
@@ -0,0 +1,96 @@
+package middleware_test
+
+import (
+	"bufio"
+	"context"
+	"github.com/go-chi/chi/v5"
+	"github.com/vancho-go/gophermart/internal/app/auth"
+	"github.com/vancho-go/gophermart/internal/app/events"
+	"github.com/vancho-go/gophermart/internal/app/handlers"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"github.com/vancho-go/gophermart/internal/app/middleware"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"github.com/vancho-go/gophermart/internal/app/openapi"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// activeUsers считает всех пользователей активными.
+var activeUsers = auth.UserCheckerFunc(func(context.Context, string) (bool, error) {
+	return true, nil
+})
+
+// TestTraceKeepsStreamPastWriteTimeout проверяет, что SSE-поток за цепочкой middleware сервера
+// снимает WriteTimeout: событие, опубликованное после истечения таймаута, доходит до клиента.
+func TestTraceKeepsStreamPastWriteTimeout(t *testing.T) {
+	const writeTimeout = time.Millisecond * 200
+
+	if err := auth.SetKeys("trace-test-key", nil); err != nil {
+		t.Fatal(err)
+	}
+	log := logger.NewNopLogger()
+	validation, err := openapi.ValidationMiddleware(openapi.ValidationEnforce, log)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bus := events.NewEventBus()
+	r := chi.NewRouter()
+	r.Use(middleware.Trace)
+	r.Use(validation)
+	r.With(auth.Middleware(activeUsers)).Get("/api/v1/user/orders/events", handlers.GetOrderEvents(bus, log))
+
+	server := httptest.NewUnstartedServer(r)
+	server.Config.WriteTimeout = writeTimeout
+	server.Start()
+	defer server.Close()
+
+	req, err := http.NewRequest(http.MethodGet, server.URL+"/api/v1/user/orders/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cookie, err := auth.GenerateCookie("user-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.AddCookie(cookie)
+
+	res, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("status = %d, want %d", res.StatusCode, http.StatusOK)
+	}
+
+	time.Sleep(writeTimeout * 2)
+	bus.Publish("user-1", models.APIOrderStatusEvent{Number: "12345678903", Status: models.OrderStatusProcessed})
+
+	lines := make(chan string)
+	go func() {
+		defer close(lines)
+		scanner := bufio.NewScanner(res.Body)
+		for scanner.Scan() {
+			lines <- scanner.Text()
+		}
+	}()
+
+	deadline := time.After(time.Second * 5)
+	for {
+		select {
+		case line, ok := <-lines:
+			if !ok {
+				t.Fatal("stream closed before the event was delivered")
+			}
+			if strings.HasPrefix(line, "data: ") && strings.Contains(line, "12345678903") {
+				return
+			}
+		case <-deadline:
+			t.Fatal("event was not delivered")
+		}
+	}
+}
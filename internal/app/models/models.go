@@ -1,27 +1,70 @@
 package models
 
-import "time"
+import (
+	"encoding/json"
+	"time"
+)
 
 type APIRegisterRequest struct {
 	Login    string `json:"login"`
+	Email    string `json:"email,omitempty"`
 	Password string `json:"password"`
 }
 
 type APIAuthRequest struct {
+	// Login — логин или email пользователя
 	Login    string `json:"login"`
 	Password string `json:"password"`
 }
 
+// UserProfile — данные учетной записи, которые пользователь видит о себе.
+type UserProfile struct {
+	UserID       string    `json:"user_id"`
+	Login        string    `json:"login"`
+	Email        string    `json:"email,omitempty"`
+	RegisteredAt time.Time `json:"registered_at"`
+}
+
 type APIAddOrderRequest struct {
 	UserID      string
 	OrderNumber string
 }
 
+// APIAddOrderJSONRequest — тело загрузки заказа с Content-Type: application/json.
+type APIAddOrderJSONRequest struct {
+	Order string `json:"order"`
+}
+
 type APIGetOrderResponse struct {
-	Number     string    `json:"number"`
-	Status     string    `json:"status"`
-	Accrual    *float64  `json:"accrual,omitempty"`
-	UploadedAt time.Time `json:"uploaded_at"`
+	Number     string      `json:"number"`
+	Status     OrderStatus `json:"status"`
+	Accrual    *float64    `json:"accrual,omitempty"`
+	UploadedAt time.Time   `json:"uploaded_at"`
+}
+
+// MarshalJSON отдает uploaded_at в UTC (RFC3339 с суффиксом Z) независимо от часового пояса,
+// в котором драйвер вернул время.
+func (r APIGetOrderResponse) MarshalJSON() ([]byte, error) {
+	type response APIGetOrderResponse
+	return json.Marshal(struct {
+		response
+		UploadedAt string `json:"uploaded_at"`
+	}{
+		response:   response(r),
+		UploadedAt: r.UploadedAt.UTC().Format(time.RFC3339),
+	})
+}
+
+const (
+	OrderBatchAccepted  = "accepted"
+	OrderBatchDuplicate = "duplicate"
+	OrderBatchInvalid   = "invalid"
+)
+
+type APIOrderBatchResult struct {
+	Number string `json:"number"`
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
 }
 
 type APIGetBonusesAmountResponse struct {
@@ -37,11 +80,206 @@ type APIUseBonusesRequest struct {
 type APIGetWithdrawalsHistoryResponse struct {
 	Order       string    `json:"order"`
 	Sum         float64   `json:"sum"`
-	ProcessedAt time.Time `json:"Processed_at"`
+	ProcessedAt time.Time `json:"processed_at"`
+}
+
+// MarshalJSON отдает processed_at в том же формате, что и uploaded_at заказов: RFC3339 в UTC.
+func (r APIGetWithdrawalsHistoryResponse) MarshalJSON() ([]byte, error) {
+	type response APIGetWithdrawalsHistoryResponse
+	return json.Marshal(struct {
+		response
+		ProcessedAt string `json:"processed_at"`
+	}{
+		response:    response(r),
+		ProcessedAt: r.ProcessedAt.UTC().Format(time.RFC3339),
+	})
 }
 
 type APIOrderInfoResponse struct {
-	Order   string  `json:"order"`
-	Status  string  `json:"status"`
-	Accrual float64 `json:"accrual,omitempty"`
+	Order   string      `json:"order"`
+	Status  OrderStatus `json:"status"`
+	Accrual float64     `json:"accrual,omitempty"`
+}
+
+type APIOrderStatusEvent struct {
+	Number  string      `json:"number"`
+	Status  OrderStatus `json:"status"`
+	Accrual *float64    `json:"accrual,omitempty"`
+}
+
+const (
+	BalanceCredit = "CREDIT"
+	BalanceDebit  = "DEBIT"
+
+	BalanceReasonAccrual    = "accrual"
+	BalanceReasonWithdrawal = "withdrawal"
+	// BalanceReasonAdjustment — ручное изменение начисления по заказу или баланса пользователя
+	// администратором
+	BalanceReasonAdjustment = "adjustment"
+)
+
+// APIBalanceHistoryEntry — операция с балансом: CREDIT (начисление) или DEBIT (списание) на
+// сумму Amount, Balance — баланс после операции.
+type APIBalanceHistoryEntry struct {
+	TxID      int64     `json:"tx_id"`
+	Direction string    `json:"direction"`
+	Reason    string    `json:"reason"`
+	Order     string    `json:"order"`
+	Amount    float64   `json:"amount"`
+	Balance   float64   `json:"balance"`
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// APIOrderEvent — запись журнала смены статусов заказа.
+type APIOrderEvent struct {
+	EventID   int64       `json:"event_id"`
+	OldStatus OrderStatus `json:"old_status"`
+	NewStatus OrderStatus `json:"new_status"`
+	Accrual   *float64    `json:"accrual,omitempty"`
+	ChangedAt time.Time   `json:"changed_at"`
+}
+
+// APIOrderStreamMessage — сообщение WebSocket-потока изменений статусов заказов.
+type APIOrderStreamMessage struct {
+	OrderID string      `json:"order_id"`
+	Status  OrderStatus `json:"status"`
+	Accrual *float64    `json:"accrual,omitempty"`
+}
+
+type APIWebhookRequest struct {
+	URL string `json:"url"`
+	// Secret — ключ подписи уведомлений; если не задан, генерируется сервером
+	Secret string `json:"secret,omitempty"`
+}
+
+type APIWebhookResponse struct {
+	ID     int64  `json:"id"`
+	URL    string `json:"url"`
+	Secret string `json:"secret"`
+}
+
+// Webhook — зарегистрированный пользователем адрес уведомлений. Пустой Secret означает webhook,
+// зарегистрированный до появления собственных ключей, он подписывается общим ключом.
+type Webhook struct {
+	ID     int64
+	URL    string
+	Secret string
+}
+
+// WebhookDelivery — попытка доставки уведомления об изменении заказа на webhook.
+type WebhookDelivery struct {
+	ID           int64       `json:"id"`
+	WebhookID    int64       `json:"-"`
+	Order        string      `json:"order"`
+	Status       OrderStatus `json:"status"`
+	Attempt      int         `json:"attempt"`
+	ResponseCode *int        `json:"response_code,omitempty"`
+	Error        string      `json:"error,omitempty"`
+	Succeeded    bool        `json:"succeeded"`
+	AttemptedAt  time.Time   `json:"attempted_at"`
+}
+
+type APIWebhookPayload struct {
+	Order   string      `json:"order"`
+	Status  OrderStatus `json:"status"`
+	Accrual *float64    `json:"accrual,omitempty"`
+}
+
+type IdempotentResponse struct {
+	Status int
+	Header map[string][]string
+	Body   []byte
+}
+
+type APIAdminUpdateOrderStatusRequest struct {
+	Status  OrderStatus `json:"status"`
+	Accrual *float64    `json:"accrual,omitempty"`
+}
+
+type SystemStats struct {
+	TotalUsers         int64            `json:"total_users"`
+	TotalOrders        int64            `json:"total_orders"`
+	OrdersByStatus     map[string]int64 `json:"orders_by_status"`
+	TotalAccrualIssued float64          `json:"total_accrual_issued"`
+	TotalWithdrawn     float64          `json:"total_withdrawn"`
+}
+
+// DailyStats — показатели за один день: регистрации, загруженные заказы, начисленные и списанные бонусы.
+type DailyStats struct {
+	Date            string  `json:"date"`
+	Registrations   int64   `json:"registrations"`
+	OrdersUploaded  int64   `json:"orders_uploaded"`
+	AccrualCredited float64 `json:"accrual_credited"`
+	Withdrawn       float64 `json:"withdrawn"`
+}
+
+// StuckOrder — заказ, который слишком долго не доходит до конечного статуса.
+// LastUpdatedAt — время последней смены статуса или загрузки, если статус не менялся.
+type StuckOrder struct {
+	OrderID       string      `json:"order_id"`
+	UserID        string      `json:"user_id"`
+	Status        OrderStatus `json:"status"`
+	UploadedAt    time.Time   `json:"uploaded_at"`
+	LastUpdatedAt time.Time   `json:"last_updated_at"`
+	LastCheckedAt *time.Time  `json:"last_checked_at,omitempty"`
+	ErrorCount    int         `json:"error_count"`
+	LastError     string      `json:"last_error,omitempty"`
+}
+
+// DeadOrder — заказ, исключенный из опроса после серии неудачных обновлений.
+type DeadOrder struct {
+	OrderID     string      `json:"order_id"`
+	UserID      string      `json:"user_id"`
+	Status      OrderStatus `json:"status"`
+	Attempts    int         `json:"attempts"`
+	LastError   string      `json:"last_error"`
+	LastAttempt time.Time   `json:"last_attempt"`
+}
+
+// BalanceDiscrepancy — пользователь, у которого кешированный баланс balances.current
+// расходится с суммой операций в журнале balance_transactions.
+type BalanceDiscrepancy struct {
+	UserID        string  `json:"user_id"`
+	CachedBalance float64 `json:"cached_balance"`
+	LedgerBalance float64 `json:"ledger_balance"`
+	Difference    float64 `json:"difference"`
+}
+
+type APIRebuildBalanceResponse struct {
+	UserID  string  `json:"user_id"`
+	Current float64 `json:"current"`
+}
+
+// APIAdjustBalanceRequest — ручная корректировка баланса: Delta > 0 начисляет, Delta < 0 списывает.
+type APIAdjustBalanceRequest struct {
+	Delta  float64 `json:"delta"`
+	Reason string  `json:"reason"`
+}
+
+type APIAdjustBalanceResponse struct {
+	AdjustmentID int64   `json:"adjustment_id"`
+	UserID       string  `json:"user_id"`
+	Current      float64 `json:"current"`
+}
+
+// APIVersionResponse — сведения о сборке запущенного бинарника.
+type APIVersionResponse struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildTime string `json:"build_time,omitempty"`
+}
+
+type DBHealth struct {
+	Healthy   bool      `json:"healthy"`
+	LastCheck time.Time `json:"last_check"`
+	Error     string    `json:"error,omitempty"`
+}
+
+type APIPingResponse struct {
+	Status   string   `json:"status"`
+	Database DBHealth `json:"database"`
+}
+
+type APIDeleteAccountRequest struct {
+	Password string `json:"password"`
 }
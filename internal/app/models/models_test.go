@@ -0,0 +1,51 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+)
+
+// TestTimestampsMarshalAsUTC проверяет, что время заказов и списаний сериализуется в RFC3339
+// с суффиксом Z независимо от часового пояса, в котором его вернул драйвер.
+func TestTimestampsMarshalAsUTC(t *testing.T) {
+	moscow := time.FixedZone("MSK", 3*60*60)
+	accrual := 500.0
+	tests := []struct {
+		name  string
+		value any
+		want  string
+	}{
+		{
+			name:  "order",
+			value: APIGetOrderResponse{Number: "9278923470", Status: OrderStatusProcessed, Accrual: &accrual, UploadedAt: time.Date(2020, 12, 10, 15, 15, 45, 0, moscow)},
+			want:  `{"number":"9278923470","status":"PROCESSED","accrual":500,"uploaded_at":"2020-12-10T12:15:45Z"}`,
+		},
+		{
+			name:  "order without accrual",
+			value: APIGetOrderResponse{Number: "12345678903", Status: OrderStatusNew, UploadedAt: time.Date(2020, 12, 10, 1, 0, 0, 0, moscow)},
+			want:  `{"number":"12345678903","status":"NEW","uploaded_at":"2020-12-09T22:00:00Z"}`,
+		},
+		{
+			name:  "withdrawal",
+			value: APIGetWithdrawalsHistoryResponse{Order: "2377225624", Sum: 500, ProcessedAt: time.Date(2020, 12, 9, 19, 9, 57, 0, moscow)},
+			want:  `{"order":"2377225624","sum":500,"processed_at":"2020-12-09T16:09:57Z"}`,
+		},
+		{
+			name:  "fractional seconds",
+			value: APIGetWithdrawalsHistoryResponse{Order: "2377225624", Sum: 0.5, ProcessedAt: time.Date(2020, 12, 9, 16, 9, 57, 123456789, time.UTC)},
+			want:  `{"order":"2377225624","sum":0.5,"processed_at":"2020-12-09T16:09:57Z"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := json.Marshal(tt.value)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(got) != tt.want {
+				t.Errorf("json = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,58 @@
+package models
+
+import (
+	"encoding/json"
+	"fmt"
+)
+
+// OrderStatus — статус заказа в системе лояльности.
+type OrderStatus string
+
+const (
+	OrderStatusNew        OrderStatus = "NEW"
+	OrderStatusProcessing OrderStatus = "PROCESSING"
+	OrderStatusInvalid    OrderStatus = "INVALID"
+	OrderStatusProcessed  OrderStatus = "PROCESSED"
+)
+
+// accrualOrderStatuses сопоставляет статусы accrual-системы статусам заказа: REGISTERED означает,
+// что заказ принят в обработку, но расчет еще не начат. NEW accrual-система не возвращает.
+var accrualOrderStatuses = map[string]OrderStatus{
+	"REGISTERED": OrderStatusProcessing,
+	"PROCESSING": OrderStatusProcessing,
+	"INVALID":    OrderStatusInvalid,
+	"PROCESSED":  OrderStatusProcessed,
+}
+
+// Valid сообщает, является ли s одним из известных статусов заказа.
+func (s OrderStatus) Valid() bool {
+	switch s {
+	case OrderStatusNew, OrderStatusProcessing, OrderStatusInvalid, OrderStatusProcessed:
+		return true
+	}
+	return false
+}
+
+// IsFinal сообщает, что статус окончательный и заказ больше не опрашивается.
+func (s OrderStatus) IsFinal() bool {
+	return s == OrderStatusInvalid || s == OrderStatusProcessed
+}
+
+// UnmarshalJSON переводит статус accrual-системы в статус заказа и отклоняет неизвестные статусы,
+// чтобы опечатка в ответе не попала в базу.
+func (r *APIOrderInfoResponse) UnmarshalJSON(data []byte) error {
+	var raw struct {
+		Order   string  `json:"order"`
+		Status  string  `json:"status"`
+		Accrual float64 `json:"accrual,omitempty"`
+	}
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	status, ok := accrualOrderStatuses[raw.Status]
+	if !ok {
+		return fmt.Errorf("unknown accrual order status %q", raw.Status)
+	}
+	*r = APIOrderInfoResponse{Order: raw.Order, Status: status, Accrual: raw.Accrual}
+	return nil
+}
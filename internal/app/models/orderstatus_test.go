@@ -0,0 +1,71 @@
+package models
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+// TestAPIOrderInfoResponseStatusMapping проверяет, что статусы accrual-системы переводятся в статусы
+// заказа, а неизвестные и опечатанные статусы отклоняются при разборе ответа.
+func TestAPIOrderInfoResponseStatusMapping(t *testing.T) {
+	tests := []struct {
+		name    string
+		body    string
+		want    OrderStatus
+		accrual float64
+		wantErr bool
+	}{
+		{name: "registered", body: `{"order":"79927398713","status":"REGISTERED"}`, want: OrderStatusProcessing},
+		{name: "processing", body: `{"order":"79927398713","status":"PROCESSING"}`, want: OrderStatusProcessing},
+		{name: "invalid", body: `{"order":"79927398713","status":"INVALID"}`, want: OrderStatusInvalid},
+		{name: "processed", body: `{"order":"79927398713","status":"PROCESSED","accrual":729.98}`, want: OrderStatusProcessed, accrual: 729.98},
+		{name: "typo", body: `{"order":"79927398713","status":"PROCESED"}`, wantErr: true},
+		{name: "lowercase", body: `{"order":"79927398713","status":"processed"}`, wantErr: true},
+		{name: "internal status", body: `{"order":"79927398713","status":"NEW"}`, wantErr: true},
+		{name: "empty status", body: `{"order":"79927398713","status":""}`, wantErr: true},
+		{name: "missing status", body: `{"order":"79927398713"}`, wantErr: true},
+		{name: "malformed json", body: `{"order":`, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var got APIOrderInfoResponse
+			err := json.Unmarshal([]byte(tt.body), &got)
+			if tt.wantErr {
+				if err == nil {
+					t.Fatalf("Unmarshal(%s) = %+v, want error", tt.body, got)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unmarshal(%s): %v", tt.body, err)
+			}
+			if got.Order != "79927398713" || got.Status != tt.want || got.Accrual != tt.accrual {
+				t.Errorf("Unmarshal(%s) = %+v, want status %s accrual %v", tt.body, got, tt.want, tt.accrual)
+			}
+		})
+	}
+}
+
+func TestOrderStatusValidAndFinal(t *testing.T) {
+	tests := []struct {
+		status OrderStatus
+		valid  bool
+		final  bool
+	}{
+		{status: OrderStatusNew, valid: true},
+		{status: OrderStatusProcessing, valid: true},
+		{status: OrderStatusInvalid, valid: true, final: true},
+		{status: OrderStatusProcessed, valid: true, final: true},
+		{status: "REGISTERED"},
+		{status: "PROCESED"},
+		{status: ""},
+	}
+	for _, tt := range tests {
+		if got := tt.status.Valid(); got != tt.valid {
+			t.Errorf("OrderStatus(%q).Valid() = %v, want %v", tt.status, got, tt.valid)
+		}
+		if got := tt.status.IsFinal(); got != tt.final {
+			t.Errorf("OrderStatus(%q).IsFinal() = %v, want %v", tt.status, got, tt.final)
+		}
+	}
+}
@@ -0,0 +1,65 @@
+package openapi
+
+import (
+	"bytes"
+	"fmt"
+	"gopkg.in/yaml.v3"
+	"net/http"
+	"sync"
+)
+
+//go:generate go run ../../../cmd/openapi-yaml ../../../api/openapi.yaml
+
+var (
+	yamlSpec     []byte
+	yamlSpecErr  error
+	yamlSpecOnce sync.Once
+)
+
+// YAML возвращает спецификацию в формате YAML. Источником остается openapi.json с учетом
+// SetBasePath, порядок ключей при конвертации сохраняется.
+func YAML() ([]byte, error) {
+	yamlSpecOnce.Do(func() {
+		var node yaml.Node
+		// JSON является подмножеством YAML, поэтому yaml.v3 читает спецификацию в дерево узлов
+		if err := yaml.Unmarshal(servedSpec, &node); err != nil {
+			yamlSpecErr = fmt.Errorf("yaml: error decoding openapi spec: %w", err)
+			return
+		}
+		resetStyle(&node)
+
+		var buf bytes.Buffer
+		encoder := yaml.NewEncoder(&buf)
+		encoder.SetIndent(2)
+		if err := encoder.Encode(&node); err != nil {
+			yamlSpecErr = fmt.Errorf("yaml: error encoding openapi spec: %w", err)
+			return
+		}
+		if err := encoder.Close(); err != nil {
+			yamlSpecErr = fmt.Errorf("yaml: error encoding openapi spec: %w", err)
+			return
+		}
+		yamlSpec = buf.Bytes()
+	})
+	return yamlSpec, yamlSpecErr
+}
+
+// resetStyle переводит узлы из flow-стиля JSON в блочный стиль YAML.
+func resetStyle(node *yaml.Node) {
+	node.Style = 0
+	for _, child := range node.Content {
+		resetStyle(child)
+	}
+}
+
+// DocsHandler отдает спецификацию в формате YAML.
+func DocsHandler(res http.ResponseWriter, _ *http.Request) {
+	body, err := YAML()
+	if err != nil {
+		http.Error(res, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	res.Header().Set("Content-Type", "application/yaml")
+	res.WriteHeader(http.StatusOK)
+	res.Write(body)
+}
@@ -0,0 +1,144 @@
+package openapi
+
+import (
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"github.com/getkin/kin-openapi/openapi3"
+	"github.com/getkin/kin-openapi/openapi3filter"
+	"github.com/getkin/kin-openapi/routers"
+	"github.com/getkin/kin-openapi/routers/legacy"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"go.uber.org/zap"
+	"net/http"
+)
+
+const (
+	ValidationOff     = "off"
+	ValidationWarn    = "warn"
+	ValidationEnforce = "enforce"
+)
+
+//go:embed openapi.json
+var spec []byte
+
+var (
+	// servedSpec — спецификация, которую получают клиенты: с servers, указывающим на basePath
+	servedSpec = spec
+	basePath   string
+)
+
+func Spec() []byte {
+	return spec
+}
+
+// SetBasePath сообщает, что API смонтировано под префиксом prefix: отдаваемая спецификация
+// получает servers с этим префиксом, а Swagger UI загружает ее по пути с префиксом. Проверка
+// запросов выполняется по исходной спецификации, так как префикс отрезается до роутера.
+// Вызывается до запуска сервера.
+func SetBasePath(prefix string) error {
+	basePath = prefix
+	if prefix == "" {
+		servedSpec = spec
+		return nil
+	}
+
+	var doc map[string]json.RawMessage
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		return fmt.Errorf("setBasePath: error decoding openapi spec: %w", err)
+	}
+	servers, err := json.Marshal([]map[string]string{{"url": prefix}})
+	if err != nil {
+		return fmt.Errorf("setBasePath: error encoding servers: %w", err)
+	}
+	doc["servers"] = servers
+
+	served, err := json.MarshalIndent(doc, "", "  ")
+	if err != nil {
+		return fmt.Errorf("setBasePath: error encoding openapi spec: %w", err)
+	}
+	servedSpec = served
+	return nil
+}
+
+func Load() (*openapi3.T, error) {
+	doc, err := openapi3.NewLoader().LoadFromData(spec)
+	if err != nil {
+		return nil, fmt.Errorf("load: error loading openapi spec: %w", err)
+	}
+	if err = doc.Validate(context.Background()); err != nil {
+		return nil, fmt.Errorf("load: openapi spec is invalid: %w", err)
+	}
+	return doc, nil
+}
+
+func Handler(res http.ResponseWriter, _ *http.Request) {
+	res.Header().Set("Content-Type", "application/json")
+	res.WriteHeader(http.StatusOK)
+	res.Write(servedSpec)
+}
+
+// ValidationMiddleware проверяет входящие запросы на соответствие спецификации.
+// В режиме warn несоответствия только логируются, в режиме enforce запрос отклоняется с 400.
+func ValidationMiddleware(mode string, logger logger.Logger) (func(http.Handler) http.Handler, error) {
+	if mode == ValidationOff || mode == "" {
+		return func(next http.Handler) http.Handler { return next }, nil
+	}
+	if mode != ValidationWarn && mode != ValidationEnforce {
+		return nil, fmt.Errorf("validationMiddleware: unknown validation mode %q", mode)
+	}
+
+	doc, err := Load()
+	if err != nil {
+		return nil, fmt.Errorf("validationMiddleware: %w", err)
+	}
+	router, err := legacy.NewRouter(doc)
+	if err != nil {
+		return nil, fmt.Errorf("validationMiddleware: error building router: %w", err)
+	}
+
+	options := &openapi3filter.Options{
+		// аутентификация проверяется auth.Middleware
+		AuthenticationFunc: openapi3filter.NoopAuthenticationFunc,
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+			route, pathParams, err := router.FindRoute(req)
+			if err != nil {
+				if err != routers.ErrPathNotFound && err != routers.ErrMethodNotAllowed {
+					logger.Warn("validationMiddleware:", zap.Error(err))
+				}
+				next.ServeHTTP(res, req)
+				return
+			}
+
+			input := &openapi3filter.RequestValidationInput{
+				Request:    req,
+				PathParams: pathParams,
+				Route:      route,
+				Options:    options,
+			}
+			if err = openapi3filter.ValidateRequest(req.Context(), input); err != nil {
+				logger.Warn("validationMiddleware: request does not match openapi spec",
+					zap.String("method", req.Method), zap.String("path", req.URL.Path), zap.Error(err))
+				if mode == ValidationEnforce {
+					writeInvalidRequest(res, err)
+					return
+				}
+			}
+			next.ServeHTTP(res, req)
+		})
+	}, nil
+}
+
+// writeInvalidRequest отвечает в том же формате, что и handlers: {"error":{"code":"...","message":"..."}}.
+func writeInvalidRequest(res http.ResponseWriter, err error) {
+	res.Header().Set("Content-Type", "application/json")
+	res.Header().Set("X-Content-Type-Options", "nosniff")
+	res.WriteHeader(http.StatusBadRequest)
+	json.NewEncoder(res).Encode(map[string]map[string]string{
+		"error": {"code": "INVALID_REQUEST", "message": err.Error()},
+	})
+}
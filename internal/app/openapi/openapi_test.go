@@ -0,0 +1,45 @@
+package openapi
+
+import (
+	"gopkg.in/yaml.v3"
+	"os"
+	"reflect"
+	"testing"
+)
+
+// committedSpecPath — YAML-версия спецификации в репозитории, генерируется go generate.
+const committedSpecPath = "../../../api/openapi.yaml"
+
+func TestLoad(t *testing.T) {
+	doc, err := Load()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if doc.Paths.Find("/api/v1/user/orders") == nil {
+		t.Error("spec has no /api/v1/user/orders path")
+	}
+}
+
+// TestCommittedYAMLMatchesSpec проверяет, что api/openapi.yaml не разошелся со встроенной
+// openapi.json, по которой проверяются запросы.
+func TestCommittedYAMLMatchesSpec(t *testing.T) {
+	committed, err := os.ReadFile(committedSpecPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	generated, err := YAML()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var committedDoc, generatedDoc interface{}
+	if err = yaml.Unmarshal(committed, &committedDoc); err != nil {
+		t.Fatalf("decoding %s: %v", committedSpecPath, err)
+	}
+	if err = yaml.Unmarshal(generated, &generatedDoc); err != nil {
+		t.Fatalf("decoding generated spec: %v", err)
+	}
+	if !reflect.DeepEqual(committedDoc, generatedDoc) {
+		t.Errorf("%s differs from openapi.json, run go generate ./internal/app/openapi", committedSpecPath)
+	}
+}
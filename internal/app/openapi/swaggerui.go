@@ -0,0 +1,34 @@
+package openapi
+
+import (
+	"fmt"
+	"net/http"
+)
+
+// swaggerUIPage подключает Swagger UI с CDN, чтобы не хранить статику в бинарнике.
+// Адрес спецификации подставляется при отдаче страницы.
+const swaggerUIPage = `<!DOCTYPE html>
+<html lang="en">
+<head>
+  <meta charset="utf-8">
+  <title>Gophermart API</title>
+  <link rel="stylesheet" href="https://unpkg.com/swagger-ui-dist@5/swagger-ui.css">
+</head>
+<body>
+  <div id="swagger-ui"></div>
+  <script src="https://unpkg.com/swagger-ui-dist@5/swagger-ui-bundle.js" crossorigin></script>
+  <script>
+    window.onload = () => {
+      window.ui = SwaggerUIBundle({url: %q, dom_id: "#swagger-ui"});
+    };
+  </script>
+</body>
+</html>
+`
+
+// SwaggerUIHandler отдает страницу Swagger UI для спецификации, доступной по <base path>/openapi.json.
+func SwaggerUIHandler(res http.ResponseWriter, _ *http.Request) {
+	res.Header().Set("Content-Type", "text/html; charset=utf-8")
+	res.WriteHeader(http.StatusOK)
+	fmt.Fprintf(res, swaggerUIPage, basePath+"/openapi.json")
+}
@@ -0,0 +1,107 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/vancho-go/gophermart/internal/app/auth"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"time"
+)
+
+// GetUserProfile возвращает данные учетной записи пользователя или ErrUserNotFound для
+// удаленного пользователя.
+func (s *Storage) GetUserProfile(ctx context.Context, userID string) (models.UserProfile, error) {
+	ctx, done := s.observeQuery(ctx, "getUserProfile")
+	defer done()
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	query := "SELECT user_id, login, COALESCE(email, ''), registered_at FROM users WHERE user_id=$1 AND deleted_at IS NULL"
+	var profile models.UserProfile
+	err := s.DB.QueryRow(ctx, query, userID).Scan(&profile.UserID, &profile.Login, &profile.Email, &profile.RegisteredAt)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return models.UserProfile{}, fmt.Errorf("getUserProfile: %w", ErrUserNotFound)
+	} else if err != nil {
+		return models.UserProfile{}, fmt.Errorf("getUserProfile: error scanning row: %w", err)
+	}
+	profile.RegisteredAt = profile.RegisteredAt.UTC()
+	return profile, nil
+}
+
+// VerifyUserPassword возвращает ErrUserNotFound, если пользователя нет или пароль не совпадает.
+func (s *Storage) VerifyUserPassword(ctx context.Context, userID, password string) error {
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	query := "SELECT password FROM users WHERE user_id=$1 AND deleted_at IS NULL"
+	var hashedPassword string
+	err := s.DB.QueryRow(ctx, query, userID).Scan(&hashedPassword)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("verifyUserPassword: %w", ErrUserNotFound)
+	} else if err != nil {
+		return fmt.Errorf("verifyUserPassword: error scanning row: %w", err)
+	}
+
+	if !auth.IsPasswordEqualsToHashedPassword(password, hashedPassword) {
+		return fmt.Errorf("verifyUserPassword: %w", ErrUserNotFound)
+	}
+	return nil
+}
+
+// deletedLoginRetention — сколько логин мягко удаленного пользователя остается недоступным
+// для регистрации.
+const deletedLoginRetention = time.Hour * 24 * 30
+
+// SoftDeleteUser помечает пользователя удаленным и стирает его персональные данные (email, хеш
+// пароля, вебхуки). Заказы, списания и баланс сохраняются для аудита, а логин остается занятым
+// deletedLoginRetention. Возвращает ErrUserNotFound, если пользователя нет или он уже удален.
+func (s *Storage) SoftDeleteUser(ctx context.Context, userID string) error {
+	ctx, done := s.observeQuery(ctx, "softDeleteUser")
+	defer done()
+
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("softDeleteUser: transaction error: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := "UPDATE users SET email = NULL, password = '', deleted_at = NOW() WHERE user_id=$1 AND deleted_at IS NULL"
+	result, err := tx.Exec(ctx, query, userID)
+	if err != nil {
+		return fmt.Errorf("softDeleteUser: error deleting user: %w", err)
+	}
+	deleted := result.RowsAffected()
+	if deleted == 0 {
+		return fmt.Errorf("softDeleteUser: %w", ErrUserNotFound)
+	}
+
+	query = "DELETE FROM user_webhooks WHERE user_id=$1"
+	if _, err = tx.Exec(ctx, query, userID); err != nil {
+		return fmt.Errorf("softDeleteUser: error deleting webhook: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("softDeleteUser: error committing transaction: %w", err)
+	}
+	return nil
+}
+
+// IsUserActive сообщает, что пользователь существует и не удален. Запрос идет в основную БД, а не
+// в реплику, чтобы токены удаленного пользователя отклонялись сразу после удаления.
+func (s *Storage) IsUserActive(ctx context.Context, userID string) (bool, error) {
+	ctx, done := s.observeQuery(ctx, "isUserActive")
+	defer done()
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	var active bool
+	query := "SELECT EXISTS (SELECT 1 FROM users WHERE user_id=$1 AND deleted_at IS NULL)"
+	if err := s.DB.QueryRow(ctx, query, userID).Scan(&active); err != nil {
+		return false, fmt.Errorf("isUserActive: error checking user: %w", err)
+	}
+	return active, nil
+}
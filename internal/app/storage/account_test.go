@@ -0,0 +1,156 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/vancho-go/gophermart/internal/app/auth"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"testing"
+)
+
+func TestSoftDeleteUserReservesLogin(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	userID, err := s.RegisterUser(ctx, "alice", "alice@example.com", "password-alice")
+	if err != nil {
+		t.Fatalf("register: %v", err)
+	}
+	if err = s.SoftDeleteUser(ctx, userID); err != nil {
+		t.Fatalf("soft delete: %v", err)
+	}
+	if err = s.SoftDeleteUser(ctx, userID); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("second soft delete error = %v, want %v", err, ErrUserNotFound)
+	}
+
+	// логин остается занятым, в том числе в другом регистре
+	for _, login := range []string{"alice", "ALICE"} {
+		if _, err = s.RegisterUser(ctx, login, "", "password-new"); !errors.Is(err, ErrUsernameDeletedPendingExpiry) {
+			t.Errorf("register %s error = %v, want %v", login, err, ErrUsernameDeletedPendingExpiry)
+		}
+	}
+
+	// персональные данные стерты: войти нельзя, а email свободен
+	if _, err = s.AuthenticateUser(ctx, "alice", "password-alice"); !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("login of a deleted user error = %v, want %v", err, ErrUserNotFound)
+	}
+	if _, err = s.RegisterUser(ctx, "bob", "alice@example.com", "password-bob"); err != nil {
+		t.Errorf("register with the email of a deleted user: %v", err)
+	}
+
+	// по истечении срока логин освобождается
+	query := "UPDATE users SET deleted_at = NOW() - $2 * INTERVAL '1 millisecond' - INTERVAL '1 second' WHERE user_id=$1"
+	if _, err = s.DB.Exec(ctx, query, userID, deletedLoginRetention.Milliseconds()); err != nil {
+		t.Fatal(err)
+	}
+	newUserID, err := s.RegisterUser(ctx, "alice", "", "password-new")
+	if err != nil {
+		t.Fatalf("register after the retention: %v", err)
+	}
+	if newUserID == userID {
+		t.Errorf("new user reused the id of the deleted user")
+	}
+}
+
+func TestIsUserActive(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	activeID := registerTestUser(t, s, "alice")
+	deletedID := registerTestUser(t, s, "bob")
+	if err := s.SoftDeleteUser(ctx, deletedID); err != nil {
+		t.Fatalf("soft delete: %v", err)
+	}
+
+	for userID, want := range map[string]bool{activeID: true, deletedID: false, "unknown-user": false} {
+		active, err := s.IsUserActive(ctx, userID)
+		if err != nil {
+			t.Fatalf("is user %s active: %v", userID, err)
+		}
+		if active != want {
+			t.Errorf("user %s active = %v, want %v", userID, active, want)
+		}
+	}
+}
+
+// TestRegisterUserIDCollision проверяет, что при совпадении идентификатора вставка повторяется
+// с новым, а после maxUserIDAttempts совпадений регистрация завершается ошибкой.
+func TestRegisterUserIDCollision(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	takenID := registerTestUser(t, s, "alice")
+
+	var attempts int
+	s.generateUserID = func() string {
+		attempts++
+		return takenID
+	}
+	_, err := s.RegisterUser(ctx, "bob", "", "password")
+	if !errors.Is(err, errUserIDTaken) {
+		t.Fatalf("error = %v, want %v after exhausted attempts", err, errUserIDTaken)
+	}
+	if attempts != maxUserIDAttempts {
+		t.Errorf("attempts = %d, want %d", attempts, maxUserIDAttempts)
+	}
+
+	ids := []string{takenID, "fresh-user-id"}
+	attempts = 0
+	s.generateUserID = func() string {
+		attempts++
+		return ids[attempts-1]
+	}
+	userID, err := s.RegisterUser(ctx, "bob", "", "password")
+	if err != nil {
+		t.Fatalf("registration after one collision: %v", err)
+	}
+	if userID != "fresh-user-id" || attempts != 2 {
+		t.Errorf("user id = %q after %d attempts, want fresh-user-id after 2", userID, attempts)
+	}
+}
+
+// TestAuthenticateUserUpgradesBcryptHash проверяет, что хеш bcrypt, созданный до перехода на
+// Argon2id, заменяется хешем Argon2id при первом успешном входе, а неудачный вход его не трогает.
+func TestAuthenticateUserUpgradesBcryptHash(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	userID := registerTestUser(t, s, "alice")
+
+	legacy, err := bcrypt.GenerateFromPassword([]byte("password-alice"), bcrypt.MinCost)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = s.DB.Exec(ctx, "UPDATE users SET password=$1 WHERE user_id=$2", string(legacy), userID); err != nil {
+		t.Fatal(err)
+	}
+	storedHash := func() string {
+		t.Helper()
+		var hash string
+		if err := s.DB.QueryRow(ctx, "SELECT password FROM users WHERE user_id=$1", userID).Scan(&hash); err != nil {
+			t.Fatal(err)
+		}
+		return hash
+	}
+
+	if _, err = s.AuthenticateUser(ctx, "alice", "wrong-password"); !errors.Is(err, ErrUserNotFound) {
+		t.Fatalf("login with a wrong password: %v, want ErrUserNotFound", err)
+	}
+	if hash := storedHash(); hash != string(legacy) {
+		t.Fatalf("failed login changed the hash to %s", hash)
+	}
+
+	if got, err := s.AuthenticateUser(ctx, "alice", "password-alice"); err != nil || got != userID {
+		t.Fatalf("login = %q, %v; want %q", got, err, userID)
+	}
+	upgraded := storedHash()
+	if !strings.HasPrefix(upgraded, "$argon2id$") || auth.NeedsRehash(upgraded) {
+		t.Fatalf("hash after login = %s, want a current argon2id hash", upgraded)
+	}
+
+	// новый хеш принимает тот же пароль и больше не перехешируется
+	if got, err := s.AuthenticateUser(ctx, "alice", "password-alice"); err != nil || got != userID {
+		t.Fatalf("login after upgrade = %q, %v; want %q", got, err, userID)
+	}
+	if hash := storedHash(); hash != upgraded {
+		t.Errorf("current hash was rehashed again")
+	}
+}
@@ -0,0 +1,66 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/vancho-go/gophermart/internal/app/accrual"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+// TestUpdateOrderStatusesBoundsSlowAccrual проверяет, что запрос к accrual-системе, отвечающей
+// дольше WithAccrualRequestTimeout, прерывается по таймауту и не засчитывается заказу как неудача.
+func TestUpdateOrderStatusesBoundsSlowAccrual(t *testing.T) {
+	const accrualTimeout = time.Millisecond * 100
+
+	tests := []struct {
+		name         string
+		orderNumbers []string
+	}{
+		{name: "single", orderNumbers: []string{"12345678903"}},
+		{name: "batch", orderNumbers: []string{"12345678903", "2377225624"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				// тело дочитывается, чтобы сервер заметил разрыв соединения клиентом
+				io.Copy(io.Discard, req.Body)
+				select {
+				case <-req.Context().Done():
+				case <-time.After(time.Second * 10):
+				}
+			}))
+			defer server.Close()
+			client, err := accrual.NewClient(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			s := &Storage{accrualClient: client, accrualTimeout: accrualTimeout}
+
+			var results []UpdateResult
+			started := time.Now()
+			s.updateOrderStatuses(context.Background(), tt.orderNumbers, func(result UpdateResult) bool {
+				results = append(results, result)
+				return true
+			})
+			if elapsed := time.Since(started); elapsed > accrualTimeout*time.Duration(len(tt.orderNumbers))*10 {
+				t.Errorf("update took %s, want about %s per request", elapsed, accrualTimeout)
+			}
+
+			if len(results) != len(tt.orderNumbers) {
+				t.Fatalf("got %d results, want %d", len(results), len(tt.orderNumbers))
+			}
+			for _, result := range results {
+				if result.Phase != UpdatePhaseFetch || !errors.Is(result.Err, context.DeadlineExceeded) {
+					t.Errorf("order %s: phase %s, error %v; want a fetch timeout", result.OrderNumber, result.Phase, result.Err)
+				}
+				if !isTransientUpdateError(result.Err) {
+					t.Errorf("order %s: timeout counted as a failed update", result.OrderNumber)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,80 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/vancho-go/gophermart/internal/app/models"
+)
+
+// AdjustBalance вручную изменяет баланс пользователя на delta и записывает корректировку с причиной
+// и оператором в balance_adjustments и журнал операций. Строка баланса блокируется до конца
+// транзакции, поэтому одновременное списание не может увести баланс в минус. Возвращает
+// ErrUserNotFound для неизвестного пользователя и ErrNotEnoughBonuses, если баланс стал бы отрицательным.
+func (s *Storage) AdjustBalance(ctx context.Context, userID string, request models.APIAdjustBalanceRequest, operator string) (response models.APIAdjustBalanceResponse, err error) {
+	ctx, done := s.observeQuery(ctx, "adjustBalance")
+	defer done()
+
+	err = withRetry(ctx, func() error {
+		response, err = s.adjustBalance(ctx, userID, request, operator)
+		return err
+	})
+	return response, err
+}
+
+func (s *Storage) adjustBalance(ctx context.Context, userID string, request models.APIAdjustBalanceRequest, operator string) (models.APIAdjustBalanceResponse, error) {
+	tx, err := s.DB.BeginTx(ctx, s.financialTxOptions())
+	if err != nil {
+		return models.APIAdjustBalanceResponse{}, fmt.Errorf("adjustBalance: transaction error: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var exists bool
+	query := "SELECT EXISTS (SELECT 1 FROM users WHERE user_id=$1 AND deleted_at IS NULL)"
+	if err = tx.QueryRow(ctx, query, userID).Scan(&exists); err != nil {
+		return models.APIAdjustBalanceResponse{}, fmt.Errorf("adjustBalance: error checking user: %w", err)
+	}
+	if !exists {
+		return models.APIAdjustBalanceResponse{}, fmt.Errorf("adjustBalance: %w", ErrUserNotFound)
+	}
+
+	if err = ensureBalanceRow(ctx, tx, userID); err != nil {
+		return models.APIAdjustBalanceResponse{}, fmt.Errorf("adjustBalance: %w", err)
+	}
+
+	var current float64
+	query = "SELECT current FROM balances WHERE user_id=$1 FOR UPDATE"
+	if err = tx.QueryRow(ctx, query, userID).Scan(&current); err != nil {
+		return models.APIAdjustBalanceResponse{}, fmt.Errorf("adjustBalance: error getting current balance: %w", err)
+	}
+	if current+request.Delta < 0 {
+		return models.APIAdjustBalanceResponse{}, fmt.Errorf("adjustBalance: balance %.2f, delta %.2f: %w", current, request.Delta, ErrNotEnoughBonuses)
+	}
+
+	response := models.APIAdjustBalanceResponse{UserID: userID}
+	query = "UPDATE balances SET current = current + $1 WHERE user_id = $2 RETURNING current::float"
+	if err = tx.QueryRow(ctx, query, request.Delta, userID).Scan(&response.Current); err != nil {
+		return models.APIAdjustBalanceResponse{}, fmt.Errorf("adjustBalance: error updating balance: %w", err)
+	}
+
+	query = `INSERT INTO balance_adjustments (user_id, delta, reason, operator) VALUES ($1, $2, $3, $4)
+		RETURNING adjustment_id`
+	err = tx.QueryRow(ctx, query, userID, request.Delta, request.Reason, operator).Scan(&response.AdjustmentID)
+	if err != nil {
+		return models.APIAdjustBalanceResponse{}, fmt.Errorf("adjustBalance: error saving adjustment: %w", err)
+	}
+
+	// корректировка не относится к заказу, поэтому в журнале вместо номера заказа ее идентификатор
+	direction, amount := models.BalanceCredit, request.Delta
+	if amount < 0 {
+		direction, amount = models.BalanceDebit, -amount
+	}
+	reference := fmt.Sprintf("adjustment-%d", response.AdjustmentID)
+	if err = recordBalanceTransaction(ctx, tx, userID, reference, amount, direction, models.BalanceReasonAdjustment); err != nil {
+		return models.APIAdjustBalanceResponse{}, fmt.Errorf("adjustBalance: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return models.APIAdjustBalanceResponse{}, fmt.Errorf("adjustBalance: error committing transaction: %w", err)
+	}
+	return response, nil
+}
@@ -0,0 +1,59 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"testing"
+)
+
+func TestAdjustBalance(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	userID := registerTestUser(t, s, "alice")
+	creditTestUser(t, s, userID, "12345678903", 50)
+
+	credit, err := s.AdjustBalance(ctx, userID, models.APIAdjustBalanceRequest{Delta: 25, Reason: "goodwill"}, "operator-1")
+	if err != nil {
+		t.Fatalf("credit adjustment: %v", err)
+	}
+	if credit.Current != 75 || credit.UserID != userID || credit.AdjustmentID == 0 {
+		t.Errorf("credit adjustment response = %+v, want current 75", credit)
+	}
+
+	debit, err := s.AdjustBalance(ctx, userID, models.APIAdjustBalanceRequest{Delta: -75, Reason: "mistaken accrual"}, "operator-2")
+	if err != nil {
+		t.Fatalf("debit adjustment: %v", err)
+	}
+	if debit.Current != 0 {
+		t.Errorf("balance after debit adjustment = %v, want 0", debit.Current)
+	}
+
+	_, err = s.AdjustBalance(ctx, userID, models.APIAdjustBalanceRequest{Delta: -0.01, Reason: "too much"}, "operator-2")
+	if !errors.Is(err, ErrNotEnoughBonuses) {
+		t.Fatalf("negative result: error = %v, want %v", err, ErrNotEnoughBonuses)
+	}
+
+	_, err = s.AdjustBalance(ctx, "no-such-user", models.APIAdjustBalanceRequest{Delta: 10, Reason: "goodwill"}, "operator-1")
+	if !errors.Is(err, ErrUserNotFound) {
+		t.Errorf("unknown user: error = %v, want %v", err, ErrUserNotFound)
+	}
+
+	balance, err := s.GetCurrentBonusesAmount(ctx, userID)
+	if err != nil {
+		t.Fatalf("get balance: %v", err)
+	}
+	if balance.Current != 0 {
+		t.Errorf("balance after rejected adjustment = %v, want 0", balance.Current)
+	}
+
+	var adjustments int
+	query := "SELECT COUNT(*) FROM balance_adjustments WHERE user_id = $1 AND operator IN ('operator-1', 'operator-2')"
+	if err = s.DB.QueryRow(ctx, query, userID).Scan(&adjustments); err != nil {
+		t.Fatal(err)
+	}
+	if adjustments != 2 {
+		t.Errorf("recorded adjustments = %d, want 2", adjustments)
+	}
+	assertLedgerConsistent(t, s)
+}
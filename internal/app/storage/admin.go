@@ -0,0 +1,194 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"fmt"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"strings"
+	"time"
+)
+
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func (s *Storage) GetSystemStats(ctx context.Context) (models.SystemStats, error) {
+	ctx, done := s.observeQuery(ctx, "getSystemStats")
+	defer done()
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT
+			(SELECT COUNT(*) FROM users),
+			(SELECT COUNT(*) FROM orders),
+			(SELECT COALESCE(json_object_agg(status, count), '{}')
+				FROM (SELECT status, COUNT(*) AS count FROM orders GROUP BY status) AS by_status),
+			(SELECT COALESCE(SUM(accrual), 0.0)::float FROM orders),
+			(SELECT COALESCE(SUM(sum), 0.0)::float FROM withdrawals)`
+
+	var (
+		stats          models.SystemStats
+		ordersByStatus []byte
+	)
+	err := s.DB.QueryRow(ctx, query).Scan(&stats.TotalUsers, &stats.TotalOrders, &ordersByStatus,
+		&stats.TotalAccrualIssued, &stats.TotalWithdrawn)
+	if err != nil {
+		return models.SystemStats{}, fmt.Errorf("getSystemStats: error scanning stats: %w", err)
+	}
+
+	if err = json.Unmarshal(ordersByStatus, &stats.OrdersByStatus); err != nil {
+		return models.SystemStats{}, fmt.Errorf("getSystemStats: error decoding orders by status: %w", err)
+	}
+	return stats, nil
+}
+
+// GetDailyStats возвращает показатели по дням с from по to включительно (даты в формате
+// YYYY-MM-DD) в часовом поясе timeZone. Дни без событий возвращаются с нулями.
+func (s *Storage) GetDailyStats(ctx context.Context, from, to, timeZone string) ([]models.DailyStats, error) {
+	ctx, done := s.observeQuery(ctx, "getDailyStats")
+	defer done()
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	// границы дней переводятся из timeZone в timestamptz, чтобы индексы по времени использовались
+	query := `
+		WITH bounds AS (
+			SELECT $1::date::timestamp AT TIME ZONE $3 AS since, ($2::date + 1)::timestamp AT TIME ZONE $3 AS until
+		), days AS (
+			SELECT generate_series($1::date, $2::date, INTERVAL '1 day')::date AS day
+		)
+		SELECT to_char(d.day, 'YYYY-MM-DD'), COALESCE(u.count, 0), COALESCE(o.count, 0),
+			COALESCE(a.total, 0)::float, COALESCE(w.total, 0)::float
+		FROM days d
+		LEFT JOIN (
+			SELECT date_trunc('day', registered_at AT TIME ZONE $3)::date AS day, COUNT(*) AS count
+			FROM users, bounds WHERE registered_at >= bounds.since AND registered_at < bounds.until GROUP BY 1
+		) AS u ON u.day = d.day
+		LEFT JOIN (
+			SELECT date_trunc('day', uploaded_at AT TIME ZONE $3)::date AS day, COUNT(*) AS count
+			FROM orders, bounds WHERE uploaded_at >= bounds.since AND uploaded_at < bounds.until GROUP BY 1
+		) AS o ON o.day = d.day
+		LEFT JOIN (
+			SELECT date_trunc('day', created_at AT TIME ZONE $3)::date AS day, SUM(amount) AS total
+			FROM balance_transactions, bounds
+			WHERE reason = 'accrual' AND created_at >= bounds.since AND created_at < bounds.until GROUP BY 1
+		) AS a ON a.day = d.day
+		LEFT JOIN (
+			SELECT date_trunc('day', processed_at AT TIME ZONE $3)::date AS day, SUM(sum) AS total
+			FROM withdrawals, bounds WHERE processed_at >= bounds.since AND processed_at < bounds.until GROUP BY 1
+		) AS w ON w.day = d.day
+		ORDER BY d.day`
+
+	rows, err := s.readDB().Query(ctx, query, from, to, timeZone)
+	if err != nil {
+		return nil, fmt.Errorf("getDailyStats: error aggregating stats: %w", err)
+	}
+	defer rows.Close()
+
+	stats := []models.DailyStats{}
+	for rows.Next() {
+		var day models.DailyStats
+		if err = rows.Scan(&day.Date, &day.Registrations, &day.OrdersUploaded, &day.AccrualCredited, &day.Withdrawn); err != nil {
+			return nil, fmt.Errorf("getDailyStats: error scanning row: %w", err)
+		}
+		stats = append(stats, day)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("getDailyStats: error aggregating stats: %w", err)
+	}
+	return stats, nil
+}
+
+// GetStuckOrders возвращает незавершенные заказы, статус которых не менялся дольше olderThan,
+// начиная с самых давних.
+func (s *Storage) GetStuckOrders(ctx context.Context, olderThan time.Duration, limit, offset int) ([]models.StuckOrder, error) {
+	ctx, done := s.observeQuery(ctx, "getStuckOrders")
+	defer done()
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT o.order_id, o.user_id, o.status, o.uploaded_at, o.last_checked_at,
+			COALESCE(e.changed_at, o.uploaded_at) AS last_updated_at,
+			COALESCE(f.attempt_count, 0), COALESCE(f.last_error, '')
+		FROM orders o
+		LEFT JOIN (
+			SELECT order_id, MAX(changed_at) AS changed_at FROM order_events GROUP BY order_id
+		) AS e ON e.order_id = o.order_id
+		LEFT JOIN failed_updates f ON f.order_id = o.order_id
+		WHERE o.status NOT IN ('INVALID', 'PROCESSED')
+			AND COALESCE(e.changed_at, o.uploaded_at) < NOW() - $1 * INTERVAL '1 millisecond'
+		ORDER BY last_updated_at, o.order_id
+		LIMIT $2 OFFSET $3`
+
+	rows, err := s.readDB().Query(ctx, query, olderThan.Milliseconds(), limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("getStuckOrders: error selecting orders: %w", err)
+	}
+	defer rows.Close()
+
+	orders := []models.StuckOrder{}
+	for rows.Next() {
+		var (
+			order       models.StuckOrder
+			lastChecked sql.NullTime
+		)
+		err = rows.Scan(&order.OrderID, &order.UserID, &order.Status, &order.UploadedAt, &lastChecked,
+			&order.LastUpdatedAt, &order.ErrorCount, &order.LastError)
+		if err != nil {
+			return nil, fmt.Errorf("getStuckOrders: error scanning row: %w", err)
+		}
+		order.UploadedAt, order.LastUpdatedAt = order.UploadedAt.UTC(), order.LastUpdatedAt.UTC()
+		if lastChecked.Valid {
+			lastCheckedAt := lastChecked.Time.UTC()
+			order.LastCheckedAt = &lastCheckedAt
+		}
+		orders = append(orders, order)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("getStuckOrders: error selecting orders: %w", err)
+	}
+	return orders, nil
+}
+
+// SearchUsers ищет пользователей, логин которых содержит query без учета регистра, в порядке логинов.
+// Пустой query возвращает всех пользователей. Удаленные пользователи не возвращаются.
+func (s *Storage) SearchUsers(ctx context.Context, query string, limit, offset int) ([]models.UserProfile, error) {
+	ctx, done := s.observeQuery(ctx, "searchUsers")
+	defer done()
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	// % и _ в запросе ищутся буквально
+	pattern := "%" + likeEscaper.Replace(query) + "%"
+	sqlQuery := `SELECT user_id, login, COALESCE(email, ''), registered_at
+		FROM users
+		WHERE deleted_at IS NULL AND login ILIKE $1
+		ORDER BY login
+		LIMIT $2 OFFSET $3`
+
+	rows, err := s.readDB().Query(ctx, sqlQuery, pattern, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("searchUsers: error selecting users: %w", err)
+	}
+	defer rows.Close()
+
+	users := []models.UserProfile{}
+	for rows.Next() {
+		var user models.UserProfile
+		if err = rows.Scan(&user.UserID, &user.Login, &user.Email, &user.RegisteredAt); err != nil {
+			return nil, fmt.Errorf("searchUsers: error scanning row: %w", err)
+		}
+		user.RegisteredAt = user.RegisteredAt.UTC()
+		users = append(users, user)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("searchUsers: error selecting users: %w", err)
+	}
+	return users, nil
+}
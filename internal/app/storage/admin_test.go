@@ -0,0 +1,137 @@
+package storage
+
+import (
+	"context"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestSearchUsersPagination проверяет, что поиск по подстроке логина без учета регистра отдает
+// страницы в порядке логинов, пропускает удаленных пользователей и ищет % и _ буквально.
+func TestSearchUsersPagination(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	// в постраничную выдачу попадают только строчные логины без знаков: порядок остальных зависит от collation базы
+	for _, login := range []string{"alinka", "bob", "alice", "bo_b", "alina", "boxb"} {
+		registerTestUser(t, s, login)
+	}
+	deletedID := registerTestUser(t, s, "alisa")
+	if err := s.SoftDeleteUser(ctx, deletedID); err != nil {
+		t.Fatalf("soft delete: %v", err)
+	}
+
+	tests := []struct {
+		name       string
+		query      string
+		limit      int
+		offset     int
+		wantLogins []string
+	}{
+		{name: "first page", query: "ali", limit: 2, wantLogins: []string{"alice", "alina"}},
+		{name: "second page", query: "ali", limit: 2, offset: 2, wantLogins: []string{"alinka"}},
+		{name: "past the end", query: "ali", limit: 2, offset: 4, wantLogins: []string{}},
+		{name: "case insensitive", query: "ALIN", limit: 10, wantLogins: []string{"alina", "alinka"}},
+		{name: "underscore is literal", query: "o_b", limit: 10, wantLogins: []string{"bo_b"}},
+		{name: "percent is literal", query: "%", limit: 10, wantLogins: []string{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			users, err := s.SearchUsers(ctx, tt.query, tt.limit, tt.offset)
+			if err != nil {
+				t.Fatalf("search users: %v", err)
+			}
+			logins := make([]string, 0, len(users))
+			for _, user := range users {
+				if user.UserID == "" || user.RegisteredAt.IsZero() {
+					t.Errorf("user %+v has no id or registration time", user)
+				}
+				logins = append(logins, user.Login)
+			}
+			if users == nil || strings.Join(logins, ",") != strings.Join(tt.wantLogins, ",") {
+				t.Errorf("logins = %v, want %v", logins, tt.wantLogins)
+			}
+		})
+	}
+}
+
+// TestGetDailyStats проверяет агрегацию по дням: события попадают в день своего часового пояса,
+// события вне периода не учитываются, а дни без событий возвращаются с нулями.
+func TestGetDailyStats(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	aliceID := registerTestUser(t, s, "alice")
+	bobID := registerTestUser(t, s, "bob")
+	creditTestUser(t, s, aliceID, "79927398713", 100)
+	creditTestUser(t, s, aliceID, "79927398721", 50)
+	creditTestUser(t, s, bobID, "79927398739", 70)
+	if err := s.UseBonuses(ctx, models.APIUseBonusesRequest{OrderNumber: "2377225624", Sum: 30}, aliceID); err != nil {
+		t.Fatalf("withdraw: %v", err)
+	}
+
+	at := func(value string) time.Time {
+		parsed, err := time.Parse(time.RFC3339, value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return parsed
+	}
+	seed := []struct {
+		query string
+		args  []any
+	}{
+		{query: "UPDATE users SET registered_at = $2 WHERE user_id = $1", args: []any{aliceID, at("2024-03-01T10:00:00Z")}},
+		// 21:30 UTC 3 марта — уже 4 марта по Москве
+		{query: "UPDATE users SET registered_at = $2 WHERE user_id = $1", args: []any{bobID, at("2024-03-03T21:30:00Z")}},
+		{query: "UPDATE orders SET uploaded_at = $2 WHERE order_id = $1", args: []any{"79927398713", at("2024-03-01T12:00:00Z")}},
+		{query: "UPDATE orders SET uploaded_at = $2 WHERE order_id = $1", args: []any{"79927398721", at("2024-03-03T12:00:00Z")}},
+		// заказ и начисление до начала периода
+		{query: "UPDATE orders SET uploaded_at = $2 WHERE order_id = $1", args: []any{"79927398739", at("2024-02-28T12:00:00Z")}},
+		{query: "UPDATE balance_transactions SET created_at = $2 WHERE order_id = $1", args: []any{"79927398713", at("2024-03-01T13:00:00Z")}},
+		{query: "UPDATE balance_transactions SET created_at = $2 WHERE order_id = $1", args: []any{"79927398721", at("2024-03-03T12:00:00Z")}},
+		{query: "UPDATE balance_transactions SET created_at = $2 WHERE order_id = $1", args: []any{"79927398739", at("2024-02-28T12:00:00Z")}},
+		{query: "UPDATE balance_transactions SET created_at = $2 WHERE order_id = $1", args: []any{"2377225624", at("2024-03-03T15:00:00Z")}},
+		{query: "UPDATE withdrawals SET processed_at = $2 WHERE order_id = $1", args: []any{"2377225624", at("2024-03-03T15:00:00Z")}},
+	}
+	for _, statement := range seed {
+		if _, err := s.DB.Exec(ctx, statement.query, statement.args...); err != nil {
+			t.Fatalf("seed %s: %v", statement.query, err)
+		}
+	}
+
+	tests := []struct {
+		name     string
+		timeZone string
+		want     []models.DailyStats
+	}{
+		{name: "UTC", timeZone: "UTC", want: []models.DailyStats{
+			{Date: "2024-03-01", Registrations: 1, OrdersUploaded: 1, AccrualCredited: 100},
+			{Date: "2024-03-02"},
+			{Date: "2024-03-03", Registrations: 1, OrdersUploaded: 1, AccrualCredited: 50, Withdrawn: 30},
+			{Date: "2024-03-04"},
+		}},
+		{name: "Moscow", timeZone: "Europe/Moscow", want: []models.DailyStats{
+			{Date: "2024-03-01", Registrations: 1, OrdersUploaded: 1, AccrualCredited: 100},
+			{Date: "2024-03-02"},
+			{Date: "2024-03-03", OrdersUploaded: 1, AccrualCredited: 50, Withdrawn: 30},
+			{Date: "2024-03-04", Registrations: 1},
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			stats, err := s.GetDailyStats(ctx, "2024-03-01", "2024-03-04", tt.timeZone)
+			if err != nil {
+				t.Fatalf("get daily stats: %v", err)
+			}
+			if len(stats) != len(tt.want) {
+				t.Fatalf("stats = %+v, want %d days", stats, len(tt.want))
+			}
+			for i, day := range stats {
+				if day != tt.want[i] {
+					t.Errorf("day %d = %+v, want %+v", i, day, tt.want[i])
+				}
+			}
+		})
+	}
+}
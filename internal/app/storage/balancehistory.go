@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"time"
+)
+
+const recordBalanceTransactionQuery = `INSERT INTO balance_transactions (user_id, order_id, amount, direction, reason)
+	VALUES ($1, $2, $3, $4, $5)`
+
+// recordBalanceTransaction записывает операцию с балансом в транзакции tx, изменившей баланс.
+func recordBalanceTransaction(ctx context.Context, tx pgx.Tx, userID, orderID string, amount float64, direction, reason string) error {
+	if _, err := tx.Exec(ctx, recordBalanceTransactionQuery, userID, orderID, amount, direction, reason); err != nil {
+		return fmt.Errorf("recordBalanceTransaction: error saving %s for order %s: %w", direction, orderID, err)
+	}
+	return nil
+}
+
+// BalanceHistoryFilter ограничивает выборку истории баланса: операции из [From, To) (нулевые
+// границы не ограничивают период), не больше Limit операций, начиная с Offset от самой новой.
+type BalanceHistoryFilter struct {
+	From   time.Time
+	To     time.Time
+	Limit  int
+	Offset int
+}
+
+// GetBalanceHistory возвращает операции с балансом пользователя от новых к старым с балансом после
+// каждой операции. Баланс считается по всей истории, а затем операции фильтруются по периоду.
+func (s *Storage) GetBalanceHistory(ctx context.Context, userID string, filter BalanceHistoryFilter) ([]models.APIBalanceHistoryEntry, error) {
+	ctx, done := s.observeQuery(ctx, "getBalanceHistory")
+	defer done()
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT tx_id, direction, reason, order_id, amount, balance, created_at FROM (
+			SELECT tx_id, direction, reason, order_id, amount::float, created_at,
+				SUM(CASE direction WHEN 'CREDIT' THEN amount ELSE -amount END)
+					OVER (ORDER BY created_at, tx_id)::float AS balance
+			FROM balance_transactions WHERE user_id = $1
+		) AS history
+		WHERE ($2::timestamptz IS NULL OR created_at >= $2) AND ($3::timestamptz IS NULL OR created_at < $3)
+		ORDER BY created_at DESC, tx_id DESC
+		LIMIT $4 OFFSET $5`
+
+	rows, err := s.readDB().Query(ctx, query, userID, nullTime(filter.From), nullTime(filter.To), filter.Limit, filter.Offset)
+	if err != nil {
+		return nil, fmt.Errorf("getBalanceHistory: error getting balance history: %w", err)
+	}
+	defer rows.Close()
+
+	history := []models.APIBalanceHistoryEntry{}
+	for rows.Next() {
+		var entry models.APIBalanceHistoryEntry
+		if err = rows.Scan(&entry.TxID, &entry.Direction, &entry.Reason, &entry.Order, &entry.Amount, &entry.Balance, &entry.CreatedAt); err != nil {
+			return nil, fmt.Errorf("getBalanceHistory: error scanning balance history: %w", err)
+		}
+		entry.CreatedAt = entry.CreatedAt.UTC()
+		history = append(history, entry)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("getBalanceHistory: error getting balance history: %w", err)
+	}
+	return history, nil
+}
+
+func nullTime(t time.Time) sql.NullTime {
+	return sql.NullTime{Time: t, Valid: !t.IsZero()}
+}
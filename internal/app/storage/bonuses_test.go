@@ -0,0 +1,97 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"testing"
+)
+
+// TestUseBonusesRejectsNonPositiveSum проверяет, что списание неположительной суммы не проходит
+// и на уровне базы (ограничение withdrawals_sum_positive) и не увеличивает баланс.
+func TestUseBonusesRejectsNonPositiveSum(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	userID := registerTestUser(t, s, "alice")
+	creditTestUser(t, s, userID, "12345678903", 50)
+
+	for _, sum := range []float64{0, -100} {
+		request := models.APIUseBonusesRequest{OrderNumber: "2377225624", Sum: sum}
+		if err := s.UseBonuses(ctx, request, userID); err == nil {
+			t.Errorf("withdrawal of %v was accepted", sum)
+		}
+	}
+
+	balance, err := s.GetCurrentBonusesAmount(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Current != 50 || balance.Withdrawn != 0 {
+		t.Errorf("balance = %+v, want current 50 and nothing withdrawn", balance)
+	}
+	assertLedgerConsistent(t, s)
+}
+
+func TestEmptyHistories(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	userID := registerTestUser(t, s, "alice")
+
+	withdrawals, err := s.GetWithdrawalsHistory(ctx, userID)
+	if err != nil {
+		t.Fatalf("withdrawal history: %v", err)
+	}
+	if withdrawals == nil || len(withdrawals) != 0 {
+		t.Errorf("withdrawal history = %#v, want an empty list", withdrawals)
+	}
+
+	orders, err := s.GetOrders(ctx, userID, OrdersFilter{})
+	if err != nil {
+		t.Fatalf("orders: %v", err)
+	}
+	if len(orders) != 0 {
+		t.Errorf("orders = %+v, want none", orders)
+	}
+}
+
+// TestEnsureBalanceRowRecreatesDeletedRow проверяет, что начисление пользователю без строки в
+// balances создает ее заново, а не теряет начисление в UPDATE без затронутых строк.
+func TestEnsureBalanceRowRecreatesDeletedRow(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	userID := registerTestUser(t, s, "alice")
+
+	if _, err := s.DB.Exec(ctx, "DELETE FROM balances WHERE user_id = $1", userID); err != nil {
+		t.Fatal(err)
+	}
+	missing, err := s.UsersWithoutBalance(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(missing) != 1 || missing[0] != userID {
+		t.Fatalf("users without balance = %q, want %s", missing, userID)
+	}
+
+	err = s.UseBonuses(ctx, models.APIUseBonusesRequest{OrderNumber: "2377225624", Sum: 10}, userID)
+	if !errors.Is(err, ErrNotEnoughBonuses) {
+		t.Fatalf("withdrawal without a balance row: error = %v, want %v", err, ErrNotEnoughBonuses)
+	}
+
+	creditTestUser(t, s, userID, "12345678903", 50)
+
+	var rows int
+	if err = s.DB.QueryRow(ctx, "SELECT COUNT(*) FROM balances WHERE user_id = $1", userID).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 1 {
+		t.Fatalf("balance rows = %d, want 1", rows)
+	}
+	balance, err := s.GetCurrentBonusesAmount(ctx, userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if balance.Current != 50 {
+		t.Errorf("current balance = %v, want 50", balance.Current)
+	}
+	assertLedgerConsistent(t, s)
+}
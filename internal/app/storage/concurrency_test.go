@@ -0,0 +1,203 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"sync"
+	"testing"
+)
+
+// creditTestUser начисляет пользователю amount бонусов за обработанный заказ orderNumber.
+func creditTestUser(t *testing.T, s *Storage, userID, orderNumber string, amount float64) {
+	t.Helper()
+
+	addTestOrder(t, s, userID, orderNumber)
+	if _, err := s.applyOrderStatus(context.Background(), orderNumber, models.OrderStatusProcessed, &amount); err != nil {
+		t.Fatalf("credit order %s: %v", orderNumber, err)
+	}
+}
+
+// assertLedgerConsistent проверяет, что кешированные балансы совпадают с журналом операций.
+func assertLedgerConsistent(t *testing.T, s *Storage) {
+	t.Helper()
+
+	discrepancies, err := s.GetBalanceDiscrepancies(context.Background())
+	if err != nil {
+		t.Fatalf("get balance discrepancies: %v", err)
+	}
+	if len(discrepancies) != 0 {
+		t.Errorf("balance discrepancies = %+v, want none", discrepancies)
+	}
+}
+
+func TestConcurrentWithdrawalsDoNotOverdraw(t *testing.T) {
+	const (
+		credit      = 100.0
+		sum         = 10.0
+		withdrawals = 20
+	)
+
+	tests := []struct {
+		name      string
+		isolation pgx.TxIsoLevel
+	}{
+		{name: "read committed", isolation: pgx.ReadCommitted},
+		{name: "repeatable read", isolation: pgx.RepeatableRead},
+		{name: "serializable", isolation: pgx.Serializable},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newTestStorage(t, WithFinancialTxIsolation(tt.isolation))
+			ctx := context.Background()
+			userID := registerTestUser(t, s, "alice")
+			creditTestUser(t, s, userID, "12345678903", credit)
+
+			var wg sync.WaitGroup
+			errs := make(chan error, withdrawals)
+			for i := 0; i < withdrawals; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					request := models.APIUseBonusesRequest{OrderNumber: fmt.Sprintf("withdrawal-%d", i), Sum: sum}
+					errs <- s.UseBonuses(ctx, request, userID)
+				}(i)
+			}
+			wg.Wait()
+			close(errs)
+
+			succeeded := 0
+			for err := range errs {
+				switch {
+				case err == nil:
+					succeeded++
+				case errors.Is(err, ErrNotEnoughBonuses):
+				case isRetriable(err) && tt.isolation != pgx.ReadCommitted:
+					// конфликт сериализации, не разрешившийся за maxRetryAttempts повторов
+				default:
+					t.Errorf("unexpected withdrawal error: %v", err)
+				}
+			}
+			if tt.isolation == pgx.ReadCommitted && succeeded != int(credit/sum) {
+				t.Errorf("succeeded withdrawals = %d, want %d", succeeded, int(credit/sum))
+			}
+			if float64(succeeded)*sum > credit {
+				t.Fatalf("%d withdrawals of %v succeeded with a balance of %v", succeeded, sum, credit)
+			}
+
+			balance, err := s.GetCurrentBonusesAmount(ctx, userID)
+			if err != nil {
+				t.Fatalf("get balance: %v", err)
+			}
+			if want := credit - float64(succeeded)*sum; balance.Current != want || balance.Withdrawn != float64(succeeded)*sum {
+				t.Errorf("balance = %+v, want current %v and withdrawn %v", balance, want, float64(succeeded)*sum)
+			}
+			assertLedgerConsistent(t, s)
+		})
+	}
+}
+
+func TestConcurrentCreditsAndDebitsKeepLedger(t *testing.T) {
+	const (
+		orders = 10
+		credit = 30.0
+		debit  = 20.0
+	)
+
+	s := newTestStorage(t, WithFinancialTxIsolation(pgx.ReadCommitted))
+	ctx := context.Background()
+	userID := registerTestUser(t, s, "alice")
+	orderNumbers := make([]string, orders)
+	for i := range orderNumbers {
+		orderNumbers[i] = fmt.Sprintf("order-%d", i)
+		addTestOrder(t, s, userID, orderNumbers[i])
+	}
+
+	var (
+		wg        sync.WaitGroup
+		mu        sync.Mutex
+		withdrawn float64
+	)
+	for i, orderNumber := range orderNumbers {
+		wg.Add(2)
+		go func(orderNumber string) {
+			defer wg.Done()
+			amount := credit
+			if _, err := s.applyOrderStatus(ctx, orderNumber, models.OrderStatusProcessed, &amount); err != nil {
+				t.Errorf("credit order %s: %v", orderNumber, err)
+			}
+		}(orderNumber)
+		go func(i int) {
+			defer wg.Done()
+			request := models.APIUseBonusesRequest{OrderNumber: fmt.Sprintf("withdrawal-%d", i), Sum: debit}
+			err := s.UseBonuses(ctx, request, userID)
+			switch {
+			case err == nil:
+				mu.Lock()
+				withdrawn += debit
+				mu.Unlock()
+			case !errors.Is(err, ErrNotEnoughBonuses):
+				t.Errorf("withdrawal %d: %v", i, err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	balance, err := s.GetCurrentBonusesAmount(ctx, userID)
+	if err != nil {
+		t.Fatalf("get balance: %v", err)
+	}
+	if want := orders*credit - withdrawn; balance.Current != want || balance.Withdrawn != withdrawn {
+		t.Errorf("balance = %+v, want current %v and withdrawn %v", balance, want, withdrawn)
+	}
+	assertLedgerConsistent(t, s)
+}
+
+func TestConcurrentRegistrationsDoNotDuplicateLogin(t *testing.T) {
+	const registrations = 10
+
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	var wg sync.WaitGroup
+	errs := make(chan error, registrations)
+	for i := 0; i < registrations; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// логины сравниваются без учета регистра
+			login := "alice"
+			if i%2 == 1 {
+				login = "Alice"
+			}
+			_, err := s.RegisterUser(ctx, login, "", "password")
+			errs <- err
+		}(i)
+	}
+	wg.Wait()
+	close(errs)
+
+	succeeded := 0
+	for err := range errs {
+		switch {
+		case err == nil:
+			succeeded++
+		case !errors.Is(err, ErrUsernameNotUnique):
+			t.Errorf("unexpected registration error: %v", err)
+		}
+	}
+	if succeeded != 1 {
+		t.Errorf("succeeded registrations = %d, want 1", succeeded)
+	}
+
+	var users, balances int
+	query := "SELECT COUNT(*), (SELECT COUNT(*) FROM balances) FROM users WHERE LOWER(login) = 'alice'"
+	if err := s.DB.QueryRow(ctx, query).Scan(&users, &balances); err != nil {
+		t.Fatal(err)
+	}
+	if users != 1 || balances != 1 {
+		t.Errorf("users, balances = %d, %d, want 1, 1", users, balances)
+	}
+}
@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+)
+
+// DispatchQueue — очередь заказов, статус которых запрашивается сразу после добавления.
+type DispatchQueue interface {
+	Enqueue(orderNumber string) bool
+	Orders() <-chan string
+}
+
+// WithDispatchQueue включает немедленный запрос статуса добавленных заказов через queue.
+func WithDispatchQueue(queue DispatchQueue) Option {
+	return func(s *Storage) {
+		s.dispatchQueue = queue
+	}
+}
+
+// dispatchOrders ставит добавленные заказы в очередь немедленного обновления. Заказы, не
+// поместившиеся в очередь, дождутся периодического опроса.
+func (s *Storage) dispatchOrders(orderNumbers ...string) {
+	if s.dispatchQueue == nil {
+		return
+	}
+	for _, orderNumber := range orderNumbers {
+		if !s.dispatchQueue.Enqueue(orderNumber) {
+			return
+		}
+	}
+}
+
+// RunDispatchedUpdates обновляет статусы заказов из очереди тем же пулом воркеров, что и периодический
+// опрос. Завершается, когда очередь закрыта и обработана до конца или отменен ctx.
+func (s *Storage) RunDispatchedUpdates(ctx context.Context, logger logger.Logger) {
+	if s.dispatchQueue == nil {
+		return
+	}
+
+	orderBatches := make(chan []string)
+	go func() {
+		defer close(orderBatches)
+		for orderNumber := range s.dispatchQueue.Orders() {
+			select {
+			case orderBatches <- []string{orderNumber}:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	var resultChannels []<-chan UpdateResult
+	for i := 0; i < s.accrualWorkers; i++ {
+		resultChannels = append(resultChannels, s.prepareAndUpdateOrderStatus(ctx, orderBatches))
+	}
+	results := mergeChannels(ctx, resultChannels...)
+
+	// после 429 потребитель возвращает управление, остальные заказы очереди продолжают обрабатываться
+	for s.orderStatusConsumer(ctx, results, logger) {
+	}
+}
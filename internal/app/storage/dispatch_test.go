@@ -0,0 +1,58 @@
+package storage
+
+import (
+	"context"
+	"github.com/vancho-go/gophermart/internal/app/accrual/accrualtest"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"github.com/vancho-go/gophermart/internal/app/updater"
+	"testing"
+	"time"
+)
+
+// TestAddOrderDispatchesImmediately проверяет, что статус добавленного заказа запрашивается через
+// очередь сразу, без периодического цикла обновления.
+func TestAddOrderDispatchesImmediately(t *testing.T) {
+	const orderNumber = "12345678903"
+
+	queue := updater.NewQueue(10)
+	s, server := newAccrualTestStorage(t, WithDispatchQueue(queue))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	userID := registerTestUser(t, s, "alice")
+	server.Script(orderNumber, accrualtest.Processed(100))
+
+	dispatcherDone := make(chan struct{})
+	go func() {
+		defer close(dispatcherDone)
+		s.RunDispatchedUpdates(ctx, logger.NewNopLogger())
+	}()
+
+	addTestOrder(t, s, userID, orderNumber)
+
+	deadline := time.Now().Add(time.Second * 5)
+	for {
+		orders, err := s.GetOrders(context.Background(), userID, OrdersFilter{})
+		if err != nil {
+			t.Fatalf("get orders: %v", err)
+		}
+		if len(orders) == 1 && orders[0].Status == models.OrderStatusProcessed {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("orders = %+v, want the order processed without an update cycle", orders)
+		}
+		time.Sleep(time.Millisecond * 20)
+	}
+	assertOrderState(t, s, userID, models.OrderStatusProcessed, 100)
+	if requests := server.Requests(orderNumber); requests != 1 {
+		t.Errorf("accrual requests = %d, want 1", requests)
+	}
+
+	queue.Close()
+	select {
+	case <-dispatcherDone:
+	case <-time.After(time.Second * 5):
+		t.Fatal("dispatcher did not stop after the queue was closed")
+	}
+}
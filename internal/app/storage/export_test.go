@@ -0,0 +1,16 @@
+package storage
+
+import "github.com/vancho-go/gophermart/internal/app/events"
+
+// Помощники для внешних тестов storage_test, которые проверяют хранилище вместе с обработчиками:
+// пакет handlers импортирует storage, поэтому такие тесты не могут быть в пакете storage.
+var (
+	NewAccrualTestStorage = newAccrualTestStorage
+	RegisterTestUser      = registerTestUser
+	AddTestOrder          = addTestOrder
+)
+
+// EventBus возвращает шину, в которую хранилище публикует изменения статусов заказов.
+func (s *Storage) EventBus() *events.EventBus {
+	return s.events
+}
@@ -0,0 +1,146 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/vancho-go/gophermart/internal/app/accrual"
+	"github.com/vancho-go/gophermart/internal/app/models"
+)
+
+// defaultMaxUpdateAttempts — после стольких неудачных попыток обновления подряд заказ «умирает»:
+// перестает опрашиваться и остается в failed_updates, пока администратор не вернет его в опрос.
+// Пользователь продолжает видеть прежний статус заказа.
+const defaultMaxUpdateAttempts = 5
+
+// WithMaxUpdateAttempts задает число неудачных попыток обновления подряд, после которого
+// заказ исключается из опроса.
+func WithMaxUpdateAttempts(attempts int) Option {
+	return func(s *Storage) {
+		s.maxUpdateAttempts = attempts
+	}
+}
+
+// isTransientUpdateError сообщает, что ошибка обновления вызвана не заказом, а состоянием
+// accrual-системы или самого сервиса, и не засчитывается в неудачные попытки: 429, разомкнутая
+// цепь, заказ еще не зарегистрирован, истекший цикл обновления, недоступная БД. Остальные
+// ошибки (500, некорректный ответ) считаются постоянными.
+func isTransientUpdateError(err error) bool {
+	return errors.Is(err, accrual.ErrTooManyRequests) ||
+		errors.Is(err, accrual.ErrCircuitOpen) ||
+		errors.Is(err, accrual.ErrOrderNotRegistered) ||
+		errors.Is(err, context.Canceled) ||
+		errors.Is(err, context.DeadlineExceeded) ||
+		errors.Is(err, ErrDatabaseUnavailable)
+}
+
+// failedOrderUpdate — ошибка обновления заказа с числом неудачных попыток подряд.
+type failedOrderUpdate struct {
+	orderNumber string
+	attempts    int
+	err         error
+}
+
+func (e *failedOrderUpdate) Error() string {
+	return fmt.Sprintf("order %s update failed (attempt %d): %v", e.orderNumber, e.attempts, e.err)
+}
+
+func (e *failedOrderUpdate) Unwrap() error {
+	return e.err
+}
+
+// recordFailedUpdate увеличивает счетчик неудачных попыток обновления заказа и возвращает его значение.
+func (s *Storage) recordFailedUpdate(ctx context.Context, orderNumber string, updateErr error) (int, error) {
+	query := `INSERT INTO failed_updates (order_id, attempt_count, last_error, last_attempt)
+		VALUES ($1, 1, $2, CURRENT_TIMESTAMP)
+		ON CONFLICT (order_id) DO UPDATE
+		SET attempt_count = failed_updates.attempt_count + 1, last_error = EXCLUDED.last_error, last_attempt = EXCLUDED.last_attempt
+		RETURNING attempt_count`
+
+	var attempts int
+	if err := s.DB.QueryRow(ctx, query, orderNumber, updateErr.Error()).Scan(&attempts); err != nil {
+		return 0, fmt.Errorf("recordFailedUpdate: error saving failed update: %w", err)
+	}
+	return attempts, nil
+}
+
+// clearFailedUpdate сбрасывает счетчик неудачных попыток после успешного обновления.
+func (s *Storage) clearFailedUpdate(ctx context.Context, orderNumber string) error {
+	query := "DELETE FROM failed_updates WHERE order_id = $1"
+	if _, err := s.DB.Exec(ctx, query, orderNumber); err != nil {
+		return fmt.Errorf("clearFailedUpdate: error deleting failed update: %w", err)
+	}
+	return nil
+}
+
+// ListDeadOrders возвращает заказы, исключенные из опроса после maxUpdateAttempts неудач подряд.
+func (s *Storage) ListDeadOrders(ctx context.Context) ([]models.DeadOrder, error) {
+	ctx, done := s.observeQuery(ctx, "listDeadOrders")
+	defer done()
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT o.order_id, o.user_id, o.status, f.attempt_count, COALESCE(f.last_error, ''), f.last_attempt
+		FROM failed_updates f
+		JOIN orders o ON o.order_id = f.order_id
+		WHERE f.attempt_count >= $1
+		ORDER BY f.last_attempt`
+	rows, err := s.DB.Query(ctx, query, s.maxUpdateAttempts)
+	if err != nil {
+		return nil, fmt.Errorf("listDeadOrders: error selecting orders: %w", err)
+	}
+	defer rows.Close()
+
+	orders := []models.DeadOrder{}
+	for rows.Next() {
+		var order models.DeadOrder
+		err = rows.Scan(&order.OrderID, &order.UserID, &order.Status, &order.Attempts, &order.LastError, &order.LastAttempt)
+		if err != nil {
+			return nil, fmt.Errorf("listDeadOrders: error scanning row: %w", err)
+		}
+		order.LastAttempt = order.LastAttempt.UTC()
+		orders = append(orders, order)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("listDeadOrders: error selecting orders: %w", err)
+	}
+	return orders, nil
+}
+
+// RequeueOrder сбрасывает счетчик неудачных попыток заказа и возвращает его в опрос первым
+// в очереди. Возвращает ErrOrderNotFound для неизвестного заказа.
+func (s *Storage) RequeueOrder(ctx context.Context, orderID string) error {
+	ctx, done := s.observeQuery(ctx, "requeueOrder")
+	defer done()
+
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("requeueOrder: transaction error: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var status models.OrderStatus
+	query := "UPDATE orders SET last_checked_at = NULL WHERE order_id = $1 RETURNING status"
+	err = tx.QueryRow(ctx, query, orderID).Scan(&status)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("requeueOrder: %w", ErrOrderNotFound)
+	} else if err != nil {
+		return fmt.Errorf("requeueOrder: error resetting order %s: %w", orderID, err)
+	}
+
+	query = "DELETE FROM failed_updates WHERE order_id = $1"
+	if _, err = tx.Exec(ctx, query, orderID); err != nil {
+		return fmt.Errorf("requeueOrder: error deleting failed update: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("requeueOrder: error committing transaction: %w", err)
+	}
+
+	if !status.IsFinal() {
+		s.dispatchOrders(orderID)
+	}
+	return nil
+}
@@ -0,0 +1,108 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/vancho-go/gophermart/internal/app/accrual/accrualtest"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"testing"
+)
+
+// TestPersistentFailuresMarkOrderDead проверяет, что после maxUpdateAttempts ответов 500 подряд
+// заказ исключается из опроса с прежним статусом, а RequeueOrder возвращает его в опрос.
+func TestPersistentFailuresMarkOrderDead(t *testing.T) {
+	const (
+		orderNumber = "12345678903"
+		maxAttempts = 3
+	)
+
+	s, server := newAccrualTestStorage(t, WithMaxUpdateAttempts(maxAttempts))
+	ctx := context.Background()
+	userID := registerTestUser(t, s, "alice")
+	addTestOrder(t, s, userID, orderNumber)
+	server.Script(orderNumber, accrualtest.InternalError())
+
+	for attempt := 1; attempt <= maxAttempts; attempt++ {
+		dead, err := s.ListDeadOrders(ctx)
+		if err != nil {
+			t.Fatalf("list dead orders: %v", err)
+		}
+		if len(dead) != 0 {
+			t.Fatalf("before attempt %d dead orders = %+v, want none", attempt, dead)
+		}
+		if err = s.HandleOrderNumbers(ctx, logger.NewNopLogger()); err != nil {
+			t.Fatalf("update cycle %d: %v", attempt, err)
+		}
+		if requests := server.Requests(orderNumber); requests != attempt {
+			t.Fatalf("after cycle %d accrual requests = %d, want %d", attempt, requests, attempt)
+		}
+	}
+
+	dead, err := s.ListDeadOrders(ctx)
+	if err != nil {
+		t.Fatalf("list dead orders: %v", err)
+	}
+	if len(dead) != 1 || dead[0].OrderID != orderNumber || dead[0].UserID != userID ||
+		dead[0].Status != models.OrderStatusNew || dead[0].Attempts != maxAttempts || dead[0].LastError == "" {
+		t.Fatalf("dead orders = %+v, want order %s in NEW after %d attempts", dead, orderNumber, maxAttempts)
+	}
+
+	// мертвый заказ больше не опрашивается, пользователь видит прежний статус
+	if err = s.HandleOrderNumbers(ctx, logger.NewNopLogger()); err != nil {
+		t.Fatalf("update cycle: %v", err)
+	}
+	if requests := server.Requests(orderNumber); requests != maxAttempts {
+		t.Errorf("dead order was polled again: %d requests", requests)
+	}
+	assertOrderState(t, s, userID, models.OrderStatusNew, 0)
+
+	server.Script(orderNumber, accrualtest.Processed(100))
+	if err = s.RequeueOrder(ctx, orderNumber); err != nil {
+		t.Fatalf("requeue: %v", err)
+	}
+	if dead, err = s.ListDeadOrders(ctx); err != nil || len(dead) != 0 {
+		t.Fatalf("after requeue dead orders = %+v, error %v; want none", dead, err)
+	}
+	if err = s.HandleOrderNumbers(ctx, logger.NewNopLogger()); err != nil {
+		t.Fatalf("update cycle: %v", err)
+	}
+	assertOrderState(t, s, userID, models.OrderStatusProcessed, 100)
+}
+
+// TestFailuresResetAfterSuccess проверяет, что счетчик считает только неудачи подряд: успешный
+// ответ между ошибками 500 обнуляет его.
+func TestFailuresResetAfterSuccess(t *testing.T) {
+	const (
+		orderNumber = "12345678903"
+		maxAttempts = 2
+	)
+
+	s, server := newAccrualTestStorage(t, WithMaxUpdateAttempts(maxAttempts))
+	ctx := context.Background()
+	userID := registerTestUser(t, s, "alice")
+	addTestOrder(t, s, userID, orderNumber)
+	server.Script(orderNumber, accrualtest.InternalError(), accrualtest.Processing(), accrualtest.InternalError(),
+		accrualtest.Processed(50))
+
+	for cycle := 1; cycle <= 4; cycle++ {
+		if err := s.HandleOrderNumbers(ctx, logger.NewNopLogger()); err != nil {
+			t.Fatalf("update cycle %d: %v", cycle, err)
+		}
+		dead, err := s.ListDeadOrders(ctx)
+		if err != nil {
+			t.Fatalf("list dead orders: %v", err)
+		}
+		if len(dead) != 0 {
+			t.Fatalf("after cycle %d dead orders = %+v, want none", cycle, dead)
+		}
+	}
+	assertOrderState(t, s, userID, models.OrderStatusProcessed, 50)
+}
+
+func TestRequeueUnknownOrder(t *testing.T) {
+	s := newTestStorage(t)
+	if err := s.RequeueOrder(context.Background(), "12345678903"); !errors.Is(err, ErrOrderNotFound) {
+		t.Errorf("requeue unknown order: %v, want ErrOrderNotFound", err)
+	}
+}
@@ -0,0 +1,106 @@
+package storage
+
+import (
+	"context"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"go.uber.org/zap"
+	"sync"
+	"time"
+)
+
+// unhealthyThreshold — число неудачных проверок подряд, после которого БД считается недоступной
+// и фоновое обновление заказов приостанавливается.
+const unhealthyThreshold = 3
+
+type healthState struct {
+	mu                  sync.RWMutex
+	healthy             bool
+	lastCheck           time.Time
+	lastError           string
+	consecutiveFailures int
+}
+
+// RunHealthCheck периодически проверяет соединение с БД и репликой для чтения и логирует смену состояния.
+func (s *Storage) RunHealthCheck(ctx context.Context, interval time.Duration, logger logger.Logger) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		checkHealth(ctx, s.DB, &s.health, interval, logger)
+		if s.replica != nil {
+			checkHealth(ctx, s.replica, &s.replicaHealth, interval, logger.With(zap.String("db", "replica")))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func checkHealth(ctx context.Context, db *pgxpool.Pool, health *healthState, timeout time.Duration, logger logger.Logger) {
+	pingCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	err := db.Ping(pingCtx)
+
+	health.mu.Lock()
+	defer health.mu.Unlock()
+
+	health.lastCheck = time.Now()
+	if err != nil {
+		health.lastError = err.Error()
+		health.consecutiveFailures++
+		if health.healthy && health.consecutiveFailures >= unhealthyThreshold {
+			health.healthy = false
+			logger.Error("checkHealth: database became unavailable", zap.Int("failures", health.consecutiveFailures), zap.Error(err))
+		} else {
+			logger.Warn("checkHealth: database ping failed", zap.Int("failures", health.consecutiveFailures), zap.Error(err))
+		}
+		return
+	}
+
+	if !health.healthy {
+		logger.Info("checkHealth: database connection restored")
+	}
+	health.healthy = true
+	health.lastError = ""
+	health.consecutiveFailures = 0
+}
+
+func (s *Storage) Health() models.DBHealth {
+	s.health.mu.RLock()
+	defer s.health.mu.RUnlock()
+
+	return models.DBHealth{
+		Healthy:   s.health.healthy,
+		LastCheck: s.health.lastCheck,
+		Error:     s.health.lastError,
+	}
+}
+
+func (s *Storage) isHealthy() bool {
+	return s.health.isHealthy()
+}
+
+func (h *healthState) isHealthy() bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	return h.healthy
+}
+
+// readDB возвращает пул для читающих запросов: реплику, если она настроена и доступна, иначе основную БД.
+// Реплика отстает от основной БД, поэтому только что добавленный заказ или списание может
+// появиться в списках с задержкой репликации.
+func (s *Storage) readDB() *pgxpool.Pool {
+	if s.replica != nil && s.replicaHealth.isHealthy() {
+		return s.replica
+	}
+	return s.DB
+}
+
+// Stats возвращает статистику пула соединений для диагностики.
+func (s *Storage) Stats() *pgxpool.Stat {
+	return s.DB.Stat()
+}
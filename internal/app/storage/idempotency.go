@@ -0,0 +1,151 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"time"
+)
+
+var (
+	ErrIdempotencyKeyInProgress = errors.New("request with this idempotency key is still in progress")
+	// ErrIdempotencyKeyReused — ключ уже использован для запроса с другим методом, путем или телом.
+	ErrIdempotencyKeyReused = errors.New("idempotency key was used for a different request")
+)
+
+// staleIdempotencyReservation — через сколько резерв ключа без ответа считается брошенным
+// упавшим экземпляром и может быть перехвачен. Больше времени обработки любого запроса вместе
+// с сохранением ответа, чтобы не перехватить резерв запроса, который еще выполняется.
+const staleIdempotencyReservation = time.Minute
+
+func WithIdempotencyKeyTTL(ttl time.Duration) Option {
+	return func(s *Storage) {
+		s.idempotencyKeyTTL = ttl
+	}
+}
+
+// ReserveIdempotencyKey резервирует ключ за пользователем для запроса с хешем requestHash. Если
+// ключ уже использовался и запрос завершен, возвращает сохраненный ответ; если запрос еще
+// выполняется — ErrIdempotencyKeyInProgress; если ключ использован для другого запроса —
+// ErrIdempotencyKeyReused. Резерв старше staleIdempotencyReservation без ответа перехватывается.
+// Если ключ успешно зарезервирован, возвращает nil, nil.
+func (s *Storage) ReserveIdempotencyKey(ctx context.Context, userID, key, requestHash string) (*models.IdempotentResponse, error) {
+	ctx, done := s.observeQuery(ctx, "reserveIdempotencyKey")
+	defer done()
+
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reserveIdempotencyKey: transaction error: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	query := "DELETE FROM idempotency_keys WHERE user_id = $1 AND idempotency_key = $2 AND created_at < $3"
+	_, err = tx.Exec(ctx, query, userID, key, time.Now().Add(-s.idempotencyKeyTTL))
+	if err != nil {
+		return nil, fmt.Errorf("reserveIdempotencyKey: error deleting expired key: %w", err)
+	}
+
+	query = "INSERT INTO idempotency_keys (user_id, idempotency_key, request_hash) VALUES ($1, $2, $3) ON CONFLICT DO NOTHING"
+	result, err := tx.Exec(ctx, query, userID, key, requestHash)
+	if err != nil {
+		return nil, fmt.Errorf("reserveIdempotencyKey: error inserting key: %w", err)
+	}
+
+	if result.RowsAffected() == 0 {
+		var (
+			status     sql.NullInt32
+			header     []byte
+			body       []byte
+			storedHash sql.NullString
+			stale      bool
+		)
+		query = `SELECT response_status, response_headers, response_body, request_hash, reserved_at < $3
+			FROM idempotency_keys WHERE user_id = $1 AND idempotency_key = $2 FOR UPDATE`
+		err = tx.QueryRow(ctx, query, userID, key, time.Now().Add(-staleIdempotencyReservation)).
+			Scan(&status, &header, &body, &storedHash, &stale)
+		if err != nil {
+			return nil, fmt.Errorf("reserveIdempotencyKey: error scanning stored response: %w", err)
+		}
+		// у ключей, сохраненных до миграции 16, хеша запроса нет
+		if storedHash.Valid && storedHash.String != requestHash {
+			return nil, fmt.Errorf("reserveIdempotencyKey: %w", ErrIdempotencyKeyReused)
+		}
+		if status.Valid {
+			response := &models.IdempotentResponse{Status: int(status.Int32), Body: body}
+			if header != nil {
+				if err = json.Unmarshal(header, &response.Header); err != nil {
+					return nil, fmt.Errorf("reserveIdempotencyKey: error decoding stored headers: %w", err)
+				}
+			}
+			return response, nil
+		}
+		if !stale {
+			return nil, fmt.Errorf("reserveIdempotencyKey: %w", ErrIdempotencyKeyInProgress)
+		}
+
+		query = `UPDATE idempotency_keys SET reserved_at = CURRENT_TIMESTAMP, request_hash = $3
+			WHERE user_id = $1 AND idempotency_key = $2`
+		if _, err = tx.Exec(ctx, query, userID, key, requestHash); err != nil {
+			return nil, fmt.Errorf("reserveIdempotencyKey: error taking over stale reservation: %w", err)
+		}
+	}
+
+	err = tx.Commit(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("reserveIdempotencyKey: error committing transaction: %w", err)
+	}
+	return nil, nil
+}
+
+func (s *Storage) SaveIdempotentResponse(ctx context.Context, userID, key string, response models.IdempotentResponse) error {
+	ctx, done := s.observeQuery(ctx, "saveIdempotentResponse")
+	defer done()
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	header, err := json.Marshal(response.Header)
+	if err != nil {
+		return fmt.Errorf("saveIdempotentResponse: error encoding headers: %w", err)
+	}
+
+	query := `UPDATE idempotency_keys SET response_status = $1, response_headers = $2, response_body = $3
+		WHERE user_id = $4 AND idempotency_key = $5`
+	_, err = s.DB.Exec(ctx, query, response.Status, header, response.Body, userID, key)
+	if err != nil {
+		return fmt.Errorf("saveIdempotentResponse: error saving response: %w", err)
+	}
+	return nil
+}
+
+// ReleaseIdempotencyKey удаляет резерв ключа, чтобы клиент мог повторить запрос.
+func (s *Storage) ReleaseIdempotencyKey(ctx context.Context, userID, key string) error {
+	ctx, done := s.observeQuery(ctx, "releaseIdempotencyKey")
+	defer done()
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	query := "DELETE FROM idempotency_keys WHERE user_id = $1 AND idempotency_key = $2"
+	_, err := s.DB.Exec(ctx, query, userID, key)
+	if err != nil {
+		return fmt.Errorf("releaseIdempotencyKey: error deleting key: %w", err)
+	}
+	return nil
+}
+
+func (s *Storage) DeleteExpiredIdempotencyKeys(ctx context.Context) (int64, error) {
+	ctx, done := s.observeQuery(ctx, "deleteExpiredIdempotencyKeys")
+	defer done()
+
+	query := "DELETE FROM idempotency_keys WHERE created_at < $1"
+	result, err := s.DB.Exec(ctx, query, time.Now().Add(-s.idempotencyKeyTTL))
+	if err != nil {
+		return 0, fmt.Errorf("deleteExpiredIdempotencyKeys: error deleting keys: %w", err)
+	}
+	deleted := result.RowsAffected()
+	return deleted, nil
+}
@@ -0,0 +1,73 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"net/http"
+	"testing"
+)
+
+func TestReserveIdempotencyKey(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	userID := registerTestUser(t, s, "idempotent")
+
+	stored, err := s.ReserveIdempotencyKey(ctx, userID, "key-1", "hash-a")
+	if err != nil || stored != nil {
+		t.Fatalf("first reservation = %v, %v; want nil, nil", stored, err)
+	}
+
+	if _, err = s.ReserveIdempotencyKey(ctx, userID, "key-1", "hash-a"); !errors.Is(err, ErrIdempotencyKeyInProgress) {
+		t.Fatalf("reservation in progress error = %v, want %v", err, ErrIdempotencyKeyInProgress)
+	}
+	if _, err = s.ReserveIdempotencyKey(ctx, userID, "key-1", "hash-b"); !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Fatalf("reservation with another request error = %v, want %v", err, ErrIdempotencyKeyReused)
+	}
+
+	response := models.IdempotentResponse{
+		Status: http.StatusOK,
+		Header: map[string][]string{"Content-Type": {"application/json"}},
+		Body:   []byte(`{"ok":true}`),
+	}
+	if err = s.SaveIdempotentResponse(ctx, userID, "key-1", response); err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err = s.ReserveIdempotencyKey(ctx, userID, "key-1", "hash-a")
+	if err != nil || stored == nil {
+		t.Fatalf("replay = %v, %v; want stored response", stored, err)
+	}
+	if stored.Status != response.Status || string(stored.Body) != string(response.Body) ||
+		http.Header(stored.Header).Get("Content-Type") != "application/json" {
+		t.Errorf("replayed response = %+v, want %+v", stored, response)
+	}
+	if _, err = s.ReserveIdempotencyKey(ctx, userID, "key-1", "hash-b"); !errors.Is(err, ErrIdempotencyKeyReused) {
+		t.Errorf("completed key with another request error = %v, want %v", err, ErrIdempotencyKeyReused)
+	}
+}
+
+// TestReserveIdempotencyKeyTakesOverStaleReservation проверяет, что резерв, брошенный упавшим
+// экземпляром без ответа, перехватывается после staleIdempotencyReservation.
+func TestReserveIdempotencyKeyTakesOverStaleReservation(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	userID := registerTestUser(t, s, "crashed")
+
+	if _, err := s.ReserveIdempotencyKey(ctx, userID, "key-1", "hash-a"); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.DB.Exec(ctx, "UPDATE idempotency_keys SET reserved_at = reserved_at - make_interval(secs => $1) WHERE user_id = $2",
+		(staleIdempotencyReservation * 2).Seconds(), userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stored, err := s.ReserveIdempotencyKey(ctx, userID, "key-1", "hash-a")
+	if err != nil || stored != nil {
+		t.Fatalf("takeover = %v, %v; want nil, nil", stored, err)
+	}
+	if _, err = s.ReserveIdempotencyKey(ctx, userID, "key-1", "hash-a"); !errors.Is(err, ErrIdempotencyKeyInProgress) {
+		t.Errorf("reservation after takeover error = %v, want %v", err, ErrIdempotencyKeyInProgress)
+	}
+}
@@ -0,0 +1,98 @@
+//go:build integration
+
+package storage
+
+import (
+	"context"
+	"strings"
+	"testing"
+)
+
+// TestHotQueriesUseIndexes проверяет по EXPLAIN, что выборки по пользователю, опрос
+// необработанных заказов и чтение баланса идут по индексам, а не последовательным сканированием.
+// Последовательное сканирование запрещено в транзакции: на маленьких тестовых таблицах
+// планировщик выбрал бы его и при наличии индекса.
+func TestHotQueriesUseIndexes(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	seed := []string{
+		`INSERT INTO users (user_id, login, password)
+			SELECT 'index-user-' || g, 'index-login-' || g, '' FROM generate_series(1, 50) g`,
+		`INSERT INTO orders (order_id, user_id, status, uploaded_at)
+			SELECT 'index-order-' || g, 'index-user-' || (g % 50 + 1),
+				(ARRAY['NEW', 'PROCESSING', 'INVALID', 'PROCESSED'])[g % 4 + 1], NOW() - g * INTERVAL '1 minute'
+			FROM generate_series(1, 5000) g`,
+		`INSERT INTO withdrawals (user_id, order_id, sum, processed_at)
+			SELECT 'index-user-' || (g % 50 + 1), 'index-withdrawal-' || g, 1, NOW() - g * INTERVAL '1 minute'
+			FROM generate_series(1, 2000) g`,
+		`ANALYZE users, orders, withdrawals, balances, balance_transactions`,
+	}
+	for _, query := range seed {
+		if _, err := s.DB.Exec(ctx, query); err != nil {
+			t.Fatalf("seed: %v", err)
+		}
+	}
+
+	tests := []struct {
+		name      string
+		query     string
+		wantIndex []string
+	}{
+		{name: "orders of a user", wantIndex: []string{"orders_user_uploaded_idx"},
+			query: `SELECT order_id, uploaded_at, status, accrual FROM orders WHERE user_id = 'index-user-1' ORDER BY uploaded_at, order_id`},
+		{name: "withdrawals of a user", wantIndex: []string{"withdrawals_user_processed_idx"},
+			query: `SELECT order_id, sum, processed_at FROM withdrawals WHERE user_id = 'index-user-1' ORDER BY processed_at`},
+		{name: "withdrawn sum", wantIndex: []string{"balance_transactions_user_created_idx"},
+			query: `SELECT COALESCE(SUM(amount), 0.0)::float FROM balance_transactions WHERE user_id = 'index-user-1' AND reason = 'withdrawal'`},
+		{name: "current balance", wantIndex: []string{"balances_pkey"},
+			query: `SELECT current FROM balances WHERE user_id = 'index-user-1'`},
+		{name: "pending orders", wantIndex: []string{"orders_pending_last_checked_idx", "orders_status_pending_idx"},
+			query: `SELECT order_id FROM orders WHERE status NOT IN ('INVALID', 'PROCESSED')
+				ORDER BY last_checked_at NULLS FIRST, uploaded_at LIMIT 100`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			plan := explain(t, s, tt.query)
+			for _, index := range tt.wantIndex {
+				if strings.Contains(plan, index) {
+					return
+				}
+			}
+			t.Errorf("plan does not use %s:\n%s", strings.Join(tt.wantIndex, " or "), plan)
+		})
+	}
+}
+
+// explain возвращает план запроса query с запрещенным последовательным сканированием.
+func explain(t *testing.T, s *Storage, query string) string {
+	t.Helper()
+
+	ctx := context.Background()
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tx.Rollback(ctx)
+	if _, err = tx.Exec(ctx, "SET LOCAL enable_seqscan = off"); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := tx.Query(ctx, "EXPLAIN "+query)
+	if err != nil {
+		t.Fatalf("explain: %v", err)
+	}
+	defer rows.Close()
+	var plan []string
+	for rows.Next() {
+		var line string
+		if err = rows.Scan(&line); err != nil {
+			t.Fatal(err)
+		}
+		plan = append(plan, line)
+	}
+	if err = rows.Err(); err != nil {
+		t.Fatal(err)
+	}
+	return strings.Join(plan, "\n")
+}
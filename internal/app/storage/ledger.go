@@ -0,0 +1,202 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/vancho-go/gophermart/internal/app/models"
+)
+
+// ledgerBalanceQuery — баланс пользователя $1 по журналу balance_transactions.
+const ledgerBalanceQuery = `SELECT COALESCE(SUM(CASE direction WHEN 'CREDIT' THEN amount ELSE -amount END), 0)
+	FROM balance_transactions WHERE user_id = $1`
+
+const ensureBalanceRowQuery = "INSERT INTO balances (user_id) VALUES ($1) ON CONFLICT (user_id) DO NOTHING"
+
+// ensureBalanceRow создает нулевой баланс пользователя, если строки в balances нет, например
+// после сбоя при регистрации или ручного удаления. Вызывается в транзакции до чтения или
+// изменения баланса, чтобы UPDATE не завершался молча без затронутых строк.
+func ensureBalanceRow(ctx context.Context, tx pgx.Tx, userID string) error {
+	if _, err := tx.Exec(ctx, ensureBalanceRowQuery, userID); err != nil {
+		return fmt.Errorf("ensureBalanceRow: error creating balance for user %s: %w", userID, err)
+	}
+	return nil
+}
+
+// UsersWithoutBalance возвращает пользователей, у которых нет строки в balances.
+func (s *Storage) UsersWithoutBalance(ctx context.Context) ([]string, error) {
+	query := `SELECT u.user_id FROM users u
+		WHERE NOT EXISTS (SELECT 1 FROM balances b WHERE b.user_id = u.user_id)
+		ORDER BY u.user_id`
+	rows, err := s.DB.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("usersWithoutBalance: error selecting users: %w", err)
+	}
+	defer rows.Close()
+
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err = rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("usersWithoutBalance: error scanning row: %w", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("usersWithoutBalance: error selecting users: %w", err)
+	}
+	return userIDs, nil
+}
+
+// RebuildBalance пересчитывает кешированный баланс пользователя по журналу операций и
+// возвращает новое значение.
+func (s *Storage) RebuildBalance(ctx context.Context, userID string) (float64, error) {
+	ctx, done := s.observeQuery(ctx, "rebuildBalance")
+	defer done()
+
+	tx, err := s.DB.BeginTx(ctx, s.financialTxOptions())
+	if err != nil {
+		return 0, fmt.Errorf("rebuildBalance: transaction error: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	var balance float64
+	query := "UPDATE balances SET current = (" + ledgerBalanceQuery + ") WHERE user_id = $1 RETURNING current::float"
+	err = tx.QueryRow(ctx, query, userID).Scan(&balance)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return 0, fmt.Errorf("rebuildBalance: %w", ErrUserNotFound)
+	} else if err != nil {
+		return 0, fmt.Errorf("rebuildBalance: error updating balance for user %s: %w", userID, err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return 0, fmt.Errorf("rebuildBalance: error committing transaction: %w", err)
+	}
+	return balance, nil
+}
+
+// GetBalanceDiscrepancies возвращает пользователей, чей кешированный баланс расходится с журналом.
+func (s *Storage) GetBalanceDiscrepancies(ctx context.Context) ([]models.BalanceDiscrepancy, error) {
+	ctx, done := s.observeQuery(ctx, "getBalanceDiscrepancies")
+	defer done()
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `
+		SELECT b.user_id, b.current::float, COALESCE(l.balance, 0)::float, (b.current - COALESCE(l.balance, 0))::float
+		FROM balances b
+		LEFT JOIN (
+			SELECT user_id, SUM(CASE direction WHEN 'CREDIT' THEN amount ELSE -amount END) AS balance
+			FROM balance_transactions GROUP BY user_id
+		) AS l ON l.user_id = b.user_id
+		WHERE b.current IS DISTINCT FROM COALESCE(l.balance, 0)
+		ORDER BY b.user_id`
+
+	rows, err := s.DB.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("getBalanceDiscrepancies: error comparing balances: %w", err)
+	}
+	defer rows.Close()
+
+	discrepancies := []models.BalanceDiscrepancy{}
+	for rows.Next() {
+		var d models.BalanceDiscrepancy
+		if err = rows.Scan(&d.UserID, &d.CachedBalance, &d.LedgerBalance, &d.Difference); err != nil {
+			return nil, fmt.Errorf("getBalanceDiscrepancies: error scanning row: %w", err)
+		}
+		discrepancies = append(discrepancies, d)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("getBalanceDiscrepancies: error comparing balances: %w", err)
+	}
+	return discrepancies, nil
+}
+
+// defaultBalanceDiscrepancyThreshold — расхождение баланса, которое ReconcileBalances считает
+// ошибкой, а не погрешностью округления.
+const defaultBalanceDiscrepancyThreshold = 0.01
+
+// WithBalanceDiscrepancyThreshold задает расхождение, начиная с которого ReconcileBalances
+// сообщает о пользователе.
+func WithBalanceDiscrepancyThreshold(threshold float64) Option {
+	return func(s *Storage) {
+		s.balanceDiscrepancyThreshold = threshold
+	}
+}
+
+// ReconcileBalances сверяет кешированный баланс каждого пользователя с суммой начислений по заказам
+// за вычетом списаний с учетом ручных корректировок и возвращает пользователей, у которых
+// расхождение не меньше balanceDiscrepancyThreshold. В отличие от GetBalanceDiscrepancies
+// источником служат сами заказы и списания, а не журнал операций, поэтому сверка находит и
+// операции, не попавшие в журнал.
+func (s *Storage) ReconcileBalances(ctx context.Context) ([]string, error) {
+	ctx, done := s.observeQuery(ctx, "reconcileBalances")
+	defer done()
+
+	query := `
+		SELECT b.user_id
+		FROM balances b
+		LEFT JOIN (SELECT user_id, SUM(accrual) AS total FROM orders GROUP BY user_id) AS o
+			ON o.user_id = b.user_id
+		LEFT JOIN (SELECT user_id, SUM(sum) AS total FROM withdrawals GROUP BY user_id) AS w
+			ON w.user_id = b.user_id
+		LEFT JOIN (SELECT user_id, SUM(delta) AS total FROM balance_adjustments GROUP BY user_id) AS a
+			ON a.user_id = b.user_id
+		WHERE ABS(b.current - (COALESCE(o.total, 0) - COALESCE(w.total, 0) + COALESCE(a.total, 0))) >= $1
+		ORDER BY b.user_id`
+
+	rows, err := s.readDB().Query(ctx, query, s.balanceDiscrepancyThreshold)
+	if err != nil {
+		return nil, fmt.Errorf("reconcileBalances: error comparing balances: %w", err)
+	}
+	defer rows.Close()
+
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err = rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("reconcileBalances: error scanning row: %w", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("reconcileBalances: error comparing balances: %w", err)
+	}
+	return userIDs, nil
+}
+
+// NegativeBalances возвращает пользователей с отрицательным балансом. Ограничение CHECK (current >= 0)
+// не должно этого допускать, поэтому каждая найденная строка учитывается в NegativeBalanceViolations.
+func (s *Storage) NegativeBalances(ctx context.Context) ([]string, error) {
+	ctx, done := s.observeQuery(ctx, "negativeBalances")
+	defer done()
+
+	query := "SELECT user_id FROM balances WHERE current < 0 ORDER BY user_id"
+	rows, err := s.DB.Query(ctx, query)
+	if err != nil {
+		return nil, fmt.Errorf("negativeBalances: error selecting balances: %w", err)
+	}
+	defer rows.Close()
+
+	var userIDs []string
+	for rows.Next() {
+		var userID string
+		if err = rows.Scan(&userID); err != nil {
+			return nil, fmt.Errorf("negativeBalances: error scanning row: %w", err)
+		}
+		userIDs = append(userIDs, userID)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("negativeBalances: error selecting balances: %w", err)
+	}
+	s.negativeBalances.Add(int64(len(userIDs)))
+	return userIDs, nil
+}
+
+// NegativeBalanceViolations возвращает, сколько раз с момента запуска обнаружен отрицательный
+// баланс: при списании или при периодической проверке.
+func (s *Storage) NegativeBalanceViolations() int64 {
+	return s.negativeBalances.Value()
+}
@@ -0,0 +1,205 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/jackc/pgx/v5/pgxpool"
+)
+
+// migrations применяются последовательно после создания базовой схемы в createIfNotExists.
+// Номер миграции — ее индекс в срезе плюс один, поэтому новые миграции добавляются только в конец.
+var migrations = []string{
+	// 1: списание с нулевой суммой не имеет смысла
+	`ALTER TABLE withdrawals ADD CONSTRAINT withdrawals_sum_positive CHECK (sum > 0) NOT VALID`,
+	// 2: логины сравниваются без учета регистра
+	`CREATE INDEX IF NOT EXISTS users_login_lower_idx ON users (LOWER(login))`,
+	// 3: удаленные аккаунты анонимизируются, заказы и списания сохраняются для аудита
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS deleted_at TIMESTAMP WITH TIME ZONE DEFAULT NULL`,
+	// 4: необязательный email как альтернативный идентификатор для входа
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS email VARCHAR DEFAULT NULL;
+	CREATE UNIQUE INDEX IF NOT EXISTS users_email_lower_key ON users (LOWER(email)) WHERE email IS NOT NULL`,
+	// 5: заказы, обновление которых раз за разом завершается ошибкой, исключаются из опроса
+	`CREATE TABLE IF NOT EXISTS failed_updates (
+		order_id VARCHAR PRIMARY KEY REFERENCES orders(order_id) ON DELETE CASCADE,
+		attempt_count INT NOT NULL DEFAULT 0,
+		last_error TEXT,
+		last_attempt TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`,
+	// 6: за цикл опрашивается ограниченная порция заказов, давно не проверявшиеся — в первую очередь
+	`ALTER TABLE orders ADD COLUMN IF NOT EXISTS last_checked_at TIMESTAMP WITH TIME ZONE DEFAULT NULL;
+	CREATE INDEX IF NOT EXISTS orders_pending_last_checked_idx ON orders (last_checked_at NULLS FIRST, uploaded_at)
+		WHERE status NOT IN ('INVALID', 'PROCESSED')`,
+	// 7: неизменяемый журнал смены статусов заказов для аудита
+	`CREATE TABLE IF NOT EXISTS order_events (
+		event_id SERIAL PRIMARY KEY,
+		order_id VARCHAR NOT NULL REFERENCES orders(order_id) ON DELETE CASCADE,
+		old_status VARCHAR NOT NULL,
+		new_status VARCHAR NOT NULL,
+		accrual NUMERIC(20, 2) DEFAULT NULL,
+		changed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS order_events_order_id_idx ON order_events (order_id, event_id)`,
+	// 8: журнал операций с балансом, заполняется по уже начисленным заказам и списаниям
+	`CREATE TABLE IF NOT EXISTS balance_transactions (
+		tx_id SERIAL PRIMARY KEY,
+		user_id VARCHAR NOT NULL REFERENCES users(user_id) ON DELETE CASCADE,
+		order_id VARCHAR NOT NULL,
+		amount NUMERIC(20, 2) NOT NULL CHECK (amount > 0),
+		direction VARCHAR NOT NULL CHECK (direction IN ('CREDIT', 'DEBIT')),
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS balance_transactions_user_created_idx ON balance_transactions (user_id, created_at, tx_id);
+	INSERT INTO balance_transactions (user_id, order_id, amount, direction, created_at)
+		SELECT user_id, order_id, amount, direction, created_at FROM (
+			SELECT user_id, order_id, accrual AS amount, 'CREDIT' AS direction, uploaded_at AS created_at
+				FROM orders WHERE accrual > 0
+			UNION ALL
+			SELECT user_id, order_id, sum, 'DEBIT', processed_at FROM withdrawals WHERE sum > 0
+		) AS movements
+		ORDER BY created_at`,
+	// 9: причина операции отличает списание бонусов от ручного уменьшения начисления
+	`ALTER TABLE balance_transactions ADD COLUMN IF NOT EXISTS reason VARCHAR NOT NULL DEFAULT 'accrual'
+		CHECK (reason IN ('accrual', 'withdrawal', 'adjustment'));
+	UPDATE balance_transactions SET reason = 'withdrawal' WHERE direction = 'DEBIT'`,
+	// 10: индексы под выборки по пользователю и опрос необработанных заказов; balances.user_id
+	// становится первичным ключом, чтобы UPDATE баланса не мог затронуть несколько строк.
+	// Если у пользователя несколько строк баланса, миграция останавливается: какая из сумм верна,
+	// решает оператор, а не порядок строк на диске
+	`CREATE INDEX IF NOT EXISTS orders_user_uploaded_idx ON orders (user_id, uploaded_at);
+	CREATE INDEX IF NOT EXISTS orders_status_pending_idx ON orders (status) WHERE status NOT IN ('PROCESSED', 'INVALID');
+	CREATE INDEX IF NOT EXISTS withdrawals_user_processed_idx ON withdrawals (user_id, processed_at);
+	DO $$
+	DECLARE
+		duplicates TEXT;
+	BEGIN
+		SELECT string_agg(user_id, ', ' ORDER BY user_id) INTO duplicates
+		FROM (SELECT user_id FROM balances GROUP BY user_id HAVING COUNT(*) > 1) AS duplicated;
+		IF duplicates IS NOT NULL THEN
+			RAISE EXCEPTION 'balances has several rows for users: %', duplicates
+				USING HINT = 'keep one correct row per user (balance_transactions holds the ledger to recompute it from) and restart';
+		END IF;
+		IF NOT EXISTS (SELECT 1 FROM pg_constraint WHERE conrelid = 'balances'::regclass AND contype = 'p') THEN
+			ALTER TABLE balances ADD PRIMARY KEY (user_id);
+		END IF;
+	END $$`,
+	// 11: в orders.status допускаются только известные статусы; неизвестные, записанные раньше
+	// из ответов accrual-системы, возвращаются в NEW, чтобы заказ опросили заново
+	`UPDATE orders SET status = 'NEW' WHERE status NOT IN ('NEW', 'PROCESSING', 'INVALID', 'PROCESSED');
+	ALTER TABLE orders ADD CONSTRAINT orders_status_check CHECK (status IN ('NEW', 'PROCESSING', 'INVALID', 'PROCESSED'))`,
+	// 12: время регистрации; у пользователей, созданных до миграции, это время ее применения
+	`ALTER TABLE users ADD COLUMN IF NOT EXISTS registered_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT NOW()`,
+	// 13: ручные корректировки баланса администратором: кто, на сколько и почему
+	`CREATE TABLE IF NOT EXISTS balance_adjustments (
+		adjustment_id BIGSERIAL PRIMARY KEY,
+		user_id VARCHAR NOT NULL REFERENCES users(user_id),
+		delta NUMERIC(20, 2) NOT NULL CHECK (delta <> 0),
+		reason TEXT NOT NULL,
+		operator VARCHAR NOT NULL,
+		created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS balance_adjustments_user_idx ON balance_adjustments (user_id, created_at)`,
+	// 14: идентификатор и собственный ключ подписи webhook, журнал попыток доставки уведомлений
+	`ALTER TABLE user_webhooks ADD COLUMN IF NOT EXISTS webhook_id BIGSERIAL UNIQUE;
+	ALTER TABLE user_webhooks ADD COLUMN IF NOT EXISTS secret VARCHAR NOT NULL DEFAULT '';
+	CREATE TABLE IF NOT EXISTS webhook_deliveries (
+		delivery_id BIGSERIAL PRIMARY KEY,
+		webhook_id BIGINT REFERENCES user_webhooks(webhook_id) ON DELETE CASCADE NOT NULL,
+		order_id VARCHAR NOT NULL,
+		status VARCHAR NOT NULL,
+		attempt INT NOT NULL,
+		response_code INT DEFAULT NULL,
+		error VARCHAR DEFAULT NULL,
+		succeeded BOOLEAN NOT NULL,
+		attempted_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+	);
+	CREATE INDEX IF NOT EXISTS webhook_deliveries_webhook_idx ON webhook_deliveries (webhook_id, attempted_at)`,
+	// 15: время последнего изменения заказа для ETag списка заказов; индекс отвечает на MAX без обхода таблицы
+	`ALTER TABLE orders ADD COLUMN IF NOT EXISTS updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP;
+	CREATE INDEX IF NOT EXISTS orders_user_updated_idx ON orders (user_id, updated_at)`,
+	// 16: ключ идемпотентности привязывается к запросу, ответ повторяется с заголовками, а резерв
+	// упавшего экземпляра можно перехватить по времени резервирования
+	`ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS request_hash VARCHAR DEFAULT NULL;
+	ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS response_headers JSONB DEFAULT NULL;
+	ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS reserved_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP`,
+	// 17: логин активного пользователя уникален без учета регистра: проверка перед вставкой не спасает
+	// от одновременных регистраций. Уже совпадающие логины, как и дубликаты в миграции 10,
+	// останавливают миграцию: какой аккаунт оставить, решает оператор
+	`DO $$
+	DECLARE
+		duplicates TEXT;
+	BEGIN
+		SELECT string_agg(login, ', ' ORDER BY login) INTO duplicates
+		FROM (SELECT LOWER(login) AS login FROM users WHERE deleted_at IS NULL
+			GROUP BY LOWER(login) HAVING COUNT(*) > 1) AS duplicated;
+		IF duplicates IS NOT NULL THEN
+			RAISE EXCEPTION 'users has several active accounts with logins: %', duplicates
+				USING HINT = 'rename or delete the extra accounts and restart';
+		END IF;
+	END $$;
+	CREATE UNIQUE INDEX IF NOT EXISTS users_login_lower_key ON users (LOWER(login)) WHERE deleted_at IS NULL`,
+	// 18: ревизия заказа из общей последовательности меняется при каждом изменении заказа, поэтому
+	// метка списка заказов меняется, даже если изменения пришлись на один тик updated_at или
+	// зафиксированы не в порядке начала транзакций
+	`CREATE SEQUENCE IF NOT EXISTS orders_revision_seq;
+	ALTER TABLE orders ADD COLUMN IF NOT EXISTS revision BIGINT NOT NULL DEFAULT nextval('orders_revision_seq');
+	CREATE INDEX IF NOT EXISTS orders_user_revision_idx ON orders (user_id, revision)`,
+	// 19: метка списка заказов строится по revision, updated_at из миграции 15 и его индекс
+	// больше не читаются
+	`DROP INDEX IF EXISTS orders_user_updated_idx;
+	ALTER TABLE orders DROP COLUMN IF EXISTS updated_at`,
+}
+
+// schemaMigrationsLock — ключ advisory-блокировки, чтобы несколько экземпляров сервиса
+// не применяли миграции одновременно.
+const schemaMigrationsLock = 7355608
+
+func applyMigrations(ctx context.Context, db *pgxpool.Pool) error {
+	_, err := db.Exec(ctx, `CREATE TABLE IF NOT EXISTS schema_migrations (
+		version INT PRIMARY KEY,
+		applied_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+	)`)
+	if err != nil {
+		return fmt.Errorf("applyMigrations: error creating schema_migrations: %w", err)
+	}
+
+	for i, migration := range migrations {
+		if err = applyMigration(ctx, db, i+1, migration); err != nil {
+			return fmt.Errorf("applyMigrations: %w", err)
+		}
+	}
+	return nil
+}
+
+func applyMigration(ctx context.Context, db *pgxpool.Pool, version int, migration string) error {
+	tx, err := db.Begin(ctx)
+	if err != nil {
+		return fmt.Errorf("applyMigration: transaction error: %w", err)
+	}
+	defer tx.Rollback(ctx)
+
+	_, err = tx.Exec(ctx, "SELECT pg_advisory_xact_lock($1)", schemaMigrationsLock)
+	if err != nil {
+		return fmt.Errorf("applyMigration: error acquiring lock: %w", err)
+	}
+
+	var applied bool
+	err = tx.QueryRow(ctx, "SELECT EXISTS (SELECT 1 FROM schema_migrations WHERE version = $1)", version).Scan(&applied)
+	if err != nil {
+		return fmt.Errorf("applyMigration: error checking migration %d: %w", version, err)
+	}
+	if applied {
+		return nil
+	}
+
+	if _, err = tx.Exec(ctx, migration); err != nil {
+		return fmt.Errorf("applyMigration: error applying migration %d: %w", version, err)
+	}
+	if _, err = tx.Exec(ctx, "INSERT INTO schema_migrations (version) VALUES ($1)", version); err != nil {
+		return fmt.Errorf("applyMigration: error recording migration %d: %w", version, err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("applyMigration: error committing migration %d: %w", version, err)
+	}
+	return nil
+}
@@ -0,0 +1,114 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"strings"
+	"testing"
+)
+
+// TestBalancesPrimaryKeyMigrationRefusesDuplicates проверяет, что миграция 10 не удаляет
+// дублирующиеся строки баланса, а останавливается и называет пользователей.
+func TestBalancesPrimaryKeyMigrationRefusesDuplicates(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	userID := registerTestUser(t, s, "duplicated")
+
+	restore := func() {
+		if _, err := s.DB.Exec(ctx, "DELETE FROM balances WHERE user_id = $1", userID); err != nil {
+			t.Fatal(err)
+		}
+		if err := applyMigrations(ctx, s.DB); err != nil {
+			t.Fatalf("reapplying migrations: %v", err)
+		}
+	}
+	t.Cleanup(restore)
+
+	statements := []string{
+		"ALTER TABLE balances DROP CONSTRAINT balances_pkey",
+		"DELETE FROM schema_migrations WHERE version = 10",
+	}
+	for _, statement := range statements {
+		if _, err := s.DB.Exec(ctx, statement); err != nil {
+			t.Fatalf("%s: %v", statement, err)
+		}
+	}
+	// регистрация уже создала одну строку баланса
+	_, err := s.DB.Exec(ctx, "INSERT INTO balances (user_id, current) VALUES ($1, 20)", userID)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	err = applyMigrations(ctx, s.DB)
+	if err == nil || !strings.Contains(err.Error(), userID) {
+		t.Fatalf("applyMigrations error = %v, want an error naming %s", err, userID)
+	}
+
+	var rows int
+	if err = s.DB.QueryRow(ctx, "SELECT COUNT(*) FROM balances WHERE user_id = $1", userID).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 2 {
+		t.Errorf("balances rows = %d, want both duplicates kept", rows)
+	}
+}
+
+// TestBalancesHaveOneRowPerUser проверяет, что user_id — первичный ключ balances и повторная
+// регистрация или повторное создание баланса не добавляют вторую строку.
+func TestBalancesHaveOneRowPerUser(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	userID := registerTestUser(t, s, "alice")
+
+	var primaryKey string
+	query := `SELECT a.attname FROM pg_constraint c
+		JOIN pg_attribute a ON a.attrelid = c.conrelid AND a.attnum = ANY (c.conkey)
+		WHERE c.conrelid = 'balances'::regclass AND c.contype = 'p'`
+	if err := s.DB.QueryRow(ctx, query).Scan(&primaryKey); err != nil {
+		t.Fatalf("balances primary key: %v", err)
+	}
+	if primaryKey != "user_id" {
+		t.Errorf("balances primary key column = %q, want user_id", primaryKey)
+	}
+
+	if _, err := s.RegisterUser(ctx, "alice", "", "password-alice"); !errors.Is(err, ErrUsernameNotUnique) {
+		t.Errorf("second registration: error = %v, want %v", err, ErrUsernameNotUnique)
+	}
+	if _, err := s.DB.Exec(ctx, ensureBalanceRowQuery, userID); err != nil {
+		t.Fatalf("ensure balance row: %v", err)
+	}
+	_, err := s.DB.Exec(ctx, "INSERT INTO balances (user_id) VALUES ($1)", userID)
+	var pgErr *pgconn.PgError
+	if !errors.As(err, &pgErr) || pgErr.Code != pgerrcode.UniqueViolation {
+		t.Errorf("inserting a second balance row: error = %v, want a unique violation", err)
+	}
+
+	var rows int
+	if err = s.DB.QueryRow(ctx, "SELECT COUNT(*) FROM balances WHERE user_id = $1", userID).Scan(&rows); err != nil {
+		t.Fatal(err)
+	}
+	if rows != 1 {
+		t.Errorf("balances rows = %d, want 1", rows)
+	}
+}
+
+// TestOrdersUpdatedAtDropped проверяет, что миграция 19 удалила orders.updated_at и его индекс,
+// которые заменила ревизия заказа.
+func TestOrdersUpdatedAtDropped(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+
+	var columns, indexes int
+	query := "SELECT COUNT(*) FROM information_schema.columns WHERE table_name = 'orders' AND column_name = 'updated_at'"
+	if err := s.DB.QueryRow(ctx, query).Scan(&columns); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DB.QueryRow(ctx, "SELECT COUNT(*) FROM pg_indexes WHERE indexname = 'orders_user_updated_idx'").Scan(&indexes); err != nil {
+		t.Fatal(err)
+	}
+	if columns != 0 || indexes != 0 {
+		t.Errorf("orders.updated_at columns = %d, orders_user_updated_idx indexes = %d; want both dropped", columns, indexes)
+	}
+}
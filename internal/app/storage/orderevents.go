@@ -0,0 +1,48 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"github.com/vancho-go/gophermart/internal/app/models"
+)
+
+// GetOrderEvents возвращает журнал смены статусов заказа orderID в порядке изменений.
+// Если заказ не найден или принадлежит другому пользователю, возвращает ErrOrderNotFound.
+func (s *Storage) GetOrderEvents(ctx context.Context, userID, orderID string) ([]models.APIOrderEvent, error) {
+	ctx, done := s.observeQuery(ctx, "getOrderEvents")
+	defer done()
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	var owned bool
+	query := "SELECT EXISTS (SELECT 1 FROM orders WHERE order_id = $1 AND user_id = $2)"
+	if err := s.readDB().QueryRow(ctx, query, orderID, userID).Scan(&owned); err != nil {
+		return nil, fmt.Errorf("getOrderEvents: error checking order owner: %w", err)
+	}
+	if !owned {
+		return nil, fmt.Errorf("getOrderEvents: %w", ErrOrderNotFound)
+	}
+
+	query = `SELECT event_id, old_status, new_status, accrual::float, changed_at
+		FROM order_events WHERE order_id = $1 ORDER BY event_id`
+	rows, err := s.readDB().Query(ctx, query, orderID)
+	if err != nil {
+		return nil, fmt.Errorf("getOrderEvents: error getting order events: %w", err)
+	}
+	defer rows.Close()
+
+	orderEvents := []models.APIOrderEvent{}
+	for rows.Next() {
+		var event models.APIOrderEvent
+		if err = rows.Scan(&event.EventID, &event.OldStatus, &event.NewStatus, &event.Accrual, &event.ChangedAt); err != nil {
+			return nil, fmt.Errorf("getOrderEvents: error scanning order event: %w", err)
+		}
+		event.ChangedAt = event.ChangedAt.UTC()
+		orderEvents = append(orderEvents, event)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("getOrderEvents: error getting order events: %w", err)
+	}
+	return orderEvents, nil
+}
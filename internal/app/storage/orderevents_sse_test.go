@@ -0,0 +1,102 @@
+package storage_test
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"github.com/vancho-go/gophermart/internal/app/accrual/accrualtest"
+	"github.com/vancho-go/gophermart/internal/app/auth"
+	"github.com/vancho-go/gophermart/internal/app/handlers"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"github.com/vancho-go/gophermart/internal/app/storage"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+// TestOrderEventsEndToEnd проверяет путь от ответа accrual-системы до клиента: цикл обновления
+// сохраняет статус заказа, и подписчик SSE-потока пользователя получает событие об изменении.
+func TestOrderEventsEndToEnd(t *testing.T) {
+	const orderNumber = "12345678903"
+
+	s, accrualServer := storage.NewAccrualTestStorage(t)
+	ctx := context.Background()
+	aliceID := storage.RegisterTestUser(t, s, "alice")
+	bobID := storage.RegisterTestUser(t, s, "bob")
+	storage.AddTestOrder(t, s, aliceID, orderNumber)
+	accrualServer.Script(orderNumber, accrualtest.Processed(729.98))
+
+	eventsHandler := handlers.GetOrderEvents(s.EventBus(), logger.NewNopLogger())
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		// пользователь задается заголовком вместо JWT, как после auth.Middleware
+		userID := req.Header.Get("X-Test-User")
+		eventsHandler(res, req.WithContext(context.WithValue(req.Context(), auth.UserIDContextKey, userID)))
+	}))
+	defer server.Close()
+
+	streamCtx, cancel := context.WithTimeout(ctx, time.Second*10)
+	defer cancel()
+	subscribe := func(userID string) *bufio.Reader {
+		t.Helper()
+		req, err := http.NewRequestWithContext(streamCtx, http.MethodGet, server.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Test-User", userID)
+		// заголовки ответа отправляются после подписки на шину
+		res, err := server.Client().Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { res.Body.Close() })
+		if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" {
+			t.Fatalf("stream response = %d %s, want 200 text/event-stream", res.StatusCode, res.Header.Get("Content-Type"))
+		}
+		return bufio.NewReader(res.Body)
+	}
+	aliceStream := subscribe(aliceID)
+	bobStream := subscribe(bobID)
+
+	if err := s.HandleOrderNumbers(ctx, logger.NewNopLogger()); err != nil {
+		t.Fatalf("update cycle: %v", err)
+	}
+
+	var eventName, data string
+	for data == "" {
+		line, err := aliceStream.ReadString('\n')
+		if err != nil {
+			t.Fatalf("read stream: %v", err)
+		}
+		switch line = strings.TrimSuffix(line, "\n"); {
+		case strings.HasPrefix(line, "event: "):
+			eventName = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	if eventName != "order_update" {
+		t.Errorf("event = %q, want order_update", eventName)
+	}
+	var event models.APIOrderStatusEvent
+	if err := json.Unmarshal([]byte(data), &event); err != nil {
+		t.Fatalf("decode event %s: %v", data, err)
+	}
+	if event.Number != orderNumber || event.Status != models.OrderStatusProcessed || event.Accrual == nil || *event.Accrual != 729.98 {
+		t.Errorf("event = %s, want order %s processed with accrual 729.98", data, orderNumber)
+	}
+
+	// чужой заказ в поток другого пользователя не попадает: до отмены потока в нем нет событий
+	cancel()
+	for {
+		line, err := bobStream.ReadString('\n')
+		if strings.HasPrefix(line, "data: ") {
+			t.Errorf("bob received an event of another user: %s", line)
+		}
+		if err != nil {
+			break
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"testing"
+)
+
+func TestGetOrderEvents(t *testing.T) {
+	const orderNumber = "12345678903"
+
+	s := newTestStorage(t)
+	ctx := context.Background()
+	userID := registerTestUser(t, s, "alice")
+	otherUserID := registerTestUser(t, s, "bob")
+	addTestOrder(t, s, userID, orderNumber)
+
+	history, err := s.GetOrderEvents(ctx, userID, orderNumber)
+	if err != nil {
+		t.Fatalf("get history of a new order: %v", err)
+	}
+	if len(history) != 0 {
+		t.Fatalf("history of a new order = %+v, want empty", history)
+	}
+
+	accrualSum := 120.5
+	transitions := []struct {
+		status  models.OrderStatus
+		accrual *float64
+	}{
+		{status: models.OrderStatusProcessing},
+		{status: models.OrderStatusProcessed, accrual: &accrualSum},
+	}
+	for _, transition := range transitions {
+		if _, err = s.applyOrderStatus(ctx, orderNumber, transition.status, transition.accrual); err != nil {
+			t.Fatalf("apply %s: %v", transition.status, err)
+		}
+	}
+
+	history, err = s.GetOrderEvents(ctx, userID, orderNumber)
+	if err != nil {
+		t.Fatalf("get history: %v", err)
+	}
+	want := []struct {
+		oldStatus, newStatus models.OrderStatus
+	}{
+		{oldStatus: models.OrderStatusNew, newStatus: models.OrderStatusProcessing},
+		{oldStatus: models.OrderStatusProcessing, newStatus: models.OrderStatusProcessed},
+	}
+	if len(history) != len(want) {
+		t.Fatalf("history = %+v, want %d transitions", history, len(want))
+	}
+	for i, event := range history {
+		if event.OldStatus != want[i].oldStatus || event.NewStatus != want[i].newStatus {
+			t.Errorf("transition %d = %s -> %s, want %s -> %s", i, event.OldStatus, event.NewStatus, want[i].oldStatus, want[i].newStatus)
+		}
+		if i > 0 && history[i-1].EventID >= event.EventID {
+			t.Errorf("transition %d is out of order", i)
+		}
+	}
+	if last := history[len(history)-1]; last.Accrual == nil || *last.Accrual != accrualSum {
+		t.Errorf("accrual of the last transition = %v, want %v", last.Accrual, accrualSum)
+	}
+
+	if _, err = s.GetOrderEvents(ctx, otherUserID, orderNumber); !errors.Is(err, ErrOrderNotFound) {
+		t.Errorf("history of another user's order: error = %v, want %v", err, ErrOrderNotFound)
+	}
+}
@@ -0,0 +1,337 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/vancho-go/gophermart/internal/app/accrual"
+	"github.com/vancho-go/gophermart/internal/app/accrual/accrualtest"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"net/http"
+	"sync"
+	"testing"
+)
+
+// newAccrualTestStorage подключает хранилище к поддельной accrual-системе. Заказы проверяются
+// в каждом цикле обновления без паузы между проверками, opts дополняют эти настройки.
+func newAccrualTestStorage(t *testing.T, opts ...Option) (*Storage, *accrualtest.Server) {
+	t.Helper()
+
+	server := accrualtest.NewServer()
+	t.Cleanup(server.Close)
+	client, err := accrual.NewClient(server.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts = append([]Option{WithAccrualClient(client), WithPendingOrdersBatch(defaultPendingBatchSize, 0)}, opts...)
+	return newTestStorage(t, opts...), server
+}
+
+// addTestOrder загружает заказ orderNumber пользователя userID.
+func addTestOrder(t *testing.T, s *Storage, userID, orderNumber string) {
+	t.Helper()
+
+	if err := s.AddOrder(context.Background(), models.APIAddOrderRequest{UserID: userID, OrderNumber: orderNumber}); err != nil {
+		t.Fatalf("add order %s: %v", orderNumber, err)
+	}
+}
+
+// assertOrderState проверяет статус заказа и текущий баланс пользователя.
+func assertOrderState(t *testing.T, s *Storage, userID string, wantStatus models.OrderStatus, wantCurrent float64) {
+	t.Helper()
+
+	ctx := context.Background()
+	orders, err := s.GetOrders(ctx, userID, OrdersFilter{})
+	if err != nil {
+		t.Fatalf("get orders: %v", err)
+	}
+	if len(orders) != 1 || orders[0].Status != wantStatus {
+		t.Fatalf("orders = %+v, want one order in status %s", orders, wantStatus)
+	}
+	balance, err := s.GetCurrentBonusesAmount(ctx, userID)
+	if err != nil {
+		t.Fatalf("get balance: %v", err)
+	}
+	if balance.Current != wantCurrent {
+		t.Fatalf("current balance = %v, want %v", balance.Current, wantCurrent)
+	}
+}
+
+func TestHandleOrderNumbers(t *testing.T) {
+	const orderNumber = "12345678903"
+
+	s, server := newAccrualTestStorage(t)
+	ctx := context.Background()
+	userID := registerTestUser(t, s, "alice")
+	addTestOrder(t, s, userID, orderNumber)
+	server.Script(orderNumber, accrualtest.NotRegistered(), accrualtest.Processing(), accrualtest.Processed(729.5))
+
+	assertOrderState(t, s, userID, models.OrderStatusNew, 0)
+
+	statusEvents, unsubscribe := s.events.Subscribe(userID)
+	defer unsubscribe()
+
+	cycles := []struct {
+		wantStatus  models.OrderStatus
+		wantCurrent float64
+	}{
+		{wantStatus: models.OrderStatusNew, wantCurrent: 0},
+		{wantStatus: models.OrderStatusProcessing, wantCurrent: 0},
+		{wantStatus: models.OrderStatusProcessed, wantCurrent: 729.5},
+	}
+	for _, cycle := range cycles {
+		if err := s.HandleOrderNumbers(ctx, logger.NewNopLogger()); err != nil {
+			t.Fatalf("update cycle: %v", err)
+		}
+		assertOrderState(t, s, userID, cycle.wantStatus, cycle.wantCurrent)
+	}
+
+	// рассылаются только изменения статуса: заказ, еще не известный accrual-системе, остается в NEW
+	for _, want := range []models.OrderStatus{models.OrderStatusProcessing, models.OrderStatusProcessed} {
+		select {
+		case event := <-statusEvents:
+			if event.Number != orderNumber || event.Status != want {
+				t.Errorf("event = %+v, want order %s in status %s", event, orderNumber, want)
+			}
+		default:
+			t.Fatalf("no event for status %s", want)
+		}
+	}
+
+	// обработанный заказ больше не опрашивается и не начисляется повторно
+	requests := server.Requests(orderNumber)
+	if err := s.HandleOrderNumbers(ctx, logger.NewNopLogger()); err != nil {
+		t.Fatalf("update cycle: %v", err)
+	}
+	if server.Requests(orderNumber) != requests {
+		t.Errorf("processed order was polled again")
+	}
+	assertOrderState(t, s, userID, models.OrderStatusProcessed, 729.5)
+}
+
+// TestRegisteredOrderKeepsPolling проверяет переход REGISTERED→PROCESSING→PROCESSED: ответ
+// REGISTERED и голый 202 сохраняются как PROCESSING, заказ остается в опросе до окончательного статуса.
+func TestRegisteredOrderKeepsPolling(t *testing.T) {
+	const orderNumber = "12345678903"
+
+	s, server := newAccrualTestStorage(t)
+	ctx := context.Background()
+	userID := registerTestUser(t, s, "alice")
+	addTestOrder(t, s, userID, orderNumber)
+	server.Script(orderNumber, accrualtest.Registered(), accrualtest.Registered(),
+		accrualtest.Response{StatusCode: http.StatusAccepted}, accrualtest.Processing(), accrualtest.Processed(300))
+
+	cycles := []struct {
+		wantStatus  models.OrderStatus
+		wantCurrent float64
+	}{
+		{wantStatus: models.OrderStatusProcessing},
+		{wantStatus: models.OrderStatusProcessing},
+		{wantStatus: models.OrderStatusProcessing},
+		{wantStatus: models.OrderStatusProcessing},
+		{wantStatus: models.OrderStatusProcessed, wantCurrent: 300},
+	}
+	for i, cycle := range cycles {
+		if err := s.HandleOrderNumbers(ctx, logger.NewNopLogger()); err != nil {
+			t.Fatalf("update cycle %d: %v", i+1, err)
+		}
+		if requests := server.Requests(orderNumber); requests != i+1 {
+			t.Fatalf("after cycle %d accrual requests = %d, want %d", i+1, requests, i+1)
+		}
+		assertOrderState(t, s, userID, cycle.wantStatus, cycle.wantCurrent)
+	}
+
+	dead, err := s.ListDeadOrders(ctx)
+	if err != nil || len(dead) != 0 {
+		t.Errorf("dead orders = %+v, error %v; want none", dead, err)
+	}
+}
+
+// TestApplyOrderStatusCreditsOnce проверяет, что повторный ответ PROCESSED по уже обработанному
+// заказу, в том числе полученный одновременно несколькими циклами, не начисляет вознаграждение повторно.
+func TestApplyOrderStatusCreditsOnce(t *testing.T) {
+	const (
+		orderNumber = "12345678903"
+		repeats     = 8
+	)
+
+	s := newTestStorage(t)
+	ctx := context.Background()
+	userID := registerTestUser(t, s, "alice")
+	addTestOrder(t, s, userID, orderNumber)
+
+	accrualSum := 500.0
+	update, err := s.applyOrderStatus(ctx, orderNumber, models.OrderStatusProcessed, &accrualSum)
+	if err != nil || update == nil {
+		t.Fatalf("first PROCESSED: update %v, error %v", update, err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, repeats)
+	for i := 0; i < repeats; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			repeated, err := s.applyOrderStatus(ctx, orderNumber, models.OrderStatusProcessed, &accrualSum)
+			if err == nil && repeated != nil {
+				err = fmt.Errorf("repeated PROCESSED changed the order: %+v", repeated.event)
+			}
+			errs <- err
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Error(err)
+		}
+	}
+
+	assertOrderState(t, s, userID, models.OrderStatusProcessed, accrualSum)
+	var credits int
+	query := "SELECT COUNT(*) FROM balance_transactions WHERE order_id = $1 AND direction = $2"
+	if err = s.DB.QueryRow(ctx, query, orderNumber, models.BalanceCredit).Scan(&credits); err != nil {
+		t.Fatal(err)
+	}
+	if credits != 1 {
+		t.Errorf("balance credits for the order = %d, want 1", credits)
+	}
+}
+
+// TestAdminUpdateOrderStatusGuardsCredit проверяет, что ручная правка не уводит заказ с начислением
+// из PROCESSED и не уменьшает начисление больше, чем осталось на балансе.
+func TestAdminUpdateOrderStatusGuardsCredit(t *testing.T) {
+	const orderNumber = "12345678903"
+
+	s := newTestStorage(t)
+	ctx := context.Background()
+	userID := registerTestUser(t, s, "alice")
+	creditTestUser(t, s, userID, orderNumber, 500)
+
+	assertOrder := func(wantStatus models.OrderStatus, wantAccrual, wantCurrent float64) {
+		t.Helper()
+
+		var (
+			status  models.OrderStatus
+			accrual float64
+		)
+		query := "SELECT status, accrual::float FROM orders WHERE order_id = $1"
+		if err := s.DB.QueryRow(ctx, query, orderNumber).Scan(&status, &accrual); err != nil {
+			t.Fatal(err)
+		}
+		if status != wantStatus || accrual != wantAccrual {
+			t.Fatalf("order = %s with accrual %v, want %s with accrual %v", status, accrual, wantStatus, wantAccrual)
+		}
+		balance, err := s.GetCurrentBonusesAmount(ctx, userID)
+		if err != nil {
+			t.Fatalf("get balance: %v", err)
+		}
+		if balance.Current != wantCurrent {
+			t.Fatalf("current balance = %v, want %v", balance.Current, wantCurrent)
+		}
+	}
+
+	for _, status := range []models.OrderStatus{models.OrderStatusNew, models.OrderStatusProcessing, models.OrderStatusInvalid} {
+		err := s.AdminUpdateOrderStatus(ctx, orderNumber, status, nil)
+		if !errors.Is(err, ErrOrderStatusTransition) {
+			t.Errorf("PROCESSED -> %s: error = %v, want ErrOrderStatusTransition", status, err)
+		}
+	}
+	assertOrder(models.OrderStatusProcessed, 500, 500)
+
+	if err := s.UseBonuses(ctx, models.APIUseBonusesRequest{OrderNumber: "2377225624", Sum: 400}, userID); err != nil {
+		t.Fatalf("use bonuses: %v", err)
+	}
+	accrual := 50.0
+	if err := s.AdminUpdateOrderStatus(ctx, orderNumber, models.OrderStatusProcessed, &accrual); !errors.Is(err, ErrNotEnoughBonuses) {
+		t.Errorf("decrease below spent bonuses: error = %v, want ErrNotEnoughBonuses", err)
+	}
+	assertOrder(models.OrderStatusProcessed, 500, 100)
+
+	accrual = 400
+	if err := s.AdminUpdateOrderStatus(ctx, orderNumber, models.OrderStatusProcessed, &accrual); err != nil {
+		t.Fatalf("decrease within balance: %v", err)
+	}
+	assertOrder(models.OrderStatusProcessed, 400, 0)
+	assertLedgerConsistent(t, s)
+}
+
+// TestPendingOrdersRotate проверяет, что при pendingBatchSize меньше числа незавершенных
+// заказов цикл берет давно не проверявшиеся заказы: заказы, которые долго остаются в
+// PROCESSING, не вытесняют остальные.
+func TestPendingOrdersRotate(t *testing.T) {
+	const batchSize = 2
+	orderNumbers := []string{"79927398713", "79927398721", "79927398739", "79927398747", "79927398754"}
+
+	s, server := newAccrualTestStorage(t, WithPendingOrdersBatch(batchSize, 0), WithAccrualBatchSize(1))
+	ctx := context.Background()
+	userID := registerTestUser(t, s, "alice")
+	for _, orderNumber := range orderNumbers {
+		addTestOrder(t, s, userID, orderNumber)
+		server.Script(orderNumber, accrualtest.Processing())
+	}
+
+	polled := func() map[string]int {
+		requests := make(map[string]int, len(orderNumbers))
+		for _, orderNumber := range orderNumbers {
+			requests[orderNumber] = server.Requests(orderNumber)
+		}
+		return requests
+	}
+
+	cycles := (len(orderNumbers) + batchSize - 1) / batchSize
+	for cycle := 1; cycle <= cycles; cycle++ {
+		before := server.TotalRequests()
+		if err := s.HandleOrderNumbers(ctx, logger.NewNopLogger()); err != nil {
+			t.Fatalf("update cycle %d: %v", cycle, err)
+		}
+		if requests := server.TotalRequests() - before; requests != batchSize {
+			t.Errorf("cycle %d polled %d orders, want %d", cycle, requests, batchSize)
+		}
+	}
+
+	for orderNumber, requests := range polled() {
+		if requests == 0 {
+			t.Errorf("order %s was not polled in %d cycles", orderNumber, cycles)
+		}
+	}
+	// после полного круга снова опрашивается заказ, проверенный раньше всех
+	if requests := polled()[orderNumbers[0]]; requests != 2 {
+		t.Errorf("first order was polled %d times, want 2", requests)
+	}
+}
+
+// TestApplyOrderInfoRejectsInvalidResponse проверяет, что некорректный ответ accrual-системы
+// отклоняется до обращения к БД.
+func TestApplyOrderInfoRejectsInvalidResponse(t *testing.T) {
+	const orderNumber = "12345678903"
+
+	tests := []struct {
+		name      string
+		orderInfo models.APIOrderInfoResponse
+	}{
+		{name: "unknown status", orderInfo: models.APIOrderInfoResponse{Order: orderNumber, Status: "CANCELLED"}},
+		{name: "negative accrual", orderInfo: models.APIOrderInfoResponse{Order: orderNumber, Status: models.OrderStatusProcessed, Accrual: -10}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := &Storage{}
+			result := s.applyOrderInfo(context.Background(), orderNumber, tt.orderInfo)
+			if result.Phase != UpdatePhaseValidate || !errors.Is(result.Err, accrual.ErrInvalidResponse) {
+				t.Errorf("phase %s, error %v; want a validation error", result.Phase, result.Err)
+			}
+		})
+	}
+}
+
+// TestApplyOrderStatusRejectsUnknownStatus проверяет, что статус вне orders_status_check
+// отклоняется до начала транзакции.
+func TestApplyOrderStatusRejectsUnknownStatus(t *testing.T) {
+	for _, status := range []models.OrderStatus{"REGISTERED", "PROCESED", ""} {
+		s := &Storage{}
+		if _, err := s.applyOrderStatus(context.Background(), "12345678903", status, nil); !errors.Is(err, ErrInvalidOrderStatus) {
+			t.Errorf("applyOrderStatus(%q): %v, want ErrInvalidOrderStatus", status, err)
+		}
+	}
+}
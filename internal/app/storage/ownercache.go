@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+const (
+	defaultOwnerCacheSize = 10000
+	defaultOwnerCacheTTL  = time.Minute * 5
+)
+
+// orderOwnerCache — LRU-кеш владельцев номеров заказов с ограниченным временем жизни записей.
+// Владелец заказа после вставки не меняется, поэтому повторная загрузка того же номера
+// определяется без обращения к БД.
+type orderOwnerCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	order    *list.List
+	entries  map[string]*list.Element
+	now      func() time.Time
+}
+
+type ownerCacheEntry struct {
+	orderNumber string
+	userID      string
+	expiresAt   time.Time
+}
+
+func newOrderOwnerCache(capacity int, ttl time.Duration) *orderOwnerCache {
+	return &orderOwnerCache{
+		capacity: capacity,
+		ttl:      ttl,
+		order:    list.New(),
+		entries:  make(map[string]*list.Element, capacity),
+		now:      time.Now,
+	}
+}
+
+// get возвращает владельца номера заказа, если он есть в кеше и запись не устарела.
+func (c *orderOwnerCache) get(orderNumber string) (string, bool) {
+	if c == nil {
+		return "", false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	element, ok := c.entries[orderNumber]
+	if !ok {
+		return "", false
+	}
+	entry := element.Value.(*ownerCacheEntry)
+	if c.now().After(entry.expiresAt) {
+		c.order.Remove(element)
+		delete(c.entries, orderNumber)
+		return "", false
+	}
+	c.order.MoveToFront(element)
+	return entry.userID, true
+}
+
+// add запоминает владельца номера заказа, вытесняя самую давно использованную запись.
+func (c *orderOwnerCache) add(orderNumber, userID string) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expiresAt := c.now().Add(c.ttl)
+	if element, ok := c.entries[orderNumber]; ok {
+		entry := element.Value.(*ownerCacheEntry)
+		entry.userID, entry.expiresAt = userID, expiresAt
+		c.order.MoveToFront(element)
+		return
+	}
+
+	c.entries[orderNumber] = c.order.PushFront(&ownerCacheEntry{orderNumber: orderNumber, userID: userID, expiresAt: expiresAt})
+	if c.order.Len() > c.capacity {
+		oldest := c.order.Back()
+		c.order.Remove(oldest)
+		delete(c.entries, oldest.Value.(*ownerCacheEntry).orderNumber)
+	}
+}
+
+// WithOrderOwnerCache задает размер и время жизни кеша владельцев заказов, size 0 отключает кеш.
+func WithOrderOwnerCache(size int, ttl time.Duration) Option {
+	return func(s *Storage) {
+		if size <= 0 {
+			s.ownerCache = nil
+			return
+		}
+		s.ownerCache = newOrderOwnerCache(size, ttl)
+	}
+}
+
+// duplicateOrderError возвращает ошибку повторной загрузки заказа в зависимости от владельца.
+func duplicateOrderError(ownerID, userID string) error {
+	if ownerID == userID {
+		return ErrOrderNumberWasAlreadyAddedByThisUser
+	}
+	return ErrOrderNumberWasAlreadyAddedByAnotherUser
+}
@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// Интервал опроса accrual-системы адаптивный: удваивается после цикла, в котором она ответила 429,
+// и уменьшается вдвое после успешного цикла, оставаясь в границах [minPollInterval, maxPollInterval].
+var (
+	minPollInterval int64 = int64(time.Millisecond * 500)
+	maxPollInterval int64 = int64(time.Second * 60)
+	pollInterval    int64 = int64(time.Millisecond * 500)
+)
+
+// SetPollIntervalBounds задает границы интервала опроса и сбрасывает его к минимальному.
+func SetPollIntervalBounds(lower, upper time.Duration) {
+	atomic.StoreInt64(&minPollInterval, int64(lower))
+	atomic.StoreInt64(&maxPollInterval, int64(upper))
+	atomic.StoreInt64(&pollInterval, int64(lower))
+}
+
+// PollInterval возвращает текущий интервал между циклами обновления статусов заказов.
+func PollInterval() time.Duration {
+	return time.Duration(atomic.LoadInt64(&pollInterval))
+}
+
+func backOffPollInterval() time.Duration {
+	return updatePollInterval(func(current int64) int64 {
+		if upper := atomic.LoadInt64(&maxPollInterval); current*2 < upper {
+			return current * 2
+		}
+		return atomic.LoadInt64(&maxPollInterval)
+	})
+}
+
+func recoverPollInterval() time.Duration {
+	return updatePollInterval(func(current int64) int64 {
+		if lower := atomic.LoadInt64(&minPollInterval); current/2 > lower {
+			return current / 2
+		}
+		return atomic.LoadInt64(&minPollInterval)
+	})
+}
+
+func updatePollInterval(next func(current int64) int64) time.Duration {
+	for {
+		current := atomic.LoadInt64(&pollInterval)
+		updated := next(current)
+		if atomic.CompareAndSwapInt64(&pollInterval, current, updated) {
+			return time.Duration(updated)
+		}
+	}
+}
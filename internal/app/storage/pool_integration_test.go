@@ -0,0 +1,46 @@
+//go:build integration
+
+package storage
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// TestPoolLimits проверяет, что пул открывает не больше maxConns соединений: следующий запрос
+// ждет освобождения соединения, а не открывает новое.
+func TestPoolLimits(t *testing.T) {
+	const maxConns, minConns = 3, 1
+	s := newTestStorage(t, WithPoolLimits(maxConns, minConns, 0))
+	ctx := context.Background()
+
+	config := s.DB.Config()
+	if config.MaxConns != maxConns || config.MinConns != minConns {
+		t.Errorf("pool limits = %d/%d, want %d/%d", config.MaxConns, config.MinConns, maxConns, minConns)
+	}
+	if config.MaxConnLifetime != unlimitedConnLifetime {
+		t.Errorf("max conn lifetime = %s, want %s for 0", config.MaxConnLifetime, unlimitedConnLifetime)
+	}
+
+	for i := 0; i < maxConns; i++ {
+		conn, err := s.DB.Acquire(ctx)
+		if err != nil {
+			t.Fatalf("acquire connection %d: %v", i+1, err)
+		}
+		defer conn.Release()
+	}
+
+	acquireCtx, cancel := context.WithTimeout(ctx, time.Millisecond*200)
+	defer cancel()
+	if conn, err := s.DB.Acquire(acquireCtx); !errors.Is(err, context.DeadlineExceeded) {
+		if err == nil {
+			conn.Release()
+		}
+		t.Fatalf("acquire over the limit: error = %v, want %v", err, context.DeadlineExceeded)
+	}
+	if total := s.DB.Stat().TotalConns(); total != maxConns {
+		t.Errorf("open connections = %d, want %d", total, maxConns)
+	}
+}
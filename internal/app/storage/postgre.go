@@ -3,56 +3,334 @@ package storage
 import (
 	"context"
 	"database/sql"
-	"encoding/json"
 	"errors"
+	"expvar"
 	"fmt"
 	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5"
 	"github.com/jackc/pgx/v5/pgconn"
-	_ "github.com/jackc/pgx/v5/stdlib"
+	"github.com/jackc/pgx/v5/pgxpool"
+	"github.com/vancho-go/gophermart/internal/app/accrual"
 	"github.com/vancho-go/gophermart/internal/app/auth"
+	"github.com/vancho-go/gophermart/internal/app/events"
 	"github.com/vancho-go/gophermart/internal/app/logger"
 	"github.com/vancho-go/gophermart/internal/app/models"
 	"go.uber.org/zap"
-	"io"
-	"net/http"
-	url2 "net/url"
-	"runtime"
+	"strconv"
 	"sync"
+	"sync/atomic"
 	"time"
 )
 
 var (
 	ErrUsernameNotUnique                       = errors.New("username is already in use")
+	ErrUsernameDeletedPendingExpiry            = errors.New("username belongs to a deleted user and is not released yet")
+	ErrEmailNotUnique                          = errors.New("email is already in use")
 	ErrUserNotFound                            = errors.New("user not found")
 	ErrOrderNumberWasAlreadyAddedByThisUser    = errors.New("order number has already been added by this user")
 	ErrOrderNumberWasAlreadyAddedByAnotherUser = errors.New("order number has already been added by another user")
 	ErrNotEnoughBonuses                        = errors.New("not enough bonuses to use for order")
-	ErrEmptyWithdrawalHistory                  = errors.New("no withdrawals for this user")
+	ErrOrderNotFound                           = errors.New("order not found")
+	ErrDatabaseUnavailable                     = errors.New("database is unavailable")
+	ErrInvalidOrderStatus                      = errors.New("invalid order status")
+	ErrWebhookNotFound                         = errors.New("webhook not found")
+	ErrNegativeBalance                         = errors.New("balance invariant violated: negative balance")
+	ErrOrderStatusTransition                   = errors.New("order status transition is not allowed")
+
+	errUserIDTaken = errors.New("user id is already taken")
+)
+
+const (
+	defaultAccrualWorkers        = 10
+	defaultAccrualBatchSize      = 50
+	defaultUpdateCycleTimeout    = time.Second * 30
+	defaultPendingBatchSize      = 100
+	defaultOrderCheckCooldown    = time.Second * 10
+	defaultAccrualRequestTimeout = time.Second * 5
+	defaultStatementTimeout      = time.Second * 30
+	// unlimitedConnLifetime заменяет нулевое время жизни соединения: в pgxpool 0 означает
+	// немедленное пересоздание, а не отсутствие ограничения
+	unlimitedConnLifetime = time.Hour * 24 * 365
+	maxUserIDAttempts     = 3
 )
 
 type Storage struct {
-	DB *sql.DB
+	DB                   *pgxpool.Pool
+	maxConns             int
+	minConns             int
+	connMaxLifetime      time.Duration
+	events               *events.EventBus
+	financialTxIsolation pgx.TxIsoLevel
+	webhookNotifier      WebhookNotifier
+	idempotencyKeyTTL    time.Duration
+	// balanceDiscrepancyThreshold — расхождение, о котором сообщает ReconcileBalances
+	balanceDiscrepancyThreshold float64
+	health                      healthState
+	// replica — необязательная реплика для читающих запросов списков и баланса
+	replica          *pgxpool.Pool
+	replicaURI       string
+	replicaHealth    healthState
+	accrualClient    AccrualClient
+	queryTimeout     time.Duration
+	accrualWorkers   int
+	accrualTimeout   time.Duration
+	accrualBatchSize int
+	// batchUnsupported выставляется, когда accrual-система ответила 404 на пакетный запрос,
+	// после этого статусы запрашиваются по одному заказу
+	batchUnsupported atomic.Bool
+	// updateCycleRunning защищает от наложения циклов обновления статусов заказов
+	updateCycleRunning      atomic.Bool
+	updateCycleTimeout      time.Duration
+	updaterStatementTimeout time.Duration
+	// statementTimeout — statement_timeout, выставляемый каждому новому соединению с БД
+	statementTimeout   time.Duration
+	slowQueryThreshold time.Duration
+	pendingBatchSize   int
+	checkCooldown      time.Duration
+	maxUpdateAttempts  int
+	slowQueryLogger    logger.Logger
+	dispatchQueue      DispatchQueue
+	// generateUserID выдает идентификатор нового пользователя, тесты подменяют его, чтобы
+	// получить коллизию
+	generateUserID func() string
+	// inFlight — номера заказов, обновление которых выполняется прямо сейчас
+	inFlight sync.Map
+	// ownerCache избавляет повторную загрузку известного номера заказа от запроса в БД
+	ownerCache *orderOwnerCache
+	// updateResults — счетчики результатов обновления заказов по классам, см. logUpdateResult
+	updateResults expvar.Map
+	// negativeBalances — сколько раз обнаружен отрицательный баланс, см. ErrNegativeBalance
+	negativeBalances expvar.Int
+}
+
+type AccrualClient interface {
+	GetOrderInfo(ctx context.Context, orderNumber string) (*models.APIOrderInfoResponse, error)
+	BatchGetOrderInfo(ctx context.Context, orderNumbers []string) ([]models.APIOrderInfoResponse, error)
+}
+
+type WebhookNotifier interface {
+	Notify(webhook models.Webhook, payload models.APIWebhookPayload)
+}
+
+type Option func(*Storage)
+
+// WithFinancialTxIsolation задает уровень изоляции транзакций, изменяющих баланс.
+func WithFinancialTxIsolation(level pgx.TxIsoLevel) Option {
+	return func(s *Storage) {
+		s.financialTxIsolation = level
+	}
+}
+
+// WithQueryTimeout ограничивает время выполнения читающих запросов, 0 отключает ограничение.
+func WithQueryTimeout(timeout time.Duration) Option {
+	return func(s *Storage) {
+		s.queryTimeout = timeout
+	}
+}
+
+// withQueryTimeout возвращает контекст короткого запроса вне транзакции, отмена которого прерывает
+// запрос в драйвере.
+func (s *Storage) withQueryTimeout(ctx context.Context) (context.Context, context.CancelFunc) {
+	if s.queryTimeout <= 0 {
+		return context.WithCancel(ctx)
+	}
+	return context.WithTimeout(ctx, s.queryTimeout)
+}
+
+// WithAccrualRequestTimeout ограничивает время одного запроса к accrual-системе.
+func WithAccrualRequestTimeout(timeout time.Duration) Option {
+	return func(s *Storage) {
+		s.accrualTimeout = timeout
+	}
+}
+
+// WithUpdaterTimeouts ограничивает длительность цикла обновления статусов заказов и, через
+// statement_timeout, длительность отдельного запроса фонового обновления (0 — без ограничения).
+func WithUpdaterTimeouts(cycleTimeout, statementTimeout time.Duration) Option {
+	return func(s *Storage) {
+		s.updateCycleTimeout = cycleTimeout
+		s.updaterStatementTimeout = statementTimeout
+	}
 }
 
-func Initialize(uri string) (*Storage, error) {
-	db, err := sql.Open("pgx", uri)
+// WithStatementTimeout задает statement_timeout, с которым открывается каждое соединение с основной БД
+// и репликой (0 — без ограничения). Прерывает на стороне сервера запросы, которые не отменил
+// контекст, например после потери клиента. SET LOCAL фонового обновления переопределяет его в транзакции.
+func WithStatementTimeout(timeout time.Duration) Option {
+	return func(s *Storage) {
+		s.statementTimeout = timeout
+	}
+}
+
+// openPool открывает пул соединений uri с ограничениями WithPoolLimits, выставляя каждому
+// соединению statement_timeout.
+func (s *Storage) openPool(ctx context.Context, uri string) (*pgxpool.Pool, error) {
+	config, err := pgxpool.ParseConfig(uri)
+	if err != nil {
+		return nil, fmt.Errorf("openPool: error parsing database uri: %w", err)
+	}
+	if s.statementTimeout > 0 {
+		config.ConnConfig.RuntimeParams["statement_timeout"] = strconv.FormatInt(s.statementTimeout.Milliseconds(), 10)
+	}
+	if s.maxConns > 0 {
+		config.MaxConns = int32(s.maxConns)
+		config.MinConns = int32(s.minConns)
+		config.MaxConnLifetime = s.connMaxLifetime
+		if s.connMaxLifetime == 0 {
+			config.MaxConnLifetime = unlimitedConnLifetime
+		}
+	}
+
+	pool, err := pgxpool.NewWithConfig(ctx, config)
+	if err != nil {
+		return nil, fmt.Errorf("openPool: %w", err)
+	}
+	return pool, nil
+}
+
+// setUpdaterStatementTimeout выставляет statement_timeout до конца транзакции tx.
+func (s *Storage) setUpdaterStatementTimeout(ctx context.Context, tx pgx.Tx) error {
+	if s.updaterStatementTimeout <= 0 {
+		return nil
+	}
+	timeout := strconv.FormatInt(s.updaterStatementTimeout.Milliseconds(), 10)
+	if _, err := tx.Exec(ctx, "SELECT set_config('statement_timeout', $1, true)", timeout); err != nil {
+		return fmt.Errorf("setUpdaterStatementTimeout: %w", err)
+	}
+	return nil
+}
+
+// WithReadReplica направляет запросы списков заказов, списаний и баланса на реплику uri,
+// пока она доступна. Пустой uri отключает реплику.
+func WithReadReplica(uri string) Option {
+	return func(s *Storage) {
+		s.replicaURI = uri
+	}
+}
+
+// WithPendingOrdersBatch задает, сколько незавершенных заказов опрашивается за цикл и через
+// какое время после проверки заказ может быть проверен снова.
+func WithPendingOrdersBatch(size int, checkCooldown time.Duration) Option {
+	return func(s *Storage) {
+		s.pendingBatchSize = size
+		s.checkCooldown = checkCooldown
+	}
+}
+
+// WithAccrualBatchSize задает число заказов в одном пакетном запросе к accrual-системе,
+// 1 отключает пакетные запросы.
+func WithAccrualBatchSize(size int) Option {
+	return func(s *Storage) {
+		s.accrualBatchSize = size
+	}
+}
+
+// WithAccrualWorkers задает число параллельных запросов к accrual-системе за один цикл обновления.
+// Запросы ограничены сетью, а не CPU, но большее значение сильнее нагружает accrual-систему
+// и быстрее исчерпывает ее лимит запросов (429).
+func WithAccrualWorkers(workers int) Option {
+	return func(s *Storage) {
+		s.accrualWorkers = workers
+	}
+}
+
+// WithPoolLimits ограничивает пулы основной БД и реплики: не больше maxConns открытых, не меньше
+// minConns соединений держатся открытыми, соединения старше connMaxLifetime пересоздаются (0 — без ограничения).
+func WithPoolLimits(maxConns, minConns int, connMaxLifetime time.Duration) Option {
+	return func(s *Storage) {
+		s.maxConns = maxConns
+		s.minConns = minConns
+		s.connMaxLifetime = connMaxLifetime
+	}
+}
+
+func WithAccrualClient(client AccrualClient) Option {
+	return func(s *Storage) {
+		s.accrualClient = client
+	}
+}
+
+func WithWebhookNotifier(notifier WebhookNotifier) Option {
+	return func(s *Storage) {
+		s.webhookNotifier = notifier
+	}
+}
+
+func ParseIsolationLevel(level string) (pgx.TxIsoLevel, error) {
+	switch level {
+	case "read_committed":
+		return pgx.ReadCommitted, nil
+	case "repeatable_read":
+		return pgx.RepeatableRead, nil
+	case "serializable":
+		return pgx.Serializable, nil
+	default:
+		return "", fmt.Errorf("parseIsolationLevel: unknown isolation level %q", level)
+	}
+}
+
+type orderStatusUpdate struct {
+	userID string
+	event  models.APIOrderStatusEvent
+}
+
+func Initialize(uri string, eventBus *events.EventBus, opts ...Option) (*Storage, error) {
+	storage := &Storage{
+		events:                      eventBus,
+		financialTxIsolation:        pgx.RepeatableRead,
+		idempotencyKeyTTL:           time.Hour * 24,
+		balanceDiscrepancyThreshold: defaultBalanceDiscrepancyThreshold,
+		accrualWorkers:              defaultAccrualWorkers,
+		accrualTimeout:              defaultAccrualRequestTimeout,
+		accrualBatchSize:            defaultAccrualBatchSize,
+		updateCycleTimeout:          defaultUpdateCycleTimeout,
+		statementTimeout:            defaultStatementTimeout,
+		pendingBatchSize:            defaultPendingBatchSize,
+		checkCooldown:               defaultOrderCheckCooldown,
+		maxUpdateAttempts:           defaultMaxUpdateAttempts,
+		health:                      healthState{healthy: true, lastCheck: time.Now()},
+		ownerCache:                  newOrderOwnerCache(defaultOwnerCacheSize, defaultOwnerCacheTTL),
+		generateUserID:              auth.GenerateUserID,
+	}
+	for _, opt := range opts {
+		opt(storage)
+	}
+
+	ctx := context.Background()
+	db, err := storage.openPool(ctx, uri)
 	if err != nil {
 		return nil, fmt.Errorf("initialize: error opening database: %w", err)
 	}
 
-	err = db.Ping()
+	err = db.Ping(ctx)
 	if err != nil {
 		return nil, fmt.Errorf("initialize: error verifing database connection: %w", err)
 	}
 
-	err = createIfNotExists(db)
+	err = createIfNotExists(ctx, db)
 	if err != nil {
 		return nil, fmt.Errorf("initialize: error creating database structure: %w", err)
 	}
-	return &Storage{DB: db}, nil
+
+	err = applyMigrations(ctx, db)
+	if err != nil {
+		return nil, fmt.Errorf("initialize: error migrating database structure: %w", err)
+	}
+
+	storage.DB = db
+
+	if storage.replicaURI != "" {
+		replica, err := storage.openPool(ctx, storage.replicaURI)
+		if err != nil {
+			return nil, fmt.Errorf("initialize: error opening read replica: %w", err)
+		}
+		storage.replica = replica
+		storage.replicaHealth = healthState{healthy: true, lastCheck: time.Now()}
+	}
+	return storage, nil
 }
 
-func createIfNotExists(db *sql.DB) error {
+func createIfNotExists(ctx context.Context, db *pgxpool.Pool) error {
 	createTableQuery := `
 		CREATE TABLE IF NOT EXISTS users (
 -- 			id SERIAL PRIMARY KEY,
@@ -69,7 +347,7 @@ func createIfNotExists(db *sql.DB) error {
 			accrual NUMERIC(20, 2) DEFAULT NULL
 		);
 		CREATE TABLE IF NOT EXISTS balances (
-			user_id VARCHAR REFERENCES users(user_id) ON DELETE CASCADE NOT NULL,
+			user_id VARCHAR PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE NOT NULL,
 			current NUMERIC(20, 2) DEFAULT 0.0 CHECK (current >=0)
 		);
 		CREATE TABLE IF NOT EXISTS withdrawals (
@@ -79,34 +357,54 @@ func createIfNotExists(db *sql.DB) error {
 		    processed_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
 		    UNIQUE(order_id)
 		);
+		CREATE TABLE IF NOT EXISTS user_webhooks (
+		    user_id VARCHAR PRIMARY KEY REFERENCES users(user_id) ON DELETE CASCADE NOT NULL,
+		    url VARCHAR NOT NULL,
+		    updated_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP
+		);
+		CREATE TABLE IF NOT EXISTS idempotency_keys (
+		    user_id VARCHAR REFERENCES users(user_id) ON DELETE CASCADE NOT NULL,
+		    idempotency_key VARCHAR NOT NULL,
+		    response_status INT DEFAULT NULL,
+		    response_body BYTEA DEFAULT NULL,
+		    created_at TIMESTAMP WITH TIME ZONE NOT NULL DEFAULT CURRENT_TIMESTAMP,
+		    PRIMARY KEY (user_id, idempotency_key)
+		);
 `
 
-	_, err := db.Exec(createTableQuery)
+	_, err := db.Exec(ctx, createTableQuery)
 	if err != nil {
 		return fmt.Errorf("createIfNotExists: %w", err)
 	}
 	return nil
 }
 
-func (s *Storage) RegisterUser(ctx context.Context, username, password string) (string, error) {
-	usernameUnique, err := s.isUsernameUnique(ctx, username)
-	if err != nil {
-		return "", fmt.Errorf("register: user register error: %w", err)
-	}
-	if !usernameUnique {
-		return "", ErrUsernameNotUnique
-	}
+// RegisterUser регистрирует пользователя, пустой email означает, что email не указан.
+func (s *Storage) RegisterUser(ctx context.Context, username, email, password string) (userID string, err error) {
+	// хеширование пароля не замеряется observeQuery, но попадает в трассировку
+	ctx, span := tracer.Start(ctx, "storage.RegisterUser")
+	defer span.End()
 
-	userID := auth.GenerateUserID()
-	userIDUnique, err := s.isUserIDUnique(ctx, userID)
-	if err != nil {
-		return "", fmt.Errorf("register: user register error: %w", err)
+	err = withRetry(ctx, func() error {
+		userID, err = s.registerUser(ctx, username, email, password)
+		return err
+	})
+	return userID, err
+}
+
+func (s *Storage) registerUser(ctx context.Context, username, email, password string) (string, error) {
+	if err := s.checkUsernameAvailable(ctx, username); err != nil {
+		return "", err
 	}
-	for !userIDUnique {
-		userIDUnique, err = s.isUserIDUnique(ctx, userID)
+
+	if email != "" {
+		emailUnique, err := s.isEmailUnique(ctx, email)
 		if err != nil {
 			return "", fmt.Errorf("register: user register error: %w", err)
 		}
+		if !emailUnique {
+			return "", ErrEmailNotUnique
+		}
 	}
 
 	hashedPassword, err := auth.HashPassword(password)
@@ -114,35 +412,69 @@ func (s *Storage) RegisterUser(ctx context.Context, username, password string) (
 		return "", fmt.Errorf("register: user register error: %w", err)
 	}
 
-	tx, err := s.DB.BeginTx(ctx, nil)
-	if err != nil {
-		err = fmt.Errorf("registerUser: transaction error: %w", err)
-		return "", err
+	// коллизия UUIDv4 практически невозможна, поэтому уникальность не проверяется заранее:
+	// первичный ключ отклонит совпавший идентификатор, и вставка повторится с новым
+	for attempt := 0; attempt < maxUserIDAttempts; attempt++ {
+		userID := s.generateUserID()
+		err = s.insertUser(ctx, userID, username, email, hashedPassword)
+		if errors.Is(err, errUserIDTaken) {
+			continue
+		}
+		if err != nil {
+			return "", err
+		}
+		return userID, nil
 	}
-	defer tx.Rollback()
+	return "", fmt.Errorf("register: no unique user id after %d attempts: %w", maxUserIDAttempts, err)
+}
 
-	query := "INSERT INTO users (user_id, login, password) VALUES ($1,$2,$3)"
-	_, err = tx.ExecContext(ctx, query, userID, username, hashedPassword)
+// insertUser создает пользователя и его баланс в одной транзакции. Возвращает errUserIDTaken,
+// если userID уже занят, и ErrUsernameNotUnique, если логин успели занять одновременной регистрацией.
+func (s *Storage) insertUser(ctx context.Context, userID, username, email, hashedPassword string) error {
+	tx, err := s.DB.Begin(ctx)
 	if err != nil {
-		return "", fmt.Errorf("register: user register error: %w", err)
+		return fmt.Errorf("registerUser: transaction error: %w", err)
 	}
+	defer tx.Rollback(ctx)
 
-	query = "INSERT INTO balances (user_id) VALUES ($1)"
-	_, err = tx.ExecContext(ctx, query, userID)
+	query := "INSERT INTO users (user_id, login, email, password) VALUES ($1,$2,NULLIF($3,''),$4)"
+	_, err = tx.Exec(ctx, query, userID, username, email, hashedPassword)
 	if err != nil {
-		return "", fmt.Errorf("register: error adding balance wallet: %w", err)
+		var pgErr *pgconn.PgError
+		if errors.As(err, &pgErr) && pgErr.Code == pgerrcode.UniqueViolation {
+			switch pgErr.ConstraintName {
+			case "users_login_lower_key":
+				return ErrUsernameNotUnique
+			case "users_email_lower_key":
+				return ErrEmailNotUnique
+			case "users_pkey", "users_user_id_key":
+				return errUserIDTaken
+			}
+		}
+		return fmt.Errorf("register: user register error: %w", err)
 	}
 
-	err = tx.Commit()
+	query = "INSERT INTO balances (user_id) VALUES ($1)"
+	_, err = tx.Exec(ctx, query, userID)
 	if err != nil {
-		err = fmt.Errorf("register: error committing transaction: %w", err)
-		return "", err
+		return fmt.Errorf("register: error adding balance wallet: %w", err)
 	}
 
-	return userID, nil
+	if err = tx.Commit(ctx); err != nil {
+		return fmt.Errorf("register: error committing transaction: %w", err)
+	}
+	return nil
 }
 
+// userByIdentifierCondition находит активного пользователя по логину или email, совпадение
+// по логину приоритетнее, если логин одного пользователя совпал с email другого.
+const userByIdentifierCondition = `(LOWER(login)=LOWER($1) OR LOWER(email)=LOWER($1)) AND deleted_at IS NULL
+	ORDER BY LOWER(login)=LOWER($1) DESC LIMIT 1`
+
 func (s *Storage) AuthenticateUser(ctx context.Context, username, password string) (string, error) {
+	ctx, span := tracer.Start(ctx, "storage.AuthenticateUser")
+	defer span.End()
+
 	hashedPassword, err := s.getHashedPasswordByUsername(ctx, username)
 	if err != nil {
 		return "", fmt.Errorf("authenticateUser: error user auth: %w", err)
@@ -154,16 +486,40 @@ func (s *Storage) AuthenticateUser(ctx context.Context, username, password strin
 	if err != nil {
 		return "", fmt.Errorf("authenticateUser: error user auth: %w", err)
 	}
+
+	// хеши старой схемы (bcrypt) переводятся на текущую при входе, пока известен пароль;
+	// при ошибке пароль перехешируется при следующем входе, поэтому вход не прерывается
+	if auth.NeedsRehash(hashedPassword) {
+		_ = s.rehashPassword(ctx, userID, password, hashedPassword)
+	}
 	return userID, nil
 }
 
+// rehashPassword заменяет хеш пароля пользователя хешем текущей схемы, если хеш не изменился
+// с момента проверки.
+func (s *Storage) rehashPassword(ctx context.Context, userID, password, oldHash string) error {
+	newHash, err := auth.HashPassword(password)
+	if err != nil {
+		return fmt.Errorf("rehashPassword: %w", err)
+	}
+
+	query := "UPDATE users SET password=$1 WHERE user_id=$2 AND password=$3"
+	if _, err = s.DB.Exec(ctx, query, newHash, userID, oldHash); err != nil {
+		return fmt.Errorf("rehashPassword: error updating password: %w", err)
+	}
+	return nil
+}
+
 func (s *Storage) getHashedPasswordByUsername(ctx context.Context, username string) (string, error) {
-	query := "SELECT password FROM users WHERE login=$1"
-	row := s.DB.QueryRowContext(ctx, query, username)
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	query := "SELECT password FROM users WHERE " + userByIdentifierCondition
+	row := s.DB.QueryRow(ctx, query, username)
 
 	var hashedPassword string
 	err := row.Scan(&hashedPassword)
-	if errors.Is(err, sql.ErrNoRows) {
+	if errors.Is(err, pgx.ErrNoRows) {
 		return "", fmt.Errorf("getHashedPasswordByUsername: username not found: %w", ErrUserNotFound)
 	} else if err != nil {
 		return "", fmt.Errorf("getHashedPasswordByUsername: error scanning row: %w", err)
@@ -171,35 +527,57 @@ func (s *Storage) getHashedPasswordByUsername(ctx context.Context, username stri
 	return hashedPassword, nil
 }
 
-func (s *Storage) isUsernameUnique(ctx context.Context, username string) (bool, error) {
-	query := "SELECT COUNT(*) FROM users WHERE login=$1"
-	row := s.DB.QueryRowContext(ctx, query, username)
-
-	var count int
-	if err := row.Scan(&count); err != nil {
-		return false, fmt.Errorf("isUsernameUnique: error scanning row: %w", err)
+// checkUsernameAvailable возвращает ErrUsernameNotUnique, если логин занят активным пользователем,
+// и ErrUsernameDeletedPendingExpiry, если пользователь с этим логином удален меньше
+// deletedLoginRetention назад. После этого срока логин можно зарегистрировать повторно:
+// поиск пользователей по логину учитывает только активных.
+func (s *Storage) checkUsernameAvailable(ctx context.Context, username string) error {
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `SELECT
+			COUNT(*) FILTER (WHERE deleted_at IS NULL),
+			COUNT(*) FILTER (WHERE deleted_at > NOW() - $2 * INTERVAL '1 millisecond')
+		FROM users WHERE LOWER(login)=LOWER($1)`
+	row := s.DB.QueryRow(ctx, query, username, deletedLoginRetention.Milliseconds())
+
+	var active, pendingExpiry int
+	if err := row.Scan(&active, &pendingExpiry); err != nil {
+		return fmt.Errorf("checkUsernameAvailable: error scanning row: %w", err)
 	}
-	return count == 0, nil
+	switch {
+	case active > 0:
+		return ErrUsernameNotUnique
+	case pendingExpiry > 0:
+		return ErrUsernameDeletedPendingExpiry
+	}
+	return nil
 }
 
-func (s *Storage) isUserIDUnique(ctx context.Context, userID string) (bool, error) {
-	query := "SELECT COUNT(*) FROM users WHERE user_id=$1"
-	row := s.DB.QueryRowContext(ctx, query, userID)
+func (s *Storage) isEmailUnique(ctx context.Context, email string) (bool, error) {
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	query := "SELECT COUNT(*) FROM users WHERE LOWER(email)=LOWER($1)"
+	row := s.DB.QueryRow(ctx, query, email)
 
 	var count int
 	if err := row.Scan(&count); err != nil {
-		return false, fmt.Errorf("isUserIDUnique: error scanning row: %w", err)
+		return false, fmt.Errorf("isEmailUnique: error scanning row: %w", err)
 	}
 	return count == 0, nil
 }
 
 func (s *Storage) getUserIDByUsername(ctx context.Context, username string) (string, error) {
-	query := "SELECT user_id FROM users WHERE login=$1"
-	row := s.DB.QueryRowContext(ctx, query, username)
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	query := "SELECT user_id FROM users WHERE " + userByIdentifierCondition
+	row := s.DB.QueryRow(ctx, query, username)
 
 	var userID string
 	err := row.Scan(&userID)
-	if errors.Is(err, sql.ErrNoRows) {
+	if errors.Is(err, pgx.ErrNoRows) {
 		return "", fmt.Errorf("getUserIDByUsername: username not found: %w", ErrUserNotFound)
 	} else if err != nil {
 		return "", fmt.Errorf("getUserIDByUsername: error scanning row: %w", err)
@@ -208,8 +586,31 @@ func (s *Storage) getUserIDByUsername(ctx context.Context, username string) (str
 }
 
 func (s *Storage) AddOrder(ctx context.Context, order models.APIAddOrderRequest) error {
+	ctx, done := s.observeQuery(ctx, "addOrder")
+	defer done()
+
+	if ownerID, ok := s.ownerCache.get(order.OrderNumber); ok {
+		return fmt.Errorf("addOrder: error adding order number: %w", duplicateOrderError(ownerID, order.UserID))
+	}
+
+	err := withRetry(ctx, func() error {
+		return s.addOrder(ctx, order)
+	})
+	if err != nil {
+		return err
+	}
+
+	s.ownerCache.add(order.OrderNumber, order.UserID)
+	s.dispatchOrders(order.OrderNumber)
+	return nil
+}
+
+func (s *Storage) addOrder(ctx context.Context, order models.APIAddOrderRequest) error {
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
 	query := "INSERT INTO orders (order_id, user_id) VALUES ($1, $2)"
-	_, err := s.DB.ExecContext(ctx, query, order.OrderNumber, order.UserID)
+	_, err := s.DB.Exec(ctx, query, order.OrderNumber, order.UserID)
 	if err != nil {
 		var pgErr *pgconn.PgError
 		if errors.As(err, &pgErr) {
@@ -218,12 +619,8 @@ func (s *Storage) AddOrder(ctx context.Context, order models.APIAddOrderRequest)
 				if err != nil {
 					return fmt.Errorf("addOrder: %w", err)
 				}
-
-				if userID == order.UserID {
-					return fmt.Errorf("addOrder: error adding order number: %w", ErrOrderNumberWasAlreadyAddedByThisUser)
-				} else {
-					return fmt.Errorf("addOrder: error adding order number: %w", ErrOrderNumberWasAlreadyAddedByAnotherUser)
-				}
+				s.ownerCache.add(order.OrderNumber, userID)
+				return fmt.Errorf("addOrder: error adding order number: %w", duplicateOrderError(userID, order.UserID))
 			}
 		}
 		return fmt.Errorf("addOrder: error adding order number: %w", err)
@@ -231,19 +628,118 @@ func (s *Storage) AddOrder(ctx context.Context, order models.APIAddOrderRequest)
 	return nil
 }
 
-func (s *Storage) GetOrders(ctx context.Context, userID string) ([]models.APIGetOrderResponse, error) {
-	query := "SELECT order_id,uploaded_at,status,accrual FROM orders WHERE user_id=$1 ORDER BY uploaded_at"
+// AddOrders добавляет номера заказов пользователя одним запросом INSERT ... ON CONFLICT DO NOTHING.
+// Возвращает ошибки в порядке orderNumbers: nil для добавленного номера или ошибку дубликата в тех же
+// терминах, что и AddOrder. Повтор номера внутри пакета считается дубликатом этого пользователя.
+func (s *Storage) AddOrders(ctx context.Context, userID string, orderNumbers []string) ([]error, error) {
+	ctx, done := s.observeQuery(ctx, "addOrders")
+	defer done()
 
-	rows, err := s.DB.QueryContext(ctx, query, userID)
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("addOrders: transaction error: %w", err)
+	}
+	defer tx.Rollback(ctx)
 
-	if rows.Err() != nil {
-		return []models.APIGetOrderResponse{}, fmt.Errorf("getOrders: error getting orders: %w", rows.Err())
+	query := `INSERT INTO orders (order_id, user_id) SELECT UNNEST($1::varchar[]), $2
+		ON CONFLICT (order_id) DO NOTHING RETURNING order_id`
+	rows, err := tx.Query(ctx, query, orderNumbers, userID)
+	if err != nil {
+		return nil, fmt.Errorf("addOrders: error adding order numbers: %w", err)
 	}
-	defer rows.Close()
+	inserted := make(map[string]bool, len(orderNumbers))
+	for rows.Next() {
+		var orderNumber string
+		if err = rows.Scan(&orderNumber); err != nil {
+			rows.Close()
+			return nil, fmt.Errorf("addOrders: error scanning added order number: %w", err)
+		}
+		inserted[orderNumber] = true
+	}
+	rows.Close()
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("addOrders: error adding order numbers: %w", err)
+	}
+
+	owners := make(map[string]string)
+	if len(inserted) < len(orderNumbers) {
+		query = "SELECT order_id, user_id FROM orders WHERE order_id = ANY($1::varchar[])"
+		rows, err = tx.Query(ctx, query, orderNumbers)
+		if err != nil {
+			return nil, fmt.Errorf("addOrders: error getting userID by orderID: %w", err)
+		}
+		for rows.Next() {
+			var orderNumber, ownerID string
+			if err = rows.Scan(&orderNumber, &ownerID); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("addOrders: error scanning order owner: %w", err)
+			}
+			owners[orderNumber] = ownerID
+		}
+		rows.Close()
+		if err = rows.Err(); err != nil {
+			return nil, fmt.Errorf("addOrders: error getting userID by orderID: %w", err)
+		}
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("addOrders: error committing transaction: %w", err)
+	}
+
+	results := make([]error, len(orderNumbers))
+	for i, orderNumber := range orderNumbers {
+		if inserted[orderNumber] {
+			// номер добавлен этим запросом: первое вхождение принято, повторы — дубликаты
+			inserted[orderNumber] = false
+			s.ownerCache.add(orderNumber, userID)
+			s.dispatchOrders(orderNumber)
+			continue
+		}
+		ownerID, ok := owners[orderNumber]
+		if !ok {
+			ownerID = userID
+		}
+		s.ownerCache.add(orderNumber, ownerID)
+		results[i] = duplicateOrderError(ownerID, userID)
+	}
+	return results, nil
+}
+
+// OrdersFilter ограничивает выборку заказов: только статус Status (пустой — любой), не больше
+// Limit заказов (0 — без ограничения), начиная с Offset.
+type OrdersFilter struct {
+	Status models.OrderStatus
+	Limit  int
+	Offset int
+}
+
+// GetOrders возвращает заказы пользователя, подходящие под filter, от старых к новым.
+func (s *Storage) GetOrders(ctx context.Context, userID string, filter OrdersFilter) (orders []models.APIGetOrderResponse, err error) {
+	ctx, done := s.observeQuery(ctx, "getOrders")
+	defer done()
+
+	err = withRetry(ctx, func() error {
+		orders, err = s.getOrders(ctx, userID, filter)
+		return err
+	})
+	return orders, err
+}
+
+func (s *Storage) getOrders(ctx context.Context, userID string, filter OrdersFilter) ([]models.APIGetOrderResponse, error) {
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	// LIMIT NULL означает отсутствие ограничения
+	query := `SELECT order_id,uploaded_at,status,accrual FROM orders
+		WHERE user_id=$1 AND ($2 = '' OR status = $2)
+		ORDER BY uploaded_at, order_id
+		LIMIT NULLIF($3, 0) OFFSET $4`
 
+	rows, err := s.readDB().Query(ctx, query, userID, string(filter.Status), filter.Limit, filter.Offset)
 	if err != nil {
 		return nil, fmt.Errorf("getOrders: error getting orders: %w", err)
 	}
+	defer rows.Close()
 
 	var orderList []models.APIGetOrderResponse
 	for rows.Next() {
@@ -252,15 +748,45 @@ func (s *Storage) GetOrders(ctx context.Context, userID string) ([]models.APIGet
 		if err != nil {
 			return nil, fmt.Errorf("getOrders: error getting orders: %w", err)
 		}
+		order.UploadedAt = order.UploadedAt.UTC()
 		orderList = append(orderList, order)
 	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("getOrders: error getting orders: %w", err)
+	}
 
 	return orderList, nil
 }
 
+// OrdersVersion возвращает метку состояния списка заказов пользователя, которая меняется при
+// загрузке заказа и при изменении его статуса или начисления. Каждое изменение получает новую,
+// большую прежней ревизию из orders_revision_seq, поэтому сумма ревизий растет с каждым изменением
+// независимо от времени и порядка фиксации транзакций, а количество учитывает удаление заказов.
+// Запрос отвечает по индексу orders_user_revision_idx, не читая сами заказы.
+func (s *Storage) OrdersVersion(ctx context.Context, userID string) (string, error) {
+	ctx, done := s.observeQuery(ctx, "ordersVersion")
+	defer done()
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	var revisions, count int64
+	query := "SELECT COALESCE(SUM(revision), 0)::bigint, COUNT(*) FROM orders WHERE user_id = $1"
+	if err := s.readDB().QueryRow(ctx, query, userID).Scan(&revisions, &count); err != nil {
+		return "", fmt.Errorf("ordersVersion: error selecting order revisions: %w", err)
+	}
+	if count == 0 {
+		return "0", nil
+	}
+	return fmt.Sprintf("%d-%d", revisions, count), nil
+}
+
 func (s *Storage) getUserID(ctx context.Context, orderID string) (string, error) {
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
 	query := "SELECT user_id FROM orders WHERE order_id = $1"
-	row := s.DB.QueryRowContext(ctx, query, orderID)
+	row := s.DB.QueryRow(ctx, query, orderID)
 	var userID string
 	err := row.Scan(&userID)
 	if err != nil {
@@ -269,37 +795,49 @@ func (s *Storage) getUserID(ctx context.Context, orderID string) (string, error)
 	return userID, nil
 }
 
-func (s *Storage) GetCurrentBonusesAmount(ctx context.Context, userID string) (models.APIGetBonusesAmountResponse, error) {
+func (s *Storage) GetCurrentBonusesAmount(ctx context.Context, userID string) (balance models.APIGetBonusesAmountResponse, err error) {
+	ctx, done := s.observeQuery(ctx, "getCurrentBonusesAmount")
+	defer done()
+
+	err = withRetry(ctx, func() error {
+		balance, err = s.getCurrentBonusesAmount(ctx, userID)
+		return err
+	})
+	return balance, err
+}
+
+func (s *Storage) getCurrentBonusesAmount(ctx context.Context, userID string) (models.APIGetBonusesAmountResponse, error) {
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
 	var bonusesResponse models.APIGetBonusesAmountResponse
 
-	tx, err := s.DB.BeginTx(ctx, nil)
+	tx, err := s.readDB().BeginTx(ctx, pgx.TxOptions{AccessMode: pgx.ReadOnly})
 	if err != nil {
 		err = fmt.Errorf("getCurrentBonusesAmount: transaction error: %w", err)
 		return models.APIGetBonusesAmountResponse{}, err
 	}
-	defer tx.Rollback()
+	defer tx.Rollback(ctx)
 
-	query := "SELECT current FROM balances WHERE user_id=$1"
-	rowCurrent := tx.QueryRowContext(ctx, query, userID)
+	// пользователь без строки в balances еще ничего не накопил; больше одной строки быть не может —
+	// user_id является первичным ключом balances (миграция 10)
+	query := "SELECT COALESCE((SELECT current FROM balances WHERE user_id=$1), 0.0)::float"
+	rowCurrent := tx.QueryRow(ctx, query, userID)
 	err = rowCurrent.Scan(&bonusesResponse.Current)
 	if err != nil {
-		if errors.Is(err, sql.ErrNoRows) {
-			bonusesResponse.Current = 0
-		} else {
-			err = fmt.Errorf("getCurrentBonusesAmount: error scanning current amount: %w", err)
-			return models.APIGetBonusesAmountResponse{}, err
-		}
+		err = fmt.Errorf("getCurrentBonusesAmount: error scanning current amount: %w", err)
+		return models.APIGetBonusesAmountResponse{}, err
 	}
 
-	query = "SELECT COALESCE(SUM(sum),0.0)::float as sum FROM withdrawals WHERE user_id=$1"
-	rowSum := tx.QueryRowContext(ctx, query, userID)
+	query = "SELECT COALESCE(SUM(amount),0.0)::float FROM balance_transactions WHERE user_id=$1 AND reason='withdrawal'"
+	rowSum := tx.QueryRow(ctx, query, userID)
 	err = rowSum.Scan(&bonusesResponse.Withdrawn)
 	if err != nil {
 		err = fmt.Errorf("getCurrentBonusesAmount: error scanning withdrawn amount: %w", err)
 		return models.APIGetBonusesAmountResponse{}, err
 	}
 
-	err = tx.Commit()
+	err = tx.Commit(ctx)
 	if err != nil {
 		err = fmt.Errorf("getCurrentBonusesAmount: error committing transaction: %w", err)
 		return models.APIGetBonusesAmountResponse{}, err
@@ -307,43 +845,77 @@ func (s *Storage) GetCurrentBonusesAmount(ctx context.Context, userID string) (m
 	return bonusesResponse, nil
 }
 
-func (s *Storage) UseBonuses(ctx context.Context, request models.APIUseBonusesRequest, userID string) (err error) {
-	tx, err := s.DB.BeginTx(ctx, nil)
-	if err != nil {
-		err = fmt.Errorf("useBonuses: transaction error: %w", err)
-		return err
+// BalanceVersion возвращает метку состояния баланса пользователя — xmin его строки в balances,
+// который меняется с каждой транзакцией, изменившей баланс, в том числе при списании.
+func (s *Storage) BalanceVersion(ctx context.Context, userID string) (string, error) {
+	ctx, done := s.observeQuery(ctx, "balanceVersion")
+	defer done()
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	var version string
+	query := "SELECT COALESCE((SELECT xmin::text FROM balances WHERE user_id = $1), '0')"
+	if err := s.readDB().QueryRow(ctx, query, userID).Scan(&version); err != nil {
+		return "", fmt.Errorf("balanceVersion: error selecting balance version: %w", err)
 	}
-	defer tx.Rollback()
+	return version, nil
+}
 
-	var current float64
-	query := "SELECT current FROM balances where user_id=$1"
-	rowSum := tx.QueryRowContext(ctx, query, userID)
-	err = rowSum.Scan(&current)
+func (s *Storage) financialTxOptions() pgx.TxOptions {
+	return pgx.TxOptions{IsoLevel: s.financialTxIsolation}
+}
+
+// UseBonuses повторяется при кратковременных ошибках: повтор уже зафиксированного списания
+// отклоняется уникальностью withdrawals.order_id.
+func (s *Storage) UseBonuses(ctx context.Context, request models.APIUseBonusesRequest, userID string) error {
+	ctx, done := s.observeQuery(ctx, "useBonuses")
+	defer done()
+
+	return withRetry(ctx, func() error {
+		return s.useBonuses(ctx, request, userID)
+	})
+}
+
+func (s *Storage) useBonuses(ctx context.Context, request models.APIUseBonusesRequest, userID string) (err error) {
+	tx, err := s.DB.BeginTx(ctx, s.financialTxOptions())
 	if err != nil {
-		err = fmt.Errorf("useBonuses: error getting current bonuses amount: %w", err)
+		err = fmt.Errorf("useBonuses: transaction error: %w", err)
 		return err
 	}
+	defer tx.Rollback(ctx)
 
-	dif := current - request.Sum
-
-	if dif < 0 {
-		return fmt.Errorf("useBonuses: %w", ErrNotEnoughBonuses)
+	if err = ensureBalanceRow(ctx, tx, userID); err != nil {
+		return fmt.Errorf("useBonuses: %w", err)
 	}
 
-	query = "UPDATE balances SET current=$1 WHERE user_id=$2"
-	_, err = tx.ExecContext(ctx, query, dif, userID)
-	if err != nil {
+	// баланс уменьшается одним условным UPDATE, чтобы параллельные списания не читали один и тот же
+	// остаток при любом уровне изоляции
+	var current float64
+	query := "UPDATE balances SET current = current - $1 WHERE user_id = $2 AND current >= $1 RETURNING current::float"
+	err = tx.QueryRow(ctx, query, request.Sum, userID).Scan(&current)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return fmt.Errorf("useBonuses: %w", ErrNotEnoughBonuses)
+	} else if err != nil {
 		err = fmt.Errorf("useBonuses: error updating current bonuses amount: %w", err)
 		return err
 	}
+	// защита на случай обхода CHECK (current >= 0): такое списание откатывается
+	if current < 0 {
+		s.negativeBalances.Add(1)
+		return fmt.Errorf("useBonuses: user %s: %w", userID, ErrNegativeBalance)
+	}
 
 	query = "INSERT INTO withdrawals (user_id,order_id,sum) VALUES ($1,$2,$3)"
-	_, err = tx.ExecContext(ctx, query, userID, request.OrderNumber, request.Sum)
+	_, err = tx.Exec(ctx, query, userID, request.OrderNumber, request.Sum)
 	if err != nil {
 		err = fmt.Errorf("useBonuses: error inserting data to withdrawals: %w", err)
 		return err
 	}
-	err = tx.Commit()
+	if err = recordBalanceTransaction(ctx, tx, userID, request.OrderNumber, request.Sum, models.BalanceDebit, models.BalanceReasonWithdrawal); err != nil {
+		return fmt.Errorf("useBonuses: %w", err)
+	}
+	err = tx.Commit(ctx)
 	if err != nil {
 		err = fmt.Errorf("useBonuses: error committing transaction: %w", err)
 		return err
@@ -352,94 +924,135 @@ func (s *Storage) UseBonuses(ctx context.Context, request models.APIUseBonusesRe
 }
 
 func (s *Storage) GetWithdrawalsHistory(ctx context.Context, userID string) ([]models.APIGetWithdrawalsHistoryResponse, error) {
-	query := "SELECT order_id,sum,processed_at FROM withdrawals WHERE user_id=$1 ORDER BY processed_at"
+	ctx, done := s.observeQuery(ctx, "getWithdrawalsHistory")
+	defer done()
 
-	rows, err := s.DB.QueryContext(ctx, query, userID)
-	if rows.Err() != nil {
-		return []models.APIGetWithdrawalsHistoryResponse{}, fmt.Errorf("getWithdrawalsHistory: error getting orders: %w", rows.Err())
-	}
-	defer rows.Close()
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
 
+	query := "SELECT order_id,sum,processed_at FROM withdrawals WHERE user_id=$1 ORDER BY processed_at"
+
+	rows, err := s.readDB().Query(ctx, query, userID)
 	if err != nil {
 		return nil, fmt.Errorf("getWithdrawalsHistory: error getting withdrawal history: %w", err)
 	}
+	defer rows.Close()
 
-	var withdrawalsHistory []models.APIGetWithdrawalsHistoryResponse
+	withdrawalsHistory := []models.APIGetWithdrawalsHistoryResponse{}
 	for rows.Next() {
 		var withdrawalHistory models.APIGetWithdrawalsHistoryResponse
 		err = rows.Scan(&withdrawalHistory.Order, &withdrawalHistory.Sum, &withdrawalHistory.ProcessedAt)
 		if err != nil {
 			return nil, fmt.Errorf("getWithdrawalsHistory: error getting orders: %w", err)
 		}
+		withdrawalHistory.ProcessedAt = withdrawalHistory.ProcessedAt.UTC()
 		withdrawalsHistory = append(withdrawalsHistory, withdrawalHistory)
 	}
-
-	if len(withdrawalsHistory) == 0 {
-		return withdrawalsHistory, fmt.Errorf("getWithdrawalsHistory: %w", ErrEmptyWithdrawalHistory)
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("getWithdrawalsHistory: error getting withdrawal history: %w", err)
 	}
 
 	return withdrawalsHistory, nil
-
 }
 
-func (s *Storage) HandleOrderNumbers(ctx context.Context, accrualSystemAddress string, logger logger.Logger) {
-	// Отсюда будут запускаться задачи на обновление статуса заказа
+// HandleOrderNumbers выполняет один цикл обновления статусов заказов не дольше updateCycleTimeout.
+// Если предыдущий цикл еще не завершился, тик пропускается.
+func (s *Storage) HandleOrderNumbers(ctx context.Context, logger logger.Logger) error {
+	if !s.updateCycleRunning.CompareAndSwap(false, true) {
+		logger.Debug("handleOrderNumbers: previous update cycle is still running, tick skipped")
+		return nil
+	}
+	defer s.updateCycleRunning.Store(false)
+
+	if !s.isHealthy() {
+		return fmt.Errorf("handleOrderNumbers: update task paused: %w", ErrDatabaseUnavailable)
+	}
 
-	select {
-	case <-ctx.Done():
+	if ctx.Err() != nil {
 		logger.Info("handleOrderNumbers: update task cancelled by context")
-	default:
-		ctx, cancel := context.WithCancel(ctx)
-		defer cancel()
+		return nil
+	}
 
-		orderNumbersChannel, err := s.getNotCalculatedOrderNumbers(ctx, logger)
-		if err != nil {
-			logger.Error("handleOrderNumbers:", zap.Error(err))
-			return
-		}
+	ctx, cancel := context.WithTimeout(ctx, s.updateCycleTimeout)
+	defer cancel()
 
-		var stageUpdateOrderStatusChannels []<-chan string
-		var updateErrors []<-chan error
+	orderNumbersChannel, err := s.getNotCalculatedOrderNumbers(ctx)
+	if err != nil {
+		return fmt.Errorf("handleOrderNumbers: %w", err)
+	}
 
-		for i := 0; i < runtime.NumCPU(); i++ {
-			updateOrderStatusChannel, updateOrderStatusErrors, err := s.prepareAndUpdateOrderStatus(ctx, orderNumbersChannel, accrualSystemAddress)
-			if err != nil {
-				logger.Error("handleOrderNumbers:", zap.Error(err))
-				return
-			}
-			stageUpdateOrderStatusChannels = append(stageUpdateOrderStatusChannels, updateOrderStatusChannel)
-			updateErrors = append(updateErrors, updateOrderStatusErrors)
-		}
-		stageUpdateOrderStatusMerged := mergeChannels(ctx, stageUpdateOrderStatusChannels...)
-		errorsMerged := mergeChannels(ctx, updateErrors...)
+	orderBatchesChannel := batchOrderNumbers(ctx, orderNumbersChannel, s.accrualBatchSize)
+
+	var resultChannels []<-chan UpdateResult
+	for i := 0; i < s.accrualWorkers; i++ {
+		resultChannels = append(resultChannels, s.prepareAndUpdateOrderStatus(ctx, orderBatchesChannel))
+	}
 
-		orderStatusConsumer(ctx, stageUpdateOrderStatusMerged, errorsMerged, logger)
+	if s.orderStatusConsumer(ctx, mergeChannels(ctx, resultChannels...), logger) {
+		logger.Info("handleOrderNumbers: poll interval increased", zap.Duration("interval", backOffPollInterval()))
+	} else {
+		recoverPollInterval()
 	}
 
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("handleOrderNumbers: update cycle aborted after %s: %w", s.updateCycleTimeout, ctx.Err())
+	}
+	return nil
 }
 
-func (s *Storage) getNotCalculatedOrderNumbers(ctx context.Context, logger logger.Logger) (<-chan string, error) {
+// getNotCalculatedOrderNumbers отбирает не больше pendingBatchSize незавершенных заказов, которые
+// не проверялись дольше checkCooldown, начиная с давно не проверявшихся, и отмечает их проверенными.
+// SKIP LOCKED не дает нескольким экземплярам сервиса отобрать одни и те же заказы.
+func (s *Storage) getNotCalculatedOrderNumbers(ctx context.Context) (<-chan string, error) {
 	// producer
 
-	outputChannel := make(chan string)
-
-	query := "SELECT order_id FROM orders WHERE status NOT IN ('INVALID', 'PROCESSED')"
-	rows, err := s.DB.Query(query)
+	tx, err := s.DB.Begin(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("getNotCalculatedOrderNumbers: transaction error: %w", err)
+	}
+	defer tx.Rollback(ctx)
 
-	if rows.Err() != nil {
-		logger.Error("getNotCalculatedOrderNumbers:", zap.Error(err))
+	if err = s.setUpdaterStatementTimeout(ctx, tx); err != nil {
+		return nil, fmt.Errorf("getNotCalculatedOrderNumbers: %w", err)
 	}
 
+	query := `UPDATE orders SET last_checked_at = CURRENT_TIMESTAMP
+		WHERE order_id IN (
+			SELECT o.order_id FROM orders o
+			LEFT JOIN failed_updates f ON f.order_id = o.order_id
+			WHERE o.status NOT IN ('INVALID', 'PROCESSED') AND COALESCE(f.attempt_count, 0) < $1
+				AND (o.last_checked_at IS NULL OR o.last_checked_at < CURRENT_TIMESTAMP - $2 * INTERVAL '1 millisecond')
+			ORDER BY o.last_checked_at NULLS FIRST, o.uploaded_at
+			LIMIT $3
+			FOR UPDATE OF o SKIP LOCKED
+		)
+		RETURNING order_id`
+	rows, err := tx.Query(ctx, query, s.maxUpdateAttempts, s.checkCooldown.Milliseconds(), s.pendingBatchSize)
 	if err != nil {
-		logger.Error("getNotCalculatedOrderNumbers:", zap.Error(err))
+		return nil, fmt.Errorf("getNotCalculatedOrderNumbers: error getting order numbers: %w", err)
 	}
+	defer rows.Close()
+
+	var orderNumbers []string
+	for rows.Next() {
+		var orderNumber string
+		if err = rows.Scan(&orderNumber); err != nil {
+			return nil, fmt.Errorf("getNotCalculatedOrderNumbers: error scanning order number: %w", err)
+		}
+		orderNumbers = append(orderNumbers, orderNumber)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("getNotCalculatedOrderNumbers: error getting order numbers: %w", err)
+	}
+
+	if err = tx.Commit(ctx); err != nil {
+		return nil, fmt.Errorf("getNotCalculatedOrderNumbers: error committing transaction: %w", err)
+	}
+
+	outputChannel := make(chan string)
 	go func() {
 		defer close(outputChannel)
-		for rows.Next() {
-			var orderNumber string
-			if err := rows.Scan(&orderNumber); err != nil {
-				logger.Error("getNotCalculatedOrderNumbers:", zap.Error(err))
-			}
+		for _, orderNumber := range orderNumbers {
 			select {
 			case <-ctx.Done():
 				return
@@ -451,109 +1064,334 @@ func (s *Storage) getNotCalculatedOrderNumbers(ctx context.Context, logger logge
 	return outputChannel, nil
 }
 
-func (s *Storage) prepareAndUpdateOrderStatus(ctx context.Context, orderNumbers <-chan string, accrualSystemAddress string) (<-chan string, <-chan error, error) {
-	outChannel := make(chan string)
-	errorChannel := make(chan error)
+// batchOrderNumbers группирует номера заказов в пакеты не больше size номеров.
+func batchOrderNumbers(ctx context.Context, orderNumbers <-chan string, size int) <-chan []string {
+	outChannel := make(chan []string)
 
 	go func() {
 		defer close(outChannel)
-		defer close(errorChannel)
 
-		select {
-		case <-ctx.Done():
-			return
-		case orderNumber, ok := <-orderNumbers:
-			if ok {
-				ctxWTO, cancel := context.WithTimeout(ctx, time.Second*5)
-				defer cancel()
+		batch := make([]string, 0, size)
+		flush := func() bool {
+			if len(batch) == 0 {
+				return true
+			}
+			select {
+			case outChannel <- batch:
+				batch = make([]string, 0, size)
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
 
-				err := s.updateOrderStatus(ctxWTO, orderNumber, accrualSystemAddress)
-				if err != nil {
-					errorChannel <- err
-				} else {
-					outChannel <- fmt.Sprintf("prepareAndUpdateOrderStatus: order '%s' updated", orderNumber)
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case orderNumber, ok := <-orderNumbers:
+				if !ok {
+					flush()
+					return
+				}
+				batch = append(batch, orderNumber)
+				if len(batch) == size && !flush() {
+					return
+				}
+			}
+		}
+	}()
+	return outChannel
+}
+
+// prepareAndUpdateOrderStatus запускает воркер, который обновляет статусы заказов из orderBatches
+// и передает результат по каждому заказу в возвращаемый канал.
+func (s *Storage) prepareAndUpdateOrderStatus(ctx context.Context, orderBatches <-chan []string) <-chan UpdateResult {
+	resultChannel := make(chan UpdateResult)
+
+	go func() {
+		defer close(resultChannel)
+
+		emit := func(result UpdateResult) bool {
+			if err := s.trackUpdateAttempt(ctx, result.OrderNumber, result.Err); err != nil {
+				if result.Err == nil {
+					result.Phase = UpdatePhaseTrack
 				}
-			} else {
+				result.Err = err
+			}
+			select {
+			case resultChannel <- result:
+				return true
+			case <-ctx.Done():
+				return false
+			}
+		}
+
+		for {
+			select {
+			case <-ctx.Done():
 				return
+			case batch, ok := <-orderBatches:
+				if !ok {
+					return
+				}
+
+				// заказы, которые уже обновляются другим циклом, повторно не запрашиваются
+				var orderNumbers []string
+				for _, orderNumber := range batch {
+					if _, loaded := s.inFlight.LoadOrStore(orderNumber, struct{}{}); !loaded {
+						orderNumbers = append(orderNumbers, orderNumber)
+					}
+				}
+
+				completed := s.updateOrderStatuses(ctx, orderNumbers, emit)
+				for _, orderNumber := range orderNumbers {
+					s.inFlight.Delete(orderNumber)
+				}
+				if !completed {
+					return
+				}
 			}
 		}
 	}()
-	return outChannel, errorChannel, nil
+	return resultChannel
 }
 
-func (s *Storage) updateOrderStatus(ctx context.Context, orderNumber string, accrualSystemAddress string) error {
-	orderInfo, err := getOrderInfo(ctx, orderNumber, accrualSystemAddress)
-	if err != nil {
-		return fmt.Errorf("updateOrderStatus: error getting order info: %w", err)
+// updateOrderStatuses обновляет статусы заказов пакетным запросом, а если accrual-система его не
+// поддерживает — запросом на каждый заказ. Результат по каждому заказу передается в emit;
+// возвращает false, если emit прервал обработку.
+func (s *Storage) updateOrderStatuses(ctx context.Context, orderNumbers []string,
+	emit func(result UpdateResult) bool) bool {
+	if len(orderNumbers) > 1 && !s.batchUnsupported.Load() {
+		ctxWTO, cancel := context.WithTimeout(ctx, s.accrualTimeout)
+		ordersInfo, err := s.accrualClient.BatchGetOrderInfo(ctxWTO, orderNumbers)
+		cancel()
+		switch {
+		case errors.Is(err, accrual.ErrBatchNotSupported):
+			s.batchUnsupported.Store(true)
+		case errors.Is(err, accrual.ErrInvalidResponse):
+			// ответ целиком отклонен из-за одного заказа: опрашиваем по одному, чтобы ошибка
+			// досталась только ему
+		case err != nil:
+			err = fmt.Errorf("updateOrderStatuses: error getting orders info: %w", err)
+			for _, orderNumber := range orderNumbers {
+				if !emit(UpdateResult{OrderNumber: orderNumber, Phase: UpdatePhaseFetch, Err: err}) {
+					return false
+				}
+			}
+			return true
+		default:
+			infoByOrder := make(map[string]models.APIOrderInfoResponse, len(ordersInfo))
+			for _, orderInfo := range ordersInfo {
+				infoByOrder[orderInfo.Order] = orderInfo
+			}
+			for _, orderNumber := range orderNumbers {
+				result := UpdateResult{OrderNumber: orderNumber, Phase: UpdatePhaseFetch}
+				if orderInfo, found := infoByOrder[orderNumber]; found {
+					result = s.applyOrderInfo(ctx, orderNumber, orderInfo)
+				} else {
+					result.Err = fmt.Errorf("updateOrderStatuses: order %s: %w", orderNumber, accrual.ErrOrderNotRegistered)
+				}
+				if !emit(result) {
+					return false
+				}
+			}
+			return true
+		}
 	}
 
-	tx, err := s.DB.BeginTx(ctx, nil)
-	if err != nil {
-		err = fmt.Errorf("updateOrderStatus: error beginning transaction: %w", err)
-		return err
+	for _, orderNumber := range orderNumbers {
+		ctxWTO, cancel := context.WithTimeout(ctx, s.accrualTimeout)
+		result := s.updateOrderStatus(ctxWTO, orderNumber)
+		cancel()
+		if !emit(result) {
+			return false
+		}
 	}
-	defer tx.Rollback()
+	return true
+}
 
-	query := "UPDATE orders SET status = $1, accrual = $2 WHERE order_id = $3"
-	_, err = tx.ExecContext(ctx, query, orderInfo.Status, orderInfo.Accrual, orderNumber)
+// trackUpdateAttempt ведет счетчик неудачных обновлений заказа в failed_updates. Временные
+// ошибки (см. isTransientUpdateError) не считаются ошибкой заказа. Возвращает ошибку обновления, дополненную числом попыток.
+func (s *Storage) trackUpdateAttempt(ctx context.Context, orderNumber string, updateErr error) error {
+	if updateErr == nil {
+		return s.clearFailedUpdate(ctx, orderNumber)
+	}
+	if isTransientUpdateError(updateErr) {
+		return updateErr
+	}
+
+	attempts, err := s.recordFailedUpdate(ctx, orderNumber, updateErr)
 	if err != nil {
-		return fmt.Errorf("updateOrderStatus: error updating status for order %s: %w", orderNumber, err)
+		return errors.Join(updateErr, err)
 	}
-	if orderInfo.Accrual > 0 {
-		query = "UPDATE balances SET current = current + $1 WHERE user_id = (SELECT user_id FROM orders WHERE order_id = $2) RETURNING current"
-		_, err = tx.ExecContext(ctx, query, orderInfo.Accrual, orderNumber)
-		if err != nil {
-			return fmt.Errorf("updateOrderStatus: error updating balance for order %s: %w", orderNumber, err)
-		}
+	return &failedOrderUpdate{orderNumber: orderNumber, attempts: attempts, err: updateErr}
+}
+
+// updateOrderStatus запрашивает статус заказа у accrual-системы и сохраняет его.
+func (s *Storage) updateOrderStatus(ctx context.Context, orderNumber string) UpdateResult {
+	orderInfo, err := s.accrualClient.GetOrderInfo(ctx, orderNumber)
+	if err != nil {
+		return UpdateResult{OrderNumber: orderNumber, Phase: UpdatePhaseFetch,
+			Err: fmt.Errorf("updateOrderStatus: error getting order info: %w", err)}
 	}
+	return s.applyOrderInfo(ctx, orderNumber, *orderInfo)
+}
 
-	err = tx.Commit()
+// applyOrderInfo проверяет ответ accrual-системы и сохраняет статус заказа. Некорректный ответ
+// не сохраняется и считается неудачной попыткой обновления.
+func (s *Storage) applyOrderInfo(ctx context.Context, orderNumber string, orderInfo models.APIOrderInfoResponse) UpdateResult {
+	orderInfo, err := accrual.ValidateOrderInfo(orderNumber, orderInfo)
 	if err != nil {
-		err = fmt.Errorf("updateOrderStatus: error committing transaction: %w", err)
-		return err
+		return UpdateResult{OrderNumber: orderNumber, Phase: UpdatePhaseValidate, Err: fmt.Errorf("applyOrderInfo: %w", err)}
 	}
 
-	return nil
+	var accrual *float64
+	if orderInfo.Accrual > 0 || orderInfo.Status == models.OrderStatusProcessed {
+		accrual = &orderInfo.Accrual
+	}
+	update, err := s.applyOrderStatus(ctx, orderNumber, orderInfo.Status, accrual)
+	result := UpdateResult{OrderNumber: orderNumber, Phase: UpdatePhaseStore, Err: err, update: update}
+	if update != nil {
+		// изменение рассылается сразу после фиксации: результат может не дойти до потребителя,
+		// если цикл прерван ответом 429 или отменой ctx, а повторно заказ уже не изменится.
+		// Рассылка сохраняет спан трассировки, но не отменяется вместе с циклом
+		result.dispatchErr = s.dispatchOrderStatusUpdate(context.WithoutCancel(ctx), *update)
+	}
+	return result
 }
 
-func getOrderInfo(ctx context.Context, orderNumber string, accrualSystemAddress string) (*models.APIOrderInfoResponse, error) {
-	url, err := url2.JoinPath(accrualSystemAddress, "/api/orders/", orderNumber)
+// applyOrderStatus в одной транзакции обновляет статус заказа и начисляет на баланс разницу
+// между новым и ранее начисленным вознаграждением. Записи после блокировки заказа отправляются
+// одним пакетом pgx.Batch. Возвращает nil без ошибки, если ничего не изменилось.
+// Неизвестный статус отклоняется до обращения к БД.
+func (s *Storage) applyOrderStatus(ctx context.Context, orderNumber string, status models.OrderStatus, accrual *float64) (*orderStatusUpdate, error) {
+	ctx, done := s.observeQuery(ctx, "applyOrderStatus")
+	defer done()
+
+	if !status.Valid() {
+		return nil, fmt.Errorf("applyOrderStatus: %w %q for order %s", ErrInvalidOrderStatus, status, orderNumber)
+	}
+
+	tx, err := s.DB.BeginTx(ctx, s.financialTxOptions())
 	if err != nil {
-		return nil, fmt.Errorf("getOrderInfo: error joining path: %w", err)
+		err = fmt.Errorf("applyOrderStatus: error beginning transaction: %w", err)
+		return nil, err
+	}
+	defer tx.Rollback(ctx)
+
+	var (
+		userID         string
+		currentStatus  models.OrderStatus
+		currentAccrual sql.NullFloat64
+	)
+	query := "SELECT user_id, status, accrual FROM orders WHERE order_id = $1 FOR UPDATE"
+	err = tx.QueryRow(ctx, query, orderNumber).Scan(&userID, &currentStatus, &currentAccrual)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, fmt.Errorf("applyOrderStatus: %w", ErrOrderNotFound)
+	} else if err != nil {
+		return nil, fmt.Errorf("applyOrderStatus: error getting order %s: %w", orderNumber, err)
+	}
+
+	var newAccrual float64
+	if accrual != nil {
+		newAccrual = *accrual
+	}
+	if currentStatus == status && currentAccrual.Valid == (accrual != nil) && currentAccrual.Float64 == newAccrual {
+		return nil, nil
+	}
+	// начисленное вознаграждение есть только у PROCESSED: перевод в другой статус молча списал бы его
+	if currentAccrual.Float64 > 0 && status != models.OrderStatusProcessed {
+		return nil, fmt.Errorf("applyOrderStatus: order %s with accrual %.2f to %s: %w",
+			orderNumber, currentAccrual.Float64, status, ErrOrderStatusTransition)
+	}
+
+	delta := newAccrual - currentAccrual.Float64
+	if delta < 0 {
+		// списать можно только то, что пользователь еще не потратил
+		if err = ensureBalanceRow(ctx, tx, userID); err != nil {
+			return nil, fmt.Errorf("applyOrderStatus: %w", err)
+		}
+		var current float64
+		query = "SELECT current FROM balances WHERE user_id = $1 FOR UPDATE"
+		if err = tx.QueryRow(ctx, query, userID).Scan(&current); err != nil {
+			return nil, fmt.Errorf("applyOrderStatus: error getting current balance: %w", err)
+		}
+		if current+delta < 0 {
+			return nil, fmt.Errorf("applyOrderStatus: balance %.2f, delta %.2f: %w", current, delta, ErrNotEnoughBonuses)
+		}
 	}
 
-	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	// изменения заказа и баланса отправляются одним пакетом, без ожидания ответа на каждый запрос
+	batch := &pgx.Batch{}
+	batch.Queue(`UPDATE orders SET status = $1, accrual = $2, revision = nextval('orders_revision_seq')
+		WHERE order_id = $3`, status, accrual, orderNumber)
+	batch.Queue("INSERT INTO order_events (order_id, old_status, new_status, accrual) VALUES ($1, $2, $3, $4)",
+		orderNumber, currentStatus, status, accrual)
+	steps := []string{"updating status", "recording event"}
+	if delta != 0 {
+		// уменьшение ранее начисленного вознаграждения (ручная правка) записывается как списание
+		direction, reason, amount := models.BalanceCredit, models.BalanceReasonAccrual, delta
+		if delta < 0 {
+			direction, reason, amount = models.BalanceDebit, models.BalanceReasonAdjustment, -delta
+		}
+		batch.Queue(ensureBalanceRowQuery, userID)
+		batch.Queue("UPDATE balances SET current = current + $1 WHERE user_id = $2", delta, userID)
+		batch.Queue(recordBalanceTransactionQuery, userID, orderNumber, amount, direction, reason)
+		steps = append(steps, "creating balance", "updating balance", "recording balance transaction")
+	}
+
+	results := tx.SendBatch(ctx, batch)
+	for _, step := range steps {
+		if _, err = results.Exec(); err != nil {
+			results.Close()
+			return nil, fmt.Errorf("applyOrderStatus: error %s for order %s: %w", step, orderNumber, err)
+		}
+	}
+	if err = results.Close(); err != nil {
+		return nil, fmt.Errorf("applyOrderStatus: error sending batch for order %s: %w", orderNumber, err)
+	}
+
+	err = tx.Commit(ctx)
 	if err != nil {
-		return nil, fmt.Errorf("getOrderInfo: error with request: %w", err)
+		err = fmt.Errorf("applyOrderStatus: error committing transaction: %w", err)
+		return nil, err
 	}
 
-	client := &http.Client{}
+	return &orderStatusUpdate{
+		userID: userID,
+		event:  models.APIOrderStatusEvent{Number: orderNumber, Status: status, Accrual: accrual},
+	}, nil
+}
 
-	resp, err := client.Do(req)
+// AdminUpdateOrderStatus принудительно выставляет статус и начисление заказа, например для
+// заказов, зависших в NEW или PROCESSING. Возвращает ErrOrderStatusTransition при попытке
+// увести из PROCESSED заказ с начислением и ErrNotEnoughBonuses, если уменьшение начисления
+// сделало бы баланс отрицательным.
+func (s *Storage) AdminUpdateOrderStatus(ctx context.Context, orderID string, status models.OrderStatus, accrual *float64) error {
+	update, err := s.applyOrderStatus(ctx, orderID, status, accrual)
 	if err != nil {
-		return nil, fmt.Errorf("getOrderInfo: error get: %w", err)
+		return fmt.Errorf("adminUpdateOrderStatus: %w", err)
+	}
+	if update == nil {
+		return nil
 	}
-	defer resp.Body.Close()
 
-	switch resp.StatusCode {
-	case http.StatusOK:
-		var orderInfo models.APIOrderInfoResponse
-		if err := json.NewDecoder(resp.Body).Decode(&orderInfo); err != nil {
-			return nil, fmt.Errorf("getOrderInfo: error decoding JSON resp: %w", err)
-		}
-		return &orderInfo, nil
-	case http.StatusNoContent:
-		return nil, fmt.Errorf("getOrderInfo: order %s not registered in the system", orderNumber)
-	case http.StatusTooManyRequests:
-		retryAfter := resp.Header.Get("Retry-After")
-		return nil, fmt.Errorf("getOrderInfo: rate limit exceeded, retry after %s seconds", retryAfter)
-	case http.StatusInternalServerError:
-		return nil, fmt.Errorf("getOrderInfo: interna; server error")
-	default:
-		body, _ := io.ReadAll(resp.Body)
-		return nil, fmt.Errorf("getOrderInfo: unexpected status code: %d, body: %s", resp.StatusCode, string(body))
+	if err = s.dispatchOrderStatusUpdate(ctx, *update); err != nil {
+		return fmt.Errorf("adminUpdateOrderStatus: %w", err)
 	}
+	return nil
+}
 
+// dispatchOrderStatusUpdate уведомляет подписчиков и webhook пользователя об изменении заказа.
+func (s *Storage) dispatchOrderStatusUpdate(ctx context.Context, update orderStatusUpdate) error {
+	if s.events != nil {
+		s.events.Publish(update.userID, update.event)
+	}
+	if err := s.notifyWebhook(ctx, update); err != nil {
+		return fmt.Errorf("dispatchOrderStatusUpdate: %w", err)
+	}
+	return nil
 }
 
 func mergeChannels[T any](ctx context.Context, ce ...<-chan T) <-chan T {
@@ -585,25 +1423,25 @@ func mergeChannels[T any](ctx context.Context, ce ...<-chan T) <-chan T {
 	return out
 }
 
-func orderStatusConsumer(ctx context.Context, orderInfoResult <-chan string, orderInfoErrors <-chan error, logger logger.Logger) {
+// orderStatusConsumer учитывает и логирует результаты обновления заказов; изменения статусов
+// рассылаются еще в applyOrderInfo. Возвращает true, если accrual-система ответила 429: оставшиеся запросы цикла не
+// отправляются, а интервал опроса увеличивается.
+func (s *Storage) orderStatusConsumer(ctx context.Context, results <-chan UpdateResult, logger logger.Logger) (rateLimited bool) {
 	for {
 		select {
 		case <-ctx.Done():
 			logger.Error("orderStatusConsumer:", zap.Error(ctx.Err()))
-			return
-		case err, ok := <-orderInfoErrors:
-			if ok {
-				logger.Error("orderStatusConsumer:", zap.Error(err))
+			return false
+		case result, ok := <-results:
+			if !ok {
+				return false
 			}
-
-		case order, ok := <-orderInfoResult:
-			if ok {
-				logger.Info("orderStatusConsumer:" + order)
-			} else {
-				return
+			if result.dispatchErr != nil {
+				logger.Error("orderStatusConsumer:", zap.String("order", result.OrderNumber), zap.Error(result.dispatchErr))
+			}
+			if s.logUpdateResult(result, logger) == updateClassRateLimited {
+				return true
 			}
-
 		}
-
 	}
 }
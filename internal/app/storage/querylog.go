@@ -0,0 +1,42 @@
+package storage
+
+import (
+	"context"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"go.opentelemetry.io/otel"
+	"go.uber.org/zap"
+	"strings"
+	"time"
+)
+
+// WithSlowQueryLog логирует операции с БД, выполнявшиеся дольше threshold. В лог попадает
+// только имя операции, без текста запроса и параметров. 0 отключает замеры. Операции с
+// хешированием пароля не замеряются: bcrypt сам по себе занимает десятки миллисекунд.
+func WithSlowQueryLog(threshold time.Duration, logger logger.Logger) Option {
+	return func(s *Storage) {
+		s.slowQueryThreshold = threshold
+		s.slowQueryLogger = logger
+	}
+}
+
+var tracer = otel.Tracer("github.com/vancho-go/gophermart/internal/app/storage")
+
+// observeQuery начинает дочерний спан storage.<Operation> и замер операции operation, возвращаемая
+// функция завершает их:
+//
+//	ctx, done := s.observeQuery(ctx, "getOrders")
+//	defer done()
+func (s *Storage) observeQuery(ctx context.Context, operation string) (context.Context, func()) {
+	ctx, span := tracer.Start(ctx, "storage."+strings.ToUpper(operation[:1])+operation[1:])
+	if s.slowQueryThreshold <= 0 {
+		return ctx, func() { span.End() }
+	}
+
+	start := time.Now()
+	return ctx, func() {
+		span.End()
+		if elapsed := time.Since(start); elapsed >= s.slowQueryThreshold {
+			s.slowQueryLogger.Warn("slow query", zap.String("operation", operation), zap.Duration("duration", elapsed))
+		}
+	}
+}
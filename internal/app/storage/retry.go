@@ -0,0 +1,47 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"math/rand"
+	"time"
+)
+
+// Кратковременные ошибки Postgres (переключение мастера, разрыв соединения, конфликт
+// сериализации, взаимная блокировка) повторяются до maxRetryAttempts раз с экспоненциальной паузой со случайным разбросом.
+const (
+	maxRetryAttempts = 3
+	retryBaseDelay   = time.Millisecond * 50
+)
+
+// isRetriable сообщает, можно ли повторить операцию, завершившуюся ошибкой err.
+func isRetriable(err error) bool {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) {
+		return pgerrcode.IsConnectionException(pgErr.Code) || pgErr.Code == pgerrcode.SerializationFailure ||
+			pgErr.Code == pgerrcode.DeadlockDetected
+	}
+	// Ошибка возникла до отправки запроса на сервер
+	return pgconn.SafeToRetry(err)
+}
+
+// withRetry выполняет идемпотентную операцию op, повторяя ее при кратковременных ошибках.
+// Остальные ошибки возвращаются без изменений, отмена ctx прерывает повторы.
+func withRetry(ctx context.Context, op func() error) error {
+	delay := retryBaseDelay
+	for attempt := 1; ; attempt++ {
+		err := op()
+		if err == nil || attempt == maxRetryAttempts || !isRetriable(err) || ctx.Err() != nil {
+			return err
+		}
+
+		select {
+		case <-ctx.Done():
+			return err
+		case <-time.After(delay/2 + time.Duration(rand.Int63n(int64(delay/2)+1))):
+		}
+		delay *= 2
+	}
+}
@@ -0,0 +1,83 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgerrcode"
+	"github.com/jackc/pgx/v5/pgconn"
+	"testing"
+	"time"
+)
+
+func TestWithRetry(t *testing.T) {
+	serializationFailure := &pgconn.PgError{Code: pgerrcode.SerializationFailure}
+	deadlock := &pgconn.PgError{Code: pgerrcode.DeadlockDetected}
+	connectionFailure := &pgconn.PgError{Code: pgerrcode.ConnectionFailure}
+	uniqueViolation := &pgconn.PgError{Code: pgerrcode.UniqueViolation}
+
+	tests := []struct {
+		name         string
+		errs         []error
+		wantAttempts int
+		wantErr      error
+	}{
+		{name: "success", errs: []error{nil}, wantAttempts: 1},
+		{name: "serialization failure then success", errs: []error{serializationFailure, nil}, wantAttempts: 2},
+		{name: "deadlock then success", errs: []error{deadlock, deadlock, nil}, wantAttempts: 3},
+		{name: "wrapped deadlock", errs: []error{fmt.Errorf("useBonuses: %w", deadlock), nil}, wantAttempts: 2},
+		{name: "connection failure", errs: []error{connectionFailure, nil}, wantAttempts: 2},
+		{name: "attempts exhausted", errs: []error{serializationFailure, deadlock, serializationFailure, nil}, wantAttempts: maxRetryAttempts, wantErr: serializationFailure},
+		{name: "permanent error", errs: []error{uniqueViolation, nil}, wantAttempts: 1, wantErr: uniqueViolation},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			err := withRetry(context.Background(), func() error {
+				err := tt.errs[attempts]
+				attempts++
+				return err
+			})
+			if !errors.Is(err, tt.wantErr) || (tt.wantErr == nil && err != nil) {
+				t.Errorf("error = %v, want %v", err, tt.wantErr)
+			}
+			if attempts != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", attempts, tt.wantAttempts)
+			}
+		})
+	}
+}
+
+// TestWithRetryBacksOff проверяет, что между попытками выдерживается пауза, которая растет
+// с каждой попыткой.
+func TestWithRetryBacksOff(t *testing.T) {
+	var calls []time.Time
+	err := withRetry(context.Background(), func() error {
+		calls = append(calls, time.Now())
+		return &pgconn.PgError{Code: pgerrcode.SerializationFailure}
+	})
+	if err == nil || len(calls) != maxRetryAttempts {
+		t.Fatalf("error = %v after %d attempts, want a failure after %d", err, len(calls), maxRetryAttempts)
+	}
+
+	delay := retryBaseDelay
+	for i := 1; i < len(calls); i++ {
+		if pause := calls[i].Sub(calls[i-1]); pause < delay/2 {
+			t.Errorf("pause before attempt %d = %s, want at least %s", i+1, pause, delay/2)
+		}
+		delay *= 2
+	}
+}
+
+func TestWithRetryStopsOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	attempts := 0
+	err := withRetry(ctx, func() error {
+		attempts++
+		cancel()
+		return &pgconn.PgError{Code: pgerrcode.SerializationFailure}
+	})
+	if err == nil || attempts != 1 {
+		t.Errorf("error = %v after %d attempts, want the first error without retries", err, attempts)
+	}
+}
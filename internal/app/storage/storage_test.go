@@ -0,0 +1,54 @@
+package storage
+
+import (
+	"context"
+	"github.com/vancho-go/gophermart/internal/app/events"
+	"os"
+	"testing"
+)
+
+// testDatabaseURIEnv — переменная окружения с адресом тестовой БД PostgreSQL. Тесты хранилища
+// очищают все таблицы сервиса, поэтому БД должна быть отдельной; без переменной они пропускаются.
+const testDatabaseURIEnv = "TEST_DATABASE_URI"
+
+// newTestStorage подключается к тестовой БД, применяет миграции и очищает таблицы до и после теста.
+func newTestStorage(t *testing.T, opts ...Option) *Storage {
+	t.Helper()
+
+	uri := os.Getenv(testDatabaseURIEnv)
+	if uri == "" {
+		t.Skipf("%s is not set", testDatabaseURIEnv)
+	}
+
+	s, err := Initialize(uri, events.NewEventBus(), opts...)
+	if err != nil {
+		t.Fatalf("initialize: %v", err)
+	}
+	truncateTables(t, s)
+	t.Cleanup(func() {
+		truncateTables(t, s)
+		s.DB.Close()
+	})
+	return s
+}
+
+func truncateTables(t *testing.T, s *Storage) {
+	t.Helper()
+
+	_, err := s.DB.Exec(context.Background(), `TRUNCATE users, orders, balances, withdrawals, user_webhooks,
+		idempotency_keys, failed_updates, order_events, balance_transactions, balance_adjustments, webhook_deliveries CASCADE`)
+	if err != nil {
+		t.Fatalf("truncate: %v", err)
+	}
+}
+
+// registerTestUser регистрирует пользователя login и возвращает его идентификатор.
+func registerTestUser(t *testing.T, s *Storage, login string) string {
+	t.Helper()
+
+	userID, err := s.RegisterUser(context.Background(), login, "", "password-"+login)
+	if err != nil {
+		t.Fatalf("register %s: %v", login, err)
+	}
+	return userID
+}
@@ -0,0 +1,126 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"github.com/vancho-go/gophermart/internal/app/accrual"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"go.uber.org/zap"
+)
+
+// UpdatePhase — этап обновления статуса заказа, на котором получен результат.
+type UpdatePhase string
+
+const (
+	// UpdatePhaseFetch — запрос статуса у accrual-системы.
+	UpdatePhaseFetch UpdatePhase = "fetch"
+	// UpdatePhaseValidate — проверка ответа accrual-системы.
+	UpdatePhaseValidate UpdatePhase = "validate"
+	// UpdatePhaseStore — сохранение статуса и начисления в БД.
+	UpdatePhaseStore UpdatePhase = "store"
+	// UpdatePhaseTrack — учет неудачных попыток в failed_updates.
+	UpdatePhaseTrack UpdatePhase = "track"
+)
+
+// UpdateResult — результат обновления одного заказа. Err == nil означает успех; update == nil
+// при успехе означает, что статус и начисление не изменились.
+type UpdateResult struct {
+	OrderNumber string
+	Phase       UpdatePhase
+	Err         error
+	update      *orderStatusUpdate
+	// dispatchErr — ошибка рассылки зафиксированного изменения подписчикам и webhook
+	dispatchErr error
+}
+
+// Классы результатов обновления, по которым ведутся счетчики order_update_results.
+const (
+	updateClassUpdated            = "updated"
+	updateClassUnchanged          = "unchanged"
+	updateClassRateLimited        = "rate_limited"
+	updateClassCircuitOpen        = "circuit_open"
+	updateClassNotRegistered      = "not_registered"
+	updateClassCancelled          = "cancelled"
+	updateClassInvalidResponse    = "invalid_response"
+	updateClassAccrualUnavailable = "accrual_unavailable"
+	updateClassDBError            = "db_error"
+	updateClassDead               = "dead"
+)
+
+// classifyUpdateResult относит результат обновления к одному из классов updateClass*.
+func (s *Storage) classifyUpdateResult(result UpdateResult) string {
+	var failed *failedOrderUpdate
+	switch err := result.Err; {
+	case err == nil && result.update != nil:
+		return updateClassUpdated
+	case err == nil:
+		return updateClassUnchanged
+	case errors.Is(err, accrual.ErrTooManyRequests):
+		return updateClassRateLimited
+	case errors.Is(err, accrual.ErrCircuitOpen):
+		return updateClassCircuitOpen
+	case errors.Is(err, accrual.ErrOrderNotRegistered):
+		return updateClassNotRegistered
+	case errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded):
+		return updateClassCancelled
+	case errors.As(err, &failed) && failed.attempts == s.maxUpdateAttempts:
+		return updateClassDead
+	case result.Phase == UpdatePhaseStore || result.Phase == UpdatePhaseTrack || errors.Is(err, ErrDatabaseUnavailable):
+		return updateClassDBError
+	case result.Phase == UpdatePhaseValidate || errors.Is(err, accrual.ErrInvalidResponse):
+		return updateClassInvalidResponse
+	default:
+		return updateClassAccrualUnavailable
+	}
+}
+
+// UpdateResults возвращает счетчики результатов обновления заказов по классам с момента запуска.
+func (s *Storage) UpdateResults() map[string]int64 {
+	counts := make(map[string]int64)
+	s.updateResults.Do(func(kv expvar.KeyValue) {
+		if v, ok := kv.Value.(*expvar.Int); ok {
+			counts[kv.Key] = v.Value()
+		}
+	})
+	return counts
+}
+
+// logUpdateResult учитывает результат в счетчике класса и логирует его с уровнем, соответствующим
+// классу. Возвращает класс результата.
+func (s *Storage) logUpdateResult(result UpdateResult, logger logger.Logger) string {
+	class := s.classifyUpdateResult(result)
+	s.updateResults.Add(class, 1)
+
+	fields := []zap.Field{zap.String("order", result.OrderNumber), zap.String("phase", string(result.Phase)),
+		zap.String("class", class)}
+	if result.Err != nil {
+		fields = append(fields, zap.Error(result.Err))
+	}
+
+	switch class {
+	case updateClassUpdated:
+		fields = append(fields, zap.String("status", string(result.update.event.Status)))
+		if result.update.event.Accrual != nil {
+			fields = append(fields, zap.Float64("accrual", *result.update.event.Accrual))
+		}
+		logger.Info("order updated", fields...)
+	case updateClassUnchanged, updateClassNotRegistered, updateClassCancelled:
+		logger.Debug("order update skipped", fields...)
+	case updateClassCircuitOpen:
+		// смена состояния цепи логируется самим accrual-клиентом
+		logger.Debug("order update skipped", fields...)
+	case updateClassRateLimited:
+		logger.Warn("accrual system is rate limiting requests", fields...)
+	case updateClassInvalidResponse:
+		logger.Warn("order update failed", fields...)
+	case updateClassDead:
+		var failed *failedOrderUpdate
+		errors.As(result.Err, &failed)
+		logger.Error("ALERT order excluded from polling after repeated failures",
+			append(fields, zap.Int("attempts", failed.attempts))...)
+	default:
+		logger.Error("order update failed", fields...)
+	}
+	return class
+}
@@ -0,0 +1,102 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"github.com/vancho-go/gophermart/internal/app/accrual"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"go.uber.org/zap/zapcore"
+	"go.uber.org/zap/zaptest/observer"
+	"testing"
+)
+
+// TestLogUpdateResult проверяет, что каждый результат обновления попадает в счетчик своего класса
+// и логируется с уровнем и сообщением этого класса.
+func TestLogUpdateResult(t *testing.T) {
+	const orderNumber = "12345678903"
+	accrualSum := 100.0
+	updated := &orderStatusUpdate{userID: "user-1", event: models.APIOrderStatusEvent{Number: orderNumber,
+		Status: models.OrderStatusProcessed, Accrual: &accrualSum}}
+	serverError := errors.New("getOrderInfo: unexpected status code: 500")
+
+	tests := []struct {
+		name        string
+		result      UpdateResult
+		wantClass   string
+		wantLevel   zapcore.Level
+		wantMessage string
+	}{
+		{name: "updated", result: UpdateResult{Phase: UpdatePhaseStore, update: updated},
+			wantClass: updateClassUpdated, wantLevel: zapcore.InfoLevel, wantMessage: "order updated"},
+		{name: "unchanged", result: UpdateResult{Phase: UpdatePhaseStore},
+			wantClass: updateClassUnchanged, wantLevel: zapcore.DebugLevel, wantMessage: "order update skipped"},
+		{name: "rate limited", result: UpdateResult{Phase: UpdatePhaseFetch, Err: fmt.Errorf("getOrderInfo: %w", accrual.ErrTooManyRequests)},
+			wantClass: updateClassRateLimited, wantLevel: zapcore.WarnLevel, wantMessage: "accrual system is rate limiting requests"},
+		{name: "circuit open", result: UpdateResult{Phase: UpdatePhaseFetch, Err: accrual.ErrCircuitOpen},
+			wantClass: updateClassCircuitOpen, wantLevel: zapcore.DebugLevel, wantMessage: "order update skipped"},
+		{name: "not registered", result: UpdateResult{Phase: UpdatePhaseFetch, Err: fmt.Errorf("getOrderInfo: %w", accrual.ErrOrderNotRegistered)},
+			wantClass: updateClassNotRegistered, wantLevel: zapcore.DebugLevel, wantMessage: "order update skipped"},
+		{name: "cancelled", result: UpdateResult{Phase: UpdatePhaseFetch, Err: context.DeadlineExceeded},
+			wantClass: updateClassCancelled, wantLevel: zapcore.DebugLevel, wantMessage: "order update skipped"},
+		{name: "invalid response", result: UpdateResult{Phase: UpdatePhaseValidate, Err: &failedOrderUpdate{orderNumber: orderNumber, attempts: 1, err: accrual.ErrInvalidResponse}},
+			wantClass: updateClassInvalidResponse, wantLevel: zapcore.WarnLevel, wantMessage: "order update failed"},
+		{name: "accrual unavailable", result: UpdateResult{Phase: UpdatePhaseFetch, Err: &failedOrderUpdate{orderNumber: orderNumber, attempts: 1, err: serverError}},
+			wantClass: updateClassAccrualUnavailable, wantLevel: zapcore.ErrorLevel, wantMessage: "order update failed"},
+		{name: "database error", result: UpdateResult{Phase: UpdatePhaseStore, Err: errors.New("applyOrderStatus: connection reset")},
+			wantClass: updateClassDBError, wantLevel: zapcore.ErrorLevel, wantMessage: "order update failed"},
+		{name: "database unavailable", result: UpdateResult{Phase: UpdatePhaseFetch, Err: ErrDatabaseUnavailable},
+			wantClass: updateClassDBError, wantLevel: zapcore.ErrorLevel, wantMessage: "order update failed"},
+		{name: "dead", result: UpdateResult{Phase: UpdatePhaseFetch, Err: &failedOrderUpdate{orderNumber: orderNumber, attempts: 3, err: serverError}},
+			wantClass: updateClassDead, wantLevel: zapcore.ErrorLevel, wantMessage: "ALERT order excluded from polling after repeated failures"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			core, recorded := observer.New(zapcore.DebugLevel)
+			s := &Storage{maxUpdateAttempts: 3}
+			tt.result.OrderNumber = orderNumber
+
+			if class := s.logUpdateResult(tt.result, logger.NewCoreLogger(core)); class != tt.wantClass {
+				t.Errorf("class = %s, want %s", class, tt.wantClass)
+			}
+			if counts := s.UpdateResults(); len(counts) != 1 || counts[tt.wantClass] != 1 {
+				t.Errorf("counters = %v, want one %s", counts, tt.wantClass)
+			}
+
+			entries := recorded.AllUntimed()
+			if len(entries) != 1 {
+				t.Fatalf("got %d log entries, want 1", len(entries))
+			}
+			entry := entries[0]
+			if entry.Level != tt.wantLevel || entry.Message != tt.wantMessage {
+				t.Errorf("log = %s %q, want %s %q", entry.Level, entry.Message, tt.wantLevel, tt.wantMessage)
+			}
+			fields := entry.ContextMap()
+			if fields["order"] != orderNumber || fields["class"] != tt.wantClass || fields["phase"] != string(tt.result.Phase) {
+				t.Errorf("fields = %v, want order, phase and class", fields)
+			}
+			if _, ok := fields["error"]; ok != (tt.result.Err != nil) {
+				t.Errorf("fields = %v, error field present = %v, want %v", fields, ok, tt.result.Err != nil)
+			}
+		})
+	}
+}
+
+// TestUpdateResultsAccumulate проверяет, что счетчики классов накапливаются между вызовами.
+func TestUpdateResultsAccumulate(t *testing.T) {
+	s := &Storage{maxUpdateAttempts: 3}
+	results := []UpdateResult{
+		{OrderNumber: "12345678903", Phase: UpdatePhaseStore},
+		{OrderNumber: "79927398713", Phase: UpdatePhaseStore},
+		{OrderNumber: "79927398721", Phase: UpdatePhaseFetch, Err: accrual.ErrTooManyRequests},
+	}
+	for _, result := range results {
+		s.logUpdateResult(result, logger.NewNopLogger())
+	}
+
+	counts := s.UpdateResults()
+	if len(counts) != 2 || counts[updateClassUnchanged] != 2 || counts[updateClassRateLimited] != 1 {
+		t.Errorf("counters = %v, want unchanged=2 rate_limited=1", counts)
+	}
+}
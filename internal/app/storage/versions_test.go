@@ -0,0 +1,96 @@
+package storage
+
+import (
+	"context"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"testing"
+)
+
+func TestOrdersVersion(t *testing.T) {
+	const orderNumber = "12345678903"
+
+	s := newTestStorage(t)
+	ctx := context.Background()
+	userID := registerTestUser(t, s, "alice")
+
+	version := func() string {
+		t.Helper()
+		v, err := s.OrdersVersion(ctx, userID)
+		if err != nil {
+			t.Fatalf("orders version: %v", err)
+		}
+		return v
+	}
+
+	empty := version()
+	addTestOrder(t, s, userID, orderNumber)
+	uploaded := version()
+	if uploaded == empty {
+		t.Fatalf("version did not change after an upload: %s", uploaded)
+	}
+
+	if _, err := s.applyOrderStatus(ctx, orderNumber, models.OrderStatusProcessing, nil); err != nil {
+		t.Fatal(err)
+	}
+	processing := version()
+	if processing == uploaded {
+		t.Fatalf("version did not change after a status change: %s", processing)
+	}
+
+	accrualSum := 10.0
+	if _, err := s.applyOrderStatus(ctx, orderNumber, models.OrderStatusProcessed, &accrualSum); err != nil {
+		t.Fatal(err)
+	}
+	processed := version()
+	if processed == processing || processed == uploaded {
+		t.Fatalf("version did not change after an accrual: %s", processed)
+	}
+
+	// неизменившийся ответ accrual-системы и проверка заказа опросом не меняют метку
+	if _, err := s.applyOrderStatus(ctx, orderNumber, models.OrderStatusProcessed, &accrualSum); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.DB.Exec(ctx, "UPDATE orders SET last_checked_at = CURRENT_TIMESTAMP WHERE order_id = $1", orderNumber); err != nil {
+		t.Fatal(err)
+	}
+	if unchanged := version(); unchanged != processed {
+		t.Errorf("version changed without an order change: %s -> %s", processed, unchanged)
+	}
+}
+
+func TestBalanceVersion(t *testing.T) {
+	s := newTestStorage(t)
+	ctx := context.Background()
+	userID := registerTestUser(t, s, "alice")
+
+	version := func() string {
+		t.Helper()
+		v, err := s.BalanceVersion(ctx, userID)
+		if err != nil {
+			t.Fatalf("balance version: %v", err)
+		}
+		return v
+	}
+
+	registered := version()
+	creditTestUser(t, s, userID, "12345678903", 100)
+	credited := version()
+	if credited == registered {
+		t.Fatalf("version did not change after an accrual: %s", credited)
+	}
+
+	if err := s.UseBonuses(ctx, models.APIUseBonusesRequest{OrderNumber: "2377225624", Sum: 40}, userID); err != nil {
+		t.Fatal(err)
+	}
+	withdrawn := version()
+	if withdrawn == credited {
+		t.Fatalf("version did not change after a withdrawal: %s", withdrawn)
+	}
+
+	if _, err := s.GetCurrentBonusesAmount(ctx, userID); err != nil {
+		t.Fatal(err)
+	}
+	if unchanged := version(); unchanged != withdrawn {
+		t.Errorf("version changed after a read: %s -> %s", withdrawn, unchanged)
+	}
+}
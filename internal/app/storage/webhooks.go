@@ -0,0 +1,140 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"github.com/jackc/pgx/v5"
+	"github.com/vancho-go/gophermart/internal/app/models"
+)
+
+// SetWebhook регистрирует webhook пользователя или заменяет адрес и ключ подписи уже
+// зарегистрированного. Идентификатор webhook при замене сохраняется вместе с журналом доставок.
+func (s *Storage) SetWebhook(ctx context.Context, userID, url, secret string) (int64, error) {
+	ctx, done := s.observeQuery(ctx, "setWebhook")
+	defer done()
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO user_webhooks (user_id, url, secret) VALUES ($1, $2, $3)
+		ON CONFLICT (user_id) DO UPDATE SET url = EXCLUDED.url, secret = EXCLUDED.secret, updated_at = CURRENT_TIMESTAMP
+		RETURNING webhook_id`
+	var webhookID int64
+	err := s.DB.QueryRow(ctx, query, userID, url, secret).Scan(&webhookID)
+	if err != nil {
+		return 0, fmt.Errorf("setWebhook: error saving webhook: %w", err)
+	}
+	return webhookID, nil
+}
+
+func (s *Storage) getWebhook(ctx context.Context, userID string) (*models.Webhook, error) {
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	query := "SELECT webhook_id, url, secret FROM user_webhooks WHERE user_id = $1"
+	var webhook models.Webhook
+	err := s.DB.QueryRow(ctx, query, userID).Scan(&webhook.ID, &webhook.URL, &webhook.Secret)
+	if errors.Is(err, pgx.ErrNoRows) {
+		return nil, nil
+	} else if err != nil {
+		return nil, fmt.Errorf("getWebhook: error scanning row: %w", err)
+	}
+	return &webhook, nil
+}
+
+// notifyWebhook ставит в очередь уведомление о переходе заказа в конечный статус. Вызывается
+// после фиксации изменения, поэтому ошибки доставки не откатывают обновление заказа.
+func (s *Storage) notifyWebhook(ctx context.Context, update orderStatusUpdate) error {
+	if s.webhookNotifier == nil || !update.event.Status.IsFinal() {
+		return nil
+	}
+
+	webhook, err := s.getWebhook(ctx, update.userID)
+	if err != nil {
+		return fmt.Errorf("notifyWebhook: %w", err)
+	}
+	if webhook == nil {
+		return nil
+	}
+
+	s.webhookNotifier.Notify(*webhook, models.APIWebhookPayload{
+		Order:   update.event.Number,
+		Status:  update.event.Status,
+		Accrual: update.event.Accrual,
+	})
+	return nil
+}
+
+// RecordWebhookDelivery сохраняет результат попытки доставки уведомления.
+func (s *Storage) RecordWebhookDelivery(ctx context.Context, delivery models.WebhookDelivery) error {
+	ctx, done := s.observeQuery(ctx, "recordWebhookDelivery")
+	defer done()
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	query := `INSERT INTO webhook_deliveries (webhook_id, order_id, status, attempt, response_code, error, succeeded)
+		VALUES ($1, $2, $3, $4, $5, NULLIF($6, ''), $7)`
+	_, err := s.DB.Exec(ctx, query, delivery.WebhookID, delivery.Order, delivery.Status, delivery.Attempt,
+		delivery.ResponseCode, delivery.Error, delivery.Succeeded)
+	if err != nil {
+		return fmt.Errorf("recordWebhookDelivery: error saving delivery: %w", err)
+	}
+	return nil
+}
+
+// GetWebhookDeliveries возвращает попытки доставки на webhook пользователя, начиная с последних.
+// Возвращает ErrWebhookNotFound, если webhook не существует или принадлежит другому пользователю.
+func (s *Storage) GetWebhookDeliveries(ctx context.Context, userID string, webhookID int64, limit, offset int) ([]models.WebhookDelivery, error) {
+	ctx, done := s.observeQuery(ctx, "getWebhookDeliveries")
+	defer done()
+
+	ctx, cancel := s.withQueryTimeout(ctx)
+	defer cancel()
+
+	var exists bool
+	query := "SELECT EXISTS (SELECT 1 FROM user_webhooks WHERE webhook_id = $1 AND user_id = $2)"
+	if err := s.DB.QueryRow(ctx, query, webhookID, userID).Scan(&exists); err != nil {
+		return nil, fmt.Errorf("getWebhookDeliveries: error checking webhook: %w", err)
+	}
+	if !exists {
+		return nil, ErrWebhookNotFound
+	}
+
+	query = `SELECT delivery_id, order_id, status, attempt, response_code, COALESCE(error, ''), succeeded, attempted_at
+		FROM webhook_deliveries
+		WHERE webhook_id = $1
+		ORDER BY attempted_at DESC, delivery_id DESC
+		LIMIT $2 OFFSET $3`
+	rows, err := s.DB.Query(ctx, query, webhookID, limit, offset)
+	if err != nil {
+		return nil, fmt.Errorf("getWebhookDeliveries: error selecting deliveries: %w", err)
+	}
+	defer rows.Close()
+
+	deliveries := []models.WebhookDelivery{}
+	for rows.Next() {
+		var (
+			delivery     models.WebhookDelivery
+			responseCode sql.NullInt32
+		)
+		err = rows.Scan(&delivery.ID, &delivery.Order, &delivery.Status, &delivery.Attempt, &responseCode,
+			&delivery.Error, &delivery.Succeeded, &delivery.AttemptedAt)
+		if err != nil {
+			return nil, fmt.Errorf("getWebhookDeliveries: error scanning row: %w", err)
+		}
+		if responseCode.Valid {
+			code := int(responseCode.Int32)
+			delivery.ResponseCode = &code
+		}
+		delivery.WebhookID = webhookID
+		delivery.AttemptedAt = delivery.AttemptedAt.UTC()
+		deliveries = append(deliveries, delivery)
+	}
+	if err = rows.Err(); err != nil {
+		return nil, fmt.Errorf("getWebhookDeliveries: error selecting deliveries: %w", err)
+	}
+	return deliveries, nil
+}
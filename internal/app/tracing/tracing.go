@@ -0,0 +1,58 @@
+// Package tracing настраивает OpenTelemetry: экспорт спанов по OTLP/HTTP и распространение
+// контекста трассировки в формате W3C traceparent.
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.21.0"
+	"net/url"
+	"strings"
+)
+
+const serviceName = "gophermart"
+
+func noopShutdown(context.Context) error { return nil }
+
+// Init включает экспорт спанов на OTLP/HTTP-коллектор endpoint (например, http://collector:4318)
+// и возвращает функцию, отправляющую накопленные спаны при остановке. Пустой endpoint оставляет
+// трассировку выключенной: спаны создаются no-op провайдером и никуда не отправляются, но
+// входящий traceparent все равно передается дальше.
+func Init(ctx context.Context, endpoint, version string) (func(context.Context) error, error) {
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+	if endpoint == "" {
+		return noopShutdown, nil
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("init: error parsing otlp endpoint: %w", err)
+	}
+	// как и для переменной OTEL_EXPORTER_OTLP_ENDPOINT, путь сигнала дописывается к адресу
+	options := []otlptracehttp.Option{
+		otlptracehttp.WithEndpoint(u.Host),
+		otlptracehttp.WithURLPath(strings.TrimSuffix(u.Path, "/") + "/v1/traces"),
+	}
+	if u.Scheme == "http" {
+		options = append(options, otlptracehttp.WithInsecure())
+	}
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, fmt.Errorf("init: error creating otlp exporter: %w", err)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewWithAttributes(semconv.SchemaURL,
+		semconv.ServiceName(serviceName), semconv.ServiceVersion(version)))
+	if err != nil {
+		return nil, fmt.Errorf("init: error building resource: %w", err)
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	return provider.Shutdown, nil
+}
@@ -0,0 +1,48 @@
+package updater
+
+import "sync"
+
+// Queue — ограниченная очередь номеров только что добавленных заказов, статус которых нужно
+// запросить у accrual-системы сразу, не дожидаясь периодического опроса. Заказ, не поместившийся
+// в очередь, остается в БД и будет обработан периодическим опросом.
+type Queue struct {
+	mu     sync.RWMutex
+	closed bool
+	orders chan string
+}
+
+func NewQueue(size int) *Queue {
+	return &Queue{orders: make(chan string, size)}
+}
+
+// Enqueue добавляет заказ в очередь без блокировки, возвращает false, если очередь заполнена или закрыта.
+func (q *Queue) Enqueue(orderNumber string) bool {
+	q.mu.RLock()
+	defer q.mu.RUnlock()
+
+	if q.closed {
+		return false
+	}
+	select {
+	case q.orders <- orderNumber:
+		return true
+	default:
+		return false
+	}
+}
+
+// Orders возвращает канал заказов очереди, он закрывается после Close, когда очередь опустеет.
+func (q *Queue) Orders() <-chan string {
+	return q.orders
+}
+
+// Close перестает принимать заказы, уже добавленные остаются в канале Orders.
+func (q *Queue) Close() {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if !q.closed {
+		q.closed = true
+		close(q.orders)
+	}
+}
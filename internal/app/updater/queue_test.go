@@ -0,0 +1,27 @@
+package updater
+
+import "testing"
+
+func TestQueue(t *testing.T) {
+	q := NewQueue(2)
+	if !q.Enqueue("12345678903") || !q.Enqueue("2377225624") {
+		t.Fatal("queue rejected orders below its size")
+	}
+	if q.Enqueue("79927398713") {
+		t.Error("full queue accepted an order")
+	}
+
+	q.Close()
+	q.Close()
+	if q.Enqueue("79927398721") {
+		t.Error("closed queue accepted an order")
+	}
+
+	var orders []string
+	for orderNumber := range q.Orders() {
+		orders = append(orders, orderNumber)
+	}
+	if len(orders) != 2 || orders[0] != "12345678903" || orders[1] != "2377225624" {
+		t.Errorf("orders after close = %q, want the two queued orders in order", orders)
+	}
+}
@@ -0,0 +1,81 @@
+package webhooks
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"net/url"
+	"strings"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrForbiddenAddress — адрес webhook указывает на сам сервис или его внутреннюю сеть.
+	ErrForbiddenAddress = errors.New("webhook address is not allowed")
+	// ErrInvalidURL — адрес webhook не является http- или https-URL с хостом.
+	ErrInvalidURL = errors.New("webhook url must be an http or https url with a host")
+	// ErrNoSecret — нет ключа подписи ни у webhook, ни в конфигурации.
+	ErrNoSecret = errors.New("webhook has no signing secret")
+)
+
+// ParseURL проверяет адрес webhook при регистрации: допускаются только http и https, а хост,
+// заданный IP-адресом или именем localhost, не должен вести во внутреннюю сеть. Имена хостов
+// проверяются повторно при каждом соединении, см. dialControl.
+func ParseURL(rawURL string) (*url.URL, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Hostname() == "" {
+		return nil, ErrInvalidURL
+	}
+
+	host := strings.ToLower(strings.TrimSuffix(u.Hostname(), "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return nil, ErrForbiddenAddress
+	}
+	if addr, err := netip.ParseAddr(host); err == nil && !isPublicAddr(addr) {
+		return nil, ErrForbiddenAddress
+	}
+	return u, nil
+}
+
+// isPublicAddr сообщает, можно ли отправлять уведомления на addr: loopback, частные,
+// link-local (в том числе адрес метаданных облака 169.254.169.254), multicast и неуказанный
+// адреса запрещены.
+func isPublicAddr(addr netip.Addr) bool {
+	addr = addr.Unmap()
+	return addr.IsValid() && !addr.IsLoopback() && !addr.IsPrivate() && !addr.IsLinkLocalUnicast() &&
+		!addr.IsLinkLocalMulticast() && !addr.IsInterfaceLocalMulticast() && !addr.IsMulticast() &&
+		!addr.IsUnspecified()
+}
+
+// dialControl проверяет адрес уже после разрешения имени, непосредственно перед соединением:
+// проверку при регистрации обходит DNS rebinding, когда имя позже начинает указывать внутрь.
+func dialControl(network, address string, _ syscall.RawConn) error {
+	addrPort, err := netip.ParseAddrPort(address)
+	if err != nil {
+		return fmt.Errorf("dialControl: error parsing address %q: %w", address, err)
+	}
+	if !isPublicAddr(addrPort.Addr()) {
+		return fmt.Errorf("dialControl: %s %s: %w", network, address, ErrForbiddenAddress)
+	}
+	return nil
+}
+
+// newClient создает HTTP-клиент уведомлений: без прокси из окружения, через который проверка
+// адреса потеряла бы смысл, без следования редиректам и с dialControl на каждом соединении.
+func newClient(timeout time.Duration) *http.Client {
+	dialer := &net.Dialer{Timeout: time.Second * 30, KeepAlive: time.Second * 30, Control: dialControl}
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = dialer.DialContext
+
+	return &http.Client{
+		Timeout:   timeout,
+		Transport: transport,
+		CheckRedirect: func(*http.Request, []*http.Request) error {
+			return http.ErrUseLastResponse
+		},
+	}
+}
@@ -0,0 +1,199 @@
+package webhooks
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"go.uber.org/zap"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+const (
+	SignatureHeader = "X-Gophermart-Signature"
+
+	retryBaseDelay = time.Second
+	// queueSize — сколько уведомлений может ждать свободного обработчика, остальные отбрасываются
+	queueSize = 1000
+)
+
+// DeliveryRecorder сохраняет попытки доставки уведомлений.
+type DeliveryRecorder interface {
+	RecordWebhookDelivery(ctx context.Context, delivery models.WebhookDelivery) error
+}
+
+type notification struct {
+	webhook models.Webhook
+	payload models.APIWebhookPayload
+}
+
+type Notifier struct {
+	client     *http.Client
+	maxRetries int
+	// retryDelay — задержка перед первым повтором, каждая следующая вдвое больше
+	retryDelay time.Duration
+	workers    int
+	secret     []byte
+	queue      chan notification
+	logger     logger.Logger
+}
+
+// NewNotifier создает отправителя уведомлений. secret подписывает уведомления webhook без
+// собственного ключа; если ключа нет ни у webhook, ни в secret, уведомление не отправляется.
+// Уведомления отправляются после запуска Run.
+func NewNotifier(timeout time.Duration, maxRetries, workers int, secret string, logger logger.Logger) *Notifier {
+	return &Notifier{
+		client:     newClient(timeout),
+		maxRetries: maxRetries,
+		retryDelay: retryBaseDelay,
+		workers:    workers,
+		secret:     []byte(secret),
+		queue:      make(chan notification, queueSize),
+		logger:     logger,
+	}
+}
+
+// Notify ставит уведомление об изменении заказа в очередь отправки на webhook, не блокируясь.
+// Если очередь заполнена, уведомление отбрасывается.
+func (n *Notifier) Notify(webhook models.Webhook, payload models.APIWebhookPayload) {
+	select {
+	case n.queue <- notification{webhook: webhook, payload: payload}:
+	default:
+		n.logger.Warn("notify: webhook queue is full, notification dropped", zap.Int64("webhook", webhook.ID),
+			zap.String("order", payload.Order))
+	}
+}
+
+// Run отправляет уведомления из очереди в workers обработчиков, записывая каждую попытку через
+// recorder, пока не отменен ctx.
+func (n *Notifier) Run(ctx context.Context, recorder DeliveryRecorder) {
+	var wg sync.WaitGroup
+	for i := 0; i < n.workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-ctx.Done():
+					return
+				case job := <-n.queue:
+					n.deliver(ctx, recorder, job)
+				}
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+// deliver отправляет уведомление, повторяя попытки с экспоненциальной задержкой. После maxRetries
+// неудачных повторов уведомление отбрасывается.
+func (n *Notifier) deliver(ctx context.Context, recorder DeliveryRecorder, job notification) {
+	body, err := json.Marshal(job.payload)
+	if err != nil {
+		n.logger.Error("deliver: error encoding payload", zap.Error(err))
+		return
+	}
+
+	secret := n.secret
+	if job.webhook.Secret != "" {
+		secret = []byte(job.webhook.Secret)
+	}
+	// подпись пустым ключом может подделать кто угодно
+	if len(secret) == 0 {
+		n.record(ctx, recorder, job, 1, 0, ErrNoSecret)
+		n.logger.Warn("deliver: webhook has no signing secret, notification dropped", zap.Int64("webhook", job.webhook.ID),
+			zap.String("order", job.payload.Order))
+		return
+	}
+
+	delay := n.retryDelay
+	for attempt := 0; attempt <= n.maxRetries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(delay):
+			}
+			delay *= 2
+		}
+
+		var statusCode int
+		statusCode, err = n.send(ctx, job.webhook.URL, secret, body)
+		n.record(ctx, recorder, job, attempt+1, statusCode, err)
+		if err == nil {
+			return
+		}
+		n.logger.Debug("deliver: webhook delivery failed", zap.String("url", job.webhook.URL),
+			zap.Int("attempt", attempt+1), zap.Error(err))
+	}
+	n.logger.Warn("deliver: webhook dropped after max retries", zap.String("url", job.webhook.URL),
+		zap.String("order", job.payload.Order), zap.Error(err))
+}
+
+// record сохраняет попытку доставки; ошибка сохранения только логируется.
+func (n *Notifier) record(ctx context.Context, recorder DeliveryRecorder, job notification, attempt, statusCode int, err error) {
+	delivery := models.WebhookDelivery{
+		WebhookID: job.webhook.ID,
+		Order:     job.payload.Order,
+		Status:    job.payload.Status,
+		Attempt:   attempt,
+		Succeeded: err == nil,
+	}
+	if statusCode != 0 {
+		delivery.ResponseCode = &statusCode
+	}
+	if err != nil {
+		delivery.Error = err.Error()
+	}
+	if recErr := recorder.RecordWebhookDelivery(ctx, delivery); recErr != nil {
+		n.logger.Error("deliver: error recording webhook delivery", zap.Int64("webhook", job.webhook.ID),
+			zap.Error(recErr))
+	}
+}
+
+// send отправляет тело на url и возвращает код ответа, 0 — если ответ не получен.
+func (n *Notifier) send(ctx context.Context, url string, secret, body []byte) (int, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return 0, fmt.Errorf("send: error with request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(SignatureHeader, "sha256="+Sign(secret, body))
+
+	resp, err := n.client.Do(req)
+	if err != nil {
+		return 0, fmt.Errorf("send: error post: %w", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return resp.StatusCode, fmt.Errorf("send: unexpected status code: %d", resp.StatusCode)
+	}
+	return resp.StatusCode, nil
+}
+
+// GenerateSecret возвращает случайный ключ подписи для webhook, зарегистрированного без ключа.
+func GenerateSecret() (string, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return "", fmt.Errorf("generateSecret: %w", err)
+	}
+	return hex.EncodeToString(secret), nil
+}
+
+// Sign возвращает HMAC-SHA256 тела запроса в hex.
+func Sign(secret, body []byte) string {
+	mac := hmac.New(sha256.New, secret)
+	mac.Write(body)
+	return hex.EncodeToString(mac.Sum(nil))
+}
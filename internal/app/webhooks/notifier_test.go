@@ -0,0 +1,333 @@
+package webhooks
+
+import (
+	"context"
+	"errors"
+	"github.com/vancho-go/gophermart/internal/app/logger"
+	"github.com/vancho-go/gophermart/internal/app/models"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+type deliveryLog struct {
+	mu         sync.Mutex
+	deliveries []models.WebhookDelivery
+}
+
+func (l *deliveryLog) RecordWebhookDelivery(_ context.Context, delivery models.WebhookDelivery) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.deliveries = append(l.deliveries, delivery)
+	return nil
+}
+
+func testJob(url, secret string) notification {
+	return notification{
+		webhook: models.Webhook{ID: 1, URL: url, Secret: secret},
+		payload: models.APIWebhookPayload{Order: "12345678903", Status: models.OrderStatusProcessed},
+	}
+}
+
+func TestParseURL(t *testing.T) {
+	tests := []struct {
+		url  string
+		want error
+	}{
+		{url: "https://example.com/hook", want: nil},
+		{url: "http://93.184.216.34:8080/hook", want: nil},
+		{url: "ftp://example.com/hook", want: ErrInvalidURL},
+		{url: "https:///hook", want: ErrInvalidURL},
+		{url: "not a url", want: ErrInvalidURL},
+		{url: "http://localhost:8080/hook", want: ErrForbiddenAddress},
+		{url: "http://api.localhost/hook", want: ErrForbiddenAddress},
+		{url: "http://127.0.0.1:8080/hook", want: ErrForbiddenAddress},
+		{url: "http://[::1]/hook", want: ErrForbiddenAddress},
+		{url: "http://169.254.169.254/latest/meta-data", want: ErrForbiddenAddress},
+		{url: "http://10.0.0.5/hook", want: ErrForbiddenAddress},
+		{url: "http://192.168.1.1/hook", want: ErrForbiddenAddress},
+		{url: "http://0.0.0.0/hook", want: ErrForbiddenAddress},
+		{url: "http://[::ffff:127.0.0.1]/hook", want: ErrForbiddenAddress},
+	}
+	for _, tt := range tests {
+		t.Run(tt.url, func(t *testing.T) {
+			if _, err := ParseURL(tt.url); !errors.Is(err, tt.want) {
+				t.Errorf("ParseURL(%q) error = %v, want %v", tt.url, err, tt.want)
+			}
+		})
+	}
+}
+
+// TestDeliverRefusesPrivateAddress проверяет, что адрес, прошедший регистрацию по имени, но
+// разрешившийся в loopback, отклоняется при соединении и запрос не доходит до сервера.
+func TestDeliverRefusesPrivateAddress(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, _ *http.Request) {
+		hits.Add(1)
+	}))
+	defer server.Close()
+
+	notifier := NewNotifier(time.Second, 0, 1, "global-secret-0123456789", logger.NewNopLogger())
+	recorder := &deliveryLog{}
+	notifier.deliver(context.Background(), recorder, testJob(server.URL, ""))
+
+	if hits.Load() != 0 {
+		t.Fatal("request reached a loopback address")
+	}
+	if len(recorder.deliveries) != 1 {
+		t.Fatalf("recorded %d deliveries, want 1", len(recorder.deliveries))
+	}
+	delivery := recorder.deliveries[0]
+	if delivery.Succeeded || !strings.Contains(delivery.Error, ErrForbiddenAddress.Error()) {
+		t.Errorf("delivery = %+v, want failure with %q", delivery, ErrForbiddenAddress)
+	}
+}
+
+func TestDeliverSignsPayload(t *testing.T) {
+	const secret = "webhook-secret-0123456789"
+
+	signatures := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		if req.Header.Get(SignatureHeader) != "sha256="+Sign([]byte(secret), body) {
+			res.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		signatures <- req.Header.Get(SignatureHeader)
+	}))
+	defer server.Close()
+
+	notifier := NewNotifier(time.Second, 0, 1, "", logger.NewNopLogger())
+	// тестовый сервер слушает loopback, поэтому проверка адреса здесь не нужна
+	notifier.client = server.Client()
+	recorder := &deliveryLog{}
+	notifier.deliver(context.Background(), recorder, testJob(server.URL, secret))
+
+	select {
+	case <-signatures:
+	default:
+		t.Fatal("signed notification was not delivered")
+	}
+	if len(recorder.deliveries) != 1 || !recorder.deliveries[0].Succeeded {
+		t.Errorf("deliveries = %+v, want one successful delivery", recorder.deliveries)
+	}
+}
+
+func TestDeliverRefusesEmptySecret(t *testing.T) {
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, _ *http.Request) {
+		hits.Add(1)
+	}))
+	defer server.Close()
+
+	notifier := NewNotifier(time.Second, 3, 1, "", logger.NewNopLogger())
+	notifier.client = server.Client()
+	recorder := &deliveryLog{}
+	notifier.deliver(context.Background(), recorder, testJob(server.URL, ""))
+
+	if hits.Load() != 0 {
+		t.Fatal("unsigned notification was sent")
+	}
+	if len(recorder.deliveries) != 1 || recorder.deliveries[0].Error != ErrNoSecret.Error() {
+		t.Errorf("deliveries = %+v, want one failure with %q", recorder.deliveries, ErrNoSecret)
+	}
+}
+
+func TestDeliverDoesNotFollowRedirects(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		http.Redirect(res, req, "http://169.254.169.254/latest/meta-data", http.StatusFound)
+	}))
+	defer server.Close()
+
+	notifier := NewNotifier(time.Second, 0, 1, "global-secret-0123456789", logger.NewNopLogger())
+	client := server.Client()
+	client.CheckRedirect = notifier.client.CheckRedirect
+	notifier.client = client
+	recorder := &deliveryLog{}
+	notifier.deliver(context.Background(), recorder, testJob(server.URL, ""))
+
+	if len(recorder.deliveries) != 1 {
+		t.Fatalf("recorded %d deliveries, want 1", len(recorder.deliveries))
+	}
+	delivery := recorder.deliveries[0]
+	if delivery.Succeeded || delivery.ResponseCode == nil || *delivery.ResponseCode != http.StatusFound {
+		t.Errorf("delivery = %+v, want failure with status %d", delivery, http.StatusFound)
+	}
+}
+
+// TestDeliverPayloadFormat сверяет тело уведомления с эталонным JSON: формат — контракт с
+// получателями webhook, и его изменение должно быть осознанным.
+func TestDeliverPayloadFormat(t *testing.T) {
+	accrual, zero := 729.98, 0.0
+	tests := []struct {
+		name    string
+		payload models.APIWebhookPayload
+		golden  string
+	}{
+		{
+			name:    "processed",
+			payload: models.APIWebhookPayload{Order: "12345678903", Status: models.OrderStatusProcessed, Accrual: &accrual},
+			golden:  `{"order":"12345678903","status":"PROCESSED","accrual":729.98}`,
+		},
+		{
+			name:    "processed without accrual",
+			payload: models.APIWebhookPayload{Order: "12345678903", Status: models.OrderStatusProcessed, Accrual: &zero},
+			golden:  `{"order":"12345678903","status":"PROCESSED","accrual":0}`,
+		},
+		{
+			name:    "processing",
+			payload: models.APIWebhookPayload{Order: "12345678903", Status: models.OrderStatusProcessing},
+			golden:  `{"order":"12345678903","status":"PROCESSING"}`,
+		},
+		{
+			name:    "invalid",
+			payload: models.APIWebhookPayload{Order: "12345678903", Status: models.OrderStatusInvalid},
+			golden:  `{"order":"12345678903","status":"INVALID"}`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			type request struct {
+				contentType string
+				body        string
+			}
+			requests := make(chan request, 1)
+			server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+				body, _ := io.ReadAll(req.Body)
+				requests <- request{contentType: req.Header.Get("Content-Type"), body: string(body)}
+			}))
+			defer server.Close()
+
+			notifier := NewNotifier(time.Second, 0, 1, "global-secret-0123456789", logger.NewNopLogger())
+			notifier.client = server.Client()
+			job := testJob(server.URL, "")
+			job.payload = tt.payload
+			notifier.deliver(context.Background(), &deliveryLog{}, job)
+
+			select {
+			case got := <-requests:
+				if got.body != tt.golden {
+					t.Errorf("payload = %s, want %s", got.body, tt.golden)
+				}
+				if got.contentType != "application/json" {
+					t.Errorf("Content-Type = %q, want application/json", got.contentType)
+				}
+			default:
+				t.Fatal("notification was not delivered")
+			}
+		})
+	}
+}
+
+// TestDeliverRetriesWithBackoff проверяет, что неудачная доставка повторяется с удваивающейся
+// задержкой до первого успешного ответа и каждая попытка записывается в журнал доставок.
+func TestDeliverRetriesWithBackoff(t *testing.T) {
+	const retryDelay = time.Millisecond * 50
+
+	var (
+		mu       sync.Mutex
+		arrivals []time.Time
+	)
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		arrivals = append(arrivals, time.Now())
+		if len(arrivals) < 3 {
+			res.WriteHeader(http.StatusInternalServerError)
+		}
+	}))
+	defer server.Close()
+
+	notifier := NewNotifier(time.Second, 5, 1, "global-secret-0123456789", logger.NewNopLogger())
+	notifier.client = server.Client()
+	notifier.retryDelay = retryDelay
+	recorder := &deliveryLog{}
+	notifier.deliver(context.Background(), recorder, testJob(server.URL, ""))
+
+	if len(arrivals) != 3 {
+		t.Fatalf("receiver got %d requests, want 3", len(arrivals))
+	}
+	for i, want := range []time.Duration{retryDelay, retryDelay * 2} {
+		if gap := arrivals[i+1].Sub(arrivals[i]); gap < want {
+			t.Errorf("delay before retry %d = %s, want at least %s", i+1, gap, want)
+		}
+	}
+
+	wantCodes := []int{http.StatusInternalServerError, http.StatusInternalServerError, http.StatusOK}
+	if len(recorder.deliveries) != len(wantCodes) {
+		t.Fatalf("recorded %d deliveries, want %d", len(recorder.deliveries), len(wantCodes))
+	}
+	for i, delivery := range recorder.deliveries {
+		succeeded := wantCodes[i] == http.StatusOK
+		if delivery.Attempt != i+1 || delivery.ResponseCode == nil || *delivery.ResponseCode != wantCodes[i] ||
+			delivery.Succeeded != succeeded || (delivery.Error == "") != succeeded {
+			t.Errorf("delivery %d = %+v, want attempt %d with status %d", i, delivery, i+1, wantCodes[i])
+		}
+	}
+}
+
+func TestDeliverGivesUpAfterMaxRetries(t *testing.T) {
+	const maxRetries = 2
+
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, _ *http.Request) {
+		hits.Add(1)
+		res.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	notifier := NewNotifier(time.Second, maxRetries, 1, "global-secret-0123456789", logger.NewNopLogger())
+	notifier.client = server.Client()
+	notifier.retryDelay = time.Millisecond
+	recorder := &deliveryLog{}
+	notifier.deliver(context.Background(), recorder, testJob(server.URL, ""))
+
+	if hits.Load() != maxRetries+1 {
+		t.Errorf("receiver got %d requests, want %d", hits.Load(), maxRetries+1)
+	}
+	if len(recorder.deliveries) != maxRetries+1 {
+		t.Fatalf("recorded %d deliveries, want %d", len(recorder.deliveries), maxRetries+1)
+	}
+	for _, delivery := range recorder.deliveries {
+		if delivery.Succeeded {
+			t.Errorf("delivery %+v succeeded, want a failure", delivery)
+		}
+	}
+}
+
+// TestDeliverStopsRetryingOnCancel проверяет, что остановка сервиса прерывает ожидание повтора.
+func TestDeliverStopsRetryingOnCancel(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	var hits atomic.Int32
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, _ *http.Request) {
+		hits.Add(1)
+		cancel()
+		res.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+
+	notifier := NewNotifier(time.Second, 3, 1, "global-secret-0123456789", logger.NewNopLogger())
+	notifier.client = server.Client()
+	notifier.retryDelay = time.Hour
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		notifier.deliver(ctx, &deliveryLog{}, testJob(server.URL, ""))
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("delivery kept waiting for a retry after cancellation")
+	}
+	if hits.Load() != 1 {
+		t.Errorf("receiver got %d requests, want 1", hits.Load())
+	}
+}